@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"gopkg.in/ini.v1"
+)
+
+func init() {
+	commands["sync-accounts"] = syncAccounts
+}
+
+// accountNameSource gives names to AWS account IDs.
+type accountNameSource interface {
+	accountNames() (map[string]string, error)
+}
+
+// accountNameSources returns the configured sources of account names, in
+// increasing order of precedence: names synced from AWS Organizations, then
+// those served from account_map_url, then the [account_map] section.
+func (c configuration) accountNameSources() []accountNameSource {
+	sources := []accountNameSource{organizationsAccountMap{}}
+	if u := c.cfg.Section("").Key("account_map_url").String(); u != "" {
+		sources = append(sources, remoteAccountMap{url: u})
+	}
+	if sec, err := c.cfg.GetSection("account_map"); err == nil {
+		sources = append(sources, iniAccountMap{sec})
+	}
+	return sources
+}
+
+// iniAccountMap is the [account_map] section of the configuration file.
+type iniAccountMap struct {
+	sec *ini.Section
+}
+
+func (m iniAccountMap) accountNames() (map[string]string, error) {
+	names := make(map[string]string)
+	for _, k := range m.sec.Keys() {
+		names[k.Name()] = k.String()
+	}
+	return names, nil
+}
+
+// remoteAccountMapTTL is how long a fetched account_map_url is used before
+// it is fetched again.
+const remoteAccountMapTTL = 24 * time.Hour
+
+// remoteAccountMap is a JSON object mapping account IDs to names served
+// from a URL, such as one published by a platform team.  It is cached in the
+// state directory, and the cached copy is used if it can't be fetched.
+type remoteAccountMap struct {
+	url string
+}
+
+func (m remoteAccountMap) accountNames() (map[string]string, error) {
+	name := fmt.Sprintf("account-map-%x.json", sha1.Sum([]byte(m.url)))
+	path, err := statePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	cached, cacheErr := ioutil.ReadFile(path)
+	if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) < remoteAccountMapTTL && cacheErr == nil {
+		return parseAccountMap(cached)
+	}
+
+	data, err := fetchAccountMap(m.url)
+	if err != nil {
+		if cacheErr == nil {
+			l.Printf("Using cached account_map_url, it could not be fetched: %s\n", err)
+			return parseAccountMap(cached)
+		}
+		return nil, err
+	}
+	names, err := parseAccountMap(data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid account_map_url: %s", err)
+	}
+	if err := writeState(name, data); err != nil {
+		l.Printf("Unable to cache account_map_url: %s\n", err)
+	}
+	return names, nil
+}
+
+func fetchAccountMap(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to fetch account_map_url: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to fetch account_map_url: %s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func parseAccountMap(data []byte) (map[string]string, error) {
+	names := make(map[string]string)
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// organizationsAccountMap is the names of the accounts in an AWS
+// Organization, as last synced with the sync-accounts command.
+type organizationsAccountMap struct{}
+
+// organizationsSync is the organizations.json state file.
+type organizationsSync struct {
+	SyncedAt time.Time         `json:"synced_at"`
+	Accounts map[string]string `json:"accounts"`
+}
+
+func (organizationsAccountMap) accountNames() (map[string]string, error) {
+	path, err := statePath("organizations.json")
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var sync organizationsSync
+	if err := json.Unmarshal(data, &sync); err != nil {
+		return nil, fmt.Errorf("Invalid organizations account cache %s: %s", path, err)
+	}
+	return sync.Accounts, nil
+}
+
+// syncAccounts caches the names of every account in the AWS Organization,
+// using the credentials of -profile (or the AWS SDK's defaults), which must
+// be allowed organizations:ListAccounts.
+func syncAccounts(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("sync-accounts takes no arguments")
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Profile:           c.profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to load AWS credentials: %s", err)
+	}
+
+	call, err := organizationsCall(aws.StringValue(sess.Config.Region))
+	if err != nil {
+		return err
+	}
+
+	sync := organizationsSync{SyncedAt: time.Now().UTC(), Accounts: make(map[string]string)}
+	next := ""
+	for {
+		page, err := listOrganizationAccounts(call, sess.Config.Credentials, next)
+		if err != nil {
+			return err
+		}
+		for _, a := range page.Accounts {
+			sync.Accounts[a.ID] = a.Name
+		}
+		if next = page.NextToken; next == "" {
+			break
+		}
+	}
+
+	data, err := json.MarshalIndent(sync, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeState("organizations.json", data); err != nil {
+		return err
+	}
+	path, err := statePath("organizations.json")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Names of %d accounts saved to %s\n", len(sync.Accounts), path)
+	return nil
+}
+
+type organizationsPage struct {
+	Accounts []struct {
+		ID   string `json:"Id"`
+		Name string `json:"Name"`
+	}
+	NextToken string
+}
+
+// organizationsCall returns the ListAccounts request to the Organizations
+// endpoint of region's partition.  If -account is given, the account's
+// endpoint_url is used, and the request goes through its proxy,
+// transport_cmd and host_aliases.
+func organizationsCall(region string) (awsJSONCall, error) {
+	// Organizations is a global service, with its endpoint in one region
+	// of each partition
+	call := awsJSONCall{Service: "organizations", Region: "us-east-1", Endpoint: c.endpointURL, Target: "AWSOrganizationsV20161128.ListAccounts"}
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		call.Region = "cn-northwest-1"
+	case strings.HasPrefix(region, "us-gov-"):
+		call.Region = "us-gov-west-1"
+	}
+
+	if c.account == "" {
+		return call, nil
+	}
+	if err := c.loadConfigurationFile(); err != nil {
+		return call, fmt.Errorf("Unable to parse configuration file: %s", err)
+	}
+	acct, err := c.cfg.GetSection(c.account)
+	if err != nil {
+		return call, fmt.Errorf("Account '%s' is not configured in %s", c.account, c.path)
+	}
+	fed, err := federator.New("", "", federator.AWSSignInURL)
+	if err != nil {
+		return call, err
+	}
+	if err := configureTransport(acct, &fed); err != nil {
+		return call, err
+	}
+	call.Client = fed.Client()
+	call.Endpoint = endpointURL(acct)
+	return call, nil
+}
+
+// listOrganizationAccounts lists a page of the organization's accounts.
+// The vendored aws-sdk-go predates AWS Organizations.
+func listOrganizationAccounts(call awsJSONCall, creds *credentials.Credentials, next string) (organizationsPage, error) {
+	var page organizationsPage
+
+	in := map[string]interface{}{}
+	if next != "" {
+		in["NextToken"] = next
+	}
+	err := call.do(creds, in, &page)
+	return page, err
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"gopkg.in/ini.v1"
+)
+
+// assertionReuseMargin is how long a cached SAML assertion must remain
+// valid to be used, leaving time to choose a role and call STS.
+const assertionReuseMargin = time.Minute
+
+// assertionCacheEntry is a SAML assertion kept from an earlier login to an
+// account, so that logging in again while it is valid, for instance to
+// choose a different role, needs no password or MFA.  As it grants access
+// to every role it lists, it is encrypted with the cookie key.
+type assertionCacheEntry struct {
+	Assertion    federator.SAMLAssertion `json:"assertion"`
+	NotOnOrAfter time.Time               `json:"not_on_or_after"`
+	Username     string                  `json:"username,omitempty"`
+}
+
+// cachingAssertions reports whether assertions for acct are cached, which
+// cache_assertion = false and -force turn off.
+func cachingAssertions(acct *ini.Section) bool {
+	return !*c.force && acct.Key("cache_assertion").MustBool(true)
+}
+
+// assertionCacheName returns the name of the state file caching the
+// assertion for acct.
+func assertionCacheName(acct *ini.Section) string {
+	key := acct.Name() + "\n" + acct.Key("sp_identity_url").String()
+	return fmt.Sprintf("assertion-%x.json", sha1.Sum([]byte(key)))
+}
+
+func assertionCachePath(acct *ini.Section) (string, error) {
+	return statePath(assertionCacheName(acct))
+}
+
+// cachedAssertionLogin returns a federator using the assertion cached for
+// acct, if it remains valid for assertionReuseMargin.
+func cachedAssertionLogin(acct *ini.Section) (federator.Federator, bool) {
+	if !cachingAssertions(acct) {
+		return federator.Federator{}, false
+	}
+	path, err := assertionCachePath(acct)
+	if err != nil {
+		return federator.Federator{}, false
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return federator.Federator{}, false
+	}
+	key, err := cookieKey()
+	if err != nil {
+		l.Printf("Unable to read cached SAML assertion: %s\n", err)
+		return federator.Federator{}, false
+	}
+	if data, err = decryptCookies(key, data); err != nil {
+		l.Printf("Ignoring cached SAML assertion %s: %s\n", path, err)
+		return federator.Federator{}, false
+	}
+	var entry assertionCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		l.Printf("Invalid cached SAML assertion %s: %s\n", path, err)
+		return federator.Federator{}, false
+	}
+	if entry.NotOnOrAfter.Before(time.Now().Add(assertionReuseMargin)) {
+		return federator.Federator{}, false
+	}
+
+	fed, err := assertionLogin(acct, entry.Assertion)
+	if err != nil {
+		l.Printf("Unable to use cached SAML assertion: %s\n", err)
+		return federator.Federator{}, false
+	}
+	fed.Username = entry.Username
+	l.Printf("Using the SAML assertion from an earlier login, valid until %s\n", formatTime(entry.NotOnOrAfter))
+	return fed, true
+}
+
+// cacheAssertion keeps the assertion fed logged in to acct with, unless the
+// IdP allows it to be used only once.
+func cacheAssertion(acct *ini.Section, fed *federator.Federator) {
+	if !cachingAssertions(acct) || *c.export {
+		return
+	}
+	assertion, notOnOrAfter, reusable := fed.Assertion()
+	if !reusable || notOnOrAfter.IsZero() {
+		return
+	}
+
+	plain, err := json.Marshal(assertionCacheEntry{Assertion: assertion, NotOnOrAfter: notOnOrAfter, Username: fed.Username})
+	if err != nil {
+		return
+	}
+	key, err := cookieKey()
+	if err != nil {
+		l.Printf("Unable to cache SAML assertion: %s\n", err)
+		return
+	}
+	data, err := encryptCookies(key, plain)
+	if err == nil {
+		err = writeState(assertionCacheName(acct), data)
+	}
+	if err != nil {
+		l.Printf("Unable to cache SAML assertion: %s\n", err)
+	}
+}
+
+// forgetAssertion removes the assertion cached for acct, such as when STS
+// has rejected it.
+func forgetAssertion(acct *ini.Section) {
+	if path, err := assertionCachePath(acct); err == nil {
+		os.Remove(path)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+// awsJSONCall is a request to an AWS API using the JSON protocol, for the
+// services whose operations the vendored aws-sdk-go lacks and for which
+// aws-sdk-go-v2 isn't vendored, such as Parameter Store and Organizations.
+type awsJSONCall struct {
+	// Service is the signing name and endpoint prefix, such as "ssm".
+	Service string
+	Region  string
+
+	// Endpoint overrides the service's endpoint in Region, such as with
+	// endpoint_url.
+	Endpoint string
+
+	// Target is the X-Amz-Target naming the operation.
+	Target string
+
+	// Client sends the request.  If it is nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// awsEndpoint returns the endpoint of service in region, taking the DNS
+// suffix from the region's partition.
+func awsEndpoint(service, region string) string {
+	suffix := "amazonaws.com"
+	if strings.HasPrefix(region, "cn-") {
+		suffix = "amazonaws.com.cn"
+	}
+	return fmt.Sprintf("https://%s.%s.%s/", service, region, suffix)
+}
+
+// do signs the request with creds, sends in and decodes the response into
+// out.  An error response is returned as an error holding its message.
+func (call awsJSONCall) do(creds *credentials.Credentials, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	endpoint := call.Endpoint
+	if endpoint == "" {
+		endpoint = awsEndpoint(call.Service, call.Region)
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", call.Target)
+
+	if _, err := v4.NewSigner(creds).Sign(req, bytes.NewReader(body), call.Service, call.Region, time.Now()); err != nil {
+		return fmt.Errorf("Unable to sign %s request: %s", call.Target, err)
+	}
+
+	client := call.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// services differ in the case of the message's name
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+			Upper   string `json:"Message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		if e.Message == "" {
+			e.Message = e.Upper
+		}
+		if i := strings.LastIndex(e.Type, "#"); i >= 0 {
+			e.Type = e.Type[i+1:]
+		}
+		return fmt.Errorf("%s returned %s: %s", call.Target, resp.Status, strings.TrimSpace(e.Type+" "+e.Message))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("Unable to decode %s response: %s", call.Target, err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+func TestAWSJSONCall(t *testing.T) {
+	var got *http.Request
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		got, body = r, string(b)
+		if strings.Contains(body, "missing") {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"com.amazonaws.ssm#ParameterNotFound","message":"Parameter /missing not found."}`))
+			return
+		}
+		w.Write([]byte(`{"Parameter":{"Value":"Admin"}}`))
+	}))
+	defer srv.Close()
+
+	call := awsJSONCall{Service: "ssm", Region: "eu-west-1", Endpoint: srv.URL, Target: "AmazonSSM.GetParameter"}
+	creds := credentials.NewStaticCredentials("AKIDEXAMPLE", "secret", "")
+
+	var out struct{ Parameter struct{ Value string } }
+	if err := call.do(creds, map[string]string{"Name": "/roles/alice"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Parameter.Value != "Admin" {
+		t.Errorf("decoded %+v", out)
+	}
+	if body != `{"Name":"/roles/alice"}` || got.ContentLength != int64(len(body)) || len(got.TransferEncoding) != 0 {
+		t.Errorf("body %q sent with length %d, transfer encoding %v", body, got.ContentLength, got.TransferEncoding)
+	}
+	if got.Header.Get("X-Amz-Target") != "AmazonSSM.GetParameter" || !strings.Contains(got.Header.Get("Authorization"), "Credential=AKIDEXAMPLE/") || !strings.Contains(got.Header.Get("Authorization"), "/eu-west-1/ssm/aws4_request") {
+		t.Errorf("unexpected headers %v", got.Header)
+	}
+
+	err := call.do(creds, map[string]string{"Name": "/missing"}, &out)
+	if err == nil || !strings.Contains(err.Error(), "ParameterNotFound Parameter /missing not found.") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestAWSEndpoint(t *testing.T) {
+	tests := map[string]string{
+		"eu-west-1":     "https://ssm.eu-west-1.amazonaws.com/",
+		"us-gov-west-1": "https://ssm.us-gov-west-1.amazonaws.com/",
+		"cn-north-1":    "https://ssm.cn-north-1.amazonaws.com.cn/",
+	}
+	for region, want := range tests {
+		if got := awsEndpoint("ssm", region); got != want {
+			t.Errorf("%s: %s, want %s", region, got, want)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"gopkg.in/ini.v1"
+)
+
+// batchRetries is how many times a throttled assumption is retried.
+const batchRetries = 5
+
+// batchResult records the outcome of assuming one role during a batch.
+type batchResult struct {
+	AccountID  string `json:"account_id"`
+	RoleArn    string `json:"role_arn"`
+	Profile    string `json:"profile"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	Expiration string `json:"expiration,omitempty"`
+}
+
+// batchReport is printed once a batch has finished.
+type batchReport struct {
+	Account string        `json:"account"`
+	Results []batchResult `json:"results"`
+}
+
+func init() {
+	commands["batch"] = batch
+}
+
+// batch assumes every role available to the account and writes each to its
+// own credential profile, named by the batch_profile template.  Progress is
+// checkpointed after each role so that an interrupted batch, or one whose
+// SAML assertion expired, resumes where it left off when run again.  With
+// -tag, every account with the tags is batched in turn.
+func batch(args []string) error {
+	if len(c.tags) == 0 {
+		acct, err := c.resolveAccount()
+		if err != nil {
+			return err
+		}
+		report, err := batchAccount(acct)
+		if report.Account != "" {
+			printBatchReport(report)
+		}
+		return err
+	}
+
+	overlay, err := c.loadConfiguration()
+	if err != nil {
+		return err
+	}
+	names := c.taggedAccounts()
+	if len(names) == 0 {
+		return fmt.Errorf("No accounts are tagged %s", c.tags.String())
+	}
+
+	var reports []batchReport
+	failed := 0
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "Batch for account %s\n", name)
+		acct, err := c.useAccount(name, "-tag filter", overlay)
+		if err == nil {
+			var report batchReport
+			if report, err = batchAccount(acct); report.Account != "" {
+				reports = append(reports, report)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s: %s\n", name, err)
+			failed++
+		}
+	}
+	printBatchReport(reports)
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d account(s) did not complete.  Run the batch again to resume them", failed, len(names))
+	}
+	return nil
+}
+
+func printBatchReport(v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return
+	}
+	fmt.Println(string(b))
+}
+
+// batchAccount runs the batch for one account, returning its report.  The
+// report is empty if the batch failed before any roles were assumed.
+func batchAccount(acct *ini.Section) (batchReport, error) {
+	rate := acct.Key("batch_rate").MustFloat64(2)
+	if rate <= 0 {
+		return batchReport{}, fmt.Errorf("batch_rate must be greater than zero")
+	}
+	template := acct.Key("batch_profile").MustString("{account}-{role}")
+	duration, err := sessionDuration(acct)
+	if err != nil {
+		return batchReport{}, err
+	}
+	policy, err := sessionPolicy(acct)
+	if err != nil {
+		return batchReport{}, err
+	}
+
+	checkpoint, err := statePath("batch-" + c.account)
+	if err != nil {
+		return batchReport{}, err
+	}
+	done := loadBatchCheckpoint(checkpoint)
+
+	fed, err := login(acct)
+	if err != nil {
+		return batchReport{}, err
+	}
+
+	roles, err := availableRoles(acct, &fed)
+	if err != nil {
+		return batchReport{}, err
+	}
+	fed.SessionDuration = duration
+	fed.SessionPolicy = policy
+
+	names := c.accountNames()
+	throttle := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer throttle.Stop()
+
+	report := batchReport{Account: c.account}
+	stopped := ""
+	for i, r := range roles {
+		account := r.AccountId()
+		if n, ok := names[account]; ok {
+			account = n
+		}
+		res := batchResult{
+			AccountID: r.AccountId(),
+			RoleArn:   r.RoleArn(),
+			Profile:   strings.NewReplacer("{account}", account, "{account_id}", r.AccountId(), "{role}", r.RoleName()).Replace(template),
+			Status:    "pending",
+		}
+
+		if prev, ok := done[res.RoleArn]; ok && prev.Status == "ok" && prev.Profile == res.Profile {
+			if exp, err := time.Parse(time.RFC3339, prev.Expiration); err == nil && exp.After(time.Now()) {
+				l.Printf("Skipping %s, completed by an earlier run\n", res.RoleArn)
+				report.Results = append(report.Results, prev)
+				continue
+			}
+		}
+
+		if stopped == "" {
+			<-throttle.C
+			fmt.Fprintf(os.Stderr, "[%d/%d] %s\n", i+1, len(roles), res.RoleArn)
+
+			creds, err := assumeWithRetry(acct, &fed, r)
+			switch {
+			case assertionStale(err):
+				stopped = err.Error()
+			case err != nil:
+				res.Status, res.Error = "failed", err.Error()
+			default:
+				if err := WriteAWSCredentials(creds, r, res.Profile); err != nil {
+					res.Status, res.Error = "failed", err.Error()
+				} else {
+					res.Status, res.Expiration = "ok", creds.Expiration.Format(time.RFC3339)
+				}
+				notifyWebhook(acct, &fed, r, creds)
+			}
+		}
+
+		report.Results = append(report.Results, res)
+		done[res.RoleArn] = res
+		if err := saveBatchCheckpoint("batch-"+c.account, done); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: Could not save batch progress: %s\n", err)
+		}
+	}
+
+	failed := 0
+	for _, res := range report.Results {
+		if res.Status != "ok" {
+			failed++
+		}
+	}
+
+	if stopped != "" {
+		return report, fmt.Errorf("Batch stopped as the SAML assertion can no longer be used (%s).  Run the batch again to log in and resume", stopped)
+	}
+	if failed > 0 {
+		return report, fmt.Errorf("%d of %d role(s) failed.  Run the batch again to retry them", failed, len(report.Results))
+	}
+
+	os.Remove(checkpoint)
+	return report, nil
+}
+
+// assumeWithRetry assumes r, backing off and retrying when STS throttles the
+// request, and logging in again if the assertion can no longer be used.
+func assumeWithRetry(acct *ini.Section, fed *federator.Federator, r federator.Role) (federator.Credentials, error) {
+	wait := time.Second
+	for attempt := 1; ; attempt++ {
+		creds, err := assumeRoleRelogin(acct, fed, r)
+		addSecret(creds.AccessKeyId, creds.SecretAccessKey, creds.SessionToken)
+		if err == nil || attempt == batchRetries || !strings.Contains(err.Error(), "Throttling") {
+			return creds, err
+		}
+
+		l.Printf("Throttled by STS, retrying in %s\n", wait)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func loadBatchCheckpoint(p string) map[string]batchResult {
+	m := make(map[string]batchResult)
+	if b, err := ioutil.ReadFile(p); err == nil {
+		json.Unmarshal(b, &m)
+	}
+	return m
+}
+
+func saveBatchCheckpoint(name string, m map[string]batchResult) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeState(name, b)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"gopkg.in/ini.v1"
+)
+
+// canICheck is an action, and optionally the resource it is performed on,
+// given with -can-i.
+type canICheck struct {
+	action   string
+	resource string
+}
+
+// canIList collects repeated -can-i flags.
+type canIList []canICheck
+
+func (l *canIList) String() string {
+	var checks []string
+	for _, c := range *l {
+		checks = append(checks, strings.TrimSpace(c.action+" "+c.resource))
+	}
+	return strings.Join(checks, ", ")
+}
+
+func (l *canIList) Set(s string) error {
+	f := strings.Fields(s)
+	switch len(f) {
+	case 1:
+		*l = append(*l, canICheck{action: f[0]})
+	case 2:
+		*l = append(*l, canICheck{action: f[0], resource: f[1]})
+	default:
+		return fmt.Errorf("expected an action optionally followed by a resource ARN, such as 's3:PutObject arn:aws:s3:::bucket/*'")
+	}
+	return nil
+}
+
+// requirePermissions runs the -can-i checks against the credentials for r,
+// exiting unsuccessfully if any is denied or they can't be made.
+func requirePermissions(acct *ini.Section, r federator.Role, creds federator.Credentials, sso bool) {
+	if len(c.canI) == 0 {
+		return
+	}
+	allowed, err := checkPermissions(endpointURL(acct), r, creds, sso)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	if !allowed {
+		os.Exit(1)
+	}
+}
+
+// checkPermissions simulates the policies of the assumed role r for each
+// -can-i check, printing whether it is allowed.  It reports whether every
+// check is.  If endpoint is set, IAM and STS are called there.
+func checkPermissions(endpoint string, r federator.Role, creds federator.Credentials, sso bool) (bool, error) {
+	cfg := &aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials(creds.AccessKeyId, creds.SecretAccessKey, creds.SessionToken),
+	}
+	if endpoint != "" {
+		cfg.Endpoint = aws.String(endpoint)
+	}
+	sess := session.New(cfg)
+	svc := iam.New(sess)
+
+	source := r.RoleArn()
+	if sso || source == "" {
+		// AWS SSO roles are created with a generated name and path
+		arn, err := callerRoleArn(sess, svc)
+		if err != nil {
+			return false, err
+		}
+		source = arn
+	}
+
+	allowed := true
+	for _, check := range c.canI {
+		in := &iam.SimulatePrincipalPolicyInput{
+			PolicySourceArn: aws.String(source),
+			ActionNames:     []*string{aws.String(check.action)},
+		}
+		if check.resource != "" {
+			in.ResourceArns = []*string{aws.String(check.resource)}
+		}
+		resp, err := svc.SimulatePrincipalPolicy(in)
+		if err != nil {
+			return false, fmt.Errorf("Unable to simulate the role's policies: %s", err)
+		}
+
+		for _, result := range resp.EvaluationResults {
+			decision := "denied, no statement allows it"
+			switch aws.StringValue(result.EvalDecision) {
+			case iam.PolicyEvaluationDecisionTypeAllowed:
+				decision = "allowed"
+			case iam.PolicyEvaluationDecisionTypeExplicitDeny:
+				decision = "denied by a Deny statement"
+			}
+			if decision != "allowed" {
+				allowed = false
+			}
+			fmt.Fprintf(os.Stderr, "%s on %s: %s\n", aws.StringValue(result.EvalActionName), aws.StringValue(result.EvalResourceName), decision)
+		}
+	}
+	return allowed, nil
+}
+
+// callerRoleArn returns the ARN of the IAM role behind the credentials.
+func callerRoleArn(sess *session.Session, svc *iam.IAM) (string, error) {
+	id, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("Unable to identify the assumed role: %s", err)
+	}
+	// arn:aws:sts::123456789012:assumed-role/<role name>/<session>
+	parts := strings.Split(aws.StringValue(id.Arn), "/")
+	if len(parts) < 3 {
+		return "", fmt.Errorf("Unexpected caller identity '%s'", aws.StringValue(id.Arn))
+	}
+	role, err := svc.GetRole(&iam.GetRoleInput{RoleName: aws.String(parts[1])})
+	if err != nil {
+		return "", fmt.Errorf("Unable to find the assumed role: %s", err)
+	}
+	return aws.StringValue(role.Role.Arn), nil
+}
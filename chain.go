@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"gopkg.in/ini.v1"
+)
+
+// chainRole assumes the account's chain_role_arn with the credentials of the
+// federated role, returning the chained credentials and role.
+func chainRole(acct *ini.Section, fed *federator.Federator, creds federator.Credentials, duration time.Duration) (federator.Credentials, federator.Role, error) {
+	arn := acct.Key("chain_role_arn").String()
+	if duration > federator.MaxChainedDuration {
+		fmt.Fprintf(os.Stderr, "WARNING: STS limits chained roles to %s sessions, ignoring the requested %s\n", federator.MaxChainedDuration, duration)
+		duration = federator.MaxChainedDuration
+	}
+
+	tags, err := parseSessionTags(acct.Key("session_tags").String())
+	if err != nil {
+		return federator.Credentials{}, "", err
+	}
+	expand := sessionTemplate(acct, fed)
+	for k, v := range tags {
+		tags[k] = expand.Replace(v)
+	}
+	name := acct.Key("session_name").MustString("aws-cli-federator-{timestamp}")
+
+	l.Printf("Assuming chained role %s\n", arn)
+	chained, err := fed.ChainRole(creds, federator.ChainInput{
+		RoleArn:     arn,
+		SessionName: sessionName(expand.Replace(name)),
+		ExternalID:  acct.Key("external_id").String(),
+		Duration:    duration,
+		Tags:        tags,
+	})
+	// chained roles aren't assumed with SAML, so have no principal
+	return chained, federator.Role(arn + ","), err
+}
+
+// federatedRole returns the role to assume with SAML when refreshing a
+// profile holding credentials for role, which is the account's chained
+// role if it has one, leaving the federated role to be chosen again.
+func federatedRole(acct *ini.Section, role string) string {
+	if acct.HasKey("chain_role_arn") && role == acct.Key("chain_role_arn").String() {
+		return ""
+	}
+	return role
+}
+
+// sessionTemplate expands the variables in session_name and session_tags.
+func sessionTemplate(acct *ini.Section, fed *federator.Federator) *strings.Replacer {
+	username := fed.Username
+	if username == "" {
+		username = os.Getenv("USER")
+	}
+	return strings.NewReplacer(
+		"{username}", username,
+		"{account}", acct.Name(),
+		"{timestamp}", fmt.Sprint(time.Now().Unix()),
+	)
+}
+
+var sessionNameInvalid = regexp.MustCompile(`[^\w+=,.@-]`)
+
+// sessionName makes name acceptable to STS as a RoleSessionName, replacing
+// the characters it doesn't allow, such as the backslash in DOMAIN\user,
+// and truncating it to 64 characters.
+func sessionName(name string) string {
+	name = sessionNameInvalid.ReplaceAllString(name, "-")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	for len(name) < 2 {
+		name += "-"
+	}
+	return name
+}
+
+// parseSessionTags parses a session_tags value of comma separated
+// Key=Value pairs.
+func parseSessionTags(s string) (map[string]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("Invalid session_tags '%s', expected comma separated Key=Value pairs", strings.TrimSpace(pair))
+		}
+		tags[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	if len(tags) > 50 {
+		return nil, fmt.Errorf("Invalid session_tags, STS allows at most 50 session tags")
+	}
+	return tags, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"gopkg.in/ini.v1"
+)
+
+func init() {
+	commands["check-profile"] = checkProfile
+}
+
+// checkProfile verifies that the credentials written to a profile are the
+// ones the AWS SDKs will actually use when that profile is selected.
+func checkProfile(args []string) error {
+	if c.profile == "" {
+		return fmt.Errorf("A profile to check must be provided with -profile")
+	}
+
+	cpath, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	cfg, err := ini.Load(cpath)
+	if err != nil {
+		return fmt.Errorf("Unable to load credentials file: %s", err)
+	}
+	prof, err := cfg.GetSection(c.profile)
+	if err != nil || !prof.HasKey("aws_access_key_id") {
+		return fmt.Errorf("Credential profile '%s' does not exist in %s", c.profile, cpath)
+	}
+	written := prof.Key("aws_access_key_id").String()
+
+	problems := 0
+	report := func(ok bool, format string, a ...interface{}) {
+		status := "OK     "
+		if !ok {
+			status = "PROBLEM"
+			problems++
+		}
+		fmt.Fprintf(os.Stderr, "[%s] %s\n", status, fmt.Sprintf(format, a...))
+	}
+
+	if exp, err := profileExpiry(c.profile); err == nil {
+		report(time.Now().Before(exp), "credentials expire at %s", formatTime(exp))
+	}
+
+	// environment credentials take precedence over every profile
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		report(id == written, "AWS_ACCESS_KEY_ID is set in the environment and will be used instead of the profile")
+	}
+
+	if keys, err := conflictingConfigKeys(c.profile); err != nil {
+		report(false, "%s", err)
+	} else if len(keys) > 0 {
+		report(false, "the '%s' profile in your AWS config file sets %s which take precedence over the static credentials", c.profile, strings.Join(keys, ", "))
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Profile:           c.profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		report(false, "the AWS SDK could not load profile '%s': %s", c.profile, err)
+		return fmt.Errorf("%d problem(s) found with profile '%s'", problems, c.profile)
+	}
+
+	resolved, err := sess.Config.Credentials.Get()
+	if err != nil {
+		report(false, "the AWS SDK could not resolve credentials for profile '%s': %s", c.profile, err)
+	} else {
+		report(resolved.AccessKeyID == written, "the AWS SDK resolves profile '%s' using %s", c.profile, resolved.ProviderName)
+		if resolved.AccessKeyID != written {
+			fmt.Fprintf(os.Stderr, "          resolved access key %s does not match %s written to %s.\n", resolved.AccessKeyID, written, cpath)
+			fmt.Fprintf(os.Stderr, "          Check ~/.aws/config for a [profile %s] stanza defining role_arn/source_profile.\n", c.profile)
+		}
+
+		if _, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{}); err != nil {
+			report(false, "STS rejected the resolved credentials: %s", err)
+		} else {
+			report(true, "STS accepted the resolved credentials")
+		}
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("%d problem(s) found with profile '%s'", problems, c.profile)
+	}
+	return nil
+}
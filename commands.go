@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// commands holds the subcommands understood by the CLI, keyed by name.
+// Subcommands share the global flag set; any flags following the
+// subcommand name are parsed before it is run.
+var commands = map[string]func(args []string) error{}
+
+// runCommand executes the subcommand named by args[0] and exits.
+func runCommand(args []string) {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		var names []string
+		for n := range commands {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		fmt.Fprintf(os.Stderr, "ERROR: Unknown command '%s'.  Available commands: %v\n", args[0], names)
+		os.Exit(1)
+	}
+
+	if err := flag.CommandLine.Parse(args[1:]); err != nil {
+		os.Exit(1)
+	}
+
+	if err := cmd(flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode/utf16"
+
+	"gopkg.in/ini.v1"
+)
+
+// resolvePath sets the configuration file path to ~/.aws/federatedcli if
+// -path wasn't given.
+func (c *configuration) resolvePath() {
+	if c.path != "" {
+		return
+	}
+
+	usr, err := user.Current()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Unable to get current user information: %s\n", err)
+		os.Exit(1)
+	}
+
+	l.Printf("Found user's homedirectory: %s\n", usr.HomeDir)
+	c.path = filepath.Join(usr.HomeDir, ".aws/federatedcli")
+}
+
+func (c *configuration) loadConfigurationFile() error {
+	c.resolvePath()
+
+	l.Printf("Loading configuration from file: %s\n", c.path)
+	data, err := readConfigFile(c.path)
+	if os.IsNotExist(err) {
+		if err = c.firstRun(); err == nil {
+			data, err = readConfigFile(c.path)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	cfg, err := ini.Load(data)
+	if err != nil {
+		return err
+	}
+	cfg.BlockMode = false
+	c.cfg = cfg
+
+	return nil
+}
+
+// resolveAccount loads the configuration file and returns the section for
+// the selected account, with any project overlay applied.  The account and
+// profile are filled in from the configuration when not given as flags.
+func (c *configuration) resolveAccount() (*ini.Section, error) {
+	overlay, err := c.loadConfiguration()
+	if err != nil {
+		return nil, err
+	}
+
+	account, source := c.chooseAccount(overlay)
+	if *c.explain {
+		c.explainAccount(overlay, source)
+	}
+	return c.useAccount(account, source, overlay)
+}
+
+// loadConfiguration loads the configuration file and returns the project
+// overlay, if any.
+func (c *configuration) loadConfiguration() (*ini.Section, error) {
+	if err := c.loadConfigurationFile(); err != nil {
+		return nil, fmt.Errorf("Unable to parse configuration file: %s", err)
+	}
+
+	overlay, err := c.loadProjectOverlay()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load project configuration: %s", err)
+	}
+	return overlay, nil
+}
+
+// useAccount selects the named account, chosen from source, and returns its
+// settings with the platform section and project overlay applied.
+func (c *configuration) useAccount(account, source string, overlay *ini.Section) (*ini.Section, error) {
+	c.account, c.accountSource = account, source
+
+	acct, found := c.matchAccount()
+	if !found {
+		return nil, fmt.Errorf("Could not find configuration matching account name '%s' (from %s)", c.account, c.accountSource)
+	}
+
+	c.applyPlatformSection(acct)
+	if overlay != nil {
+		applyOverlay(acct, overlay)
+	}
+
+	// credentials sent to a parent process are only written to a profile
+	// when one is asked for with -profile, and exported ones never are
+	if c.profile == "" && acct.HasKey("profile") && !c.sendingCredentials() && !*c.export {
+		c.profile = acct.Key("profile").String()
+	}
+
+	return acct, nil
+}
+
+// accountEnv names the environment variable that can select the account.
+const accountEnv = "AWS_FEDERATOR_ACCOUNT"
+
+// accountSources lists where the account name can come from, in order of
+// precedence.  Each returns the account name, or "" if the source does not
+// set one.
+func (c configuration) accountSources(overlay *ini.Section) []struct{ name, value string } {
+	overlayAccount := ""
+	if overlay != nil {
+		overlayAccount = overlay.Key("account").String()
+	}
+
+	return []struct{ name, value string }{
+		{"-account flag", c.account},
+		{"command line argument", c.positionalAccount},
+		{accountEnv + " environment variable", os.Getenv(accountEnv)},
+		{"project configuration", overlayAccount},
+		{"default_account setting", c.cfg.Section("").Key("default_account").String()},
+		{"built-in default", "default"},
+	}
+}
+
+// chooseAccount returns the account name to use and the source it came from.
+func (c configuration) chooseAccount(overlay *ini.Section) (string, string) {
+	for _, s := range c.accountSources(overlay) {
+		if s.value != "" {
+			return s.value, s.name
+		}
+	}
+	return "default", "built-in default"
+}
+
+// explainAccount prints how the account name was resolved.
+func (c configuration) explainAccount(overlay *ini.Section, source string) {
+	fmt.Fprintf(os.Stderr, "Account resolution:\n")
+	for _, s := range c.accountSources(overlay) {
+		switch {
+		case s.name == source:
+			fmt.Fprintf(os.Stderr, "  %-44s %s  <- used\n", s.name, s.value)
+		case s.value == "":
+			fmt.Fprintf(os.Stderr, "  %-44s (not set)\n", s.name)
+		default:
+			fmt.Fprintf(os.Stderr, "  %-44s %s\n", s.name, s.value)
+		}
+	}
+}
+
+// findAccount looks through the loaded configuration file to locate a
+//   matching account declaration with the account name loaded from the CLI.
+// It returns the configuration block if there is a match and false if there
+//   is not.
+func (c configuration) matchAccount() (*ini.Section, bool) {
+	for _, acct := range c.cfg.Sections() {
+		if acct.Name() == c.account {
+			return acct, true
+		}
+	}
+
+	return &ini.Section{}, false
+}
+
+// readConfigFile reads an INI file and normalises the encodings commonly
+// produced when files are edited on Windows or distributed by device
+// management tools: UTF-16 and UTF-8 byte order marks, and CRLF or CR line
+// endings.
+func readConfigFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xfe}):
+		data = decodeUTF16(data[2:], false)
+	case bytes.HasPrefix(data, []byte{0xfe, 0xff}):
+		data = decodeUTF16(data[2:], true)
+	case bytes.HasPrefix(data, []byte{0xef, 0xbb, 0xbf}):
+		data = data[3:]
+	}
+
+	data = bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+	data = bytes.Replace(data, []byte("\r"), []byte("\n"), -1)
+
+	return data, nil
+}
+
+func decodeUTF16(b []byte, bigEndian bool) []byte {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		if bigEndian {
+			u[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+		} else {
+			u[i] = uint16(b[2*i+1])<<8 | uint16(b[2*i])
+		}
+	}
+	return []byte(string(utf16.Decode(u)))
+}
+
+// accountNames returns the account ID to name aliases from every account
+// name source, the account_map section taking precedence.
+func (c configuration) accountNames() map[string]string {
+	names := make(map[string]string)
+	for _, source := range c.accountNameSources() {
+		m, err := source.accountNames()
+		if err != nil {
+			l.Printf("Unable to read account names: %s\n", err)
+			continue
+		}
+		for id, name := range m {
+			names[id] = name
+		}
+	}
+	return names
+}
+
+// awsAccountTags returns the tags given to AWS account IDs in the
+// account_tags section, for matching by role_policy.
+func (c configuration) awsAccountTags() map[string][]string {
+	tags := make(map[string][]string)
+	if sec, err := c.cfg.GetSection("account_tags"); err == nil {
+		for _, k := range sec.Keys() {
+			var list tagList
+			list.Set(k.String())
+			tags[k.Name()] = list
+		}
+	}
+	return tags
+}
+
+// projectConfigName is the project-local configuration overlay, searched
+// for from the working directory upwards when project_config is enabled.
+const projectConfigName = ".awsfederator"
+
+// projectOverlayKeys are the settings a project overlay may pin.
+var projectOverlayKeys = []string{"account", "role_pattern", "profile", "region"}
+
+// loadProjectOverlay returns the settings from the nearest project overlay
+// file, or nil if project_config is not enabled or there is no overlay.
+func (c *configuration) loadProjectOverlay() (*ini.Section, error) {
+	if enabled, _ := c.cfg.Section("").Key("project_config").Bool(); !enabled {
+		return nil, nil
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		path := filepath.Join(dir, projectConfigName)
+		if _, err := os.Stat(path); err == nil {
+			l.Printf("Loading project configuration from file: %s\n", path)
+			data, err := readConfigFile(path)
+			if err != nil {
+				return nil, err
+			}
+
+			overlay, err := ini.Load(data)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to parse %s: %s", path, err)
+			}
+			return overlay.Section(""), nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// applyOverlay merges the settings pinned by a project overlay over the
+// account's settings.
+func applyOverlay(acct, overlay *ini.Section) {
+	for _, k := range projectOverlayKeys {
+		if k != "account" && overlay.HasKey(k) {
+			acct.NewKey(k, overlay.Key(k).String())
+		}
+	}
+}
+
+// platformNames are the operating systems which may have their own account
+// sections.
+var platformNames = []string{"windows", "darwin", "linux", "freebsd", "netbsd", "openbsd", "dragonfly", "solaris"}
+
+// isPlatformSection reports whether a section, such as [work.windows], holds
+// the settings of its parent account for one operating system rather than
+// being an account itself.
+func isPlatformSection(name string) bool {
+	for _, p := range platformNames {
+		if strings.HasSuffix(name, "."+p) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyPlatformSection merges the settings from the account's section for
+// the running operating system, for example [work.darwin], over the
+// account's settings.
+func (c configuration) applyPlatformSection(acct *ini.Section) {
+	sec, err := c.cfg.GetSection(acct.Name() + "." + runtime.GOOS)
+	if err != nil {
+		return
+	}
+
+	l.Printf("Applying %s settings from [%s]\n", runtime.GOOS, sec.Name())
+	for _, k := range sec.Keys() {
+		acct.NewKey(k.Name(), k.String())
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+
+	"gopkg.in/ini.v1"
+)
+
+func utf16Bytes(s string, bigEndian bool) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		if bigEndian {
+			b = append(b, byte(u>>8), byte(u))
+		} else {
+			b = append(b, byte(u), byte(u>>8))
+		}
+	}
+	return b
+}
+
+func TestReadConfigFile(t *testing.T) {
+	const text = "[prod]\nurl = https://idp.example.com/saml\nusername = \"alice smith\"\nrole = 'Admin Role'\n"
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"plain", []byte(text)},
+		{"UTF-8 BOM", append([]byte{0xef, 0xbb, 0xbf}, text...)},
+		{"UTF-16 LE BOM", append([]byte{0xff, 0xfe}, utf16Bytes(text, false)...)},
+		{"UTF-16 BE BOM", append([]byte{0xfe, 0xff}, utf16Bytes(text, true)...)},
+		{"CRLF", []byte("[prod]\r\nurl = https://idp.example.com/saml\r\nusername = \"alice smith\"\r\nrole = 'Admin Role'\r\n")},
+		{"CR", []byte("[prod]\rurl = https://idp.example.com/saml\rusername = \"alice smith\"\rrole = 'Admin Role'\r")},
+		{"UTF-16 LE BOM and CRLF", append([]byte{0xff, 0xfe}, utf16Bytes("[prod]\r\nurl = https://idp.example.com/saml\r\nusername = \"alice smith\"\r\nrole = 'Admin Role'\r\n", false)...)},
+	}
+
+	dir, err := ioutil.TempDir("", "aws-cli-federator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, "config.ini")
+		if err := ioutil.WriteFile(path, tt.data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		data, err := readConfigFile(path)
+		if err != nil {
+			t.Errorf("%s: readConfigFile: %s", tt.name, err)
+			continue
+		}
+		cfg, err := ini.Load(data)
+		if err != nil {
+			t.Errorf("%s: ini.Load: %s", tt.name, err)
+			continue
+		}
+
+		sec := cfg.Section("prod")
+		want := map[string]string{
+			"url":      "https://idp.example.com/saml",
+			"username": "alice smith",
+			"role":     "Admin Role",
+		}
+		for k, v := range want {
+			if got := sec.Key(k).String(); got != v {
+				t.Errorf("%s: %s = %q, want %q", tt.name, k, got, v)
+			}
+		}
+	}
+}
+
+func TestReadConfigFileMissing(t *testing.T) {
+	if _, err := readConfigFile(filepath.Join(os.TempDir(), "aws-cli-federator-missing.ini")); !os.IsNotExist(err) {
+		t.Errorf("readConfigFile of a missing file returned %v, want a not-exist error", err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+// configKey describes a setting understood in the federatedcli
+// configuration file.  It is used to validate the file and by the docs
+// command.
+type configKey struct {
+	name string
+	// global keys are set at the top of the file, before any section.
+	global      bool
+	description string
+	// provider is the idp_type the key only applies to, if any.
+	provider string
+	// secret keys are left out of support bundles.
+	secret bool
+}
+
+var configKeys = []configKey{
+	{name: "default_account", global: true, description: "account used when none is given on the command line"},
+	{name: "project_config", global: true, description: "enable .awsfederator project configuration overlays"},
+	{name: "plain", global: true, description: "print progress and prompts as plain lines, as with -plain"},
+	{name: "account_map_url", global: true, description: "URL of a JSON object naming account IDs, used for accounts [account_map] doesn't name"},
+
+	{name: "account_type", description: "how credentials are obtained: saml (default) federation, or sso for AWS IAM Identity Center"},
+	{name: "sp_identity_url", description: "URL of the IdP initiated login for AWS"},
+	{name: "sso_start_url", description: "AWS access portal URL of an account_type sso account"},
+	{name: "sso_region", description: "region AWS IAM Identity Center is enabled in, for account_type sso"},
+	{name: "idp_type", description: "how to log in to the IdP: form (default) or a registered provider such as okta, azure, google, ping, onelogin, jumpcloud or keycloak"},
+	{name: "idp_command", description: "external program which returns the SAML assertion, used when idp_type is command or unset", provider: "command", secret: true},
+	{name: "auth_backend", description: "how IdP pages are driven: http (default) or browser-headless, which needs a build with TAGS=chromedp"},
+	{name: "headless_show", description: "show the Chrome window used by the browser-headless backend", provider: "browser-headless"},
+	{name: "form_username_field", description: "name of the login form input receiving the username, guessed by default", provider: "form"},
+	{name: "form_password_field", description: "name of the login form input receiving the password, guessed by default", provider: "form"},
+	{name: "form_extra_fields", description: "comma separated name=value fields set in every login form posted to the IdP", provider: "form", secret: true},
+	{name: "browser_callback", description: "loopback address the browser login listens on for the SAMLResponse, 127.0.0.1:21600 by default", provider: "browser"},
+	{name: "onelogin_client_id", description: "OneLogin API client ID", provider: "onelogin"},
+	{name: "onelogin_client_secret", description: "OneLogin API client secret", provider: "onelogin", secret: true},
+	{name: "onelogin_region", description: "OneLogin API region, us (default) or eu", provider: "onelogin"},
+	{name: "adfs_wstrust_endpoint", description: "ADFS WS-Trust usernamemixed endpoint for idp_type adfs-wstrust, derived from sp_identity_url by default", provider: "adfs-wstrust"},
+	{name: "adfs_relying_party", description: "identifier of the AWS relying party trust for idp_type adfs-wstrust, urn:amazon:webservices by default", provider: "adfs-wstrust"},
+	{name: "ecp_endpoint", description: "Shibboleth ECP endpoint for idp_type shibboleth-ecp, derived from sp_identity_url by default", provider: "shibboleth-ecp"},
+	{name: "ecp_sp_entity_id", description: "entity ID AWS is known by at the IdP for idp_type shibboleth-ecp, urn:amazon:webservices by default", provider: "shibboleth-ecp"},
+	{name: "username", description: "IdP username"},
+	{name: "password", description: "IdP password", secret: true},
+	{name: "assume_role", description: "role to assume without prompting, as given in the SAML assertion"},
+	{name: "role_pattern", description: "glob matched against role ARNs or names to choose a role"},
+	{name: "role_policy", description: "ordered rules choosing a role by role:, account: and tag: conditions, or prompt"},
+	{name: "role_lookup", description: "ssm: or s3:// location of a centrally managed role mapping"},
+	{name: "role_lookup_role", description: "read-only role used to read role_lookup"},
+	{name: "remember_role", description: "remember the last selected role (true or git)"},
+	{name: "tags", description: "comma separated tags used to select accounts with -tag"},
+	{name: "profile", description: "credential profile to write to"},
+	{name: "prewarm_source_profiles", description: "assume the roles of profiles using profile as their source_profile"},
+	{name: "session_policy", description: "IAM policy limiting the credentials, as a JSON file or inline JSON document"},
+	{name: "chain_role_arn", description: "role assumed with the federated role's credentials, whose credentials are written instead"},
+	{name: "external_id", description: "external ID required by the trust policy of chain_role_arn"},
+	{name: "session_name", description: "session name for chain_role_arn ({username}, {account}, {timestamp})"},
+	{name: "session_tags", description: "comma separated Key=Value session tags for chain_role_arn ({username}, {account}, {timestamp})"},
+	{name: "session_duration", description: "how long credentials last, such as 4h, between 15m and 12h and no longer than the role's maximum (default 1h)"},
+	{name: "refresh_margin", description: "minimum validity of cached credentials reused with -output credential_process (default 10m)"},
+	{name: "region", description: "AWS region exported with the credentials"},
+	{name: "batch_profile", description: "credential profile template used by batch ({account}, {account_id}, {role})"},
+	{name: "batch_rate", description: "maximum roles assumed per second by batch"},
+	{name: "webhook_url", description: "URL a JSON event is posted to after each role is assumed", secret: true},
+	{name: "transport_cmd", description: "command whose stdio IdP connections are tunnelled through (%h host, %p port)"},
+	{name: "transport_cmd_sts", description: "also tunnel STS connections through transport_cmd"},
+	{name: "proxy", description: "proxy for IdP and STS requests: env (default), system, none or a URL"},
+	{name: "saml_acs_url", description: "assertion consumer service URL the IdP posts to, for AWS compatible clouds (default https://signin.aws.amazon.com/saml)"},
+	{name: "saml_audience", description: "entity ID the service provider is known by at the IdP, for ECP and ADFS WS-Trust (default urn:amazon:webservices)"},
+	{name: "sts_region", description: "region whose STS endpoint roles are assumed with, instead of the global endpoint"},
+	{name: "endpoint_url", description: "endpoint AWS requests are sent to, such as LocalStack or moto in tests (sts_endpoint takes precedence for STS)"},
+	{name: "sts_endpoint", description: "URL of an STS compatible endpoint to assume roles with, for AWS compatible clouds and emulators"},
+	{name: "host_aliases", description: "comma separated host=address pairs dialed instead of DNS"},
+	{name: "cache_assertion", description: "set to false to stop reusing the SAML assertion of an earlier login while it is valid"},
+	{name: "http_cache", description: "set to false to stop caching the IdP's cacheable responses between logins"},
+	{name: "ntlm", description: "answer NTLM challenges from the IdP, for ADFS's /adfs/ls/auth/integrated endpoint", provider: "form"},
+	{name: "totp_secret", description: "base32 TOTP secret used to generate MFA codes", secret: true},
+	{name: "mfa_preference", description: "comma separated MFA factors to use when several are enrolled: push, totp, sms, call, token, webauthn or duo (Okta, Azure AD)"},
+	{name: "duo_factor", description: "Duo factor to use: push (default), passcode or phone"},
+	{name: "mfa_command", description: "command printing an MFA code", secret: true},
+	{name: "webauthn_command", description: "command signing WebAuthn requests with a security key, instead of libfido2's fido2-assert (Okta)", provider: "okta"},
+	{name: "webauthn_device", description: "security key used through fido2-assert, as listed by fido2-token -L, instead of the first found (Okta)", provider: "okta"},
+	{name: "prompt", description: "how to prompt for secrets (pinentry)"},
+	{name: "pinentry_program", description: "pinentry binary to use"},
+	{name: "keychain", description: "store the password in the system keychain"},
+	{name: "client_cert", description: "client certificate presented to the IdP, as a PEM file or a PKCS#12 .p12/.pfx file"},
+	{name: "client_key", description: "private key for client_cert"},
+	{name: "client_cert_password", description: "password of a PKCS#12 client_cert, asked for if not set", secret: true},
+}
+
+// lookupConfigKey returns the definition of the named key.
+func lookupConfigKey(name string) (configKey, bool) {
+	for _, k := range configKeys {
+		if k.name == name {
+			return k, true
+		}
+	}
+	return configKey{}, false
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"golang.org/x/crypto/ssh/terminal"
+	"gopkg.in/ini.v1"
+)
+
+func init() {
+	commands["configure"] = configure
+}
+
+// configure asks for the settings of an account and adds it to the
+// configuration file, creating the file if it doesn't exist.
+func configure(args []string) error {
+	c.resolvePath()
+	return configureAccount(c.path, bufio.NewReader(os.Stdin))
+}
+
+// firstRun explains that the configuration file is missing and, when
+// running interactively, offers to create it with the configure wizard.
+func (c *configuration) firstRun() error {
+	missing := fmt.Errorf("%s does not exist.  Run 'aws-cli-federator configure' to create it", c.path)
+
+	fmt.Fprintf(os.Stderr, "No configuration file was found at %s.\n", c.path)
+	fmt.Fprintf(os.Stderr, "It tells aws-cli-federator where your IdP's login page for AWS is, and how to sign in to it.\n")
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) || !terminal.IsTerminal(int(os.Stderr.Fd())) {
+		return missing
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	fmt.Fprint(os.Stderr, "Create it now? [Y/n]: ")
+	line, _ := in.ReadString('\n')
+	if answer := strings.ToLower(strings.TrimSpace(line)); answer != "" && answer != "y" && answer != "yes" {
+		return missing
+	}
+	if err := configureAccount(c.path, in); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr)
+	return nil
+}
+
+// configureAccount prompts on in for an account's settings and appends its
+// section to the configuration file at path.
+func configureAccount(path string, in *bufio.Reader) error {
+	var existing []byte
+	if data, err := readConfigFile(path); err == nil {
+		existing = data
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Adding an account to %s.  Press enter to accept the [default].\n", path)
+
+	name, err := ask(in, "Account name", "default", func(v string) error {
+		if cfg, err := ini.Load(existing); err == nil {
+			if _, err := cfg.GetSection(v); err == nil {
+				return fmt.Errorf("Account [%s] is already configured", v)
+			}
+		}
+		if strings.ContainsAny(v, "[]") {
+			return fmt.Errorf("Account names can't contain [ or ]")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	spURL, err := ask(in, "IdP initiated login URL for AWS (sp_identity_url)", "", func(v string) error {
+		u, err := url.ParseRequestURI(v)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+			return fmt.Errorf("Enter the full URL, starting with https://")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	idpType, err := ask(in, fmt.Sprintf("IdP type, one of %s", strings.Join(federator.ProviderTypes(), ", ")), "form", func(v string) error {
+		if !knownProvider(v) {
+			return fmt.Errorf("Unknown IdP type '%s'", v)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	settings := []struct{ key, prompt string }{
+		{"username", "Username (leave empty to be asked each time)"},
+		{"region", "AWS region (leave empty for none)"},
+		{"profile", "Credential profile to save credentials to (leave empty to print them instead)"},
+	}
+	values := make(map[string]string)
+	for _, s := range settings {
+		if values[s.key], err = ask(in, s.prompt, "", nil); err != nil {
+			return err
+		}
+	}
+
+	var section bytes.Buffer
+	if len(existing) > 0 {
+		section.WriteString("\n")
+	}
+	fmt.Fprintf(&section, "[%s]\nsp_identity_url = %s\n", name, spURL)
+	if idpType != "form" {
+		fmt.Fprintf(&section, "idp_type = %s\n", idpType)
+	}
+	for _, s := range settings {
+		if v := values[s.key]; v != "" {
+			fmt.Fprintf(&section, "%s = %s\n", s.key, v)
+		}
+	}
+
+	if err := writeConfig(path, append(existing, section.Bytes()...)); err != nil {
+		return fmt.Errorf("Unable to write %s: %s", path, err)
+	}
+
+	run := "aws-cli-federator"
+	if name != "default" {
+		run += " -account " + name
+	}
+	fmt.Fprintf(os.Stderr, "Added [%s] to %s.  Log in with '%s'.\n", name, path, run)
+	return nil
+}
+
+// ask prompts for a value until one is given, or def is accepted, which
+// valid, if set, accepts.
+func ask(in *bufio.Reader, prompt, def string, valid func(string) error) (string, error) {
+	for {
+		if def != "" {
+			fmt.Fprintf(os.Stderr, "%s [%s]: ", prompt, def)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: ", prompt)
+		}
+
+		line, err := in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", fmt.Errorf("Configuration cancelled")
+		}
+		v := strings.TrimSpace(line)
+		if v == "" {
+			v = def
+		}
+		if v == "" && valid == nil {
+			return "", nil
+		}
+
+		if v == "" {
+			fmt.Fprintln(os.Stderr, "A value is required.")
+		} else if valid == nil {
+			return v, nil
+		} else if err := valid(v); err != nil {
+			fmt.Fprintf(os.Stderr, "%s.\n", err)
+		} else {
+			return v, nil
+		}
+	}
+}
+
+// writeConfig replaces the configuration file with data, keeping its
+// permissions.  A new file, and its directory, are only readable by the user
+// as it may hold passwords.
+func writeConfig(path string, data []byte) error {
+	perm := os.FileMode(0600)
+	if fi, err := os.Stat(path); err == nil {
+		perm = fi.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return federator.WriteFileAtomic(path, data, perm)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kardianos/osext"
+)
+
+func init() {
+	commands["configure-credential-process"] = configureCredentialProcess
+}
+
+// configureCredentialProcess sets credential_process for a profile in
+// ~/.aws/config, so that the AWS CLI and SDKs run this tool for the account
+// given with -account whenever the profile needs credentials.  They are
+// cached in the credentials file under '<profile>-cache'.
+func configureCredentialProcess(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("configure-credential-process takes the name of the profile to configure")
+	}
+	p := c.profile
+	if len(args) == 1 {
+		p = args[0]
+	}
+	if p == "" || c.account == "" {
+		return fmt.Errorf("configure-credential-process needs an account given with -account, and the profile to configure")
+	}
+
+	// the path is only passed on if it was given, so that moving the
+	// home directory doesn't break the profile
+	explicitPath := c.path != ""
+	if err := c.loadConfigurationFile(); err != nil {
+		return fmt.Errorf("Unable to parse configuration file: %s", err)
+	}
+	if _, err := c.cfg.GetSection(c.account); err != nil {
+		return fmt.Errorf("Account '%s' is not configured in %s", c.account, c.path)
+	}
+
+	self, err := osext.Executable()
+	if err != nil {
+		return fmt.Errorf("Unable to locate executable: %s", err)
+	}
+	command := []string{self, "-account", c.account}
+	if explicitPath {
+		path, err := filepath.Abs(c.path)
+		if err != nil {
+			return err
+		}
+		command = append(command, "-path", path)
+	}
+	if c.role != "" {
+		command = append(command, "-role", c.role)
+	}
+	command = append(command, "-profile", p+"-cache", "-output", "credential_process")
+	for i, arg := range command {
+		command[i] = processArg(arg)
+	}
+	process := strings.Join(command, " ")
+
+	path, err := awsConfigPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("Unable to create %s: %s", filepath.Dir(path), err)
+	}
+
+	unlock, err := lockState()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := recoverTxn(); err != nil {
+		return fmt.Errorf("Unable to finish an interrupted write: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Unable to load %s: %s", path, err)
+	}
+
+	name := "profile " + p
+	if p == "default" {
+		name = "default"
+	}
+	updated, old, err := setCredentialProcess(data, name, process)
+	if err != nil {
+		return fmt.Errorf("Profile '%s' in %s %s", p, path, err)
+	}
+	if old == process {
+		fmt.Fprintf(os.Stderr, "Profile '%s' in %s is already configured.\n", p, path)
+		return nil
+	}
+	if old != "" {
+		fmt.Fprintf(os.Stderr, "Replacing credential_process = %s\n", old)
+	}
+
+	var txn fileTxn
+	defer txn.abort()
+
+	if err := txn.write(path, updated, 0600); err != nil {
+		return fmt.Errorf("Unable to save %s: %s", path, err)
+	}
+	if err := txn.commit(); err != nil {
+		return fmt.Errorf("Unable to save %s: %s", path, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Profile '%s' in %s now gets credentials for account '%s' from aws-cli-federator, caching them in profile '%s'.\n", p, path, c.account, p+"-cache")
+	fmt.Fprintf(os.Stderr, "Try it with: aws sts get-caller-identity --profile %s\n", p)
+	return nil
+}
+
+// processArg quotes arg for the command line of credential_process, which
+// the AWS CLI and SDKs split on whitespace outside double quotes.
+// Backslashes are left alone, as they separate Windows paths.
+func processArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'") {
+		return arg
+	}
+	return `"` + strings.Replace(arg, `"`, `\"`, -1) + `"`
+}
+
+// setCredentialProcess sets credential_process in the section name of the
+// AWS config file data, returning the file and the value it replaced.  The
+// file is edited as text, as rewriting it with ini would flatten nested
+// settings such as those under s3; every other line is left as it was.
+func setCredentialProcess(data []byte, name, process string) ([]byte, string, error) {
+	newline := "\n"
+	if bytes.Contains(data, []byte("\r\n")) {
+		newline = "\r\n"
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	start, end := -1, len(lines)
+	for i, line := range lines {
+		t := strings.TrimSpace(line)
+		if !strings.HasPrefix(t, "[") || !strings.HasSuffix(t, "]") {
+			continue
+		}
+		if start >= 0 {
+			end = i
+			break
+		}
+		if strings.Join(strings.Fields(t[1:len(t)-1]), " ") == name {
+			start = i
+		}
+	}
+
+	entry := "credential_process = " + process + newline
+	if start < 0 {
+		var buf bytes.Buffer
+		buf.Write(data)
+		if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+			buf.WriteString(newline)
+		}
+		if len(data) > 0 {
+			buf.WriteString(newline)
+		}
+		buf.WriteString("[" + name + "]" + newline + entry)
+		return buf.Bytes(), "", nil
+	}
+
+	// settings sourcing credentials some other way would be ambiguous;
+	// indented lines are nested settings, such as those under s3
+	var conflicts []string
+	old, at, last := "", -1, start
+	for i := start + 1; i < end; i++ {
+		line := lines[i]
+		t := strings.TrimSpace(line)
+		if t == "" || t[0] == '#' || t[0] == ';' {
+			continue
+		}
+		last = i
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		eq := strings.Index(t, "=")
+		if eq < 0 {
+			continue
+		}
+		k := strings.TrimSpace(t[:eq])
+		switch {
+		case k == "credential_process":
+			old, at = strings.TrimSpace(t[eq+1:]), i
+		case k == "role_arn" || k == "web_identity_token_file" || strings.HasPrefix(k, "sso_"):
+			conflicts = append(conflicts, k)
+		}
+	}
+	if len(conflicts) > 0 {
+		return nil, "", fmt.Errorf("already sources credentials with %s; remove them first", strings.Join(conflicts, ", "))
+	}
+
+	if at >= 0 {
+		lines[at] = entry
+	} else {
+		if !strings.HasSuffix(lines[last], "\n") {
+			lines[last] += newline
+		}
+		lines = append(lines[:last+1], append([]string{entry}, lines[last+1:]...)...)
+	}
+	return []byte(strings.Join(lines, "")), old, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestSetCredentialProcess(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		section string
+		want    string
+		old     string
+		err     bool
+	}{
+		{
+			name:    "empty file",
+			config:  "",
+			section: "profile dev",
+			want:    "[profile dev]\ncredential_process = acf\n",
+		},
+		{
+			name:    "new profile keeps nested settings",
+			config:  "[default]\nregion = eu-west-1\ns3 =\n  max_concurrent_requests = 20\n",
+			section: "profile dev",
+			want:    "[default]\nregion = eu-west-1\ns3 =\n  max_concurrent_requests = 20\n\n[profile dev]\ncredential_process = acf\n",
+		},
+		{
+			name:    "added after nested settings",
+			config:  "[profile dev]\nregion = eu-west-1\ns3 =\n  max_concurrent_requests = 20\n\n[profile prod]\nregion = us-east-1\n",
+			section: "profile dev",
+			want:    "[profile dev]\nregion = eu-west-1\ns3 =\n  max_concurrent_requests = 20\ncredential_process = acf\n\n[profile prod]\nregion = us-east-1\n",
+		},
+		{
+			name:    "replaced in place",
+			config:  "# mine\n[profile  dev]\ncredential_process = old --flag\nregion = eu-west-1\n",
+			section: "profile dev",
+			want:    "# mine\n[profile  dev]\ncredential_process = acf\nregion = eu-west-1\n",
+			old:     "old --flag",
+		},
+		{
+			name:    "CRLF and no final newline",
+			config:  "[default]\r\nregion = eu-west-1",
+			section: "default",
+			want:    "[default]\r\nregion = eu-west-1\r\ncredential_process = acf\r\n",
+		},
+		{
+			name:    "nested role_arn is not a conflict",
+			config:  "[profile dev]\nservices =\n  role_arn = x\n",
+			section: "profile dev",
+			want:    "[profile dev]\nservices =\n  role_arn = x\ncredential_process = acf\n",
+		},
+		{
+			name:    "conflicting sso settings",
+			config:  "[profile dev]\nsso_start_url = https://example.awsapps.com/start\n",
+			section: "profile dev",
+			err:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		got, old, err := setCredentialProcess([]byte(tt.config), tt.section, "acf")
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+		if old != tt.old {
+			t.Errorf("%s: replaced %q, want %q", tt.name, old, tt.old)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"github.com/aidan-/aws-cli-federator/platform"
+)
+
+// cookieKeyAccount names the keychain entry holding the key cookie files are
+// encrypted with.
+const cookieKeyAccount = "cookie-encryption-key"
+
+// persistCookies gives fed a cookie jar restored from the account's
+// encrypted cookie file, so that the IdP's session and remembered device
+// cookies are reused.  The returned function saves the jar again and should
+// be called once the login has succeeded.
+func persistCookies(account string, fed *federator.Federator) (func(), error) {
+	path, err := statePath("cookies-" + account)
+	if err != nil {
+		return nil, err
+	}
+	key, err := cookieKey()
+	if err != nil {
+		return nil, err
+	}
+
+	jar, err := federator.NewPersistentJar()
+	if err != nil {
+		return nil, err
+	}
+	if data, err := ioutil.ReadFile(path); err == nil {
+		if plain, err := decryptCookies(key, data); err != nil {
+			l.Printf("Ignoring cookie file %s: %s\n", path, err)
+		} else if err := jar.Load(bytes.NewReader(plain)); err != nil {
+			l.Printf("Ignoring cookie file %s: %s\n", path, err)
+		} else {
+			l.Printf("Restored IdP cookies from %s\n", path)
+		}
+	}
+	fed.SetCookieJar(jar)
+
+	return func() {
+		var buf bytes.Buffer
+		if err := jar.Save(&buf); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: Could not save IdP cookies: %s\n", err)
+			return
+		}
+		data, err := encryptCookies(key, buf.Bytes())
+		if err == nil {
+			err = writeState("cookies-"+account, data)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: Could not save IdP cookies: %s\n", err)
+		}
+	}, nil
+}
+
+// cookieKey returns the key cookie files and cached SAML assertions are
+// encrypted with, creating it on
+// first use.  It is kept in the system keychain, or in a file only the user
+// can read in the state directory when there is no keychain.
+func cookieKey() ([]byte, error) {
+	keychain := platform.Native()
+	s, err := keychain.KeychainGet(keychainService, cookieKeyAccount)
+	switch err {
+	case nil:
+		return base64.StdEncoding.DecodeString(s)
+	case platform.ErrNotFound:
+		key, err := newCookieKey()
+		if err != nil {
+			return nil, err
+		}
+		if err := keychain.KeychainSet(keychainService, cookieKeyAccount, base64.StdEncoding.EncodeToString(key)); err == nil {
+			return key, nil
+		}
+		l.Printf("Unable to store the cookie key in the keychain, using a key file\n")
+	}
+
+	// held until the key is saved, so that concurrent logins don't each
+	// create one and encrypt with a key which is then replaced
+	unlock, err := lockState()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	path, err := statePath("cookies.key")
+	if err != nil {
+		return nil, err
+	}
+	if data, err := ioutil.ReadFile(path); err == nil {
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	}
+
+	key, err := newCookieKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := federator.WriteFileAtomic(path, []byte(base64.StdEncoding.EncodeToString(key)), 0600); err != nil {
+		return nil, fmt.Errorf("Unable to save cookie key: %s", err)
+	}
+	return key, nil
+}
+
+func newCookieKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("Unable to generate cookie key: %s", err)
+	}
+	return key, nil
+}
+
+// encryptCookies seals plain with AES-GCM, prefixing the random nonce.
+func encryptCookies(key, plain []byte) ([]byte, error) {
+	gcm, err := cookieCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+func decryptCookies(key, data []byte) ([]byte, error) {
+	gcm, err := cookieCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("file is truncated")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt, the key may have changed")
+	}
+	return plain, nil
+}
+
+func cookieCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid cookie key: %s", err)
+	}
+	return cipher.NewGCM(block)
+}
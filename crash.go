@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+// secretPatterns match credential material which may end up in a panic
+// message without having been registered with addSecret.
+var secretPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// access key IDs
+	{regexp.MustCompile(`\b(?:AKIA|ASIA)[A-Z0-9]{16}\b`), "[REDACTED]"},
+	// SAML assertions, session tokens and secret access keys
+	{regexp.MustCompile(`[A-Za-z0-9+/]{40,}={0,2}`), "[REDACTED]"},
+	// key=value pairs, as found in INI files, URLs and form bodies
+	{regexp.MustCompile(`(?i)((?:password|passwd|pass|secret|token|SAMLResponse|otp)\s*[=:]\s*)[^\s&"',;]+`), "${1}[REDACTED]"},
+}
+
+var (
+	secretsMu sync.Mutex
+	secrets   []string
+)
+
+// addSecret registers values which must never appear in crash output or
+// HTTP traces.  They are also registered as they appear in form and JSON
+// request bodies, where characters such as & are escaped.
+func addSecret(values ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+
+	for _, v := range values {
+		// short values would scrub unrelated text
+		if len(v) < 4 {
+			continue
+		}
+		secrets = append(secrets, secretForms(v)...)
+	}
+}
+
+// secretForms returns the distinct forms of v: itself, its URL query
+// encoding and its JSON encoding with and without HTML characters escaped,
+// as encoding/json does by default.
+func secretForms(v string) []string {
+	candidates := []string{v, url.QueryEscape(v)}
+	var buf bytes.Buffer
+	for _, escapeHTML := range []bool{true, false} {
+		buf.Reset()
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(escapeHTML)
+		if enc.Encode(v) == nil {
+			quoted := strings.TrimSpace(buf.String())
+			candidates = append(candidates, quoted[1:len(quoted)-1])
+		}
+	}
+
+	var forms []string
+	seen := make(map[string]bool)
+	for _, f := range candidates {
+		if !seen[f] {
+			seen[f] = true
+			forms = append(forms, f)
+		}
+	}
+	return forms
+}
+
+// scrub removes registered secrets and anything that looks like credential
+// material from s.
+func scrub(s string) string {
+	secretsMu.Lock()
+	for _, v := range secrets {
+		s = strings.Replace(s, v, "[REDACTED]", -1)
+	}
+	secretsMu.Unlock()
+
+	for _, p := range secretPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
+
+// handlePanic replaces the runtime's crash output with a scrubbed message and
+// writes a scrubbed crash report to the state directory.  It must be
+// deferred at the start of main, and does not see panics in other
+// goroutines.
+func handlePanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	msg := scrub(fmt.Sprint(r))
+	report := scrub(fmt.Sprintf("%s version %s (%s, %s/%s)\ntime: %s\nargs: %s\n\npanic: %s\n\n%s",
+		"aws-cli-federator", Version, runtime.Version(), runtime.GOOS, runtime.GOARCH,
+		time.Now().UTC().Format(time.RFC3339), strings.Join(os.Args[1:], " "), msg, debug.Stack()))
+
+	fmt.Fprintf(os.Stderr, "ERROR: aws-cli-federator crashed: %s\n", msg)
+	p, err := statePath(fmt.Sprintf("crash-%s.txt", time.Now().UTC().Format("20060102T150405Z")))
+	if err == nil {
+		err = federator.WriteFileAtomic(p, []byte(report), 0600)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write crash report: %s\n\n%s", err, report)
+	} else {
+		fmt.Fprintf(os.Stderr, "A crash report with secrets removed was written to %s.  Please attach it when reporting this issue.\n", p)
+	}
+	os.Exit(2)
+}
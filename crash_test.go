@@ -0,0 +1,22 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScrubEncodedSecrets(t *testing.T) {
+	addSecret(`Zq&ss w<rd"1`)
+
+	bodies := []string{
+		`{"username":"alice","password":"Zq\u0026ss w\u003crd\"1"}`,
+		`{"username":"alice","password":"Zq&ss w<rd\"1"}`,
+		`user=alice&pwd=Zq%26ss+w%3Crd%221`,
+		`password is Zq&ss w<rd"1`,
+	}
+	for _, body := range bodies {
+		if got := traceScrub([]byte(body)); strings.Contains(got, "Zq") {
+			t.Errorf("%s: scrubbed to %s", body, got)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"gopkg.in/ini.v1"
+)
+
+// cachedCredentials are credentials issued for a role of an account, kept
+// in credentials.json in the state directory so that running the tool again
+// while they are valid needs no login.
+type cachedCredentials struct {
+	Account string `json:"account"`
+
+	// Federated is the role assumed with SAML or AWS SSO, which is what
+	// -role and assume_role name.  Role is the role the credentials are
+	// for, which differs when chain_role_arn is set.
+	Federated string `json:"federated_role"`
+	Role      string `json:"role"`
+
+	// Policy identifies the session policy limiting the credentials, as
+	// credentials are only reused with the same one.
+	Policy string `json:"policy,omitempty"`
+
+	// Settings identifies the configuration the credentials were issued
+	// under, so that changing the IdP, chained role or duration, or using
+	// another configuration file, logs in again.
+	Settings string `json:"settings,omitempty"`
+
+	AccessKeyID     string    `json:"access_key_id"`
+	SecretAccessKey string    `json:"secret_access_key"`
+	SessionToken    string    `json:"session_token"`
+	Expiration      time.Time `json:"expiration"`
+}
+
+func (e cachedCredentials) credentials() federator.Credentials {
+	return federator.Credentials{
+		AccessKeyId:     e.AccessKeyID,
+		SecretAccessKey: e.SecretAccessKey,
+		SessionToken:    e.SessionToken,
+		Expiration:      e.Expiration,
+	}
+}
+
+// policyDigest identifies a session policy without keeping it.
+func policyDigest(policy string) string {
+	if policy == "" {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha1.Sum([]byte(policy)))
+}
+
+// settingsDigest identifies the settings of acct which change the
+// credentials issued for a role, including the IdP and the STS endpoint
+// which issued them.  Session names and tags are taken as configured,
+// before {timestamp} and the like are expanded.
+func settingsDigest(acct *ini.Section, duration time.Duration) string {
+	settings := []string{c.path, duration.String()}
+	for _, k := range []string{"idp_type", "sp_identity_url", "saml_acs_url", "sts_region", "sts_endpoint", "endpoint_url", "chain_role_arn", "external_id", "session_name", "session_tags"} {
+		settings = append(settings, k+"="+acct.Key(k).String())
+	}
+	return fmt.Sprintf("%x", sha1.Sum([]byte(strings.Join(settings, "\n"))))
+}
+
+// cachedRole returns credentials cached for the role of acct named by -role
+// or assume_role, if they remain valid for longer than its refresh_margin
+// and were issued with the same session policy, duration and settings.
+func cachedRole(acct *ini.Section, policy string, duration time.Duration) (federator.Credentials, federator.Role, bool) {
+	want := c.role
+	if want == "" {
+		want = acct.Key("assume_role").String()
+	}
+	// credentials from -endpoint-url, such as LocalStack's, are never cached
+	if want == "" || *c.force || c.assertionGiven() || c.endpointURL != "" {
+		return federator.Credentials{}, "", false
+	}
+	margin, err := refreshMargin(acct)
+	if err != nil {
+		return federator.Credentials{}, "", false
+	}
+
+	cache, err := loadCredentialCache()
+	if err != nil {
+		l.Printf("Unable to read cached credentials: %s\n", err)
+		return federator.Credentials{}, "", false
+	}
+	for _, e := range cache {
+		federated := federator.Role(e.Federated)
+		if e.Account != acct.Name() || e.Policy != policyDigest(policy) || e.Settings != settingsDigest(acct, duration) {
+			continue
+		}
+		if string(federated) != want && !roleMatches(federated, want) {
+			continue
+		}
+		if e.Expiration.Before(time.Now().Add(margin)) {
+			l.Printf("Cached credentials for %s expire within the refresh margin of %s\n", federated.RoleArn(), margin)
+			continue
+		}
+		addSecret(e.AccessKeyID, e.SecretAccessKey, e.SessionToken)
+		return e.credentials(), federator.Role(e.Role), true
+	}
+	return federator.Credentials{}, "", false
+}
+
+// cacheCredentials caches creds for role, assumed through federated, and
+// drops expired entries.
+func cacheCredentials(acct *ini.Section, federated, role federator.Role, policy string, duration time.Duration, creds federator.Credentials) error {
+	unlock, err := lockState()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cache, err := loadCredentialCache()
+	if err != nil {
+		return err
+	}
+	kept := []cachedCredentials{{
+		Account:         acct.Name(),
+		Federated:       string(federated),
+		Role:            string(role),
+		Policy:          policyDigest(policy),
+		Settings:        settingsDigest(acct, duration),
+		AccessKeyID:     creds.AccessKeyId,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+		Expiration:      creds.Expiration,
+	}}
+	for _, e := range cache {
+		if e.Expiration.Before(time.Now()) || (e.Account == kept[0].Account && e.Federated == kept[0].Federated && e.Policy == kept[0].Policy && e.Settings == kept[0].Settings) {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	return saveCredentialCache(kept)
+}
+
+// forgetCachedCredentials removes the cached credentials for the role arn,
+// as when its sessions are revoked.
+func forgetCachedCredentials(arn string) error {
+	unlock, err := lockState()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cache, err := loadCredentialCache()
+	if err != nil {
+		return err
+	}
+	var kept []cachedCredentials
+	for _, e := range cache {
+		if federator.Role(e.Role).RoleArn() != arn && federator.Role(e.Federated).RoleArn() != arn {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) == len(cache) {
+		return nil
+	}
+	return saveCredentialCache(kept)
+}
+
+func loadCredentialCache() ([]cachedCredentials, error) {
+	path, err := statePath("credentials.json")
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var cache []cachedCredentials
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, fmt.Errorf("Invalid credential cache %s: %s", path, err)
+	}
+	return cache, nil
+}
+
+func saveCredentialCache(cache []cachedCredentials) error {
+	path, err := statePath("credentials.json")
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	return federator.WriteFileAtomic(path, data, 0600)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"gopkg.in/ini.v1"
+)
+
+// defaultRefreshMargin is how long credentials must remain valid to be handed
+// out again in credential_process mode when refresh_margin isn't set.
+const defaultRefreshMargin = 10 * time.Minute
+
+// refreshMargin returns the account's refresh_margin setting.
+func refreshMargin(acct *ini.Section) (time.Duration, error) {
+	if !acct.HasKey("refresh_margin") {
+		return defaultRefreshMargin, nil
+	}
+
+	d, err := time.ParseDuration(acct.Key("refresh_margin").String())
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("refresh_margin must be a duration such as '10m', found '%s'", acct.Key("refresh_margin").String())
+	}
+	return d, nil
+}
+
+// cachedProcessCredentials returns the credentials stored in profile p if
+// they remain valid for longer than the account's refresh margin, so that
+// credential_process invocations don't log in every time while never handing
+// out credentials about to expire mid-operation.
+func cachedProcessCredentials(acct *ini.Section, p string) (federator.Credentials, bool, error) {
+	margin, err := refreshMargin(acct)
+	if err != nil {
+		return federator.Credentials{}, false, err
+	}
+
+	creds, err := readProfileCredentials(p)
+	if err != nil {
+		l.Printf("Unable to read credential profile: %s\n", err)
+		return creds, false, nil
+	}
+	if creds.Expiration.Before(time.Now().Add(margin)) {
+		l.Printf("Credentials in profile '%s' expire within the refresh margin of %s\n", p, margin)
+		return creds, false, nil
+	}
+	return creds, true, nil
+}
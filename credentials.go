@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"gopkg.in/ini.v1"
+)
+
+// credentialsPath returns the location of the shared AWS credentials file.
+func credentialsPath() (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("Unable to get current user information: %s", err)
+	}
+
+	return filepath.Join(usr.HomeDir, ".aws/credentials"), nil
+}
+
+// awsConfigPath returns the location of the shared AWS config file.
+func awsConfigPath() (string, error) {
+	if p := os.Getenv("AWS_CONFIG_FILE"); p != "" {
+		return p, nil
+	}
+
+	usr, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("Unable to get current user information: %s", err)
+	}
+
+	return filepath.Join(usr.HomeDir, ".aws/config"), nil
+}
+
+// conflictingConfigKeys returns the keys of profile p's stanza in
+// ~/.aws/config which cause the AWS CLI and SDKs to source credentials from
+// somewhere other than the static keys in the credentials file.
+func conflictingConfigKeys(p string) ([]string, error) {
+	path, err := awsConfigPath()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	cfg, err := ini.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load %s: %s", path, err)
+	}
+
+	name := "profile " + p
+	if p == "default" {
+		name = "default"
+	}
+	sec, err := cfg.GetSection(name)
+	if err != nil {
+		return nil, nil
+	}
+
+	var keys []string
+	for _, k := range sec.KeyStrings() {
+		switch {
+		case k == "credential_process", k == "role_arn", k == "web_identity_token_file":
+			keys = append(keys, k)
+		case strings.HasPrefix(k, "sso_"):
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func WriteAWSCredentials(c federator.Credentials, role federator.Role, p string) error {
+	cpath, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+
+	alias := accountAlias(role.AccountId())
+
+	unlock, err := lockState()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := recoverTxn(); err != nil {
+		return fmt.Errorf("Unable to finish an interrupted write: %s", err)
+	}
+
+	l.Printf("Writing to AWS credentials file: %s\n", cpath)
+	cfg, err := ini.Load(cpath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := cfg.GetSection(p); err != nil {
+		if _, err := cfg.NewSection(p); err != nil {
+			return fmt.Errorf("Unable to create credential profile: %s", err)
+		}
+	}
+
+	prof, err := cfg.GetSection(p)
+	if err != nil {
+		return fmt.Errorf("Unable to retrieve recently created profile: %s", err)
+	}
+
+	//aws_access_key_id
+	if _, err := prof.NewKey("aws_access_key_id", c.AccessKeyId); err != nil {
+		return fmt.Errorf("Unable to write aws_access_key_id to credential file: %s", err)
+	}
+
+	//aws_secret_access_key
+	if _, err := prof.NewKey("aws_secret_access_key", c.SecretAccessKey); err != nil {
+		return fmt.Errorf("Unable to write aws_secret_access_key to credential file: %s", err)
+	}
+
+	//aws_session_token
+	if _, err := prof.NewKey("aws_session_token", c.SessionToken); err != nil {
+		return fmt.Errorf("Unable to write aws_session_token to credential file: %s", err)
+	}
+
+	//expiry, so that tools such as the tray can display the remaining TTL
+	if _, err := prof.NewKey("x_security_token_expires", c.Expiration.Format(time.RFC3339)); err != nil {
+		return fmt.Errorf("Unable to write x_security_token_expires to credential file: %s", err)
+	}
+
+	//role, so that revoke can report which profiles it invalidated
+	if _, err := prof.NewKey("x_role_arn", role.RoleArn()); err != nil {
+		return fmt.Errorf("Unable to write x_role_arn to credential file: %s", err)
+	}
+
+	// the credentials and metadata are written together, so that they
+	// can't disagree about the profile
+	var txn fileTxn
+	defer txn.abort()
+
+	var buf bytes.Buffer
+	if _, err := cfg.WriteTo(&buf); err != nil {
+		return fmt.Errorf("Unable to save configuration to disk: %s", err)
+	}
+	if err := txn.write(cpath, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("Unable to save configuration to disk: %s", err)
+	}
+
+	if meta, err := profileRecord(p, c, role, alias); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: Unable to record metadata for profile '%s': %s\n", p, err)
+	} else if path, err := statePath("profiles.json"); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: Unable to record metadata for profile '%s': %s\n", p, err)
+	} else if err := txn.write(path, meta, 0600); err != nil {
+		return fmt.Errorf("Unable to record metadata for profile '%s': %s", p, err)
+	}
+
+	return txn.commit()
+}
+
+// readProfileCredentials reads the temporary credentials, and the expiry
+// recorded alongside them, stored in profile p.
+func readProfileCredentials(p string) (federator.Credentials, error) {
+	cpath, err := credentialsPath()
+	if err != nil {
+		return federator.Credentials{}, err
+	}
+
+	cfg, err := ini.Load(cpath)
+	if err != nil {
+		return federator.Credentials{}, err
+	}
+
+	prof, err := cfg.GetSection(p)
+	if err != nil {
+		return federator.Credentials{}, fmt.Errorf("Credential profile '%s' does not exist", p)
+	}
+
+	if !prof.HasKey("x_security_token_expires") {
+		return federator.Credentials{}, fmt.Errorf("Credential profile '%s' was not written by this tool", p)
+	}
+
+	exp, err := time.Parse(time.RFC3339, prof.Key("x_security_token_expires").String())
+	if err != nil {
+		return federator.Credentials{}, fmt.Errorf("Credential profile '%s' has an invalid expiry: %s", p, err)
+	}
+
+	return federator.Credentials{
+		AccessKeyId:     prof.Key("aws_access_key_id").String(),
+		SecretAccessKey: prof.Key("aws_secret_access_key").String(),
+		SessionToken:    prof.Key("aws_session_token").String(),
+		Expiration:      exp,
+	}, nil
+}
+
+// profileExpiry reads the expiry time recorded alongside the temporary
+// credentials stored in profile p.
+func profileExpiry(p string) (time.Time, error) {
+	creds, err := readProfileCredentials(p)
+	return creds.Expiration, err
+}
+
+// profileRoleArn reads the ARN of the role whose credentials are stored in
+// profile p, from its recorded metadata or else the credentials file.
+func profileRoleArn(p string) (string, error) {
+	if meta, ok := recordedProfile(p); ok && meta.RoleArn != "" {
+		return meta.RoleArn, nil
+	}
+
+	cpath, err := credentialsPath()
+	if err != nil {
+		return "", err
+	}
+
+	cfg, err := ini.Load(cpath)
+	if err != nil {
+		return "", err
+	}
+
+	prof, err := cfg.GetSection(p)
+	if err != nil {
+		return "", fmt.Errorf("Credential profile '%s' does not exist", p)
+	}
+	return prof.Key("x_role_arn").String(), nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// sendingCredentials reports whether -creds-fd or -creds-fifo was given.
+func (c configuration) sendingCredentials() bool {
+	return c.credsFD >= 0 || c.credsFIFO != ""
+}
+
+// sendCredentials writes creds as credential_process JSON to the file
+// descriptor or FIFO given with -creds-fd or -creds-fifo, so that a parent
+// process receives them without them passing through argv, a file or the
+// environment.
+func (c configuration) sendCredentials(creds outputCredentials) error {
+	var f *os.File
+	if c.credsFD >= 0 {
+		if c.credsFD <= 2 {
+			return fmt.Errorf("-creds-fd must be a descriptor other than STDIN, STDOUT or STDERR")
+		}
+		f = os.NewFile(uintptr(c.credsFD), "creds-fd")
+		if _, err := f.Stat(); err != nil {
+			return fmt.Errorf("File descriptor %d is not open: %s", c.credsFD, err)
+		}
+	} else {
+		fi, err := os.Stat(c.credsFIFO)
+		if err != nil {
+			return err
+		}
+		// credentials must never be left behind in a regular file
+		if fi.Mode()&os.ModeNamedPipe == 0 {
+			return fmt.Errorf("%s is not a FIFO", c.credsFIFO)
+		}
+		// blocks until the parent opens the FIFO for reading
+		if f, err = os.OpenFile(c.credsFIFO, os.O_WRONLY, 0); err != nil {
+			return err
+		}
+	}
+	defer f.Close()
+
+	return printCredentialProcess(f, creds)
+}
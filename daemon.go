@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"github.com/kardianos/osext"
+	"gopkg.in/ini.v1"
+)
+
+// daemonLoginTimeout bounds a login made by the daemon, which can't
+// wait for anyone to answer a prompt.
+const daemonLoginTimeout = 2 * time.Minute
+
+func init() {
+	commands["daemon"] = runDaemon
+}
+
+// daemonRequest is sent to the daemon's socket, one per connection, by the
+// CLI and by other tools such as editor plugins.  Op is one of:
+//
+//	accounts    - list the configured accounts
+//	roles       - log in to Account and list the roles available
+//	credentials - return the credentials in Profile, first refreshing them
+//	              from Account if they are about to expire
+//	refresh     - log in to Account and write credentials to Profile
+type daemonRequest struct {
+	Op       string `json:"op"`
+	Config   string `json:"config,omitempty"`
+	Account  string `json:"account,omitempty"`
+	Profile  string `json:"profile,omitempty"`
+	Role     string `json:"role,omitempty"`
+	Duration string `json:"duration,omitempty"`
+	Policy   string `json:"session_policy,omitempty"`
+}
+
+// daemonResponse answers a daemonRequest.
+type daemonResponse struct {
+	Error       string             `json:"error,omitempty"`
+	Accounts    []daemonAccount    `json:"accounts,omitempty"`
+	Roles       []roleInfo         `json:"roles,omitempty"`
+	Credentials *daemonCredentials `json:"credentials,omitempty"`
+}
+
+// daemonAccount is a configured account listed by the accounts operation.
+type daemonAccount struct {
+	Name    string   `json:"name"`
+	Tags    []string `json:"tags,omitempty"`
+	Profile string   `json:"profile,omitempty"`
+}
+
+// daemonCredentials are returned by the credentials operation.
+type daemonCredentials struct {
+	AccessKeyID     string    `json:"access_key_id"`
+	SecretAccessKey string    `json:"secret_access_key"`
+	SessionToken    string    `json:"session_token"`
+	Expiration      time.Time `json:"expiration"`
+	RoleArn         string    `json:"role_arn,omitempty"`
+}
+
+// daemonSocket returns the path of the socket the daemon listens on.
+func daemonSocket() (string, error) {
+	return statePath("daemon.sock")
+}
+
+// daemon serializes credential refreshes for every CLI invocation, so that
+// they don't race each other on the IdP session, caches and credentials file.
+type daemon struct {
+	self string
+	// config is the configuration file used when a request doesn't name
+	// one.
+	config string
+
+	mu sync.Mutex
+	// refreshed is when each request was last refreshed, keyed by its
+	// flags, so that a refresh only answers requests for the same account,
+	// role, duration and policy.
+	refreshed map[string]time.Time
+}
+
+// runDaemon listens for requests from the CLI and other tools until
+// interrupted.  With -refresh it also refreshes the profiles of the
+// configured accounts shortly before they expire.
+func runDaemon(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("daemon takes no arguments")
+	}
+
+	self, err := osext.Executable()
+	if err != nil {
+		return fmt.Errorf("Unable to locate executable: %s", err)
+	}
+	path, err := daemonSocket()
+	if err != nil {
+		return err
+	}
+
+	// a socket left by a daemon which didn't exit cleanly is replaced
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("A daemon is already listening on %s", path)
+	}
+	os.Remove(path)
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("Unable to listen on %s: %s", path, err)
+	}
+	defer ln.Close()
+	if err := os.Chmod(path, 0600); err != nil {
+		return fmt.Errorf("Unable to restrict access to %s: %s", path, err)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	go func() {
+		<-stop
+		ln.Close()
+	}()
+
+	c.resolvePath()
+	d := &daemon{self: self, config: c.path, refreshed: make(map[string]time.Time)}
+	if *c.refresh {
+		go d.autoRefresh()
+		fmt.Fprintf(os.Stderr, "Refreshing the profiles of %s before they expire\n", c.path)
+	}
+	fmt.Fprintf(os.Stderr, "Listening on %s\n", path)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return nil
+		}
+		go d.serve(conn)
+	}
+}
+
+func (d *daemon) serve(conn net.Conn) {
+	defer conn.Close()
+
+	var req daemonRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		json.NewEncoder(conn).Encode(daemonResponse{Error: fmt.Sprintf("Invalid request: %s", err)})
+		return
+	}
+	if req.Config == "" {
+		req.Config = d.config
+	}
+
+	var resp daemonResponse
+	var err error
+	switch req.Op {
+	case "accounts":
+		resp.Accounts, err = d.accounts(req)
+	case "roles":
+		resp.Roles, err = d.roles(req)
+	case "credentials":
+		resp.Credentials, err = d.credentials(req)
+	case "refresh":
+		err = d.refresh(req)
+	default:
+		err = fmt.Errorf("Unknown operation '%s'", req.Op)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// accounts lists the accounts in the configuration file.
+func (d *daemon) accounts(req daemonRequest) ([]daemonAccount, error) {
+	data, err := readConfigFile(req.Config)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ini.Load(data)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := []daemonAccount{}
+	for _, name := range configuredAccounts(cfg, nil) {
+		sec := cfg.Section(name)
+		accounts = append(accounts, daemonAccount{Name: name, Tags: accountTags(sec), Profile: sec.Key("profile").String()})
+	}
+	return accounts, nil
+}
+
+// roles logs in to the account and lists the roles available.
+func (d *daemon) roles(req daemonRequest) ([]roleInfo, error) {
+	if req.Account == "" {
+		return nil, fmt.Errorf("Listing roles needs an account")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	out, err := d.run(append(req.flags(), "roles"))
+	if err != nil {
+		return nil, err
+	}
+	var roles []roleInfo
+	if err := json.Unmarshal(out, &roles); err != nil {
+		return nil, fmt.Errorf("Unable to read roles: %s", err)
+	}
+	return roles, nil
+}
+
+// credentials returns the credentials stored in the profile, refreshing
+// them first if they expire within the default refresh margin and an
+// account to refresh them from is given.
+func (d *daemon) credentials(req daemonRequest) (*daemonCredentials, error) {
+	if req.Profile == "" {
+		return nil, fmt.Errorf("Getting credentials needs a profile")
+	}
+
+	creds, err := readProfileCredentials(req.Profile)
+	if err != nil || time.Now().Add(defaultRefreshMargin).After(creds.Expiration) {
+		if req.Account == "" {
+			return nil, fmt.Errorf("Credential profile '%s' holds no valid credentials, and no account was given to refresh them from", req.Profile)
+		}
+		if req.Role == "" {
+			req.Role, _ = profileRoleArn(req.Profile)
+		}
+		if err := d.refresh(req); err != nil {
+			return nil, err
+		}
+		if creds, err = readProfileCredentials(req.Profile); err != nil {
+			return nil, err
+		}
+	}
+
+	role, _ := profileRoleArn(req.Profile)
+	return &daemonCredentials{
+		AccessKeyID:     creds.AccessKeyId,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+		Expiration:      creds.Expiration,
+		RoleArn:         role,
+	}, nil
+}
+
+// refresh logs in to the account and writes its credentials to the
+// profile, one refresh at a time.  A request which waited for an identical
+// request to refresh the same profile is answered by that refresh.
+func (d *daemon) refresh(req daemonRequest) error {
+	if req.Account == "" || req.Profile == "" {
+		return fmt.Errorf("A refresh needs an account and a profile")
+	}
+
+	flags := req.flags()
+	key := strings.Join(flags, "\x00")
+
+	received := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.refreshed[key].After(received) {
+		return nil
+	}
+
+	// a refresh asks for new credentials, not those already cached
+	if _, err := d.run(append(flags, "-force")); err != nil {
+		if rerr := recordRefreshFailure(req.Profile, err); rerr != nil {
+			l.Printf("Unable to record the failed refresh of '%s': %s\n", req.Profile, rerr)
+		}
+		return err
+	}
+
+	d.refreshed[key] = time.Now()
+	return nil
+}
+
+// flags returns the command line selecting the request's configuration,
+// account, profile, role and duration.
+func (req daemonRequest) flags() []string {
+	args := []string{"-no-daemon", "-account", req.Account}
+	if req.Config != "" {
+		args = append(args, "-path", req.Config)
+	}
+	if req.Profile != "" {
+		args = append(args, "-profile", req.Profile)
+	}
+	if req.Role != "" {
+		args = append(args, "-role", req.Role)
+	}
+	if req.Duration != "" {
+		args = append(args, "-duration", req.Duration)
+	}
+	if req.Policy != "" {
+		args = append(args, "-session-policy", req.Policy)
+	}
+	return args
+}
+
+// run runs the CLI with args, returning what it prints to STDOUT.
+func (d *daemon) run(args []string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(d.self, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, fmt.Errorf("%s", daemonError(stderr.String(), err))
+		}
+	case <-time.After(daemonLoginTimeout):
+		cmd.Process.Kill()
+		<-done
+		return nil, fmt.Errorf("Login did not finish within %s", daemonLoginTimeout)
+	}
+	return stdout.Bytes(), nil
+}
+
+// daemonError picks the error reported by a failed refresh from its output.
+func daemonError(stderr string, err error) string {
+	// prompts it gave up on precede the error on its line
+	for _, line := range strings.Split(stderr, "\n") {
+		if i := strings.Index(line, "ERROR: "); i >= 0 {
+			return line[i+len("ERROR: "):]
+		}
+	}
+	return err.Error()
+}
+
+// refreshWithDaemon asks a running daemon to refresh the profile with role,
+// returning the credentials it wrote.  ok is false if there is no daemon,
+// it's disabled with -no-daemon, or the daemon couldn't refresh without the
+// user, in which case the CLI logs in itself.
+func (c configuration) refreshWithDaemon(acct *ini.Section, role string) (creds federator.Credentials, arn string, ok bool) {
+	if *c.noDaemon || *c.force || c.profile == "" || c.assertionGiven() || c.endpointURL != "" {
+		return creds, "", false
+	}
+	path, err := daemonSocket()
+	if err != nil {
+		return creds, "", false
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return creds, "", false
+	}
+	defer conn.Close()
+
+	// the daemon can't ask which role to use
+	if role == "" && !acct.HasKey("assume_role") {
+		return creds, "", false
+	}
+
+	l.Printf("Asking the daemon on %s to refresh profile '%s'\n", path, c.profile)
+	conn.SetDeadline(time.Now().Add(daemonLoginTimeout + 30*time.Second))
+	req := daemonRequest{Op: "refresh", Account: acct.Name(), Profile: c.profile, Role: role}
+	if c.path != "" {
+		req.Config, _ = filepath.Abs(c.path)
+	}
+	if c.duration != 0 {
+		req.Duration = c.duration.String()
+	}
+	if req.Policy = c.sessionPolicy; req.Policy != "" && !strings.HasPrefix(strings.TrimSpace(req.Policy), "{") {
+		req.Policy, _ = filepath.Abs(req.Policy)
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		l.Printf("Unable to send request to the daemon: %s\n", err)
+		return creds, "", false
+	}
+	var resp daemonResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		l.Printf("Unable to read the daemon's response: %s\n", err)
+		return creds, "", false
+	}
+	if resp.Error != "" {
+		fmt.Fprintf(os.Stderr, "WARNING: The daemon could not refresh profile '%s', logging in here instead: %s\n", c.profile, resp.Error)
+		return creds, "", false
+	}
+
+	creds, err = readProfileCredentials(c.profile)
+	if err != nil {
+		return creds, "", false
+	}
+	arn, _ = profileRoleArn(c.profile)
+	fmt.Fprintf(os.Stderr, "Temporary credentials refreshed by the daemon in credential profile '%s'.\n", c.profile)
+	return creds, arn, true
+}
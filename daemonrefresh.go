@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+// refreshCheckInterval is how often daemon -refresh looks for profiles
+// about to expire.
+const refreshCheckInterval = time.Minute
+
+// refreshRetryDelay is how long daemon -refresh waits before trying again
+// to refresh a profile it couldn't, rather than logging in every minute.
+const refreshRetryDelay = 10 * time.Minute
+
+// autoRefresh keeps the profiles of the configured accounts refreshed
+// before their credentials expire, for as long as the daemon runs.
+func (d *daemon) autoRefresh() {
+	started := time.Now()
+	failed := make(map[string]time.Time)
+	for {
+		d.refreshExpiring(started, failed)
+		time.Sleep(refreshCheckInterval)
+	}
+}
+
+// refreshExpiring refreshes each profile whose credentials expire within
+// its account's refresh_margin.  Profiles which expired before the daemon
+// started have been abandoned and are left alone, as are those which failed
+// to refresh within refreshRetryDelay.
+func (d *daemon) refreshExpiring(started time.Time, failed map[string]time.Time) {
+	data, err := readConfigFile(d.config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: Unable to read %s: %s\n", d.config, err)
+		return
+	}
+	cfg, err := ini.Load(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: Unable to parse %s: %s\n", d.config, err)
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range configuredAccounts(cfg, nil) {
+		acct := cfg.Section(name)
+		p := acct.Key("profile").String()
+		if p == "" || seen[p] || time.Since(failed[p]) < refreshRetryDelay {
+			continue
+		}
+		seen[p] = true
+
+		// profiles never written by this tool have nothing to refresh
+		creds, err := readProfileCredentials(p)
+		if err != nil || creds.Expiration.Before(started) {
+			continue
+		}
+		margin, err := refreshMargin(acct)
+		if err != nil {
+			margin = defaultRefreshMargin
+		}
+		if creds.Expiration.After(time.Now().Add(margin)) {
+			continue
+		}
+
+		// without the stored role the daemon would have to ask for one
+		role, _ := profileRoleArn(p)
+		if role == "" && !acct.HasKey("assume_role") {
+			continue
+		}
+
+		req := daemonRequest{Op: "refresh", Config: d.config, Account: name, Profile: p, Role: federatedRole(acct, role)}
+		if err := d.refresh(req); err != nil {
+			failed[p] = time.Now()
+			fmt.Fprintf(os.Stderr, "WARNING: Unable to refresh profile '%s', trying again in %s: %s\n", p, refreshRetryDelay, err)
+			continue
+		}
+		delete(failed, p)
+		exp, err := profileExpiry(p)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Refreshed profile '%s', valid until %s\n", p, formatTime(exp))
+		// a duration shorter than the margin would otherwise be refreshed every check
+		if exp.Before(time.Now().Add(margin)) {
+			failed[p] = time.Now()
+		}
+	}
+}
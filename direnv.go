@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// direnvMinValidity is how long credentials must remain valid for
+// direnv-export to hand them out.
+const direnvMinValidity = 5 * time.Minute
+
+func init() {
+	commands["direnv-export"] = direnvExport
+}
+
+// direnvExport prints the credentials stored in the account's profile as
+// environment variables for use from a direnv .envrc:
+//
+//	eval "$(aws-cli-federator direnv-export)"
+//
+// It never prompts, so direnv is never blocked.  If the credentials are
+// missing or about to expire, it prints how to refresh them and exports
+// nothing.
+func direnvExport(args []string) error {
+	if len(args) > 0 {
+		c.positionalAccount = args[0]
+	}
+
+	acct, err := c.resolveAccount()
+	if err != nil {
+		return err
+	}
+
+	if c.profile == "" {
+		return fmt.Errorf("direnv-export requires a profile.  Set 'profile' for account '%s' or use -profile", c.account)
+	}
+
+	self := filepath.Base(os.Args[0])
+	creds, err := readProfileCredentials(c.profile)
+	if err != nil || creds.Expiration.Before(time.Now().Add(direnvMinValidity)) {
+		if err != nil {
+			l.Printf("Unable to read credential profile: %s\n", err)
+		}
+		fmt.Fprintf(os.Stderr, "%s: no valid credentials for profile '%s'.  Run '%s -account %s -profile %s' to log in, then 'direnv reload'.\n", self, c.profile, self, c.account, c.profile)
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: using credentials from profile '%s', valid until %s\n", self, c.profile, formatTime(creds.Expiration))
+	return printEnv(os.Stdout, outputCredentials{Credentials: creds, Region: acct.Key("region").String()})
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+func init() {
+	commands["docs"] = docs
+}
+
+// docTopic is a help topic shown by the docs command.  Topics are rendered
+// from the tables the tool itself uses, so they can't fall out of date.
+type docTopic struct {
+	name    string
+	summary string
+	render  func(w *docWriter)
+}
+
+var docTopics = []docTopic{
+	{name: "config", summary: "settings of the configuration file", render: docConfig},
+	{name: "flags", summary: "command line flags", render: docFlags},
+	{name: "output", summary: "formats the credentials can be printed in with -output", render: docOutput},
+	{name: "providers", summary: "IdPs which can be chosen with idp_type; 'docs <idp_type>' shows how to set one up", render: docProviders},
+}
+
+// providerGuide is the setup guide of an idp_type.  The settings it accepts
+// are taken from configKeys.
+type providerGuide struct {
+	summary string
+	url     string
+}
+
+var providerGuides = map[string]providerGuide{
+	"form":             {"fills in the IdP's HTML login forms, which suits most ADFS, Shibboleth and SimpleSAMLphp IdPs (the default)", "https://adfs.example.com/adfs/ls/IdpInitiatedSignOn.aspx?loginToRp=urn:amazon:webservices"},
+	"okta":             {"Okta's authentication API, using push, code and security key factors; sp_identity_url is the AWS app's embed link", "https://example.okta.com/home/amazon_aws/0oa1b2c3d4/272"},
+	"azure":            {"Azure AD sign in with app notifications or codes; sp_identity_url is the enterprise application's user access URL", "https://myapps.microsoft.com/signin/AWS/<application id>?tenantId=<tenant id>"},
+	"ping":             {"PingFederate's HTML Form Adapter, with PingID passcodes", "https://pf.example.com/idp/startSSO.ping?PartnerSpId=urn:amazon:webservices"},
+	"google":           {"Google Workspace SAML apps, with 2-Step Verification codes", "https://accounts.google.com/o/saml2/initsso?idpid=C01abcdef&spid=123456789012&forceauthn=false"},
+	"onelogin":         {"OneLogin's API, which needs API credentials with Authentication Only permission", "https://example.onelogin.com/launch/123456"},
+	"jumpcloud":        {"JumpCloud with its email address login and TOTP codes", "https://sso.jumpcloud.com/saml2/aws"},
+	"keycloak":         {"Keycloak's IDP initiated SSO URL for the AWS client, with one-time passwords", "https://keycloak.example.com/realms/corp/protocol/saml/clients/amazon-aws"},
+	"adfs-wstrust":     {"ADFS's WS-Trust usernamemixed endpoint rather than its login pages; ADFS applies no MFA to it", "https://adfs.example.com/adfs/ls/IdpInitiatedSignOn.aspx?loginToRp=urn:amazon:webservices"},
+	"shibboleth-ecp":   {"a Shibboleth IdP's SAML ECP endpoint, which must be enabled for AWS", "https://idp.example.edu/idp/profile/SAML2/Unsolicited/SSO?providerId=urn:amazon:webservices"},
+	"command":          {"runs idp_command, which logs in and prints the SAML assertion", "https://sso.example.com/aws"},
+	"browser":          {"signs in through your web browser and captures the SAMLResponse on a local listener", "https://sso.example.com/aws"},
+	"browser-headless": {"drives the IdP's pages in a headless Chrome, for builds with TAGS=chromedp (set with auth_backend)", "https://sso.example.com/aws"},
+}
+
+// docs prints the named help topic, or the available topics.  A topic may
+// also be an idp_type or a configuration key.
+func docs(args []string) error {
+	w := newDocWriter(os.Stdout)
+	if len(args) == 0 {
+		w.line("Topics, shown with 'aws-cli-federator docs <topic>':")
+		w.line("")
+		for _, t := range docTopics {
+			w.item(t.name, t.summary)
+		}
+		return nil
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("docs takes a single topic")
+	}
+
+	topic := args[0]
+	for _, t := range docTopics {
+		if t.name == topic {
+			t.render(w)
+			return nil
+		}
+	}
+	if knownProvider(topic) {
+		docProvider(w, topic)
+		return nil
+	}
+	if k, ok := lookupConfigKey(topic); ok {
+		w.item(k.name, k.description)
+		return nil
+	}
+
+	var names []string
+	for _, t := range docTopics {
+		names = append(names, t.name)
+	}
+	return fmt.Errorf("Unknown topic '%s'.  Topics are %s, an idp_type or a configuration key", topic, strings.Join(names, ", "))
+}
+
+func docConfig(w *docWriter) {
+	w.line("Global settings, set before any account section:")
+	w.line("")
+	for _, k := range configKeys {
+		if k.global {
+			w.item(k.name, k.description)
+		}
+	}
+	w.line("")
+	w.line("Account settings, set in an account's section:")
+	w.line("")
+	for _, k := range configKeys {
+		if k.global {
+			continue
+		}
+		desc := k.description
+		if k.provider != "" && !strings.Contains(desc, k.provider) {
+			desc += " (idp_type " + k.provider + ")"
+		}
+		w.item(k.name, desc)
+	}
+	w.line("")
+	w.para("[account_map] names account IDs, and [account_tags] gives them tags, with 123456789012 = <value> entries.  Accounts can also be named by account_map_url and by 'aws-cli-federator sync-accounts', which saves the names of the accounts in your AWS Organization.")
+}
+
+func docFlags(w *docWriter) {
+	w.line("Flags are given before any command or account name:")
+	w.line("")
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		usage := f.Usage
+		if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "-1" {
+			usage += " (default " + f.DefValue + ")"
+		}
+		w.item("-"+f.Name, usage)
+	})
+
+	var names []string
+	for n := range commands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	w.line("")
+	w.line("Commands: " + strings.Join(names, ", "))
+}
+
+func docOutput(w *docWriter) {
+	for _, name := range outputFormatNames() {
+		w.item(name, outputFormatDocs[name])
+	}
+}
+
+func docProviders(w *docWriter) {
+	for _, name := range federator.ProviderTypes() {
+		w.item(name, providerGuides[name].summary)
+	}
+}
+
+func docProvider(w *docWriter, name string) {
+	guide := providerGuides[name]
+	if guide.summary != "" {
+		w.para(strings.ToUpper(guide.summary[:1]) + guide.summary[1:] + ".")
+		w.line("")
+	}
+
+	w.line("Example:")
+	w.line("")
+	w.line("    [default]")
+	if name == "browser-headless" {
+		w.line("    auth_backend = " + name)
+	} else if name != "form" {
+		w.line("    idp_type = " + name)
+	}
+	if guide.url != "" {
+		w.line("    sp_identity_url = " + guide.url)
+	}
+
+	var keys []configKey
+	for _, k := range configKeys {
+		if k.provider == name {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) > 0 {
+		w.line("")
+		w.line("Settings:")
+		w.line("")
+		for _, k := range keys {
+			w.item(k.name, k.description)
+		}
+	}
+}
+
+// docWriter wraps help text to the width of the terminal.
+type docWriter struct {
+	out   io.Writer
+	width int
+}
+
+func newDocWriter(out *os.File) *docWriter {
+	width := 80
+	if w, _, err := terminal.GetSize(int(out.Fd())); err == nil && w > 40 {
+		width = w
+	}
+	return &docWriter{out: out, width: width}
+}
+
+func (w *docWriter) line(s string) {
+	fmt.Fprintln(w.out, s)
+}
+
+func (w *docWriter) para(s string) {
+	for _, l := range wrapText(s, w.width) {
+		w.line(l)
+	}
+}
+
+// item prints a name followed by its description, indented beneath it.
+func (w *docWriter) item(name, description string) {
+	w.line("  " + name)
+	for _, l := range wrapText(description, w.width-6) {
+		w.line("      " + l)
+	}
+}
+
+func wrapText(s string, width int) []string {
+	var lines []string
+	cur := ""
+	for _, word := range strings.Fields(s) {
+		if cur != "" && len(cur)+1+len(word) > width {
+			lines = append(lines, cur)
+			cur = ""
+		}
+		if cur != "" {
+			cur += " "
+		}
+		cur += word
+	}
+	if cur != "" {
+		lines = append(lines, cur)
+	}
+	return lines
+}
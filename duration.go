@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"gopkg.in/ini.v1"
+)
+
+// sessionDuration returns how long credentials for the account should
+// last, from -duration or its session_duration setting.  It is zero if
+// neither is set, leaving STS to use the role's default.
+func sessionDuration(acct *ini.Section) (time.Duration, error) {
+	if c.duration != 0 {
+		if err := checkSessionDuration(c.duration); err != nil {
+			return 0, fmt.Errorf("-duration %s", err)
+		}
+		return c.duration, nil
+	}
+	if !acct.HasKey("session_duration") {
+		return 0, nil
+	}
+	return parseSessionDuration(acct.Key("session_duration").String())
+}
+
+// parseSessionDuration parses a session_duration setting such as '4h'.
+func parseSessionDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("session_duration must be a duration such as '4h', found '%s'", s)
+	}
+	if err := checkSessionDuration(d); err != nil {
+		return 0, fmt.Errorf("session_duration %s", err)
+	}
+	return d, nil
+}
+
+func checkSessionDuration(d time.Duration) error {
+	if d < federator.MinSessionDuration || d > federator.MaxSessionDuration {
+		return fmt.Errorf("must be between %s and %s, found %s", federator.MinSessionDuration, federator.MaxSessionDuration, d)
+	}
+	if d%time.Second != 0 {
+		return fmt.Errorf("must be a whole number of seconds, found %s", d)
+	}
+	return nil
+}
+
+// assumeRole assumes r for fed's SessionDuration.  If that is longer than
+// the role allows, it warns and uses the longest whole number of hours the
+// role accepts instead, down to the one hour every role allows.  STS doesn't
+// say what the maximum is, and a successful attempt may consume the
+// assertion, so shorter durations are tried in turn.
+func assumeRole(fed *federator.Federator, r federator.Role) (federator.Credentials, error) {
+	creds, err := fed.AssumeRole(r)
+	if _, ok := err.(*federator.DurationError); !ok {
+		return creds, err
+	}
+
+	requested := fed.SessionDuration
+	defer func() { fed.SessionDuration = requested }()
+
+	d := requested / time.Hour * time.Hour
+	if d == requested {
+		d -= time.Hour
+	}
+	for ; d > time.Hour; d -= time.Hour {
+		fed.SessionDuration = d
+		creds, err = fed.AssumeRole(r)
+		if _, ok := err.(*federator.DurationError); !ok {
+			break
+		}
+	}
+	if d <= time.Hour {
+		fed.SessionDuration = 0
+		creds, err = fed.AssumeRole(r)
+		d = time.Hour
+	}
+	if err == nil {
+		fmt.Fprintf(os.Stderr, "WARNING: %s is longer than the maximum session duration of %s, the credentials last %s instead\n", requested, r.RoleArn(), d)
+	}
+	return creds, err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"gopkg.in/ini.v1"
+)
+
+// configureEndpoints points the federator at the SAML service provider and
+// STS of an AWS compatible cloud or emulator, when the account sets
+// saml_acs_url, sts_endpoint or endpoint_url, instead of AWS's, and at the
+// regional STS endpoint of sts_region.
+func configureEndpoints(acct *ini.Section, fed *federator.Federator) error {
+	if acs := acct.Key("saml_acs_url").String(); acs != "" {
+		if err := checkEndpointURL("saml_acs_url", acs); err != nil {
+			return err
+		}
+		fed.ACSURL = acs
+	}
+
+	if r := acct.Key("sts_region").String(); r != "" {
+		if !regionPattern.MatchString(r) {
+			return fmt.Errorf("Invalid sts_region '%s', expected a region such as eu-west-1", r)
+		}
+		fed.STS.Region = r
+	}
+
+	key, e := "sts_endpoint", acct.Key("sts_endpoint").String()
+	switch {
+	case c.endpointURL != "":
+		key, e = "-endpoint-url", c.endpointURL
+	case e == "":
+		key, e = "endpoint_url", acct.Key("endpoint_url").String()
+	}
+	if e != "" {
+		if err := checkEndpointURL(key, e); err != nil {
+			return err
+		}
+		l.Printf("Using STS endpoint %s\n", e)
+		fed.STS.Endpoint = e
+	}
+	return nil
+}
+
+// endpointURL returns the endpoint AWS requests other than the login's are
+// sent to, given with -endpoint-url or endpoint_url, or "" for AWS.
+func endpointURL(acct *ini.Section) string {
+	if c.endpointURL != "" {
+		return c.endpointURL
+	}
+	return acct.Key("endpoint_url").String()
+}
+
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+
+func checkEndpointURL(key, s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("Invalid %s '%s', expected an http or https URL", key, s)
+	}
+	return nil
+}
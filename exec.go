@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"github.com/kardianos/osext"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+func init() {
+	commands["exec"] = execCommand
+}
+
+// execUnsetEnv are removed from the environment of commands run by exec,
+// as they would make the AWS CLI and SDKs ignore the injected credentials.
+var execUnsetEnv = []string{
+	"AWS_ACCESS_KEY_ID",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SESSION_TOKEN",
+	"AWS_SECURITY_TOKEN",
+	"AWS_CREDENTIAL_EXPIRATION",
+	"AWS_PROFILE",
+	"AWS_DEFAULT_PROFILE",
+}
+
+// execCommand logs in to the account and runs a command with the
+// credentials in its environment, exiting with the command's status:
+//
+//	aws-cli-federator exec -account prod -- aws s3 ls
+//
+// The credentials are received from a second run of this tool over a pipe
+// given with -creds-fd, so they are only written to a profile if -profile is
+// given.  Signals sent to this process are passed on to the command.
+func execCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("exec needs a command to run, as in 'aws-cli-federator exec -account prod -- aws s3 ls'")
+	}
+
+	acct, err := c.resolveAccount()
+	if err != nil {
+		return err
+	}
+	creds, err := execCredentials()
+	if err != nil {
+		return err
+	}
+
+	env := []string{}
+	for _, kv := range os.Environ() {
+		if !execUnset(kv) {
+			env = append(env, kv)
+		}
+	}
+	out := outputCredentials{Credentials: creds, Region: acct.Key("region").String()}
+	for _, v := range out.environment() {
+		env = append(env, v.Name+"="+v.Value)
+	}
+	env = append(env, "AWS_CREDENTIAL_EXPIRATION="+creds.Expiration.UTC().Format(time.RFC3339))
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(sigs)
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Unable to run %s: %s", args[0], err)
+	}
+	go forwardSignals(cmd.Process, sigs)
+
+	err = cmd.Wait()
+	if exit, ok := err.(*exec.ExitError); ok {
+		if status, ok := exit.Sys().(syscall.WaitStatus); ok {
+			// a command killed by a signal exits as a shell reports it
+			if status.Signaled() {
+				os.Exit(128 + int(status.Signal()))
+			}
+			os.Exit(status.ExitStatus())
+		}
+		os.Exit(1)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// execCredentials runs this tool again with the same flags, to log in and
+// assume the role, and receives the credentials over a pipe.  Prompts are
+// shown on this terminal.
+func execCredentials() (creds federator.Credentials, err error) {
+	self, err := osext.Executable()
+	if err != nil {
+		return creds, fmt.Errorf("Unable to locate executable: %s", err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return creds, err
+	}
+	defer r.Close()
+
+	// ExtraFiles[0] is descriptor 3 in the child
+	child := exec.Command(self, append(execFlags(), "-creds-fd", "3")...)
+	child.ExtraFiles = []*os.File{w}
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stderr
+	child.Stderr = os.Stderr
+	if err := child.Start(); err != nil {
+		w.Close()
+		return creds, err
+	}
+	w.Close()
+
+	var sent struct {
+		AccessKeyId     string
+		SecretAccessKey string
+		SessionToken    string
+		Expiration      time.Time
+	}
+	decodeErr := json.NewDecoder(r).Decode(&sent)
+	if err := child.Wait(); err != nil {
+		// the child has already printed why
+		os.Exit(1)
+	}
+	if decodeErr != nil {
+		return creds, fmt.Errorf("Unable to read the credentials: %s", decodeErr)
+	}
+	addSecret(sent.AccessKeyId, sent.SecretAccessKey, sent.SessionToken)
+
+	creds.AccessKeyId = sent.AccessKeyId
+	creds.SecretAccessKey = sent.SecretAccessKey
+	creds.SessionToken = sent.SessionToken
+	creds.Expiration = sent.Expiration
+	return creds, nil
+}
+
+// execFlags returns the flags given on the command line for the account
+// chosen, leaving out those which would print or send the credentials
+// elsewhere.
+func execFlags() []string {
+	args := []string{"-account", c.account}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "account", "acct", "output", "export", "creds-fd", "creds-fifo", "print-arn":
+		case "can-i":
+			for _, check := range c.canI {
+				args = append(args, "-can-i", strings.TrimSpace(check.action+" "+check.resource))
+			}
+		default:
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	return args
+}
+
+func execUnset(kv string) bool {
+	for _, name := range execUnsetEnv {
+		if strings.HasPrefix(kv, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardSignals passes the signals received on sigs to p.  An interrupt
+// typed at a terminal already reaches the command, which shares its process
+// group, so interrupts are only passed on when STDIN isn't a terminal;
+// some commands, such as terraform, stop immediately on a second one.
+func forwardSignals(p *os.Process, sigs <-chan os.Signal) {
+	interactive := terminal.IsTerminal(int(os.Stdin.Fd()))
+	for sig := range sigs {
+		if sig == os.Interrupt && interactive {
+			continue
+		}
+		p.Signal(sig)
+	}
+}
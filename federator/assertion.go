@@ -0,0 +1,77 @@
+package federator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AssertionProvider returns an assertion obtained outside the federator,
+// for example one captured with a browser extension, rather than logging in.
+type AssertionProvider struct {
+	Assertion SAMLAssertion
+}
+
+// Authenticate implements Provider.
+func (p AssertionProvider) Authenticate(ctx context.Context) (SAMLAssertion, error) {
+	if p.Assertion == "" {
+		return "", errors.New("No SAML assertion was given")
+	}
+	return p.Assertion, nil
+}
+
+// ParseAssertion reads a SAMLResponse as copied from a browser: base64
+// encoded, possibly URL encoded or still prefixed with "SAMLResponse=" as in
+// a captured POST body, or the decoded XML shown by tools like SAML-tracer.
+func ParseAssertion(data []byte) (SAMLAssertion, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return "", errors.New("The SAML assertion is empty")
+	}
+	if data[0] == '<' {
+		return SAMLAssertion(base64.StdEncoding.EncodeToString(data)), nil
+	}
+
+	s := strings.Join(strings.Fields(string(data)), "")
+	if strings.HasPrefix(s, "SAMLResponse=") {
+		v, err := url.ParseQuery(s)
+		if err != nil {
+			return "", fmt.Errorf("Could not decode the SAMLResponse: %s", err)
+		}
+		s = v.Get("SAMLResponse")
+	} else if strings.Contains(s, "%") {
+		v, err := url.QueryUnescape(s)
+		if err != nil {
+			return "", fmt.Errorf("Could not decode the SAMLResponse: %s", err)
+		}
+		s = v
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", fmt.Errorf("The SAML assertion is not base64 encoded: %s", err)
+	}
+	if !bytes.Contains(decoded, []byte("Response")) {
+		return "", errors.New("The SAML assertion does not hold a SAMLResponse")
+	}
+	return SAMLAssertion(s), nil
+}
+
+// Assertion returns the SAML assertion obtained by the last Login, when it
+// stops being valid if it says, and whether STS may be given it more than
+// once, so that it can be kept for later logins.
+func (a *Federator) Assertion() (assertion SAMLAssertion, notOnOrAfter time.Time, reusable bool) {
+	if a.samlResponse == nil {
+		return "", time.Time{}, false
+	}
+	info, err := parseAssertionInfo(a.samlResponse64)
+	if err != nil {
+		return SAMLAssertion(a.samlResponse64), time.Time{}, false
+	}
+	return SAMLAssertion(a.samlResponse64), info.NotOnOrAfter, !info.OneTimeUse
+}
@@ -0,0 +1,404 @@
+package federator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+)
+
+// azureMaxPages bounds the number of pages followed during an Azure AD login.
+const azureMaxPages = 12
+
+// azureCodeMethods are the Azure AD MFA methods which are completed with a
+// one-time code.
+var azureCodeMethods = map[string]bool{
+	"PhoneAppOTP":      true,
+	"OneWaySMS":        true,
+	"SoftwareTokenOTP": true,
+	"HardwareTokenOTP": true,
+}
+
+// AzureADProvider drives the login.microsoftonline.com sign in pages, which
+// are rendered by JavaScript from the $Config object embedded in each page,
+// to obtain the SAML assertion of an AWS enterprise application.
+type AzureADProvider struct {
+	// AppURL is the user access URL of the AWS enterprise application, for
+	// example https://myapps.microsoft.com/signin/AWS/<app id>?tenantId=<tenant id>
+	AppURL   string
+	Username string
+	Password string
+
+	// MFA is asked for a code when Azure AD requires a second factor.
+	MFA MFAPrompter
+
+	// MFAPreference lists the kinds of factor to use, most preferred
+	// first, when the user has several MFA methods.  See mfaFactor.
+	MFAPreference []string
+
+	// Client is used for all requests.  If it is nil, a client with its own
+	// cookie jar is created.
+	Client *http.Client
+}
+
+func init() {
+	RegisterProvider("azure", func(cfg ProviderConfig) (Provider, error) {
+		return &AzureADProvider{
+			AppURL:        cfg.URL,
+			Username:      cfg.Username,
+			Password:      cfg.Password,
+			MFA:           cfg.MFA,
+			MFAPreference: parseMFAPreference(cfg.setting("mfa_preference", "")),
+			Client:        cfg.Client,
+		}, nil
+	})
+}
+
+// azureConfig is the subset of the $Config object used to drive the login.
+type azureConfig struct {
+	PageID       string `json:"pgid"`
+	URLPost      string `json:"urlPost"`
+	URLBeginAuth string `json:"urlBeginAuth"`
+	URLEndAuth   string `json:"urlEndAuth"`
+	Ctx          string `json:"sCtx"`
+	FlowToken    string `json:"sFT"`
+	Canary       string `json:"canary"`
+	ErrorCode    string `json:"sErrorCode"`
+	ErrorText    string `json:"sErrTxt"`
+	Proofs       []struct {
+		AuthMethodID string `json:"authMethodId"`
+		IsDefault    bool   `json:"isDefault"`
+		Display      string `json:"display"`
+	} `json:"arrUserProofs"`
+}
+
+type azureAuthResponse struct {
+	Success     bool
+	ResultValue string
+	Message     string
+	SessionID   string `json:"SessionId"`
+	FlowToken   string
+	Ctx         string
+}
+
+// Authenticate implements Provider.
+func (a *AzureADProvider) Authenticate(ctx context.Context) (SAMLAssertion, error) {
+	if a.Client == nil {
+		j, err := cookiejar.New(nil)
+		if err != nil {
+			return "", fmt.Errorf("Could not create cookiejar: %s", err)
+		}
+		a.Client = &http.Client{Jar: j}
+	}
+
+	req, err := http.NewRequest("GET", a.AppURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("Invalid Azure AD app URL '%s': %s", a.AppURL, err)
+	}
+
+	signedIn := false
+	for i := 0; i < azureMaxPages; i++ {
+		page, body, err := a.do(ctx, req)
+		if err != nil {
+			return "", err
+		}
+
+		if assertion, err := samlResponseFromPage(bytes.NewReader(body)); err == nil {
+			return assertion, nil
+		}
+
+		cfg, ok := parseAzureConfig(body)
+		if !ok {
+			// intermediate pages submit themselves with JavaScript
+			form, err := hiddenForm(page, bytes.NewReader(body))
+			if err != nil {
+				return "", fmt.Errorf("Azure AD returned an unexpected page: %s", err)
+			}
+			req, err = formRequest(form.URL, form.Values)
+			if err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		if err := cfg.err(); err != nil {
+			return "", err
+		}
+
+		var next url.Values
+		switch {
+		case cfg.PageID == "KmsiInterrupt":
+			// "Stay signed in?"
+			next = url.Values{"LoginOptions": {"1"}}
+		case cfg.PageID == "ConvergedTFA":
+			if next, err = a.secondFactor(ctx, page, cfg); err != nil {
+				return "", err
+			}
+		case cfg.PageID == "ConvergedSignIn" || cfg.PageID == "":
+			if signedIn {
+				return "", ErrInvalidCredentials
+			}
+			signedIn = true
+			next = url.Values{
+				"login":        {a.Username},
+				"loginfmt":     {a.Username},
+				"passwd":       {a.Password},
+				"type":         {"11"},
+				"LoginOptions": {"3"},
+				"i13":          {"0"},
+			}
+		default:
+			return "", fmt.Errorf("Azure AD requires an interactive step (%s).  Sign in to https://myapps.microsoft.com in a browser to complete it, then try again", cfg.PageID)
+		}
+
+		next.Set("ctx", cfg.Ctx)
+		next.Set("flowToken", cfg.FlowToken)
+		next.Set("canary", cfg.Canary)
+
+		u, err := page.Parse(cfg.URLPost)
+		if err != nil || cfg.URLPost == "" {
+			return "", fmt.Errorf("Azure AD page %s has no valid post URL", cfg.PageID)
+		}
+		if req, err = formRequest(u.String(), next); err != nil {
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("Azure AD login did not reach AWS after %d pages", azureMaxPages)
+}
+
+// secondFactor completes an MFA challenge, returning the form values to post
+// to the challenge page's post URL.
+func (a *AzureADProvider) secondFactor(ctx context.Context, page *url.URL, cfg azureConfig) (url.Values, error) {
+	// the user's default method is offered first
+	var methods []string
+	var choices []mfaFactor
+	codeMethod := ""
+	for _, p := range cfg.Proofs {
+		if azureCodeMethods[p.AuthMethodID] && (codeMethod == "" || p.IsDefault) {
+			codeMethod = p.AuthMethodID
+		}
+		if azureCodeMethods[p.AuthMethodID] || p.AuthMethodID == "PhoneAppNotification" {
+			choice := azureFactorChoice(p.AuthMethodID, p.Display)
+			if p.IsDefault {
+				methods = append([]string{p.AuthMethodID}, methods...)
+				choices = append([]mfaFactor{choice}, choices...)
+			} else {
+				methods = append(methods, p.AuthMethodID)
+				choices = append(choices, choice)
+			}
+		}
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("None of your Azure AD MFA methods are supported.  Use an authenticator app code, SMS or app notification")
+	}
+
+	prompter := a.MFA
+	if prompter == nil {
+		prompter = TerminalPrompter{}
+	}
+
+	i, err := chooseFactor(choices, a.MFAPreference, prompter)
+	if err != nil {
+		return nil, err
+	}
+	method := methods[i]
+
+	begin, err := a.beginAuth(ctx, page, cfg, method)
+	if err != nil {
+		return nil, err
+	}
+
+	code := ""
+	end := begin
+	if method == "PhoneAppNotification" {
+		poll := 0
+		useCode, err := waitForPush(ctx, prompter, "Microsoft Authenticator notification", func() (bool, error) {
+			poll++
+			end, err = a.endAuth(ctx, page, cfg, method, begin, end, "", poll)
+			if err == nil && end.ResultValue == "PhoneAppDenied" {
+				err = fmt.Errorf("Azure AD MFA notification was denied")
+			}
+			return end.Success, err
+		}, func() error {
+			begin, err = a.beginAuth(ctx, page, cfg, method)
+			end, poll = begin, 0
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if useCode {
+			if codeMethod == "" {
+				return nil, fmt.Errorf("You have no Azure AD MFA methods which use a code")
+			}
+			method = codeMethod
+			if begin, err = a.beginAuth(ctx, page, cfg, method); err != nil {
+				return nil, err
+			}
+			end = begin
+		}
+	}
+
+	if azureCodeMethods[method] {
+		if code, err = prompter.MFACode("Azure AD verification code"); err != nil {
+			return nil, err
+		}
+		if end, err = a.endAuth(ctx, page, cfg, method, begin, end, code, 1); err != nil {
+			return nil, err
+		}
+	}
+	if !end.Success {
+		return nil, fmt.Errorf("Azure AD MFA failed: %s", end.Message)
+	}
+
+	return url.Values{
+		"type":          {"22"},
+		"request":       {end.Ctx},
+		"mfaAuthMethod": {method},
+		"otc":           {code},
+		"login":         {a.Username},
+	}, nil
+}
+
+// azureFactorChoice describes an Azure AD MFA method for mfa_preference and
+// the factor picker.  display is the masked phone number or device name.
+func azureFactorChoice(method, display string) mfaFactor {
+	suffix := ""
+	if display != "" {
+		suffix = " (" + display + ")"
+	}
+
+	switch method {
+	case "PhoneAppNotification":
+		return mfaFactor{"push", "Microsoft Authenticator notification" + suffix}
+	case "PhoneAppOTP":
+		return mfaFactor{"totp", "Microsoft Authenticator code" + suffix}
+	case "SoftwareTokenOTP":
+		return mfaFactor{"totp", "Authenticator app code" + suffix}
+	case "HardwareTokenOTP":
+		return mfaFactor{"token", "Hardware token code" + suffix}
+	case "OneWaySMS":
+		return mfaFactor{"sms", "SMS code" + suffix}
+	}
+	return mfaFactor{method, method + suffix}
+}
+
+func (a *AzureADProvider) beginAuth(ctx context.Context, page *url.URL, cfg azureConfig, method string) (azureAuthResponse, error) {
+	return a.auth(ctx, page, cfg.URLBeginAuth, map[string]interface{}{
+		"AuthMethodId": method,
+		"Method":       "BeginAuth",
+		"ctx":          cfg.Ctx,
+		"flowToken":    cfg.FlowToken,
+	})
+}
+
+// endAuth completes the challenge started by begin with code, or for app
+// notifications checks whether it has been approved.  last is the previous
+// response, which carries the flow tokens forward.
+func (a *AzureADProvider) endAuth(ctx context.Context, page *url.URL, cfg azureConfig, method string, begin, last azureAuthResponse, code string, poll int) (azureAuthResponse, error) {
+	return a.auth(ctx, page, cfg.URLEndAuth, map[string]interface{}{
+		"AuthMethodId":       method,
+		"Method":             "EndAuth",
+		"SessionId":          begin.SessionID,
+		"FlowToken":          last.FlowToken,
+		"Ctx":                last.Ctx,
+		"AdditionalAuthData": code,
+		"PollCount":          poll,
+	})
+}
+
+func (a *AzureADProvider) auth(ctx context.Context, page *url.URL, endpoint string, body interface{}) (azureAuthResponse, error) {
+	var r azureAuthResponse
+
+	u, err := page.Parse(endpoint)
+	if err != nil || endpoint == "" {
+		return r, fmt.Errorf("Azure AD MFA page has no valid endpoint")
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return r, err
+	}
+
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(b))
+	if err != nil {
+		return r, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return r, fmt.Errorf("Azure AD MFA request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return r, fmt.Errorf("Azure AD returned an unexpected MFA response (HTTP %s)", resp.Status)
+	}
+	return r, nil
+}
+
+func (a *AzureADProvider) do(ctx context.Context, req *http.Request) (*url.URL, []byte, error) {
+	resp, err := a.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Azure AD request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return nil, nil, fmt.Errorf("Azure AD returned HTTP %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not read Azure AD response: %s", err)
+	}
+	return resp.Request.URL, body, nil
+}
+
+func formRequest(u string, v url.Values) (*http.Request, error) {
+	req, err := http.NewRequest("POST", u, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+// parseAzureConfig extracts the $Config object from a sign in page.
+func parseAzureConfig(page []byte) (azureConfig, bool) {
+	var cfg azureConfig
+
+	i := bytes.Index(page, []byte("$Config="))
+	if i < 0 {
+		return cfg, false
+	}
+
+	d := json.NewDecoder(bytes.NewReader(page[i+len("$Config="):]))
+	if err := d.Decode(&cfg); err != nil {
+		return cfg, false
+	}
+	return cfg, true
+}
+
+// err translates the error codes Azure AD shows on its sign in pages.
+func (cfg azureConfig) err() error {
+	switch {
+	case cfg.ErrorCode == "":
+		return nil
+	case cfg.ErrorCode == "50126" || cfg.ErrorCode == "50034":
+		// invalid username or password, or unknown user
+		return ErrInvalidCredentials
+	case cfg.ErrorCode == "50053":
+		return fmt.Errorf("Your Azure AD account is locked")
+	case strings.HasPrefix(cfg.ErrorCode, "530"):
+		return fmt.Errorf("Azure AD conditional access blocked this sign in (AADSTS%s): %s", cfg.ErrorCode, cfg.ErrorText)
+	}
+	return fmt.Errorf("Azure AD sign in failed (AADSTS%s): %s", cfg.ErrorCode, cfg.ErrorText)
+}
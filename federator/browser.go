@@ -0,0 +1,157 @@
+package federator
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// DefaultBrowserCallback is the address the BrowserProvider listens on for
+// the SAMLResponse.
+const DefaultBrowserCallback = "127.0.0.1:21600"
+
+// BrowserProvider signs in through the user's own web browser, so any IdP
+// can be used, including ones requiring CAPTCHAs, device certificates or
+// security keys.  The SAMLResponse is sent from the AWS role selection page
+// to a temporary listener on the loopback interface by a bookmarklet it
+// serves.
+//
+// The listener only accepts a SAMLResponse posted to a path holding a random
+// token chosen for each login, so that other local users and web pages
+// can't substitute an assertion of their own.
+type BrowserProvider struct {
+	URL string
+
+	// Callback is the address to listen on.  If it is empty,
+	// DefaultBrowserCallback is used.
+	Callback string
+
+	// Open opens a URL in the user's browser.  If it is nil, or fails, the
+	// user is asked to open the URL themselves.
+	Open func(url string) error
+
+	// Out is where instructions are written.  If it is nil, os.Stderr is
+	// used.
+	Out io.Writer
+}
+
+func init() {
+	RegisterProvider("browser", func(cfg ProviderConfig) (Provider, error) {
+		return &BrowserProvider{
+			URL:      cfg.URL,
+			Callback: cfg.setting("browser_callback", ""),
+		}, nil
+	})
+}
+
+// Authenticate implements Provider.
+func (p *BrowserProvider) Authenticate(ctx context.Context) (SAMLAssertion, error) {
+	addr := p.Callback
+	if addr == "" {
+		addr = DefaultBrowserCallback
+	}
+	out := p.Out
+	if out == nil {
+		out = os.Stderr
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("Could not listen for the browser's SAMLResponse on %s: %s", addr, err)
+	}
+	defer ln.Close()
+
+	token, err := browserToken()
+	if err != nil {
+		return "", err
+	}
+	base := "http://" + ln.Addr().String()
+	captured := make(chan SAMLAssertion, 1)
+	go http.Serve(ln, p.handler(base, token, captured))
+
+	fmt.Fprintf(out, "Sign in to your IdP in the browser, then follow the instructions at %s/%s to send the SAMLResponse from the AWS role selection page.\n", base, token)
+	if p.Open == nil || p.Open(p.URL) != nil {
+		fmt.Fprintf(out, "Open this URL in your browser: %s\n", p.URL)
+	}
+
+	select {
+	case assertion := <-captured:
+		return assertion, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// browserToken returns a random token for the paths served during one
+// login.
+func browserToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", fmt.Errorf("Unable to generate a token for the browser login: %s", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// handler serves the bookmarklet page at /<token> and receives the
+// SAMLResponse at /saml/<token>.  Every other path is not found.
+func (p *BrowserProvider) handler(base, token string, captured chan<- SAMLAssertion) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", http.NotFound)
+	mux.HandleFunc("/"+token, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, browserTrapPage, html.EscapeString(p.URL), html.EscapeString(p.URL), html.EscapeString(browserBookmarklet(base, token)))
+	})
+	mux.HandleFunc("/saml/"+token, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "The SAMLResponse must be posted", http.StatusMethodNotAllowed)
+			return
+		}
+		v := strings.Join(strings.Fields(r.PostFormValue("SAMLResponse")), "")
+		if _, err := base64.StdEncoding.DecodeString(v); v == "" || err != nil {
+			http.Error(w, "The request did not contain a SAMLResponse", http.StatusBadRequest)
+			return
+		}
+
+		select {
+		case captured <- SAMLAssertion(v):
+		default:
+			// a response has already been captured
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, browserDonePage)
+	})
+	return mux
+}
+
+// browserBookmarklet posts the SAMLResponse held by the AWS role selection
+// page to the listener at base, for the login identified by token.
+func browserBookmarklet(base, token string) string {
+	return "javascript:(function(){" +
+		"var s=document.querySelector('input[name=SAMLResponse]');" +
+		"if(!s){alert('No SAMLResponse on this page');return;}" +
+		"var f=document.createElement('form');f.method='POST';f.action='" + base + "/saml/" + token + "';" +
+		"var i=document.createElement('input');i.type='hidden';i.name='SAMLResponse';i.value=s.value;" +
+		"f.appendChild(i);document.body.appendChild(f);f.submit();})()"
+}
+
+const browserTrapPage = `<!DOCTYPE html>
+<html><head><title>aws-cli-federator</title></head><body>
+<p>Sign in at <a href="%s">%s</a>.</p>
+<p>Drag this link to your bookmarks bar and click it on the AWS role selection page: <a href="%s">Send to aws-cli-federator</a></p>
+<p>The link only works for this login.</p>
+</body></html>
+`
+
+const browserDonePage = `<!DOCTYPE html>
+<html><head><title>aws-cli-federator</title></head><body>
+<p>The SAMLResponse has been received.  You can close this window.</p>
+</body></html>
+`
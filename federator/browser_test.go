@@ -0,0 +1,53 @@
+package federator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestBrowserHandlerToken(t *testing.T) {
+	p := &BrowserProvider{URL: "https://idp.example.com/"}
+	captured := make(chan SAMLAssertion, 1)
+	h := p.handler("http://127.0.0.1:21600", "t0k3n", captured)
+
+	post := func(path string) int {
+		form := url.Values{"SAMLResponse": {"PHNhbWxwOlJlc3BvbnNlLz4="}}
+		r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for _, path := range []string{"/saml", "/saml/", "/saml/other", "/saml/t0k3n/x"} {
+		if code := post(path); code != http.StatusNotFound {
+			t.Errorf("POST %s: status %d", path, code)
+		}
+	}
+	select {
+	case a := <-captured:
+		t.Fatalf("captured %q without the token", a)
+	default:
+	}
+
+	if code := post("/saml/t0k3n"); code != http.StatusOK {
+		t.Fatalf("POST with the token: status %d", code)
+	}
+	if a := <-captured; a != "PHNhbWxwOlJlc3BvbnNlLz4=" {
+		t.Errorf("captured %q", a)
+	}
+
+	for path, want := range map[string]int{"/": http.StatusNotFound, "/t0k3n": http.StatusOK} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != want {
+			t.Errorf("GET %s: status %d, want %d", path, w.Code, want)
+		}
+		if want == http.StatusOK && !strings.Contains(w.Body.String(), "/saml/t0k3n") {
+			t.Errorf("GET %s: bookmarklet does not post to the token path", path)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package federator
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+// ErrCertificatePassword is returned by LoadPKCS12Certificate when the
+// password is wrong.
+var ErrCertificatePassword = errors.New("Incorrect password for client certificate")
+
+var (
+	oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidMicrosoftUPN   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+)
+
+// LoadClientCertificate reads a PEM encoded certificate and private key for
+// TLS client authentication.  If keyFile is empty, the key is expected to
+// be in certFile.
+func LoadClientCertificate(certFile, keyFile string) (tls.Certificate, error) {
+	if keyFile == "" {
+		keyFile = certFile
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Unable to load client certificate: %s", err)
+	}
+
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Unable to parse client certificate: %s", err)
+	}
+
+	return cert, nil
+}
+
+// LoadPKCS12Certificate reads a certificate and private key for TLS client
+// authentication from a PKCS#12 (.p12 or .pfx) file, as exported from
+// smart cards and certificate stores.  Any other certificates in the file
+// are sent as the chain.
+func LoadPKCS12Certificate(file, password string) (tls.Certificate, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Unable to load client certificate: %s", err)
+	}
+
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err == nil && len(blocks) == 0 {
+		// ToPEM doesn't report a wrong password or unreadable file, so
+		// Decode is asked why there was nothing in it
+		_, _, err = pkcs12.Decode(data, password)
+	}
+	if err == pkcs12.ErrIncorrectPassword {
+		return tls.Certificate{}, ErrCertificatePassword
+	} else if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Unable to load client certificate: %s", err)
+	}
+
+	var certs [][]byte
+	var key []byte
+	for _, b := range blocks {
+		if b.Type == "CERTIFICATE" {
+			certs = append(certs, pem.EncodeToMemory(&pem.Block{Type: b.Type, Bytes: b.Bytes}))
+		} else {
+			key = pem.EncodeToMemory(&pem.Block{Type: b.Type, Bytes: b.Bytes})
+		}
+	}
+	if key == nil {
+		return tls.Certificate{}, fmt.Errorf("Unable to load client certificate: %s holds no private key", file)
+	}
+
+	// the certificate for the key has to come first
+	for i := range certs {
+		chain := append([][]byte{certs[i]}, certs[:i]...)
+		chain = append(chain, certs[i+1:]...)
+
+		var pemCerts []byte
+		for _, c := range chain {
+			pemCerts = append(pemCerts, c...)
+		}
+		cert, err := tls.X509KeyPair(pemCerts, key)
+		if err != nil {
+			continue
+		}
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return tls.Certificate{}, fmt.Errorf("Unable to parse client certificate: %s", err)
+		}
+		return cert, nil
+	}
+	return tls.Certificate{}, fmt.Errorf("Unable to load client certificate: %s holds no certificate for its private key", file)
+}
+
+// SetClientCertificate configures the certificate presented to IdPs that
+// request TLS client authentication.
+func (a *Federator) SetClientCertificate(cert tls.Certificate) {
+	a.transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+}
+
+// UsernameFromCertificate derives the IdP username from a client
+// certificate the same way smart card (CAC/PIV) logins to ADFS do.  The
+// Microsoft UPN in the subject alternative name is preferred, followed by
+// any email address and lastly the subject common name.
+func UsernameFromCertificate(cert *x509.Certificate) (string, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+		if upn, err := parseUPN(ext.Value); err == nil && upn != "" {
+			return upn, nil
+		}
+	}
+
+	if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0], nil
+	}
+
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName, nil
+	}
+
+	return "", fmt.Errorf("Certificate does not contain a UPN, email address or common name")
+}
+
+// parseUPN extracts the UPN otherName from a DER encoded GeneralNames
+// sequence, as crypto/x509 does not expose otherName entries.
+func parseUPN(der []byte) (string, error) {
+	var names asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &names); err != nil {
+		return "", err
+	}
+
+	rest := names.Bytes
+	for len(rest) > 0 {
+		var name asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &name); err != nil {
+			return "", err
+		}
+
+		// otherName is [0] IMPLICIT SEQUENCE { type-id OID, value [0] EXPLICIT ANY }
+		if name.Class != asn1.ClassContextSpecific || name.Tag != 0 {
+			continue
+		}
+
+		var oid asn1.ObjectIdentifier
+		value, err := asn1.Unmarshal(name.Bytes, &oid)
+		if err != nil || !oid.Equal(oidMicrosoftUPN) {
+			continue
+		}
+
+		var explicit asn1.RawValue
+		if _, err := asn1.Unmarshal(value, &explicit); err != nil {
+			continue
+		}
+
+		var upn string
+		if _, err := asn1.UnmarshalWithParams(explicit.Bytes, &upn, "utf8"); err != nil {
+			continue
+		}
+		return upn, nil
+	}
+
+	return "", fmt.Errorf("No UPN present in subject alternative name")
+}
@@ -0,0 +1,80 @@
+package federator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+// MaxChainedDuration is the longest session STS allows for a role assumed
+// with the credentials of another assumed role.
+const MaxChainedDuration = time.Hour
+
+// ChainInput describes a role assumed with the credentials of another.
+type ChainInput struct {
+	RoleArn     string
+	SessionName string
+
+	// ExternalID is given if the role's trust policy requires one.
+	ExternalID string
+
+	// Duration is how long the credentials remain valid, up to
+	// MaxChainedDuration.  If it is zero, STS uses one hour.
+	Duration time.Duration
+
+	// Tags are session tags passed to the chained role's session, which
+	// the role's trust policy must allow with sts:TagSession.
+	Tags map[string]string
+}
+
+// ChainRole assumes another role with creds, as returned by AssumeRole,
+// calling STS as configured by a.STS.
+func (a *Federator) ChainRole(creds Credentials, in ChainInput) (Credentials, error) {
+	params := &sts.AssumeRoleInput{
+		RoleArn:         aws.String(in.RoleArn),
+		RoleSessionName: aws.String(in.SessionName),
+	}
+	if in.ExternalID != "" {
+		params.ExternalId = aws.String(in.ExternalID)
+	}
+	if in.Duration > 0 {
+		params.DurationSeconds = aws.Int32(int32(in.Duration / time.Second))
+	}
+	keys := make([]string, 0, len(in.Tags))
+	for k := range in.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		params.Tags = append(params.Tags, types.Tag{Key: aws.String(k), Value: aws.String(in.Tags[k])})
+	}
+
+	static := aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{
+			AccessKeyID:     creds.AccessKeyId,
+			SecretAccessKey: creds.SecretAccessKey,
+			SessionToken:    creds.SessionToken,
+			Source:          "ChainRole",
+		}, nil
+	})
+	client, err := a.STS.client(static)
+	if err != nil {
+		return Credentials{}, err
+	}
+	resp, err := client.AssumeRole(context.Background(), params)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("Unable to assume chained role %s: %s", in.RoleArn, err)
+	}
+
+	return Credentials{
+		AccessKeyId:     *resp.Credentials.AccessKeyId,
+		Expiration:      *resp.Credentials.Expiration,
+		SecretAccessKey: *resp.Credentials.SecretAccessKey,
+		SessionToken:    *resp.Credentials.SessionToken,
+	}, nil
+}
@@ -0,0 +1,145 @@
+package federator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// CommandAPIVersion identifies the request format sent to idp_command
+// plugins.
+const CommandAPIVersion = "aws-cli-federator/v1"
+
+// Exit statuses with which an idp_command plugin reports why it could not
+// log in.
+const (
+	// CommandExitInvalidCredentials reports that the IdP rejected the
+	// username or password.
+	CommandExitInvalidCredentials = 10
+	// CommandExitMFARequired reports that the IdP requires an MFA code.
+	// The plugin is run again with the code the user is asked for in the
+	// request's mfaCode.
+	CommandExitMFARequired = 11
+)
+
+// CommandRequest is written as JSON to the STDIN of an idp_command plugin.
+type CommandRequest struct {
+	APIVersion string `json:"apiVersion"`
+	// Account is the name of the account section being logged in to.
+	Account  string `json:"account"`
+	URL      string `json:"url"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	MFACode  string `json:"mfaCode,omitempty"`
+}
+
+// CommandProvider obtains the SAML assertion from an external program,
+// similar to kubectl's exec credential plugins, so bespoke SSO portals can
+// be supported without changes to the federator.  The program receives a
+// CommandRequest on STDIN and must write the base64 encoded SAMLResponse to
+// STDOUT.  Its STDERR is the user's, so it may prompt there, and a non-zero
+// exit status fails the login.
+type CommandProvider struct {
+	Command string
+	Request CommandRequest
+
+	// MFA is asked for the code when the program exits with
+	// CommandExitMFARequired.
+	MFA MFAPrompter
+}
+
+func init() {
+	RegisterProvider("command", func(cfg ProviderConfig) (Provider, error) {
+		command := cfg.setting("idp_command", "")
+		if command == "" {
+			return nil, fmt.Errorf("idp_type command requires idp_command to be set")
+		}
+		return &CommandProvider{
+			Command: command,
+			Request: CommandRequest{
+				Account:  cfg.Account,
+				URL:      cfg.URL,
+				Username: cfg.Username,
+				Password: cfg.Password,
+			},
+			MFA: cfg.MFA,
+		}, nil
+	})
+}
+
+// Authenticate implements Provider.
+func (p *CommandProvider) Authenticate(ctx context.Context) (SAMLAssertion, error) {
+	req := p.Request
+	req.APIVersion = CommandAPIVersion
+	out, err := p.run(ctx, req)
+	if exit, ok := err.(*exec.ExitError); ok && exit.ExitCode() == CommandExitMFARequired {
+		prompter := p.MFA
+		if prompter == nil {
+			prompter = TerminalPrompter{}
+		}
+		if req.MFACode, err = prompter.MFACode("MFA code"); err != nil {
+			return "", err
+		}
+		out, err = p.run(ctx, req)
+		if exit, ok := err.(*exec.ExitError); ok && exit.ExitCode() == CommandExitMFARequired {
+			return "", fmt.Errorf("idp_command rejected the MFA code")
+		}
+	}
+	if exit, ok := err.(*exec.ExitError); ok && exit.ExitCode() == CommandExitInvalidCredentials {
+		return "", ErrInvalidCredentials
+	} else if err != nil {
+		return "", err
+	}
+
+	assertion := strings.Join(strings.Fields(out), "")
+	if assertion == "" {
+		return "", fmt.Errorf("idp_command did not return a SAML assertion")
+	}
+	if _, err := base64.StdEncoding.DecodeString(assertion); err != nil {
+		return "", fmt.Errorf("idp_command returned an assertion which is not base64 encoded: %s", err)
+	}
+	return SAMLAssertion(assertion), nil
+}
+
+// run sends req to the command and returns its output.  An *exec.ExitError
+// is returned as is, so its status can be checked.
+func (p *CommandProvider) run(ctx context.Context, req CommandRequest) (string, error) {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	cmd := shellCommand(p.Command)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("Could not run idp_command: %s", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		<-done
+		return "", ctx.Err()
+	}
+	if exit, ok := err.(*exec.ExitError); ok {
+		switch exit.ExitCode() {
+		case CommandExitInvalidCredentials, CommandExitMFARequired:
+			return "", exit
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("idp_command failed: %s", err)
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,136 @@
+package federator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// SessionCookieLifetime is how long cookies without an expiry, which a
+// browser would forget when closed, are restored by a PersistentJar.
+var SessionCookieLifetime = 12 * time.Hour
+
+// PersistentJar is a cookie jar whose cookies can be saved and restored, so
+// that an IdP's session and remembered device cookies survive between runs
+// and MFA isn't required every time.
+type PersistentJar struct {
+	jar *cookiejar.Jar
+
+	mu      sync.Mutex
+	cookies map[string]savedCookie
+}
+
+// savedCookie is a cookie along with the URL it was set by.
+type savedCookie struct {
+	URL     string
+	Cookie  http.Cookie
+	SavedAt time.Time
+}
+
+func (s savedCookie) key() string {
+	return s.Cookie.Domain + "|" + s.Cookie.Path + "|" + s.Cookie.Name + "|" + s.URL
+}
+
+func (s savedCookie) expired(now time.Time) bool {
+	if s.Cookie.Expires.IsZero() {
+		return now.Sub(s.SavedAt) > SessionCookieLifetime
+	}
+	return !now.Before(s.Cookie.Expires)
+}
+
+// NewPersistentJar returns an empty PersistentJar.
+func NewPersistentJar() (*PersistentJar, error) {
+	j, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create cookiejar: %s", err)
+	}
+	return &PersistentJar{jar: j, cookies: make(map[string]savedCookie)}, nil
+}
+
+// SetCookies implements http.CookieJar.
+func (p *PersistentJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	p.jar.SetCookies(u, cookies)
+	p.record(u, cookies, time.Now())
+}
+
+// Cookies implements http.CookieJar.
+func (p *PersistentJar) Cookies(u *url.URL) []*http.Cookie {
+	return p.jar.Cookies(u)
+}
+
+func (p *PersistentJar) record(u *url.URL, cookies []*http.Cookie, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	origin := u.Scheme + "://" + u.Host + "/"
+	for _, c := range cookies {
+		s := savedCookie{URL: origin, Cookie: *c, SavedAt: now}
+		switch {
+		case c.MaxAge < 0:
+			delete(p.cookies, s.key())
+			continue
+		case c.MaxAge > 0:
+			s.Cookie.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+			s.Cookie.MaxAge = 0
+		}
+
+		if s.expired(now) {
+			delete(p.cookies, s.key())
+		} else {
+			p.cookies[s.key()] = s
+		}
+	}
+}
+
+// Save writes the unexpired cookies in the jar to w.
+func (p *PersistentJar) Save(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var cookies []savedCookie
+	for _, s := range p.cookies {
+		if !s.expired(now) {
+			cookies = append(cookies, s)
+		}
+	}
+	return json.NewEncoder(w).Encode(cookies)
+}
+
+// Load adds the unexpired cookies written by Save to the jar.
+func (p *PersistentJar) Load(r io.Reader) error {
+	var cookies []savedCookie
+	if err := json.NewDecoder(r).Decode(&cookies); err != nil {
+		return fmt.Errorf("Invalid cookie file: %s", err)
+	}
+
+	now := time.Now()
+	for _, s := range cookies {
+		if s.expired(now) {
+			continue
+		}
+		u, err := url.Parse(s.URL)
+		if err != nil {
+			continue
+		}
+		c := s.Cookie
+		p.jar.SetCookies(u, []*http.Cookie{&c})
+
+		p.mu.Lock()
+		p.cookies[s.key()] = s
+		p.mu.Unlock()
+	}
+	return nil
+}
+
+// SetCookieJar replaces the jar holding the IdP's cookies, for example with
+// a PersistentJar.  It must be called before the client is given to a
+// provider.
+func (a *Federator) SetCookieJar(jar http.CookieJar) {
+	a.http.Jar = jar
+}
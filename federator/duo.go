@@ -0,0 +1,349 @@
+package federator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Duo factors that can be chosen with DuoFactor settings.
+const (
+	DuoPush     = "push"
+	DuoPasscode = "passcode"
+	DuoPhone    = "phone"
+)
+
+// duoFactorNames are the names Duo's prompt API uses for each factor.
+var duoFactorNames = map[string]string{
+	DuoPush:     "Duo Push",
+	DuoPasscode: "Passcode",
+	DuoPhone:    "Phone Call",
+}
+
+// The traditional Duo prompt is configured either with data attributes on
+// its iframe or with a call to Duo.init.
+var (
+	duoHostPattern = regexp.MustCompile(`(?:data-host\s*=\s*|['"]host['"]\s*:\s*)['"]([^'"]+)['"]`)
+	duoSigPattern  = regexp.MustCompile(`(?:data-sig-request\s*=\s*|['"]sig_request['"]\s*:\s*)['"]([^'"]+)['"]`)
+	duoPostPattern = regexp.MustCompile(`(?:data-post-action\s*=\s*|['"]post_action['"]\s*:\s*)['"]([^'"]*)['"]`)
+)
+
+// duoFrame is the traditional Duo prompt, embedded by an IdP in an iframe.
+type duoFrame struct {
+	Host       string
+	SigRequest string
+	// PostAction is where the IdP expects the signed response, or "" for
+	// the page the prompt is on.
+	PostAction string
+}
+
+// findDuoFrame looks for the traditional Duo prompt on an IdP page.
+func findDuoFrame(page []byte) (duoFrame, bool) {
+	host, sig := duoHostPattern.FindSubmatch(page), duoSigPattern.FindSubmatch(page)
+	if host == nil || sig == nil {
+		return duoFrame{}, false
+	}
+
+	f := duoFrame{
+		Host:       html.UnescapeString(string(host[1])),
+		SigRequest: html.UnescapeString(string(sig[1])),
+	}
+	if m := duoPostPattern.FindSubmatch(page); m != nil {
+		f.PostAction = html.UnescapeString(string(m[1]))
+	}
+	return f, strings.HasPrefix(f.SigRequest, "TX|") || strings.HasPrefix(f.SigRequest, "ERR|")
+}
+
+// isDuoUniversal reports whether an IdP has redirected to Duo's Universal
+// Prompt.
+func isDuoUniversal(u *url.URL) bool {
+	return strings.Contains(u.Path, "/frame/frameless/v4/auth")
+}
+
+// duoPrompt completes Duo's second factor through the API used by its
+// prompt pages.
+type duoPrompt struct {
+	client *http.Client
+	mfa    MFAPrompter
+	// factor is DuoPush, DuoPasscode or DuoPhone.  If it is empty, a push
+	// is sent.
+	factor string
+}
+
+type duoResponse struct {
+	Stat     string `json:"stat"`
+	Message  string `json:"message"`
+	Response struct {
+		TxID       string `json:"txid"`
+		Result     string `json:"result"`
+		Status     string `json:"status"`
+		StatusCode string `json:"status_code"`
+		ResultURL  string `json:"result_url"`
+		Cookie     string `json:"cookie"`
+		Phones     []struct {
+			Key   string `json:"key"`
+			Index string `json:"index"`
+		} `json:"phones"`
+	} `json:"response"`
+}
+
+// duoResult is a completed Duo authentication.
+type duoResult struct {
+	status duoResponse
+	txid   string
+	factor string
+}
+
+// sign completes the traditional prompt in f, embedded in the page at
+// parent, and returns the sig_response to post to the IdP.
+func (d duoPrompt) sign(ctx context.Context, f duoFrame, parent string) (string, error) {
+	if strings.HasPrefix(f.SigRequest, "ERR|") {
+		return "", fmt.Errorf("Duo is not configured correctly on the IdP: %s", strings.TrimPrefix(f.SigRequest, "ERR|"))
+	}
+	parts := strings.Split(f.SigRequest, ":")
+	if len(parts) != 2 {
+		return "", fmt.Errorf("IdP returned an invalid Duo request")
+	}
+	tx, app := parts[0], parts[1]
+
+	base := f.Host
+	if !strings.Contains(base, "://") {
+		base = "https://" + base
+	}
+	base = strings.TrimRight(base, "/")
+
+	q := url.Values{"tx": {tx}, "parent": {parent}, "v": {"2.6"}}
+	req, err := formRequest(base+"/frame/web/v1/auth?"+q.Encode(), url.Values{
+		"parent":                   {parent},
+		"java_version":             {""},
+		"flash_version":            {""},
+		"screen_resolution_width":  {"1280"},
+		"screen_resolution_height": {"800"},
+		"color_depth":              {"24"},
+	})
+	if err != nil {
+		return "", err
+	}
+	page, body, err := d.page(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	form, err := hiddenForm(page, bytes.NewReader(body))
+	sid := form.Values.Get("sid")
+	if err != nil || sid == "" {
+		return "", fmt.Errorf("Duo did not offer an authentication prompt.  Sign in through a browser to enroll or resolve your Duo policy")
+	}
+
+	result, err := d.authenticate(ctx, base+"/frame/prompt", base+"/frame/status", url.Values{
+		"sid":              {sid},
+		"device":           {"phone1"},
+		"out_of_date":      {""},
+		"days_out_of_date": {"0"},
+		"days_to_block":    {"None"},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	final, err := d.post(ctx, base+result.status.Response.ResultURL, url.Values{"sid": {sid}})
+	if err != nil {
+		return "", err
+	}
+	if final.Response.Cookie == "" {
+		return "", fmt.Errorf("Duo did not sign the authentication")
+	}
+	return final.Response.Cookie + ":" + app, nil
+}
+
+// universal completes the Universal Prompt, given the page the IdP
+// redirected to, and returns the response from following Duo's redirect
+// back to the IdP.
+func (d duoPrompt) universal(ctx context.Context, page *url.URL, body []byte) (*http.Response, error) {
+	form, err := hiddenForm(page, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("Duo returned an unexpected page: %s", err)
+	}
+	req, err := formRequest(form.URL, form.Values)
+	if err != nil {
+		return nil, err
+	}
+	prompt, _, err := d.page(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	sid := prompt.Query().Get("sid")
+	if sid == "" {
+		return nil, fmt.Errorf("Duo did not offer an authentication prompt.  Sign in through a browser to enroll or resolve your Duo policy")
+	}
+	base := prompt.Scheme + "://" + prompt.Host
+
+	req, err = http.NewRequest("GET", base+"/frame/v4/auth/prompt/data?"+url.Values{"post_auth_action": {"OIDC_EXIT"}, "sid": {sid}}.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	device, deviceKey := "phone1", ""
+	if phones := data.Response.Phones; len(phones) > 0 {
+		device, deviceKey = phones[0].Index, phones[0].Key
+	}
+
+	result, err := d.authenticate(ctx, base+"/frame/v4/prompt", base+"/frame/v4/status", url.Values{
+		"sid":                 {sid},
+		"device":              {device},
+		"postAuthDestination": {"OIDC_EXIT"},
+		"browser_features":    {"{}"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if req, err = formRequest(base+"/frame/v4/oidc/exit", url.Values{
+		"sid":           {sid},
+		"txid":          {result.txid},
+		"factor":        {result.factor},
+		"device_key":    {deviceKey},
+		"_xsrf":         {form.Values.Get("_xsrf")},
+		"dampen_choice": {"true"},
+	}); err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("Duo request failed: %s", err)
+	}
+	return resp, nil
+}
+
+// authenticate sends the chosen factor with the prompt API and waits for it
+// to be approved.  A push or phone call can be resent, or swapped for a
+// passcode, while it is waiting.
+func (d duoPrompt) authenticate(ctx context.Context, promptURL, statusURL string, params url.Values) (duoResult, error) {
+	factor := d.factor
+	if factor == "" {
+		factor = DuoPush
+	}
+	if _, ok := duoFactorNames[factor]; !ok {
+		return duoResult{}, fmt.Errorf("Unknown Duo factor '%s', expected push, passcode or phone", factor)
+	}
+
+	prompter := d.mfa
+	if prompter == nil {
+		prompter = TerminalPrompter{}
+	}
+
+	for {
+		v := url.Values{}
+		for k, vs := range params {
+			v[k] = vs
+		}
+		v.Set("factor", duoFactorNames[factor])
+		if factor == DuoPasscode {
+			code, err := prompter.MFACode("Duo passcode")
+			if err != nil {
+				return duoResult{}, err
+			}
+			v.Set("passcode", code)
+		}
+
+		started, err := d.post(ctx, promptURL, v)
+		if err != nil {
+			return duoResult{}, err
+		}
+		result := duoResult{txid: started.Response.TxID, factor: duoFactorNames[factor]}
+
+		poll := func() (bool, error) {
+			status, err := d.post(ctx, statusURL, url.Values{"sid": {params.Get("sid")}, "txid": {result.txid}})
+			if err != nil {
+				return false, err
+			}
+			result.status = status
+			switch status.Response.Result {
+			case "SUCCESS":
+				return true, nil
+			case "FAILURE":
+				return false, fmt.Errorf("Duo authentication failed: %s", status.Response.Status)
+			}
+			return false, nil
+		}
+
+		if factor == DuoPasscode {
+			// passcodes are checked straight away
+			if ok, err := poll(); err != nil || !ok {
+				if err == nil {
+					err = fmt.Errorf("Duo did not accept the passcode")
+				}
+				return result, err
+			}
+			return result, nil
+		}
+
+		label := "Duo Push"
+		if factor == DuoPhone {
+			label = "Duo phone call"
+		}
+		useCode, err := waitForPush(ctx, prompter, label, poll, func() error {
+			again, err := d.post(ctx, promptURL, v)
+			result.txid = again.Response.TxID
+			return err
+		})
+		if err != nil || !useCode {
+			return result, err
+		}
+		factor = DuoPasscode
+	}
+}
+
+func (d duoPrompt) post(ctx context.Context, u string, v url.Values) (duoResponse, error) {
+	req, err := formRequest(u, v)
+	if err != nil {
+		return duoResponse{}, err
+	}
+	return d.do(ctx, req)
+}
+
+func (d duoPrompt) do(ctx context.Context, req *http.Request) (duoResponse, error) {
+	var r duoResponse
+
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return r, fmt.Errorf("Duo request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return r, fmt.Errorf("Duo returned an unexpected response (HTTP %s)", resp.Status)
+	}
+	if r.Stat != "OK" {
+		return r, fmt.Errorf("Duo returned an error: %s", r.Message)
+	}
+	return r, nil
+}
+
+// page fetches an HTML page, returning its final URL and body.
+func (d duoPrompt) page(ctx context.Context, req *http.Request) (*url.URL, []byte, error) {
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Duo request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, nil, fmt.Errorf("Duo returned HTTP %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not read Duo response: %s", err)
+	}
+	return resp.Request.URL, body, nil
+}
@@ -0,0 +1,32 @@
+package federator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MinSessionDuration and MaxSessionDuration bound the session durations STS
+// accepts.  A role's own maximum may be lower than MaxSessionDuration.
+const (
+	MinSessionDuration = 15 * time.Minute
+	MaxSessionDuration = 12 * time.Hour
+)
+
+// DurationError is returned by AssumeRole when SessionDuration is longer
+// than the role's maximum session duration.
+type DurationError struct {
+	Role      Role
+	Requested time.Duration
+}
+
+func (e *DurationError) Error() string {
+	return fmt.Sprintf("Unable to assume role: %s is longer than the maximum session duration of %s.  Request a shorter duration, or ask an administrator to raise the role's MaxSessionDuration", e.Requested, e.Role.RoleArn())
+}
+
+// durationExceeded reports whether STS rejected a request because its
+// DurationSeconds exceeds the role's MaxSessionDuration.
+func durationExceeded(err error) bool {
+	code, message := apiErrorCode(err)
+	return code == "ValidationError" && strings.Contains(message, "MaxSessionDuration")
+}
@@ -0,0 +1,164 @@
+package federator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ECPProvider logs in to a Shibboleth IdP with the SAML 2.0 Enhanced Client
+// or Proxy (ECP) profile: an AuthnRequest for AWS is sent straight to the
+// IdP's SOAP endpoint with the user's credentials, so no login pages are
+// parsed.  The ECP profile must be enabled for the AWS relying party.
+type ECPProvider struct {
+	// Endpoint is the IdP's ECP endpoint, for example
+	// https://idp.example.edu/idp/profile/SAML2/SOAP/ECP
+	Endpoint string
+	// EntityID is the entity ID AWS is known by at the IdP.  If it is empty,
+	// DefaultRelyingParty is used.
+	EntityID string
+	// ACSURL is the assertion consumer service requested.  If it is empty,
+	// AWSSignInURL is used.
+	ACSURL string
+
+	Username string
+	Password string
+	Client   *http.Client
+}
+
+func init() {
+	RegisterProvider("shibboleth-ecp", func(cfg ProviderConfig) (Provider, error) {
+		endpoint := cfg.setting("ecp_endpoint", "")
+		if endpoint == "" {
+			u, err := url.Parse(cfg.URL)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid sp_identity_url: %s", err)
+			}
+			endpoint = u.Scheme + "://" + u.Host + "/idp/profile/SAML2/SOAP/ECP"
+		}
+		return &ECPProvider{
+			Endpoint: endpoint,
+			EntityID: cfg.setting("ecp_sp_entity_id", audience(cfg)),
+			ACSURL:   cfg.ACSURL,
+			Username: cfg.Username,
+			Password: cfg.Password,
+			Client:   cfg.Client,
+		}, nil
+	})
+}
+
+const (
+	samlProtocolNS = "urn:oasis:names:tc:SAML:2.0:protocol"
+	samlSuccess    = "urn:oasis:names:tc:SAML:2.0:status:Success"
+)
+
+// Authenticate implements Provider.
+func (p *ECPProvider) Authenticate(ctx context.Context) (SAMLAssertion, error) {
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	entityID := p.EntityID
+	if entityID == "" {
+		entityID = DefaultRelyingParty
+	}
+
+	acs := p.ACSURL
+	if acs == "" {
+		acs = AWSSignInURL
+	}
+
+	req, err := http.NewRequest("POST", p.Endpoint, strings.NewReader(ecpRequest(entityID, acs, time.Now())))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.SetBasicAuth(p.Username, p.Password)
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("Could not reach the Shibboleth ECP endpoint: %s", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("Could not read Shibboleth ECP response: %s", err)
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return "", ErrInvalidCredentials
+	}
+	if reason, ok := soapFault(body); ok {
+		return "", fmt.Errorf("The IdP refused the ECP request: %s", reason)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Shibboleth ECP endpoint returned HTTP %s", resp.Status)
+	}
+
+	response, err := rawElement(body, samlProtocolNS, "Response")
+	if err != nil {
+		return "", fmt.Errorf("Invalid Shibboleth ECP response: %s", err)
+	} else if response == nil {
+		return "", fmt.Errorf("Shibboleth ECP response did not contain a SAML response")
+	}
+	if err := ecpStatus(response); err != nil {
+		return "", err
+	}
+	return SAMLAssertion(base64.StdEncoding.EncodeToString(response)), nil
+}
+
+// ecpRequest is a SOAP wrapped AuthnRequest asking for a response to be
+// returned with the PAOS binding for the assertion consumer service acs.
+func ecpRequest(entityID, acs string, now time.Time) string {
+	var issuer, consumer bytes.Buffer
+	xml.EscapeText(&issuer, []byte(entityID))
+	xml.EscapeText(&consumer, []byte(acs))
+
+	return `<S:Envelope xmlns:S="http://schemas.xmlsoap.org/soap/envelope/">` +
+		`<S:Body>` +
+		`<samlp:AuthnRequest xmlns:samlp="` + samlProtocolNS + `" ID="` + xmlID() + `" Version="2.0" IssueInstant="` + now.UTC().Format(time.RFC3339) + `"` +
+		` AssertionConsumerServiceURL="` + consumer.String() + `" ProtocolBinding="urn:oasis:names:tc:SAML:2.0:bindings:PAOS">` +
+		`<saml:Issuer xmlns:saml="` + samlAssertionNS + `">` + issuer.String() + `</saml:Issuer>` +
+		`<samlp:NameIDPolicy AllowCreate="true"/>` +
+		`</samlp:AuthnRequest>` +
+		`</S:Body>` +
+		`</S:Envelope>`
+}
+
+// ecpStatus returns an error if a SAML response isn't successful, such as
+// when the user isn't allowed to use AWS.
+func ecpStatus(response []byte) error {
+	var r struct {
+		Status struct {
+			Code struct {
+				Value string `xml:"Value,attr"`
+				Inner struct {
+					Value string `xml:"Value,attr"`
+				} `xml:"StatusCode"`
+			} `xml:"StatusCode"`
+			Message string `xml:"StatusMessage"`
+		} `xml:"Status"`
+	}
+	if err := xml.Unmarshal(response, &r); err != nil {
+		return fmt.Errorf("Invalid SAML response from the IdP: %s", err)
+	}
+	if r.Status.Code.Value == samlSuccess {
+		return nil
+	}
+
+	status := r.Status.Code.Value
+	if r.Status.Code.Inner.Value != "" {
+		status = r.Status.Code.Inner.Value
+	}
+	if msg := strings.TrimSpace(r.Status.Message); msg != "" {
+		status += ": " + msg
+	}
+	return fmt.Errorf("The IdP did not authenticate you: %s", status)
+}
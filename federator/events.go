@@ -0,0 +1,134 @@
+package federator
+
+import "time"
+
+// EventType identifies what an Event reports.
+type EventType int
+
+const (
+	// StageStarted is sent when the Federator begins a stage.
+	StageStarted EventType = iota
+	// StageFinished is sent when a stage ends, with Err set if it failed.
+	StageFinished
+	// MFARequired is sent before the user is asked for an MFA code.
+	MFARequired
+	// RolesReady is sent when the roles in the SAML assertion are known.
+	RolesReady
+	// MFAPushWaiting is sent about once a second while a push notification
+	// waits for approval.
+	MFAPushWaiting
+	// MFATouchRequired is sent before waiting for the user to touch their
+	// security key.
+	MFATouchRequired
+)
+
+// Stage is a step of obtaining credentials.
+type Stage string
+
+const (
+	StageLogin      Stage = "login"
+	StageMFA        Stage = "mfa"
+	StageAssumeRole Stage = "assume_role"
+)
+
+// Event reports progress to frontends embedding the federator, so that
+// they can show their own progress UI.
+type Event struct {
+	Type  EventType
+	Stage Stage
+
+	// Label describes the MFA code requested by an MFARequired event, or
+	// the key to touch for an MFATouchRequired event.
+	Label string
+	// Remaining is the time left to approve a push, for MFAPushWaiting
+	// events.
+	Remaining time.Duration
+	// Roles are the roles available, for RolesReady events.
+	Roles []Role
+	// Err is the reason a stage failed, for StageFinished events.
+	Err error
+}
+
+// SendTo returns an event callback delivering events to ch.  Delivery
+// blocks, so ch must be drained while the Federator is in use.
+func SendTo(ch chan<- Event) func(Event) {
+	return func(e Event) {
+		ch <- e
+	}
+}
+
+func (a *Federator) emit(e Event) {
+	if a.Events != nil {
+		a.Events(e)
+	}
+}
+
+// Prompter returns an MFAPrompter that asks a.MFA, or the terminal if it is
+// nil, for codes and reports the request as events.  Providers should be
+// given it so that their MFA prompts are visible to frontends.
+func (a *Federator) Prompter() MFAPrompter {
+	return eventPrompter{a}
+}
+
+type eventPrompter struct {
+	fed *Federator
+}
+
+func (p eventPrompter) MFACode(label string) (string, error) {
+	p.fed.emit(Event{Type: MFARequired, Stage: StageMFA, Label: label})
+
+	code, err := p.prompter().MFACode(label)
+
+	p.fed.emit(Event{Type: StageFinished, Stage: StageMFA, Err: err})
+	return code, err
+}
+
+func (p eventPrompter) PushWaiting(label string, left time.Duration) PushAction {
+	p.fed.emit(Event{Type: MFAPushWaiting, Stage: StageMFA, Label: label, Remaining: left})
+
+	if push, ok := p.prompter().(PushPrompter); ok {
+		return push.PushWaiting(label, left)
+	}
+	return PushWait
+}
+
+func (p eventPrompter) PushDone(label string) {
+	if push, ok := p.prompter().(PushPrompter); ok {
+		push.PushDone(label)
+	}
+	p.fed.emit(Event{Type: StageFinished, Stage: StageMFA})
+}
+
+func (p eventPrompter) ChooseFactor(labels []string) (int, error) {
+	chooser, ok := p.prompter().(FactorPrompter)
+	if !ok {
+		return 0, nil
+	}
+
+	p.fed.emit(Event{Type: MFARequired, Stage: StageMFA, Label: "MFA factor"})
+	return chooser.ChooseFactor(labels)
+}
+
+func (p eventPrompter) TouchKey(label string) {
+	p.fed.emit(Event{Type: MFATouchRequired, Stage: StageMFA, Label: label})
+
+	touch, ok := p.prompter().(TouchPrompter)
+	if !ok {
+		touch = TerminalPrompter{}
+	}
+	touch.TouchKey(label)
+}
+
+func (p eventPrompter) TouchDone(label string) {
+	if touch, ok := p.prompter().(TouchPrompter); ok {
+		touch.TouchDone(label)
+	}
+	p.fed.emit(Event{Type: StageFinished, Stage: StageMFA})
+}
+
+func (p eventPrompter) prompter() MFAPrompter {
+	if p.fed.MFA != nil {
+		return p.fed.MFA
+	}
+	return TerminalPrompter{}
+}
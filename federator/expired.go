@@ -0,0 +1,34 @@
+package federator
+
+import (
+	"fmt"
+	"time"
+)
+
+// ExpiredAssertionError is returned by AssumeRole when STS rejects the SAML
+// assertion as expired or no longer valid, as happens when a role is chosen
+// long after logging in.  A fresh assertion must be obtained by calling
+// Login again.
+type ExpiredAssertionError struct {
+	// NotOnOrAfter is when the assertion expires, if it says.
+	NotOnOrAfter time.Time
+	Err          error
+}
+
+func (e *ExpiredAssertionError) Error() string {
+	if e.NotOnOrAfter.IsZero() {
+		return fmt.Sprintf("Unable to assume role, the SAML assertion is no longer valid: %s", e.Err)
+	}
+	return fmt.Sprintf("Unable to assume role, the SAML assertion (valid until %s) is no longer valid: %s", e.NotOnOrAfter.Local().Format(time.RFC3339), e.Err)
+}
+
+// assertionRejected reports whether STS rejected a request because the
+// SAML assertion expired or is otherwise no longer valid.
+func assertionRejected(err error) bool {
+	code, _ := apiErrorCode(err)
+	switch code {
+	case "ExpiredTokenException", "ExpiredToken", "InvalidIdentityToken", "InvalidIdentityTokenException":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package federator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FactorPrompter is implemented by MFAPrompters which can ask the user which
+// of several enrolled MFA factors to use.
+type FactorPrompter interface {
+	MFAPrompter
+	// ChooseFactor returns the index of the factor chosen from labels,
+	// which are given most preferred first.
+	ChooseFactor(labels []string) (int, error)
+}
+
+// ChooseFactor implements FactorPrompter with a numbered menu, like the role
+// picker.  Entering nothing selects the first factor.
+func (t TerminalPrompter) ChooseFactor(labels []string) (int, error) {
+	in, out := t.In, t.Out
+	if in == nil {
+		in = os.Stdin
+	}
+	if out == nil {
+		out = os.Stderr
+	}
+
+	for n, label := range labels {
+		fmt.Fprintf(out, "%d) %s\n", n+1, label)
+	}
+	fmt.Fprintf(out, "Enter the ID# of the MFA factor to use [1]: ")
+
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, fmt.Errorf("Could not read selection: %s", err)
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return 0, nil
+	}
+
+	i, err := strconv.Atoi(line)
+	if err != nil || i < 1 || i > len(labels) {
+		return 0, fmt.Errorf("Invalid MFA factor selection, expected a number from 1 to %d", len(labels))
+	}
+	return i - 1, nil
+}
+
+// mfaFactor is an MFA factor the user has enrolled with an IdP.
+type mfaFactor struct {
+	// Kind is the name used for the factor in mfa_preference: push, totp,
+	// sms, call, token, webauthn or duo.
+	Kind  string
+	Label string
+}
+
+// parseMFAPreference splits an mfa_preference setting into the factor kinds
+// it lists, most preferred first.
+func parseMFAPreference(s string) []string {
+	var kinds []string
+	for _, k := range strings.Split(s, ",") {
+		if k = strings.ToLower(strings.TrimSpace(k)); k != "" {
+			kinds = append(kinds, k)
+		}
+	}
+	return kinds
+}
+
+// chooseFactor returns the index of the factor to use from factors, which
+// are given most preferred first: the first of the kinds in preference the
+// user has enrolled, otherwise the one the user picks if mfa can ask,
+// otherwise the first.
+func chooseFactor(factors []mfaFactor, preference []string, mfa MFAPrompter) (int, error) {
+	for _, kind := range preference {
+		for i, f := range factors {
+			if f.Kind == kind {
+				return i, nil
+			}
+		}
+	}
+
+	prompter, ok := mfa.(FactorPrompter)
+	if len(factors) < 2 || !ok {
+		return 0, nil
+	}
+
+	labels := make([]string, len(factors))
+	for i, f := range factors {
+		labels[i] = f.Label
+	}
+	return prompter.ChooseFactor(labels)
+}
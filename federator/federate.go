@@ -0,0 +1,123 @@
+package federator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// FederateOptions configures Federate.  Only URL is required.
+type FederateOptions struct {
+	// URL is the IdP initiated login URL for AWS, the sp_identity_url of
+	// the configuration file.
+	URL      string
+	Username string
+	Password string
+
+	// IdPType selects a registered provider, as idp_type does in the
+	// configuration file.  The default is the generic form login.
+	IdPType string
+	// Settings are provider specific settings, keyed as in the
+	// configuration file, such as onelogin_client_id or duo_factor.
+	Settings map[string]string
+
+	// MFA supplies one-time codes.  If it is nil, the terminal is used.
+	MFA MFAPrompter
+
+	// Role is the name or ARN of the role to assume.  It can be left empty
+	// if the user only has one role, or SelectRole is set.
+	Role string
+	// SelectRole chooses between the available roles when Role is empty
+	// and there is more than one.
+	SelectRole func(roles []Role) (Role, error)
+
+	STS    STSConfig
+	Events func(Event)
+
+	// Middleware wraps the transport used for requests to the IdP, as
+	// Federator.Use does.
+	Middleware []Middleware
+}
+
+// Federate logs in to the IdP, chooses a role and assumes it in one call,
+// for scripts and tools which just need credentials:
+//
+//	creds, err := federator.Federate(ctx, federator.FederateOptions{
+//		URL:      "https://idp.example.com/adfs/ls/IdpInitiatedSignOn.aspx?loginToRp=urn:amazon:webservices",
+//		Username: user,
+//		Password: pass,
+//		Role:     "ReadOnly",
+//	})
+func Federate(ctx context.Context, opts FederateOptions) (Credentials, error) {
+	fed, err := New(opts.Username, opts.Password, opts.URL)
+	if err != nil {
+		return Credentials{}, err
+	}
+	fed.MFA = opts.MFA
+	fed.STS = opts.STS
+	fed.Events = opts.Events
+	fed.DuoFactor = opts.Settings["duo_factor"]
+	fed.UsernameField = opts.Settings["form_username_field"]
+	fed.PasswordField = opts.Settings["form_password_field"]
+	if fed.ExtraFields, err = ParseFormFields(opts.Settings["form_extra_fields"]); err != nil {
+		return Credentials{}, err
+	}
+	fed.Use(opts.Middleware...)
+
+	// prompters which can show a push countdown have a user behind them
+	_, push := opts.MFA.(PushPrompter)
+	fed.Provider, err = NewProvider(opts.IdPType, ProviderConfig{
+		URL:         opts.URL,
+		Username:    opts.Username,
+		Password:    opts.Password,
+		MFA:         fed.Prompter(),
+		Client:      fed.Client(),
+		Interactive: opts.MFA == nil || push,
+		Setting: func(key string) string {
+			return opts.Settings[key]
+		},
+	})
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	if err := fed.LoginContext(ctx); err != nil {
+		return Credentials{}, err
+	}
+
+	roles, err := fed.GetRoles()
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	role, err := opts.chooseRole(roles)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	return fed.AssumeRoleContext(ctx, role)
+}
+
+// chooseRole picks the role to assume from roles: the one named by Role,
+// otherwise the one chosen by SelectRole, or the only one.
+func (opts FederateOptions) chooseRole(roles []Role) (Role, error) {
+	switch {
+	case opts.Role != "":
+		for _, r := range roles {
+			if r.RoleArn() == opts.Role || r.RoleName() == opts.Role {
+				return r, nil
+			}
+		}
+		return "", fmt.Errorf("Unable to find role '%s' in the roles available to you", opts.Role)
+	case opts.SelectRole != nil:
+		return opts.SelectRole(roles)
+	case len(roles) == 1:
+		return roles[0], nil
+	}
+
+	var arns []string
+	for _, r := range roles {
+		arns = append(arns, r.RoleArn())
+	}
+	return "", fmt.Errorf("A role must be chosen from %s", strings.Join(arns, ", "))
+}
@@ -1,18 +1,22 @@
 package federator
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"os"
 	"reflect"
 	"strings"
 	"time"
 
 	"github.com/RobotsAndPencils/go-saml"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"golang.org/x/net/html"
 )
 
@@ -21,7 +25,54 @@ type Federator struct {
 	Password    string
 	SPEntityUrl string
 
+	// ACSURL is the assertion consumer service the IdP posts assertions to,
+	// for AWS compatible clouds and emulators with their own SAML service
+	// provider.  If it is empty, AWSSignInURL is used.
+	ACSURL string
+
+	// MFA is consulted when an IdP form asks for a one-time code.  If it is
+	// nil, the code is read from the terminal.
+	MFA MFAPrompter
+
+	// Provider performs the login with the IdP.  If it is nil, the
+	// Federator itself fills in the IdP's HTML login forms.
+	Provider Provider
+
+	// Ledger records which assertions have been exchanged with STS so that
+	// one-time-use assertions are not replayed.  New sets it to an
+	// in-memory ledger.
+	Ledger AssertionLedger
+
+	// DuoFactor is the Duo factor used when the IdP requires Duo: DuoPush
+	// (the default), DuoPasscode or DuoPhone.
+	DuoFactor string
+
+	// UsernameField and PasswordField name the inputs of the IdP's login
+	// forms receiving the username and password.  When they are empty the
+	// inputs are guessed from their names.
+	UsernameField string
+	PasswordField string
+
+	// ExtraFields are set in every form submitted to the IdP, replacing any
+	// values the form gives them.
+	ExtraFields url.Values
+
+	// Events, if set, is called as the Federator makes progress.
+	Events func(Event)
+
+	// STS configures how STS is called to assume roles.
+	STS STSConfig
+
+	// SessionDuration is how long the credentials returned by AssumeRole
+	// remain valid.  If it is zero, STS uses the role's default of one hour.
+	SessionDuration time.Duration
+
+	// SessionPolicy, if set, is an IAM policy document further limiting
+	// what the credentials returned by AssumeRole may do.
+	SessionPolicy string
+
 	http           *http.Client
+	transport      *http.Transport
 	samlResponse   *saml.Response
 	samlResponse64 string
 }
@@ -47,6 +98,7 @@ func New(u, p, sp string) (Federator, error) {
 		Username:    u,
 		Password:    p,
 		SPEntityUrl: sp,
+		Ledger:      &MemoryLedger{},
 	}
 
 	j, err := cookiejar.New(nil)
@@ -54,40 +106,86 @@ func New(u, p, sp string) (Federator, error) {
 		return fed, fmt.Errorf("Could not create cookiejar: %s", err)
 	}
 
+	fed.transport = &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     &tls.Config{},
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+
 	c := &http.Client{
-		Jar: j,
+		Jar:       j,
+		Transport: fed.transport,
 	}
 	fed.http = c
+	fed.Use(tlsDiagnostics(fed.transport))
 
 	return fed, nil
 }
 
+// Login authenticates with the IdP and stores the resulting SAML assertion
+// for use by GetRoles and AssumeRole.
 func (a *Federator) Login() error {
-	resp, err := a.http.Get(a.SPEntityUrl)
-	if err != nil {
-		return fmt.Errorf("Could not retrieve IDP login form: %s", err)
-	}
+	return a.LoginContext(context.Background())
+}
 
-	form, err := a.followFormSubmissionsToAWS(resp)
-	if err != nil {
-		return fmt.Errorf("Unable to get SAMLResponse: %s", err)
+// LoginContext is Login with a context to cancel the login.
+func (a *Federator) LoginContext(ctx context.Context) error {
+	var p Provider = a
+	if a.Provider != nil {
+		p = a.Provider
 	}
 
-	if _, exists := form.Values["SAMLResponse"]; !exists {
-		return fmt.Errorf("Authentication failed.  Reached AWS SP without SAMLResponse.")
+	a.emit(Event{Type: StageStarted, Stage: StageLogin})
+	assertion, err := p.Authenticate(ctx)
+	a.emit(Event{Type: StageFinished, Stage: StageLogin, Err: err})
+	if err != nil {
+		return err
 	}
 
-	sr, err := saml.ParseEncodedResponse(form.Values["SAMLResponse"][0])
+	sr, err := saml.ParseEncodedResponse(string(assertion))
 	if err != nil {
 		return fmt.Errorf("Unable to parse SAML response: %s\n", err)
 	}
 
 	a.samlResponse = sr
-	a.samlResponse64 = form.Values["SAMLResponse"][0]
+	a.samlResponse64 = string(assertion)
 
 	return nil
 }
 
+// Client returns the HTTP client used to talk to the IdP, so that providers
+// share its cookies, proxy and TLS configuration.
+func (a *Federator) Client() *http.Client {
+	return a.http
+}
+
+// Authenticate implements Provider for generic form based IdPs by filling
+// and submitting login forms until the IdP posts to the AWS SAML endpoint.
+func (a *Federator) Authenticate(ctx context.Context) (SAMLAssertion, error) {
+	req, err := http.NewRequest("GET", a.SPEntityUrl, nil)
+	if err != nil {
+		return "", fmt.Errorf("Could not retrieve IDP login form: %s", err)
+	}
+
+	resp, err := a.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("Could not retrieve IDP login form: %s", err)
+	}
+
+	form, err := a.followFormSubmissionsToAWS(ctx, resp)
+	if err == ErrInvalidCredentials {
+		return "", err
+	} else if err != nil {
+		return "", fmt.Errorf("Unable to get SAMLResponse: %s", err)
+	}
+
+	if _, exists := form.Values["SAMLResponse"]; !exists {
+		return "", fmt.Errorf("Authentication failed.  Reached AWS SP without SAMLResponse.")
+	}
+
+	return SAMLAssertion(form.Values["SAMLResponse"][0]), nil
+}
+
 func (a *Federator) GetRoles() ([]Role, error) {
 	var r []Role
 
@@ -97,29 +195,74 @@ func (a *Federator) GetRoles() ([]Role, error) {
 	}
 
 	for _, role := range roles {
+		if _, _, err := Role(role).Parse(); err != nil {
+			return nil, fmt.Errorf("Invalid role in SAMLResponse: %s", err)
+		}
 		r = append(r, Role(role))
 	}
+	a.emit(Event{Type: RolesReady, Roles: r})
 
 	return r, nil
 }
 
+// AssumeRole exchanges the SAML assertion for credentials for role r.
 func (a *Federator) AssumeRole(r Role) (Credentials, error) {
+	return a.AssumeRoleContext(context.Background(), r)
+}
+
+// AssumeRoleContext is AssumeRole with a context to cancel the STS request.
+func (a *Federator) AssumeRoleContext(ctx context.Context, r Role) (creds Credentials, err error) {
+	a.emit(Event{Type: StageStarted, Stage: StageAssumeRole})
+	defer func() {
+		a.emit(Event{Type: StageFinished, Stage: StageAssumeRole, Err: err})
+	}()
+
 	if a.samlResponse == nil {
 		return Credentials{}, fmt.Errorf("You must call Login before assuming a role")
 	}
 
-	svc := sts.New(session.New())
-	params := &sts.AssumeRoleWithSAMLInput{
+	info, err := parseAssertionInfo(a.samlResponse64)
+	if err != nil {
+		return Credentials{}, err
+	}
+	if info.OneTimeUse && a.Ledger != nil && a.Ledger.Consumed(info.key()) {
+		return Credentials{}, ErrAssertionConsumed
+	}
+
+	in := &sts.AssumeRoleWithSAMLInput{
 		PrincipalArn:  aws.String(r.PrincipalArn()),
 		RoleArn:       aws.String(r.RoleArn()),
 		SAMLAssertion: aws.String(a.samlResponse64),
 	}
-
-	resp, err := svc.AssumeRoleWithSAML(params)
+	if a.SessionDuration > 0 {
+		in.DurationSeconds = aws.Int32(int32(a.SessionDuration / time.Second))
+	}
+	if a.SessionPolicy != "" {
+		in.Policy = aws.String(a.SessionPolicy)
+	}
+	client, err := a.STS.client(nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	resp, err := client.AssumeRoleWithSAML(ctx, in, a.STS.debug(in, info))
 	if err != nil {
+		if durationExceeded(err) {
+			return Credentials{}, &DurationError{Role: r, Requested: a.SessionDuration}
+		}
+		if assertionRejected(err) {
+			return Credentials{}, &ExpiredAssertionError{NotOnOrAfter: info.NotOnOrAfter, Err: err}
+		}
 		return Credentials{}, fmt.Errorf("Unable to assume role: %s", err)
 	}
 
+	// the assertion is spent whether or not this is recorded, so the
+	// credentials are returned anyway
+	if a.Ledger != nil {
+		if err := a.Ledger.Consume(info.key(), info.NotOnOrAfter); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: Unable to record the consumed SAML assertion: %s\n", err)
+		}
+	}
+
 	return Credentials{
 		AccessKeyId:     *resp.Credentials.AccessKeyId,
 		Expiration:      *resp.Credentials.Expiration,
@@ -128,7 +271,7 @@ func (a *Federator) AssumeRole(r Role) (Credentials, error) {
 	}, nil
 }
 
-func (a *Federator) fillForm(r *http.Response) (loginForm, error) {
+func (a *Federator) fillForm(r *http.Response, otpLabel string) (loginForm, error) {
 	fv := loginForm{}
 	fv.Values = make(url.Values)
 
@@ -161,9 +304,19 @@ NodeLoop:
 					continue //element doesnt have name key
 				}
 				switch {
-				case strings.Contains(strings.ToLower(name), "user"):
+				case a.UsernameField != "" && name == a.UsernameField:
 					fv.Values.Add(name, a.Username)
-				case strings.Contains(strings.ToLower(name), "pass"):
+				case a.PasswordField != "" && name == a.PasswordField:
+					fv.Values.Add(name, a.Password)
+				case isOTPField(name, t.Attr):
+					code, err := a.Prompter().MFACode(otpLabel)
+					if err != nil {
+						return fv, err
+					}
+					fv.Values.Add(name, code)
+				case a.UsernameField == "" && strings.Contains(strings.ToLower(name), "user"):
+					fv.Values.Add(name, a.Username)
+				case a.PasswordField == "" && strings.Contains(strings.ToLower(name), "pass"):
 					fv.Values.Add(name, a.Password)
 				default:
 					value, err := findAttrVal("value", t.Attr)
@@ -176,15 +329,38 @@ NodeLoop:
 		}
 	}
 
+	for name, values := range a.ExtraFields {
+		fv.Values[name] = values
+	}
+
 	return fv, nil
 }
 
+// ParseFormFields parses a form_extra_fields setting of comma separated
+// name=value pairs, such as "AuthMethod=FormsAuthentication, Kmsi=true".
+func ParseFormFields(s string) (url.Values, error) {
+	fields := make(url.Values)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("Form field '%s' must be given as name=value", pair)
+		}
+		fields.Add(name, strings.TrimSpace(parts[1]))
+	}
+	return fields, nil
+}
+
 // followFormSubmissionsToSP parses a http.Response object for form fields.
 //  When form fields are found, the method will attempt to substitute configured
 //  username and password values into their respective form fields.
 //  Once the redirects have reached the AWS SAML SP, the method will return
 //  the filled form that should contain the SAMLResponse field.
-func (a Federator) followFormSubmissionsToAWS(r *http.Response) (loginForm, error) {
+func (a Federator) followFormSubmissionsToAWS(ctx context.Context, r *http.Response) (loginForm, error) {
 	cur := r
 	count := 0 //basic checker to ensure we are not stuck in a post loop
 	lastForm := loginForm{}
@@ -194,15 +370,49 @@ func (a Federator) followFormSubmissionsToAWS(r *http.Response) (loginForm, erro
 			return loginForm{}, fmt.Errorf("Could not reach AWS SP due to redirect loop")
 		}
 
-		login, err := a.fillForm(cur)
+		body, err := ioutil.ReadAll(cur.Body)
+		cur.Body.Close()
 		if err != nil {
-			fmt.Printf("Error getting login form.  Cannot continue.\n")
+			return loginForm{}, fmt.Errorf("Could not read IDP response: %s", err)
+		}
+
+		// Duo prompts are completed through Duo's API before carrying on
+		duo := duoPrompt{client: a.http, mfa: a.Prompter(), factor: a.DuoFactor}
+		if isDuoUniversal(cur.Request.URL) {
+			if cur, err = duo.universal(ctx, cur.Request.URL, body); err != nil {
+				return loginForm{}, err
+			}
+			count++
+			continue
+		}
+		if frame, ok := findDuoFrame(body); ok {
+			if cur, err = a.submitDuoFrame(ctx, duo, frame, cur.Request.URL, body); err != nil {
+				return loginForm{}, err
+			}
+			count++
+			continue
+		}
+
+		// portals may post to several service providers from one page
+		if form, ok := samlForm(cur.Request.URL, a.ACSURL, bytes.NewReader(body)); ok {
+			lastForm = form
+			break
+		}
+
+		cur.Body = ioutil.NopCloser(bytes.NewReader(body))
+		login, err := a.fillForm(cur, otpLabel(body))
+		if err != nil {
+			return loginForm{}, fmt.Errorf("Error getting login form: %s", err)
+		}
+
+		if login.URL == "" {
+			return loginForm{}, fmt.Errorf("IDP returned a page without a login form (HTTP %s)", cur.Status)
 		}
 
 		// check if the form has been posted already (possible wrong password)
 		if lastForm.URL == login.URL {
 			if match := reflect.DeepEqual(lastForm.Values, login.Values); match {
-				return loginForm{}, fmt.Errorf("Invalid username or password")
+				return loginForm{}, ErrInvalidCredentials
 			}
 		}
 		lastForm = login
@@ -213,16 +423,21 @@ func (a Federator) followFormSubmissionsToAWS(r *http.Response) (loginForm, erro
 		}
 
 		// redirects have taken us to the AWS saml endpoint, it has been successful
-		if url.Host == "signin.aws.amazon.com" {
+		if isACS(a.ACSURL, url) {
 			lastForm = login
 			break
 		}
 
-		resp, err := a.http.PostForm(login.URL, login.Values)
+		req, err := http.NewRequest("POST", login.URL, strings.NewReader(login.Values.Encode()))
 		if err != nil {
-			fmt.Printf("Failed to post form: %s", err)
 			return loginForm{}, err
 		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		resp, err := a.http.Do(req.WithContext(ctx))
+		if err != nil {
+			return loginForm{}, fmt.Errorf("Failed to post form: %s", err)
+		}
 
 		count++
 		cur = resp
@@ -231,6 +446,56 @@ func (a Federator) followFormSubmissionsToAWS(r *http.Response) (loginForm, erro
 	return lastForm, nil
 }
 
+// submitDuoFrame completes the Duo prompt embedded in page and posts the
+// signed response back to the IdP, along with the fields of the form
+// holding the prompt.
+func (a Federator) submitDuoFrame(ctx context.Context, duo duoPrompt, frame duoFrame, page *url.URL, body []byte) (*http.Response, error) {
+	sig, err := duo.sign(ctx, frame, page.String())
+	if err != nil {
+		return nil, err
+	}
+
+	form, err := formWithID(page, bytes.NewReader(body), "duo_form")
+	if err != nil {
+		form = loginForm{URL: page.String(), Values: make(url.Values)}
+	}
+	if frame.PostAction != "" {
+		u, err := page.Parse(frame.PostAction)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Duo post action '%s': %s", frame.PostAction, err)
+		}
+		form.URL = u.String()
+	}
+	form.Values.Set("sig_response", sig)
+
+	req, err := formRequest(form.URL, form.Values)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to post Duo response: %s", err)
+	}
+	return resp, nil
+}
+
+// isOTPField guesses whether a visible form input is asking for a one-time
+// code.  It must be checked before the password match as fields such as
+// "passcode" would otherwise be filled with the password.
+func isOTPField(name string, attrs []html.Attribute) bool {
+	if t, err := findAttrVal("type", attrs); err == nil && strings.ToLower(t) == "hidden" {
+		return false
+	}
+
+	name = strings.ToLower(name)
+	for _, hint := range []string{"otp", "mfa", "passcode", "tokencode", "verificationcode", "securitycode"} {
+		if strings.Contains(name, hint) {
+			return true
+		}
+	}
+	return false
+}
+
 func findAttrVal(key string, a []html.Attribute) (string, error) {
 	for _, attr := range a {
 		if key == attr.Key {
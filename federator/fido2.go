@@ -0,0 +1,144 @@
+package federator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// FIDO2Authenticator signs WebAuthn requests with a security key through
+// libfido2's command line tools, fido2-token and fido2-assert, which are
+// packaged for most platforms (for example as fido2-tools or libfido2).
+// fido2-assert asks for the key's PIN itself if the key needs one.
+type FIDO2Authenticator struct {
+	// Device is the key to use, as listed by fido2-token -L.  If it is
+	// empty, the first key found is used.
+	Device string
+}
+
+// FIDO2Available reports whether fido2-assert is installed, so that a
+// FIDO2Authenticator can be used.
+func FIDO2Available() bool {
+	_, err := exec.LookPath("fido2-assert")
+	return err == nil
+}
+
+// Assert implements WebAuthnAuthenticator.  When several credentials are
+// allowed, those on the key are found without the user's presence first,
+// so that the key is only touched once.
+func (a FIDO2Authenticator) Assert(ctx context.Context, req WebAuthnRequest) (WebAuthnAssertion, error) {
+	device := a.Device
+	if device == "" {
+		var err error
+		if device, err = fido2Device(ctx); err != nil {
+			return WebAuthnAssertion{}, err
+		}
+	}
+
+	ids := req.CredentialIDs
+	if len(ids) > 1 {
+		var present [][]byte
+		for _, id := range ids {
+			if _, err := fido2Assert(ctx, device, req, id, false); err == nil {
+				present = append(present, id)
+			}
+		}
+		// keys without silent assertions are asked for each credential
+		if len(present) > 0 {
+			ids = present
+		}
+	}
+
+	var err error
+	for _, id := range ids {
+		var assertion WebAuthnAssertion
+		if assertion, err = fido2Assert(ctx, device, req, id, true); err == nil {
+			return assertion, nil
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("no credentials were offered")
+	}
+	return WebAuthnAssertion{}, fmt.Errorf("The security key did not sign in: %s", err)
+}
+
+// fido2Device returns the first key listed by fido2-token -L, whose lines
+// are of the form "/dev/hidraw3: vendor=0x1050, product=0x0407 (Yubico ...)".
+func fido2Device(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, "fido2-token", "-L").Output()
+	if err != nil {
+		return "", fmt.Errorf("Could not list security keys with fido2-token: %s", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// device paths may contain colons, such as ioreg://123 on darwin
+		if i := strings.Index(line, ": "); i > 0 {
+			return line[:i], nil
+		}
+	}
+	return "", fmt.Errorf("No security key was found, plug it in and try again")
+}
+
+// fido2Assert has device sign req with the credential id, requiring the
+// user's presence if up is set.  fido2-assert -G reads the client data hash,
+// relying party ID and credential ID on separate lines, and writes the
+// client data hash, relying party ID, authenticator data and signature.
+func fido2Assert(ctx context.Context, device string, req WebAuthnRequest, id []byte, up bool) (WebAuthnAssertion, error) {
+	args := []string{"-G", "-t", fmt.Sprintf("up=%t", up), device}
+	in := base64.StdEncoding.EncodeToString(req.ClientDataHash) + "\n" + req.RPID + "\n" + base64.StdEncoding.EncodeToString(id) + "\n"
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "fido2-assert", args...)
+	cmd.Stdin = strings.NewReader(in)
+	cmd.Stdout = &out
+	if up {
+		// the PIN prompt, if any
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Run(); err != nil {
+		return WebAuthnAssertion{}, err
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) < 4 {
+		return WebAuthnAssertion{}, fmt.Errorf("fido2-assert returned %d lines, expected 4", len(lines))
+	}
+	authData, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[2]))
+	if err != nil {
+		return WebAuthnAssertion{}, fmt.Errorf("fido2-assert returned invalid authenticator data: %s", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil {
+		return WebAuthnAssertion{}, fmt.Errorf("fido2-assert returned an invalid signature: %s", err)
+	}
+	return WebAuthnAssertion{CredentialID: id, AuthenticatorData: cborBytes(authData), Signature: sig}, nil
+}
+
+// cborBytes returns the content of b if it is a CBOR byte string, as
+// libfido2 encodes authenticator data, or b itself.
+func cborBytes(b []byte) []byte {
+	if len(b) == 0 || b[0]>>5 != 2 {
+		return b
+	}
+	n, head := 0, 1
+	switch info := int(b[0] & 0x1f); {
+	case info < 24:
+		n = info
+	case info == 24 && len(b) > 1:
+		n, head = int(b[1]), 2
+	case info == 25 && len(b) > 2:
+		n, head = int(b[1])<<8|int(b[2]), 3
+	default:
+		return b
+	}
+	if len(b) != head+n {
+		return b
+	}
+	return b[head:]
+}
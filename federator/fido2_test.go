@@ -0,0 +1,85 @@
+package federator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeFIDO2 installs fido2-token and fido2-assert scripts on PATH for a key
+// holding only the credential "second", which logs each assertion's
+// options and credential to the returned file.
+func fakeFIDO2(t *testing.T) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake libfido2 tools are shell scripts")
+	}
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+	second := base64.StdEncoding.EncodeToString([]byte("second"))
+	// the authenticator data is CBOR encoded, as libfido2 writes it
+	authData := base64.StdEncoding.EncodeToString(append([]byte{0x58, 37}, bytes.Repeat([]byte{0xad}, 37)...))
+
+	scripts := map[string]string{
+		"fido2-token": "#!/bin/sh\necho '/dev/hidraw7: vendor=0x1050, product=0x0407 (Yubico YubiKey OTP+FIDO+CCID)'\n",
+		"fido2-assert": `#!/bin/sh
+read hash; read rp; read cred
+echo "$3 $4 $cred" >> ` + log + `
+[ "$cred" = "` + second + `" ] || exit 1
+printf '%s\n%s\n%s\n%s\n' "$hash" "$rp" "` + authData + `" "c2lnbmF0dXJl"
+`,
+	}
+	for name, script := range scripts {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log
+}
+
+func TestFIDO2Authenticator(t *testing.T) {
+	log := fakeFIDO2(t)
+	if !FIDO2Available() {
+		t.Fatal("fido2-assert not found")
+	}
+
+	assertion, err := FIDO2Authenticator{}.Assert(context.Background(), WebAuthnRequest{
+		RPID:           "example.okta.com",
+		ClientDataHash: bytes.Repeat([]byte{1}, 32),
+		CredentialIDs:  [][]byte{[]byte("first"), []byte("second")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(assertion.CredentialID) != "second" || string(assertion.Signature) != "signature" || !bytes.Equal(assertion.AuthenticatorData, bytes.Repeat([]byte{0xad}, 37)) {
+		t.Errorf("unexpected assertion %+v", assertion)
+	}
+
+	calls, _ := ioutil.ReadFile(log)
+	first, second := base64.StdEncoding.EncodeToString([]byte("first")), base64.StdEncoding.EncodeToString([]byte("second"))
+	want := "up=false /dev/hidraw7 " + first + "\n" +
+		"up=false /dev/hidraw7 " + second + "\n" +
+		"up=true /dev/hidraw7 " + second + "\n"
+	if string(calls) != want {
+		t.Errorf("fido2-assert was run as\n%s\nwant the key touched once\n%s", calls, want)
+	}
+}
+
+func TestFIDO2AuthenticatorNoCredential(t *testing.T) {
+	fakeFIDO2(t)
+
+	_, err := FIDO2Authenticator{Device: "/dev/hidraw7"}.Assert(context.Background(), WebAuthnRequest{
+		RPID:           "example.okta.com",
+		ClientDataHash: bytes.Repeat([]byte{1}, 32),
+		CredentialIDs:  [][]byte{[]byte("other")},
+	})
+	if err == nil || !strings.Contains(err.Error(), "did not sign in") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
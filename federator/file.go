@@ -0,0 +1,42 @@
+package federator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic replaces path with data by renaming a temporary file in
+// the same directory over it, so that readers never see a partially
+// written file.  The temporary file has a unique name, so concurrent
+// writers can't interleave their data, and is removed if anything fails.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package federator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"regexp"
+	"strings"
+)
+
+// googleMaxPages bounds the number of pages followed during a Google login.
+const googleMaxPages = 10
+
+// googleError matches the error shown next to a field of the sign in form.
+var googleError = regexp.MustCompile(`(?s)class="[^"]*error-msg[^"]*"[^>]*>\s*([^<]+?)\s*<`)
+
+// GoogleProvider logs in through accounts.google.com, which asks for the
+// email address, password and any second step on separate pages, to obtain
+// the SAML assertion of a Google Workspace SAML app for AWS.
+type GoogleProvider struct {
+	// AppURL is the IdP initiated SSO URL of the SAML app, for example
+	// https://accounts.google.com/o/saml2/initsso?idpid=<idp id>&spid=<sp id>&forceauthn=false
+	AppURL   string
+	Username string
+	Password string
+
+	// MFA is asked for a code when Google requires a second step.
+	MFA MFAPrompter
+
+	// Client is used for all requests.  Google tracks the login in
+	// cookies, so it must have a cookie jar.  If it is nil, a client with
+	// its own cookie jar is created.
+	Client *http.Client
+}
+
+func init() {
+	RegisterProvider("google", func(cfg ProviderConfig) (Provider, error) {
+		return &GoogleProvider{
+			AppURL:   cfg.URL,
+			Username: cfg.Username,
+			Password: cfg.Password,
+			MFA:      cfg.MFA,
+			Client:   cfg.Client,
+		}, nil
+	})
+}
+
+// Authenticate implements Provider.
+func (g *GoogleProvider) Authenticate(ctx context.Context) (SAMLAssertion, error) {
+	if g.Client == nil || g.Client.Jar == nil {
+		j, err := cookiejar.New(nil)
+		if err != nil {
+			return "", fmt.Errorf("Could not create cookiejar: %s", err)
+		}
+		c := &http.Client{Jar: j}
+		if g.Client != nil {
+			c.Transport = g.Client.Transport
+		}
+		g.Client = c
+	}
+
+	req, err := http.NewRequest("GET", g.AppURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("Invalid Google SSO URL '%s': %s", g.AppURL, err)
+	}
+
+	passwordSent, codeSent := false, false
+	for i := 0; i < googleMaxPages; i++ {
+		resp, err := g.Client.Do(req.WithContext(ctx))
+		if err != nil {
+			return "", fmt.Errorf("Google request failed: %s", err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return "", fmt.Errorf("Could not read Google response: %s", err)
+		}
+		if resp.StatusCode >= 500 {
+			return "", fmt.Errorf("Google returned HTTP %s", resp.Status)
+		}
+
+		if assertion, err := samlResponseFromPage(bytes.NewReader(body)); err == nil {
+			return assertion, nil
+		}
+
+		// the sign in pages use gaia_loginform, and second steps a form
+		// called challenge alongside links to other methods
+		form, err := formWithID(resp.Request.URL, bytes.NewReader(body), "gaia_loginform")
+		if err != nil {
+			form, err = formWithID(resp.Request.URL, bytes.NewReader(body), "challenge")
+		}
+		if err != nil {
+			form, err = hiddenForm(resp.Request.URL, bytes.NewReader(body))
+		}
+		if err != nil {
+			return "", fmt.Errorf("Google returned an unexpected page (HTTP %s)", resp.Status)
+		}
+
+		switch {
+		case hasField(form.Values, "logincaptcha") || hasField(form.Values, "identifier-captcha-input"):
+			return "", fmt.Errorf("Google requires a CAPTCHA.  Sign in to https://accounts.google.com in a browser, then try again")
+		case hasField(form.Values, "Passwd"):
+			if passwordSent {
+				return "", ErrInvalidCredentials
+			}
+			passwordSent = true
+			form.Values.Set("Email", g.Username)
+			form.Values.Set("Passwd", g.Password)
+		case hasField(form.Values, "Email"):
+			form.Values.Set("Email", g.Username)
+		case hasField(form.Values, "Pin"):
+			if codeSent {
+				msg := "the code was not accepted"
+				if m := googleError.FindSubmatch(body); m != nil {
+					msg = string(m[1])
+				}
+				return "", fmt.Errorf("Google 2-Step Verification failed: %s", msg)
+			}
+			codeSent = true
+
+			label := "Google verification code"
+			switch {
+			case strings.Contains(form.URL, "/challenge/totp/"):
+				label = "Google Authenticator code"
+			case strings.Contains(form.URL, "/challenge/ipp/"):
+				label = "Google SMS code"
+			}
+
+			prompter := g.MFA
+			if prompter == nil {
+				prompter = TerminalPrompter{}
+			}
+			code, err := prompter.MFACode(label)
+			if err != nil {
+				return "", err
+			}
+			form.Values.Set("Pin", code)
+			form.Values.Set("TrustDevice", "on")
+		case strings.Contains(form.URL, "/challenge/az/") || strings.Contains(form.URL, "/challenge/sk/"):
+			return "", fmt.Errorf("Google 2-Step Verification by phone prompt or security key is not supported.  Make an authenticator app or SMS code your default second step")
+		}
+
+		if req, err = formRequest(form.URL, form.Values); err != nil {
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("Google login did not reach AWS after %d pages", googleMaxPages)
+}
@@ -0,0 +1,198 @@
+//go:build chromedp
+// +build chromedp
+
+package federator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// headlessTimeout bounds a whole headless login, so that a page the fill
+// script doesn't understand can't keep Chrome running forever.
+const headlessTimeout = 2 * time.Minute
+
+// HeadlessProvider logs in by driving a headless Chrome with chromedp, for
+// IdPs whose login pages only work with JavaScript.  Visible username,
+// password and one-time code fields are filled and submitted on each page
+// until the IdP posts to the AWS SAML endpoint, where the SAMLResponse is
+// captured from the request.
+type HeadlessProvider struct {
+	URL      string
+	Username string
+	Password string
+	MFA      MFAPrompter
+
+	// ACSURL is the assertion consumer service whose request carries the
+	// SAMLResponse.  If it is empty, AWSSignInURL is used.
+	ACSURL string
+
+	// Show runs Chrome with a window, to watch or help with the login.
+	Show bool
+}
+
+func init() {
+	RegisterProvider("browser-headless", func(cfg ProviderConfig) (Provider, error) {
+		return &HeadlessProvider{
+			URL:      cfg.URL,
+			Username: cfg.Username,
+			Password: cfg.Password,
+			MFA:      cfg.MFA,
+			ACSURL:   cfg.ACSURL,
+			Show:     cfg.setting("headless_show", "") == "true",
+		}, nil
+	})
+}
+
+// Authenticate implements Provider.
+func (p *HeadlessProvider) Authenticate(ctx context.Context) (SAMLAssertion, error) {
+	ctx, cancel := context.WithTimeout(ctx, headlessTimeout)
+	defer cancel()
+
+	opts := append(chromedp.DefaultExecAllocatorOptions[:], chromedp.Flag("headless", !p.Show))
+	ctx, cancel = chromedp.NewExecAllocator(ctx, opts...)
+	defer cancel()
+	ctx, cancel = chromedp.NewContext(ctx)
+	defer cancel()
+
+	acs := p.ACSURL
+	if acs == "" {
+		acs = AWSSignInURL
+	}
+
+	captured := make(chan SAMLAssertion, 1)
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		e, ok := ev.(*network.EventRequestWillBeSent)
+		if !ok {
+			return
+		}
+		if u, err := url.Parse(e.Request.URL); err != nil || !isACS(acs, u) {
+			return
+		}
+		if v := headlessSAMLResponse(e.Request); v != "" {
+			select {
+			case captured <- SAMLAssertion(v):
+			default:
+			}
+		}
+	})
+
+	// the assertion is only captured, AWS never has to see it
+	err := chromedp.Run(ctx,
+		network.Enable(),
+		network.SetBlockedURLs([]string{acs + "*"}),
+		chromedp.Navigate(p.URL),
+	)
+	if err != nil {
+		return "", fmt.Errorf("Could not open the IdP in Chrome: %s", err)
+	}
+
+	code := ""
+	passwordSent := false
+	for {
+		select {
+		case assertion := <-captured:
+			return assertion, nil
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return "", fmt.Errorf("The IdP did not post a SAMLResponse within %s", headlessTimeout)
+			}
+			return "", ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+
+		var state string
+		if err := chromedp.Run(ctx, chromedp.Evaluate(headlessFillScript(p.Username, p.Password, code, passwordSent), &state)); err != nil {
+			// the page is navigating, try again once it has loaded
+			continue
+		}
+		switch state {
+		case "otp":
+			if code, err = p.MFA.MFACode(""); err != nil {
+				return "", err
+			}
+		case "rejected":
+			// submitting again would only lock the account
+			return "", ErrInvalidCredentials
+		case "submitted-password":
+			passwordSent = true
+			code = ""
+		case "submitted":
+			code = ""
+		}
+	}
+}
+
+// headlessSAMLResponse returns the SAMLResponse posted by a request.
+func headlessSAMLResponse(req *network.Request) string {
+	var body bytes.Buffer
+	for _, entry := range req.PostDataEntries {
+		b, err := base64.StdEncoding.DecodeString(entry.Bytes)
+		if err != nil {
+			return ""
+		}
+		body.Write(b)
+	}
+	form, err := url.ParseQuery(body.String())
+	if err != nil {
+		return ""
+	}
+	return form.Get("SAMLResponse")
+}
+
+// headlessFillScript returns JavaScript which fills the empty, visible
+// login fields of the current page and submits them.  It evaluates to
+// "otp" when a one-time code is needed but code is empty, "rejected" when
+// an empty password field is shown again after passwordSent,
+// "submitted-password" or "submitted" once it has submitted the page with or
+// without the password, and "wait" otherwise.
+func headlessFillScript(user, pass, code string, passwordSent bool) string {
+	args, _ := json.Marshal([]interface{}{user, pass, code, passwordSent})
+	return `(function(user, pass, code, passwordSent) {
+	function visible(e) { return e.offsetParent !== null && !e.disabled && !e.readOnly; }
+	function set(e, v) {
+		e.focus();
+		e.value = v;
+		e.dispatchEvent(new Event('input', {bubbles: true}));
+		e.dispatchEvent(new Event('change', {bubbles: true}));
+	}
+	var inputs = [].slice.call(document.querySelectorAll('input')).filter(visible);
+	var otp = inputs.filter(function(e) {
+		return e.autocomplete === 'one-time-code' || /otp|passcode|tokencode|verif|mfa/i.test(e.name + ' ' + e.id);
+	})[0];
+	var pw = inputs.filter(function(e) { return e.type === 'password' && e !== otp; })[0];
+	var un = inputs.filter(function(e) {
+		return (e.type === 'text' || e.type === 'email') && e !== otp && /user|email|login|name/i.test(e.name + ' ' + e.id + ' ' + e.autocomplete);
+	})[0];
+
+	if (pw && !pw.value && passwordSent) { return 'rejected'; }
+
+	var changed = false;
+	if (otp && !otp.value) {
+		if (!code) { return 'otp'; }
+		set(otp, code);
+		changed = true;
+	}
+	if (un && !un.value) { set(un, user); changed = true; }
+	var filledPassword = false;
+	if (pw && !pw.value) { set(pw, pass); changed = true; filledPassword = true; }
+	if (!changed) { return 'wait'; }
+
+	var field = otp || pw || un;
+	var button = [].slice.call(document.querySelectorAll('button[type=submit], input[type=submit]')).filter(visible)[0];
+	if (button) {
+		button.click();
+	} else if (field.form) {
+		field.form.submit();
+	}
+	return filledPassword ? 'submitted-password' : 'submitted';
+}).apply(null, ` + string(args) + `)`
+}
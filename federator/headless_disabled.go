@@ -0,0 +1,12 @@
+//go:build !chromedp
+// +build !chromedp
+
+package federator
+
+import "fmt"
+
+func init() {
+	RegisterProvider("browser-headless", func(ProviderConfig) (Provider, error) {
+		return nil, fmt.Errorf("This build does not include headless browser support.  Rebuild with 'make TAGS=chromedp'")
+	})
+}
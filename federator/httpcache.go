@@ -0,0 +1,226 @@
+package federator
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPCache is an http.RoundTripper which keeps GET responses the IdP marks
+// as cacheable, such as discovery documents, metadata and static scripts,
+// in a directory so that they aren't fetched again on every login.  Fresh
+// responses are served from the cache and stale ones with a validator are
+// revalidated with a conditional request.  Responses which set cookies or
+// are private to the user are never stored.
+type HTTPCache struct {
+	Dir  string
+	Next http.RoundTripper
+}
+
+// cachedResponse is an HTTPCache entry.
+type cachedResponse struct {
+	URL      string
+	StoredAt time.Time
+	Status   int
+	Header   http.Header
+	Body     []byte
+}
+
+// SetHTTPCache caches the IdP's cacheable responses in dir.
+func (a *Federator) SetHTTPCache(dir string) {
+	a.Use(func(next http.RoundTripper) http.RoundTripper {
+		return &HTTPCache{Dir: dir, Next: next}
+	})
+}
+
+// RoundTrip implements http.RoundTripper.
+func (c *HTTPCache) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" || req.Header.Get("Authorization") != "" || req.Header.Get("Range") != "" {
+		return c.Next.RoundTrip(req)
+	}
+
+	path := filepath.Join(c.Dir, cacheKey(req.URL.String()))
+	cached, ok := c.load(path, req.URL.String())
+	if ok && cached.fresh(time.Now()) {
+		return cached.response(req), nil
+	}
+
+	if ok {
+		// revalidate with a copy, as a RoundTripper must not change req
+		r := new(http.Request)
+		*r = *req
+		r.Header = make(http.Header, len(req.Header)+2)
+		for k, v := range req.Header {
+			r.Header[k] = v
+		}
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		if lm := cached.Header.Get("Last-Modified"); lm != "" {
+			r.Header.Set("If-Modified-Since", lm)
+		}
+		req = r
+	}
+
+	resp, err := c.Next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		for k, v := range resp.Header {
+			cached.Header[k] = v
+		}
+		cached.StoredAt = time.Now()
+		c.store(path, cached)
+		return cached.response(req), nil
+	}
+
+	if !cacheable(resp) {
+		return resp, nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	c.store(path, cachedResponse{
+		URL:      req.URL.String(),
+		StoredAt: time.Now(),
+		Status:   resp.StatusCode,
+		Header:   resp.Header,
+		Body:     body,
+	})
+	return resp, nil
+}
+
+func (c *HTTPCache) load(path, url string) (cachedResponse, bool) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cachedResponse{}, false
+	}
+	var cached cachedResponse
+	if err := json.Unmarshal(data, &cached); err != nil || cached.URL != url {
+		return cachedResponse{}, false
+	}
+	return cached, true
+}
+
+// store writes an entry.  The cache is only an optimisation, so failures
+// are ignored.
+func (c *HTTPCache) store(path string, cached cachedResponse) {
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return
+	}
+	WriteFileAtomic(path, data, 0600)
+}
+
+func (cached cachedResponse) fresh(now time.Time) bool {
+	return now.Before(cached.StoredAt.Add(freshFor(cached.Header)))
+}
+
+func (cached cachedResponse) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(cached.Status) + " " + http.StatusText(cached.Status),
+		StatusCode:    cached.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        cached.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(cached.Body)),
+		ContentLength: int64(len(cached.Body)),
+		Request:       req,
+	}
+}
+
+func cacheKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheable reports whether resp may be stored: a successful or permanently
+// redirected response which is public, sets no cookies and is either fresh
+// for a while or can be revalidated.
+func cacheable(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusMovedPermanently, http.StatusPermanentRedirect:
+	default:
+		return false
+	}
+	if len(resp.Header["Set-Cookie"]) > 0 {
+		return false
+	}
+	if v := resp.Header.Get("Vary"); v != "" && !strings.EqualFold(strings.TrimSpace(v), "Accept-Encoding") {
+		return false
+	}
+
+	directives := cacheControl(resp.Header)
+	if _, ok := directives["no-store"]; ok {
+		return false
+	}
+	if _, ok := directives["private"]; ok {
+		return false
+	}
+	return freshFor(resp.Header) > 0 || resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""
+}
+
+// freshFor returns how long a response stays fresh after it is received,
+// from its max-age or Expires header.
+func freshFor(h http.Header) time.Duration {
+	directives := cacheControl(h)
+	if _, ok := directives["no-cache"]; ok {
+		return 0
+	}
+	if v, ok := directives["max-age"]; ok {
+		if secs, err := strconv.ParseInt(v, 10, 64); err == nil && secs > 0 {
+			return time.Duration(secs) * time.Second
+		}
+		return 0
+	}
+
+	expires, err := http.ParseTime(h.Get("Expires"))
+	if err != nil {
+		return 0
+	}
+	date, err := http.ParseTime(h.Get("Date"))
+	if err != nil {
+		date = time.Now()
+	}
+	if d := expires.Sub(date); d > 0 {
+		return d
+	}
+	return 0
+}
+
+func cacheControl(h http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, d := range strings.Split(h.Get("Cache-Control"), ",") {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		parts := strings.SplitN(d, "=", 2)
+		v := ""
+		if len(parts) == 2 {
+			v = strings.Trim(parts[1], `"`)
+		}
+		directives[strings.ToLower(parts[0])] = v
+	}
+	return directives
+}
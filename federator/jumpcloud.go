@@ -0,0 +1,212 @@
+package federator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+)
+
+// JumpCloudConsoleURL is the default JumpCloud user console, which
+// authenticates users for JumpCloud's SSO applications.
+const JumpCloudConsoleURL = "https://console.jumpcloud.com"
+
+// JumpCloudProvider logs in through JumpCloud's user console API, which is
+// protected by an XSRF token, and then launches the AWS SSO application.
+type JumpCloudProvider struct {
+	// AppURL is the IdP URL of the AWS application, for example
+	// https://sso.jumpcloud.com/saml2/aws
+	AppURL   string
+	Username string
+	Password string
+
+	// ConsoleURL is the user console to log in with.  If it is empty,
+	// JumpCloudConsoleURL is used.
+	ConsoleURL string
+
+	// MFA is asked for a TOTP code when JumpCloud requires one.
+	MFA MFAPrompter
+
+	// Client is used for all requests.  JumpCloud ties the XSRF token and
+	// login to cookies, so it must have a cookie jar.  If it is nil, a
+	// client with its own cookie jar is created.
+	Client *http.Client
+}
+
+func init() {
+	RegisterProvider("jumpcloud", func(cfg ProviderConfig) (Provider, error) {
+		return &JumpCloudProvider{
+			AppURL:   cfg.URL,
+			Username: cfg.Username,
+			Password: cfg.Password,
+			MFA:      cfg.MFA,
+			Client:   cfg.Client,
+		}, nil
+	})
+}
+
+type jumpCloudResponse struct {
+	XSRF       string `json:"xsrf"`
+	RedirectTo string `json:"redirectTo"`
+	Message    string `json:"message"`
+	Error      string `json:"error"`
+}
+
+// Authenticate implements Provider.
+func (j *JumpCloudProvider) Authenticate(ctx context.Context) (SAMLAssertion, error) {
+	if j.Client == nil || j.Client.Jar == nil {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return "", fmt.Errorf("Could not create cookiejar: %s", err)
+		}
+		c := &http.Client{Jar: jar}
+		if j.Client != nil {
+			c.Transport = j.Client.Transport
+		}
+		j.Client = c
+	}
+
+	app, err := url.Parse(j.AppURL)
+	if err != nil || app.Host == "" {
+		return "", fmt.Errorf("Invalid JumpCloud application URL '%s'", j.AppURL)
+	}
+	console := j.ConsoleURL
+	if console == "" {
+		console = JumpCloudConsoleURL
+	}
+	console = strings.TrimRight(console, "/")
+
+	xsrf, _, err := j.do(ctx, "GET", console+"/userconsole/xsrf", "", nil)
+	if err != nil {
+		return "", err
+	}
+	if xsrf.XSRF == "" {
+		return "", fmt.Errorf("JumpCloud did not return an XSRF token")
+	}
+
+	login := map[string]string{
+		"context":    "sso",
+		"redirectTo": strings.TrimPrefix(app.Path, "/"),
+		"email":      j.Username,
+		"password":   j.Password,
+		"otp":        "",
+	}
+	resp, status, err := j.do(ctx, "POST", console+"/userconsole/auth", xsrf.XSRF, login)
+	if err != nil {
+		return "", err
+	}
+
+	if status == http.StatusUnauthorized && resp.mfaRequired() {
+		prompter := j.MFA
+		if prompter == nil {
+			prompter = TerminalPrompter{}
+		}
+		code, err := prompter.MFACode("JumpCloud TOTP code")
+		if err != nil {
+			return "", err
+		}
+
+		login["otp"] = code
+		if resp, status, err = j.do(ctx, "POST", console+"/userconsole/auth", xsrf.XSRF, login); err != nil {
+			return "", err
+		}
+		if status == http.StatusUnauthorized {
+			return "", fmt.Errorf("JumpCloud MFA failed: %s", resp.message())
+		}
+	}
+
+	switch {
+	case status == http.StatusUnauthorized:
+		return "", ErrInvalidCredentials
+	case status != http.StatusOK:
+		return "", fmt.Errorf("JumpCloud login failed: %s (HTTP %d)", resp.message(), status)
+	}
+
+	next := app
+	if resp.RedirectTo != "" {
+		base, _ := url.Parse(console + "/")
+		if next, err = base.Parse(resp.RedirectTo); err != nil {
+			return "", fmt.Errorf("JumpCloud returned an invalid redirect '%s'", resp.RedirectTo)
+		}
+	}
+
+	req, err := http.NewRequest("GET", next.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	page, err := j.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("JumpCloud request failed: %s", err)
+	}
+	defer page.Body.Close()
+
+	body, err := ioutil.ReadAll(page.Body)
+	if err != nil {
+		return "", fmt.Errorf("Could not read JumpCloud response: %s", err)
+	}
+	if page.StatusCode >= 500 {
+		return "", fmt.Errorf("JumpCloud returned HTTP %s", page.Status)
+	}
+
+	assertion, err := samlResponseFromPage(bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("JumpCloud did not return a SAML assertion.  Check that you are assigned the application: %s", err)
+	}
+	return assertion, nil
+}
+
+// do sends a request to the user console API, returning its JSON response
+// and HTTP status.
+func (j *JumpCloudProvider) do(ctx context.Context, method, u, xsrf string, body interface{}) (jumpCloudResponse, int, error) {
+	var r jumpCloudResponse
+
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return r, 0, err
+		}
+	}
+
+	req, err := http.NewRequest(method, u, bytes.NewReader(b))
+	if err != nil {
+		return r, 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if xsrf != "" {
+		req.Header.Set("X-Xsrftoken", xsrf)
+	}
+
+	resp, err := j.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return r, 0, fmt.Errorf("JumpCloud request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return r, resp.StatusCode, fmt.Errorf("JumpCloud returned HTTP %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil && resp.StatusCode == http.StatusOK {
+		return r, resp.StatusCode, fmt.Errorf("JumpCloud returned an unexpected response (HTTP %s)", resp.Status)
+	}
+	return r, resp.StatusCode, nil
+}
+
+func (r jumpCloudResponse) mfaRequired() bool {
+	return strings.Contains(strings.ToLower(r.message()), "mfa required")
+}
+
+func (r jumpCloudResponse) message() string {
+	if r.Message != "" {
+		return r.Message
+	}
+	return r.Error
+}
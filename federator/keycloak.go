@@ -0,0 +1,140 @@
+package federator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"regexp"
+	"strings"
+)
+
+// keycloakMaxPages bounds the number of pages followed during a Keycloak
+// login.
+const keycloakMaxPages = 8
+
+// keycloakError matches the message of Keycloak's error pages and login
+// form feedback.
+var keycloakError = regexp.MustCompile(`(?s)class="[^"]*(?:kc-feedback-text|instruction|pf-c-alert__title)[^"]*"[^>]*>\s*([^<]+?)\s*<`)
+
+// KeycloakProvider logs in through a Keycloak realm's login form and, when
+// configured, its separate one-time password page.
+type KeycloakProvider struct {
+	// AppURL is the IdP initiated SSO URL of the AWS client, for example
+	// https://keycloak.example.com/realms/corp/protocol/saml/clients/amazon-aws
+	AppURL   string
+	Username string
+	Password string
+
+	// MFA is asked for the one-time password when Keycloak requires one.
+	MFA MFAPrompter
+
+	// Client is used for all requests.  Keycloak tracks the login in
+	// cookies, so it must have a cookie jar.  If it is nil, a client with
+	// its own cookie jar is created.
+	Client *http.Client
+}
+
+func init() {
+	RegisterProvider("keycloak", func(cfg ProviderConfig) (Provider, error) {
+		return &KeycloakProvider{
+			AppURL:   cfg.URL,
+			Username: cfg.Username,
+			Password: cfg.Password,
+			MFA:      cfg.MFA,
+			Client:   cfg.Client,
+		}, nil
+	})
+}
+
+// Authenticate implements Provider.
+func (k *KeycloakProvider) Authenticate(ctx context.Context) (SAMLAssertion, error) {
+	if k.Client == nil || k.Client.Jar == nil {
+		j, err := cookiejar.New(nil)
+		if err != nil {
+			return "", fmt.Errorf("Could not create cookiejar: %s", err)
+		}
+		c := &http.Client{Jar: j}
+		if k.Client != nil {
+			c.Transport = k.Client.Transport
+		}
+		k.Client = c
+	}
+
+	req, err := http.NewRequest("GET", k.AppURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("Invalid Keycloak SSO URL '%s': %s", k.AppURL, err)
+	}
+
+	signedIn, otpSent := false, false
+	for i := 0; i < keycloakMaxPages; i++ {
+		resp, err := k.Client.Do(req.WithContext(ctx))
+		if err != nil {
+			return "", fmt.Errorf("Keycloak request failed: %s", err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return "", fmt.Errorf("Could not read Keycloak response: %s", err)
+		}
+		if resp.StatusCode >= 500 {
+			return "", fmt.Errorf("Keycloak returned HTTP %s", resp.Status)
+		}
+
+		if assertion, err := samlResponseFromPage(bytes.NewReader(body)); err == nil {
+			return assertion, nil
+		}
+
+		form, err := hiddenForm(resp.Request.URL, bytes.NewReader(body))
+		if err != nil {
+			// error pages, such as "Cookie not found", have no form
+			if m := keycloakError.FindSubmatch(body); m != nil {
+				return "", fmt.Errorf("Keycloak: %s", m[1])
+			}
+			return "", fmt.Errorf("Keycloak returned an unexpected page (HTTP %s)", resp.Status)
+		}
+
+		switch {
+		case hasField(form.Values, "password"):
+			if signedIn {
+				return "", ErrInvalidCredentials
+			}
+			signedIn = true
+			form.Values.Set("username", k.Username)
+			form.Values.Set("password", k.Password)
+		case hasField(form.Values, "otp") || hasField(form.Values, "totp"):
+			if otpSent {
+				msg := "invalid one-time password"
+				if m := keycloakError.FindSubmatch(body); m != nil {
+					msg = strings.TrimSpace(string(m[1]))
+				}
+				return "", fmt.Errorf("Keycloak: %s", msg)
+			}
+			otpSent = true
+
+			prompter := k.MFA
+			if prompter == nil {
+				prompter = TerminalPrompter{}
+			}
+			code, err := prompter.MFACode("Keycloak one-time code")
+			if err != nil {
+				return "", err
+			}
+
+			name := "otp"
+			if hasField(form.Values, "totp") {
+				// Keycloak before version 7
+				name = "totp"
+			}
+			form.Values.Set(name, code)
+		}
+
+		if req, err = formRequest(form.URL, form.Values); err != nil {
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("Keycloak login did not reach AWS after %d pages", keycloakMaxPages)
+}
@@ -0,0 +1,147 @@
+package federator
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// MFAPrompter supplies one-time codes when an IdP challenges for a second
+// factor.  Applications embedding the federator can provide their own
+// implementation to collect codes from a GUI or another program instead of
+// the terminal.
+type MFAPrompter interface {
+	// MFACode returns the code for the factor described by label.
+	MFACode(label string) (string, error)
+}
+
+// TerminalPrompter reads MFA codes from a terminal.  If In or Out are nil,
+// STDIN and STDERR are used.
+type TerminalPrompter struct {
+	In  io.Reader
+	Out io.Writer
+}
+
+func (t TerminalPrompter) MFACode(label string) (string, error) {
+	in, out := t.In, t.Out
+	if in == nil {
+		in = os.Stdin
+	}
+	if out == nil {
+		out = os.Stderr
+	}
+
+	fmt.Fprintf(out, "Enter %s: ", label)
+	code, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("Could not read MFA code: %s", err)
+	}
+
+	return strings.TrimSpace(code), nil
+}
+
+// TouchKey implements TouchPrompter.
+func (t TerminalPrompter) TouchKey(label string) {
+	out := t.Out
+	if out == nil {
+		out = os.Stderr
+	}
+	fmt.Fprintf(out, "%s...\n", label)
+}
+
+// TouchDone implements TouchPrompter.
+func (t TerminalPrompter) TouchDone(label string) {}
+
+// CommandPrompter runs an external command and uses the first line it
+// writes to STDOUT as the MFA code.  The factor label is made available to
+// the command through the AWS_FEDERATOR_MFA_LABEL environment variable.
+type CommandPrompter struct {
+	Command string
+}
+
+func (c CommandPrompter) MFACode(label string) (string, error) {
+	cmd := shellCommand(c.Command)
+	cmd.Env = append(os.Environ(), "AWS_FEDERATOR_MFA_LABEL="+label)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("MFA command failed: %s", err)
+	}
+
+	code := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	if code == "" {
+		return "", fmt.Errorf("MFA command did not return a code")
+	}
+
+	return code, nil
+}
+
+// TOTPPrompter generates RFC 6238 time based codes from a base32 encoded
+// shared secret without any user interaction.
+type TOTPPrompter struct {
+	Secret string
+}
+
+func (t TOTPPrompter) MFACode(label string) (string, error) {
+	return totp(t.Secret, time.Now())
+}
+
+// StaticPrompter supplies a code given up front, for example on the command
+// line.  The code is only handed out once as IdPs which ask again have
+// rejected it.
+type StaticPrompter struct {
+	Code string
+	used bool
+}
+
+func (s *StaticPrompter) MFACode(label string) (string, error) {
+	if s.used {
+		return "", fmt.Errorf("The supplied MFA code was not accepted for %s", label)
+	}
+	s.used = true
+	return s.Code, nil
+}
+
+// totp generates a six digit code for the 30 second window containing now.
+func totp(secret string, now time.Time) (string, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	if n := len(secret) % 8; n != 0 {
+		secret += strings.Repeat("=", 8-n)
+	}
+
+	key, err := base32.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return "", fmt.Errorf("Invalid TOTP secret: %s", err)
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(now.Unix()/30))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%06d", code%1000000), nil
+}
+
+// shellCommand runs command through the platform's shell.
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("/bin/sh", "-c", command)
+}
@@ -0,0 +1,17 @@
+package federator
+
+import "net/http"
+
+// Middleware wraps the http.RoundTripper making requests to the IdP, so
+// that embedders can add headers, log requests or sign them for a corporate
+// proxy without replacing the federator's client.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// Use wraps the IdP client's transport in each of middleware in turn, so the
+// last one given, or added by a later call, sees requests first.  Like
+// SetCookieJar, it must be called before the client is given to a provider.
+func (a *Federator) Use(middleware ...Middleware) {
+	for _, m := range middleware {
+		a.http.Transport = m(a.http.Transport)
+	}
+}
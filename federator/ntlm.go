@@ -0,0 +1,282 @@
+package federator
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+// NTLMTransport answers NTLM challenges from the IdP, as sent by ADFS's
+// Windows integrated authentication endpoint (/adfs/ls/auth/integrated), with
+// NTLMv2 responses computed from the user's password.  Requests the server
+// doesn't challenge are passed through unchanged.
+type NTLMTransport struct {
+	// Username is a UPN (user@example.com) or down-level logon name
+	// (EXAMPLE\user).
+	Username string
+	Password string
+
+	// Next makes the requests.  NTLM authenticates a connection rather
+	// than a request, so it must keep connections alive.
+	Next http.RoundTripper
+}
+
+// EnableNTLM makes the IdP's client answer NTLM challenges with the
+// federator's username and password.
+func (a *Federator) EnableNTLM() {
+	a.Use(func(next http.RoundTripper) http.RoundTripper {
+		return &NTLMTransport{Username: a.Username, Password: a.Password, Next: next}
+	})
+}
+
+const (
+	ntlmNegotiateUnicode        = 0x00000001
+	ntlmRequestTarget           = 0x00000004
+	ntlmNegotiateNTLM           = 0x00000200
+	ntlmNegotiateAlwaysSign     = 0x00008000
+	ntlmExtendedSessionSecurity = 0x00080000
+	ntlmNegotiateTargetInfo     = 0x00800000
+	ntlmNegotiate128            = 0x20000000
+	ntlmNegotiate56             = 0x80000000
+
+	ntlmNegotiateFlags = ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateNTLM | ntlmNegotiateAlwaysSign |
+		ntlmExtendedSessionSecurity | ntlmNegotiate128 | ntlmNegotiate56
+
+	// ntlmAvTimestamp is the AV_PAIR of the challenge's target info holding
+	// the server's time.
+	ntlmAvTimestamp = 7
+)
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// RoundTrip implements http.RoundTripper.
+func (t *NTLMTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// the body is sent up to three times
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	send := func(auth string) (*http.Response, error) {
+		r := new(http.Request)
+		*r = *req
+		r.Header = make(http.Header, len(req.Header)+1)
+		for k, v := range req.Header {
+			r.Header[k] = v
+		}
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		if req.Body != nil {
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+		}
+		return t.Next.RoundTrip(r)
+	}
+
+	resp, err := send("")
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	scheme, _, ok := ntlmHeader(resp)
+	if !ok {
+		return resp, nil
+	}
+	drain(resp)
+
+	resp, err = send(scheme + " " + base64.StdEncoding.EncodeToString(ntlmNegotiateMessage()))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	_, challenge, ok := ntlmHeader(resp)
+	if !ok || len(challenge) == 0 {
+		return resp, nil
+	}
+	drain(resp)
+
+	user, domain := splitNTLMUsername(t.Username)
+	auth, err := ntlmAuthenticateMessage(challenge, user, domain, t.Password, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return send(scheme + " " + base64.StdEncoding.EncodeToString(auth))
+}
+
+// ntlmHeader returns the NTLM scheme offered by a 401 response, NTLM or
+// Negotiate (which accepts raw NTLM messages), along with the challenge
+// message if it holds one.
+func ntlmHeader(resp *http.Response) (scheme string, message []byte, ok bool) {
+	for _, want := range []string{"NTLM", "Negotiate"} {
+		for _, h := range resp.Header["Www-Authenticate"] {
+			fields := strings.Fields(h)
+			if len(fields) == 0 || !strings.EqualFold(fields[0], want) {
+				continue
+			}
+			if len(fields) > 1 {
+				message, _ = base64.StdEncoding.DecodeString(fields[1])
+			}
+			return want, message, true
+		}
+	}
+	return "", nil, false
+}
+
+func drain(resp *http.Response) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// splitNTLMUsername splits EXAMPLE\user into its user and domain.  UPNs are
+// used whole with an empty domain.
+func splitNTLMUsername(username string) (user, domain string) {
+	if i := strings.Index(username, `\`); i >= 0 {
+		return username[i+1:], username[:i]
+	}
+	return username, ""
+}
+
+func ntlmNegotiateMessage() []byte {
+	msg := make([]byte, 32)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 1)
+	binary.LittleEndian.PutUint32(msg[12:], ntlmNegotiateFlags)
+	// empty domain and workstation fields
+	return msg
+}
+
+// ntlmAuthenticateMessage answers a challenge message with an NTLMv2
+// response.
+func ntlmAuthenticateMessage(challenge []byte, user, domain, password string, now time.Time) ([]byte, error) {
+	clientChallenge := make([]byte, 8)
+	if _, err := io.ReadFull(rand.Reader, clientChallenge); err != nil {
+		return nil, err
+	}
+	timestamp := make([]byte, 8)
+	binary.LittleEndian.PutUint64(timestamp, uint64(now.UnixNano()/100+116444736000000000))
+	return ntlmAuthenticate(challenge, user, domain, password, timestamp, clientChallenge)
+}
+
+// ntlmAuthenticate is ntlmAuthenticateMessage with the client's challenge
+// and its time as a FILETIME, which is used if the server doesn't give its
+// own.
+func ntlmAuthenticate(challenge []byte, user, domain, password string, timestamp, clientChallenge []byte) ([]byte, error) {
+	if len(challenge) < 48 || !bytes.Equal(challenge[:8], ntlmSignature) || binary.LittleEndian.Uint32(challenge[8:]) != 2 {
+		return nil, errors.New("Invalid NTLM challenge from server")
+	}
+	flags := binary.LittleEndian.Uint32(challenge[20:])
+	serverChallenge := challenge[24:32]
+
+	var targetInfo []byte
+	if flags&ntlmNegotiateTargetInfo != 0 {
+		var ok bool
+		if targetInfo, ok = ntlmField(challenge, 40); !ok {
+			return nil, errors.New("Invalid NTLM challenge from server")
+		}
+	}
+
+	// the server's time is used when it gives one, in which case the LMv2
+	// response is left empty
+	serverTimestamp, serverTime := ntlmTimestamp(targetInfo)
+	if serverTime {
+		timestamp = serverTimestamp
+	}
+
+	key := ntlmV2Hash(user, domain, password)
+
+	var temp bytes.Buffer
+	temp.Write([]byte{1, 1, 0, 0, 0, 0, 0, 0})
+	temp.Write(timestamp)
+	temp.Write(clientChallenge)
+	temp.Write([]byte{0, 0, 0, 0})
+	temp.Write(targetInfo)
+	temp.Write([]byte{0, 0, 0, 0})
+
+	ntResponse := append(hmacMD5(key, serverChallenge, temp.Bytes()), temp.Bytes()...)
+	lmResponse := make([]byte, 24)
+	if !serverTime {
+		lmResponse = append(hmacMD5(key, serverChallenge, clientChallenge), clientChallenge...)
+	}
+
+	fields := [][]byte{lmResponse, ntResponse, utf16LE(domain), utf16LE(user), nil, nil}
+	msg := make([]byte, 64)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+	for i, f := range fields {
+		at := 12 + i*8
+		binary.LittleEndian.PutUint16(msg[at:], uint16(len(f)))
+		binary.LittleEndian.PutUint16(msg[at+2:], uint16(len(f)))
+		binary.LittleEndian.PutUint32(msg[at+4:], uint32(len(msg)))
+		msg = append(msg, f...)
+	}
+	binary.LittleEndian.PutUint32(msg[60:], flags&ntlmNegotiateFlags|ntlmNegotiateUnicode)
+	return msg, nil
+}
+
+// ntlmField returns the payload referenced by the security buffer at offset
+// at of msg.
+func ntlmField(msg []byte, at int) ([]byte, bool) {
+	n := int(binary.LittleEndian.Uint16(msg[at:]))
+	offset := int(binary.LittleEndian.Uint32(msg[at+4:]))
+	if offset+n > len(msg) {
+		return nil, false
+	}
+	return msg[offset : offset+n], true
+}
+
+// ntlmTimestamp finds the server's time in the AV_PAIRs of a challenge's
+// target info.
+func ntlmTimestamp(info []byte) ([]byte, bool) {
+	for len(info) >= 4 {
+		id := binary.LittleEndian.Uint16(info)
+		n := int(binary.LittleEndian.Uint16(info[2:]))
+		if id == 0 || len(info) < 4+n {
+			break
+		}
+		if id == ntlmAvTimestamp && n == 8 {
+			return info[4:12], true
+		}
+		info = info[4+n:]
+	}
+	return nil, false
+}
+
+// ntlmV2Hash is NTOWFv2 from MS-NLMP.
+func ntlmV2Hash(user, domain, password string) []byte {
+	h := md4.New()
+	h.Write(utf16LE(password))
+	return hmacMD5(h.Sum(nil), utf16LE(strings.ToUpper(user)+domain))
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	h := hmac.New(md5.New, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+func utf16LE(s string) []byte {
+	codes := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(codes))
+	for i, c := range codes {
+		binary.LittleEndian.PutUint16(b[2*i:], c)
+	}
+	return b
+}
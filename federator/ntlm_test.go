@@ -0,0 +1,163 @@
+package federator
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// The values of MS-NLMP section 4.2.4, NTLMv2 authentication.
+var (
+	ntlmTestUser     = "User"
+	ntlmTestDomain   = "Domain"
+	ntlmTestPassword = "Password"
+
+	ntlmTestTimestamp       = make([]byte, 8)
+	ntlmTestClientChallenge = bytes.Repeat([]byte{0xaa}, 8)
+	ntlmTestServerChallenge = unhex("0123456789abcdef")
+
+	// the CHALLENGE_MESSAGE of section 4.2.4.3, targeting "Server" with
+	// the AV_PAIRs MsvAvNbDomainName "Domain" and MsvAvNbComputerName
+	// "Server"
+	ntlmTestChallenge = unhex("4e544c4d53535000020000000c000c003800000033828ae2" +
+		"0123456789abcdef0000000000000000240024004400000006007017" +
+		"0000000f53006500720076006500720002000c0044006f006d00610069006e00" +
+		"01000c005300650072007600650072000000000000000000")
+	ntlmTestTargetInfo = ntlmTestChallenge[0x44 : 0x44+0x24]
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestNTOWFv2(t *testing.T) {
+	want := unhex("0c868a403bfd7a93a3001ef22ef02e3f")
+	if got := ntlmV2Hash(ntlmTestUser, ntlmTestDomain, ntlmTestPassword); !bytes.Equal(got, want) {
+		t.Errorf("NTOWFv2 is %x, want %x", got, want)
+	}
+}
+
+func TestNTLMAuthenticateMessage(t *testing.T) {
+	msg, err := ntlmAuthenticate(ntlmTestChallenge, ntlmTestUser, ntlmTestDomain, ntlmTestPassword, ntlmTestTimestamp, ntlmTestClientChallenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(msg[:8], ntlmSignature) || binary.LittleEndian.Uint32(msg[8:]) != 3 {
+		t.Fatalf("not an AUTHENTICATE_MESSAGE: %x", msg[:12])
+	}
+	// the payload follows the fixed fields, as no version is negotiated
+	if offset := binary.LittleEndian.Uint32(msg[16:]); offset != 64 {
+		t.Errorf("payload starts at %d, want 64", offset)
+	}
+	offered := binary.LittleEndian.Uint32(ntlmTestChallenge[20:])
+	if flags := binary.LittleEndian.Uint32(msg[60:]); flags&ntlmNegotiateUnicode == 0 || flags&^offered != 0 {
+		t.Errorf("NegotiateFlags %08x are not Unicode flags offered by the challenge, %08x", flags, offered)
+	}
+
+	// the fields are, in order, LmChallengeResponse, NtChallengeResponse,
+	// DomainName, UserName, Workstation and EncryptedRandomSessionKey
+	var fields [6][]byte
+	for i := range fields {
+		var ok bool
+		if fields[i], ok = ntlmField(msg, 12+i*8); !ok {
+			t.Fatalf("field %d is outside the message", i)
+		}
+	}
+
+	// section 4.2.4.2.1
+	if want := unhex("86c35097ac9cec102554764a57cccc19aaaaaaaaaaaaaaaa"); !bytes.Equal(fields[0], want) {
+		t.Errorf("LMv2 response is %x, want %x", fields[0], want)
+	}
+
+	// section 4.2.4.2.2, with the temp of section 3.3.2
+	var temp []byte
+	temp = append(temp, 1, 1, 0, 0, 0, 0, 0, 0)
+	temp = append(temp, ntlmTestTimestamp...)
+	temp = append(temp, ntlmTestClientChallenge...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, ntlmTestTargetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+	if want := unhex("68cd0ab851e51c96aabc927bebef6a1c"); len(fields[1]) < 16 || !bytes.Equal(fields[1][:16], want) {
+		t.Errorf("NTProofStr is %x, want %x", fields[1], want)
+	} else if !bytes.Equal(fields[1][16:], temp) {
+		t.Errorf("NTLMv2 client challenge is %x, want %x", fields[1][16:], temp)
+	}
+
+	if !bytes.Equal(fields[2], utf16LE(ntlmTestDomain)) || !bytes.Equal(fields[3], utf16LE(ntlmTestUser)) {
+		t.Errorf("domain and user are %q and %q", fields[2], fields[3])
+	}
+	if len(fields[4]) != 0 || len(fields[5]) != 0 {
+		t.Errorf("unexpected workstation %x or session key %x", fields[4], fields[5])
+	}
+}
+
+func TestNTLMTransport(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+
+		auth := strings.Fields(r.Header.Get("Authorization"))
+		if len(auth) != 2 || auth[0] != "NTLM" {
+			w.Header().Set("WWW-Authenticate", "NTLM")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		msg, err := base64.StdEncoding.DecodeString(auth[1])
+		if err != nil || len(msg) < 12 || !bytes.Equal(msg[:8], ntlmSignature) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch binary.LittleEndian.Uint32(msg[8:]) {
+		case 1:
+			w.Header().Set("WWW-Authenticate", "NTLM "+base64.StdEncoding.EncodeToString(ntlmTestChallenge))
+			w.WriteHeader(http.StatusUnauthorized)
+		case 3:
+			nt, _ := ntlmField(msg, 20)
+			user, _ := ntlmField(msg, 36)
+			domain, _ := ntlmField(msg, 28)
+			key := ntlmV2Hash(ntlmTestUser, ntlmTestDomain, ntlmTestPassword)
+			if len(nt) < 16 || !bytes.Equal(nt[:16], hmacMD5(key, ntlmTestServerChallenge, nt[16:])) ||
+				!bytes.Equal(user, utf16LE(ntlmTestUser)) || !bytes.Equal(domain, utf16LE(ntlmTestDomain)) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("signed in"))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &NTLMTransport{
+		Username: ntlmTestDomain + `\` + ntlmTestUser,
+		Password: ntlmTestPassword,
+		Next:     http.DefaultTransport,
+	}}
+	resp, err := client.Post(srv.URL, "application/x-www-form-urlencoded", strings.NewReader("AuthMethod=WindowsAuthentication"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || string(body) != "signed in" {
+		t.Fatalf("NTLM authentication failed: %s %s", resp.Status, body)
+	}
+	want := []string{"AuthMethod=WindowsAuthentication", "AuthMethod=WindowsAuthentication", "AuthMethod=WindowsAuthentication"}
+	if strings.Join(bodies, "\n") != strings.Join(want, "\n") {
+		t.Errorf("the request body was sent as %q, want it sent with each of the three requests", bodies)
+	}
+}
@@ -0,0 +1,515 @@
+package federator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+)
+
+// oktaFactorPreference lists the Okta MFA factors supported, most preferred
+// first.  "web" is Duo, when it is integrated with Okta.
+var oktaFactorPreference = []string{"token:software:totp", "token:hardware", "token", "sms", "call", "web"}
+
+// OktaProvider authenticates with Okta's authentication API rather than by
+// scraping its login pages, then exchanges the resulting session for the
+// SAML assertion of an AWS app.
+type OktaProvider struct {
+	// AppURL is the embed link of the AWS app, for example
+	// https://example.okta.com/home/amazon_aws/0oa1b2c3d4/272
+	AppURL   string
+	Username string
+	Password string
+
+	// MFA is asked for a code when Okta requires a second factor.
+	MFA MFAPrompter
+
+	// Push sends an Okta Verify push notification, when the user has
+	// enrolled in it, before falling back to a code.
+	Push bool
+
+	// WebAuthn, if set, signs in with the user's security key when they
+	// have enrolled one, in preference to any other factor.
+	WebAuthn WebAuthnAuthenticator
+
+	// MFAPreference lists the kinds of factor to use, most preferred
+	// first, when the user has enrolled in several.  See mfaFactor.
+	MFAPreference []string
+
+	// DuoFactor is the Duo factor used when Duo is the user's Okta factor:
+	// DuoPush (the default), DuoPasscode or DuoPhone.
+	DuoFactor string
+
+	// Client is used for all requests.  If it is nil, a client with its own
+	// cookie jar is created.
+	Client *http.Client
+}
+
+func init() {
+	RegisterProvider("okta", func(cfg ProviderConfig) (Provider, error) {
+		var webAuthn WebAuthnAuthenticator
+		if command := cfg.setting("webauthn_command", ""); command != "" {
+			webAuthn = CommandAuthenticator{Command: command}
+		} else if FIDO2Available() {
+			webAuthn = FIDO2Authenticator{Device: cfg.setting("webauthn_device", "")}
+		}
+		return &OktaProvider{
+			AppURL:        cfg.URL,
+			Username:      cfg.Username,
+			Password:      cfg.Password,
+			MFA:           cfg.MFA,
+			Push:          cfg.Interactive,
+			WebAuthn:      webAuthn,
+			MFAPreference: parseMFAPreference(cfg.setting("mfa_preference", "")),
+			DuoFactor:     cfg.setting("duo_factor", ""),
+			Client:        cfg.Client,
+		}, nil
+	})
+}
+
+type oktaResponse struct {
+	Status       string `json:"status"`
+	StateToken   string `json:"stateToken"`
+	SessionToken string `json:"sessionToken"`
+	FactorResult string `json:"factorResult"`
+	ErrorCode    string `json:"errorCode"`
+	ErrorSummary string `json:"errorSummary"`
+	Embedded     struct {
+		Factors []oktaFactor `json:"factors"`
+		Factor  oktaFactor   `json:"factor"`
+	} `json:"_embedded"`
+	Links struct {
+		Next struct {
+			Href string `json:"href"`
+		} `json:"next"`
+		Resend []struct {
+			Href string `json:"href"`
+		} `json:"resend"`
+	} `json:"_links"`
+}
+
+// errOktaPushRejected is returned when the user declines an Okta Verify push
+// notification.
+var errOktaPushRejected = errors.New("Okta Verify push notification was rejected")
+
+type oktaFactor struct {
+	ID         string `json:"id"`
+	FactorType string `json:"factorType"`
+	Provider   string `json:"provider"`
+	Profile    struct {
+		CredentialID string `json:"credentialId"`
+	} `json:"profile"`
+	Links struct {
+		Verify struct {
+			Href string `json:"href"`
+		} `json:"verify"`
+	} `json:"_links"`
+	Embedded struct {
+		// Verification is the Duo prompt of a web factor's challenge.
+		Verification struct {
+			Host      string `json:"host"`
+			Signature string `json:"signature"`
+			Links     struct {
+				Complete struct {
+					Href string `json:"href"`
+				} `json:"complete"`
+			} `json:"_links"`
+		} `json:"verification"`
+		// Challenge is the WebAuthn challenge of a webauthn factor.
+		Challenge struct {
+			Challenge string `json:"challenge"`
+		} `json:"challenge"`
+	} `json:"_embedded"`
+}
+
+// Authenticate implements Provider.
+func (o *OktaProvider) Authenticate(ctx context.Context) (SAMLAssertion, error) {
+	if o.Client == nil {
+		j, err := cookiejar.New(nil)
+		if err != nil {
+			return "", fmt.Errorf("Could not create cookiejar: %s", err)
+		}
+		o.Client = &http.Client{Jar: j}
+	}
+
+	app, err := url.Parse(o.AppURL)
+	if err != nil || app.Host == "" {
+		return "", fmt.Errorf("Invalid Okta app URL '%s'", o.AppURL)
+	}
+	base := app.Scheme + "://" + app.Host
+
+	resp, err := o.post(ctx, base+"/api/v1/authn", map[string]string{
+		"username": o.Username,
+		"password": o.Password,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if resp.Status == "MFA_REQUIRED" {
+		if resp, err = o.verifyFactor(ctx, resp); err != nil {
+			return "", err
+		}
+	}
+	if resp.Status != "SUCCESS" {
+		return "", fmt.Errorf("Okta login could not be completed, status %s.  Sign in through the Okta website to resolve this", resp.Status)
+	}
+
+	// exchange the session token for a session cookie and open the app
+	q := url.Values{}
+	q.Set("checkAccountSetupComplete", "true")
+	q.Set("token", resp.SessionToken)
+	q.Set("redirectUrl", o.AppURL)
+
+	req, err := http.NewRequest("GET", base+"/login/sessionCookieRedirect?"+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	page, err := o.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("Could not open Okta app: %s", err)
+	}
+	defer page.Body.Close()
+
+	if page.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Could not open Okta app: HTTP %s", page.Status)
+	}
+
+	return samlResponseFromPage(page.Body)
+}
+
+// verifyFactor completes an MFA_REQUIRED transaction with the factor named
+// first in MFAPreference, or picked by the user, from those they have
+// enrolled.  Otherwise a security key or an Okta Verify push is used, if
+// enabled, or else the most preferred code based factor.
+func (o *OktaProvider) verifyFactor(ctx context.Context, resp oktaResponse) (oktaResponse, error) {
+	factors := o.supportedFactors(resp.Embedded.Factors)
+	if len(factors) == 0 {
+		var types []string
+		for _, f := range resp.Embedded.Factors {
+			types = append(types, f.FactorType)
+		}
+		return resp, fmt.Errorf("None of your Okta MFA factors (%s) are supported", strings.Join(types, ", "))
+	}
+
+	choices := make([]mfaFactor, len(factors))
+	for i, f := range factors {
+		choices[i] = oktaFactorChoice(f)
+	}
+	i, err := chooseFactor(choices, o.MFAPreference, o.prompter())
+	if err != nil {
+		return resp, err
+	}
+	factor := factors[i]
+
+	switch factor.FactorType {
+	case "webauthn":
+		return o.verifyWebAuthn(ctx, resp, factor)
+	case "push":
+		done, useCode, err := o.verifyPush(ctx, resp, factor)
+		if !useCode {
+			return done, err
+		}
+
+		// fall back to the most preferred code based factor
+		for _, f := range factors {
+			if f.FactorType != "push" && f.FactorType != "webauthn" {
+				return o.verifyCode(ctx, resp, f)
+			}
+		}
+		if err == nil {
+			err = fmt.Errorf("You have no Okta MFA factors which use a code")
+		}
+		return resp, err
+	}
+	return o.verifyCode(ctx, resp, factor)
+}
+
+// supportedFactors returns the factors in enrolled which can be used, most
+// preferred first.  Security keys are only listed once, as verifyWebAuthn
+// offers all of them.
+func (o *OktaProvider) supportedFactors(enrolled []oktaFactor) []oktaFactor {
+	var factors []oktaFactor
+	webAuthn := false
+	for _, f := range enrolled {
+		if f.FactorType == "webauthn" && o.WebAuthn != nil && !webAuthn {
+			factors = append(factors, f)
+			webAuthn = true
+		} else if f.FactorType == "push" && f.Provider == "OKTA" && o.Push {
+			factors = append(factors, f)
+		}
+	}
+	for _, t := range oktaFactorPreference {
+		for _, f := range enrolled {
+			if f.FactorType == t && (t != "web" || f.Provider == "DUO") {
+				factors = append(factors, f)
+			}
+		}
+	}
+	return factors
+}
+
+// oktaFactorChoice describes f for mfa_preference and the factor picker.
+func oktaFactorChoice(f oktaFactor) mfaFactor {
+	switch f.FactorType {
+	case "webauthn":
+		return mfaFactor{"webauthn", "Security key"}
+	case "push":
+		return mfaFactor{"push", "Okta Verify push notification"}
+	case "token:software:totp":
+		switch f.Provider {
+		case "OKTA":
+			return mfaFactor{"totp", "Okta Verify code"}
+		case "GOOGLE":
+			return mfaFactor{"totp", "Google Authenticator code"}
+		}
+		return mfaFactor{"totp", "Authenticator app code"}
+	case "token:hardware":
+		return mfaFactor{"token", "Hardware token code"}
+	case "token":
+		switch f.Provider {
+		case "RSA":
+			return mfaFactor{"token", "RSA SecurID code"}
+		case "SYMANTEC":
+			return mfaFactor{"token", "Symantec VIP code"}
+		}
+		return mfaFactor{"token", "Token code"}
+	case "sms":
+		return mfaFactor{"sms", "SMS code"}
+	case "call":
+		return mfaFactor{"call", "Voice call code"}
+	case "web":
+		return mfaFactor{"duo", "Duo"}
+	}
+	return mfaFactor{f.FactorType, f.FactorType}
+}
+
+// verifyCode completes a Duo factor or a factor verified with a one-time
+// code.
+func (o *OktaProvider) verifyCode(ctx context.Context, resp oktaResponse, factor oktaFactor) (oktaResponse, error) {
+	if factor.FactorType == "web" {
+		return o.verifyDuo(ctx, resp, factor)
+	}
+
+	// SMS and voice call factors send the code when first verified
+	if factor.FactorType == "sms" || factor.FactorType == "call" {
+		if _, err := o.post(ctx, factor.Links.Verify.Href, map[string]string{"stateToken": resp.StateToken}); err != nil {
+			return resp, err
+		}
+	}
+
+	code, err := o.prompter().MFACode(oktaFactorChoice(factor).Label)
+	if err != nil {
+		return resp, err
+	}
+
+	return o.post(ctx, factor.Links.Verify.Href, map[string]string{
+		"stateToken": resp.StateToken,
+		"passCode":   code,
+	})
+}
+
+// verifyPush sends an Okta Verify push notification and waits for it to be
+// approved.  useCode is true if it was rejected or timed out, with err
+// saying which, or if the user chose to enter a code instead.
+func (o *OktaProvider) verifyPush(ctx context.Context, resp oktaResponse, factor oktaFactor) (done oktaResponse, useCode bool, err error) {
+	state := map[string]string{"stateToken": resp.StateToken}
+
+	challenge, err := o.post(ctx, factor.Links.Verify.Href, state)
+	if err != nil {
+		return resp, false, err
+	}
+	done = challenge
+
+	poll := challenge.Links.Next.Href
+	if poll == "" {
+		poll = factor.Links.Verify.Href
+	}
+
+	var resend func() error
+	if len(challenge.Links.Resend) > 0 {
+		href := challenge.Links.Resend[0].Href
+		resend = func() error {
+			_, err := o.post(ctx, href, state)
+			return err
+		}
+	}
+
+	useCode, err = waitForPush(ctx, o.prompter(), "Okta Verify push", func() (bool, error) {
+		r, err := o.post(ctx, poll, state)
+		if err != nil {
+			return false, err
+		}
+		done = r
+		switch r.FactorResult {
+		case "REJECTED":
+			return false, errOktaPushRejected
+		case "TIMEOUT":
+			return false, ErrPushTimeout
+		}
+		return r.Status == "SUCCESS", nil
+	}, resend)
+	if err == errOktaPushRejected || err == ErrPushTimeout {
+		return done, true, err
+	}
+	return done, useCode, err
+}
+
+// verifyWebAuthn signs Okta's WebAuthn challenge with the user's security
+// key.
+func (o *OktaProvider) verifyWebAuthn(ctx context.Context, resp oktaResponse, factor oktaFactor) (oktaResponse, error) {
+	challenge, err := o.post(ctx, factor.Links.Verify.Href, map[string]string{"stateToken": resp.StateToken})
+	if err != nil {
+		return resp, err
+	}
+	f := challenge.Embedded.Factor
+	if f.Embedded.Challenge.Challenge == "" {
+		return resp, fmt.Errorf("Okta did not return a WebAuthn challenge")
+	}
+
+	// each security key is a factor of its own, and any of them may be
+	// plugged in, so the key is offered all of them
+	if f.Profile.CredentialID != "" {
+		factor.Profile.CredentialID = f.Profile.CredentialID
+	}
+	keys := []oktaFactor{factor}
+	for _, other := range resp.Embedded.Factors {
+		if other.FactorType == "webauthn" && other.ID != factor.ID {
+			keys = append(keys, other)
+		}
+	}
+	var ids [][]byte
+	for _, k := range keys {
+		id, err := base64URL(k.Profile.CredentialID)
+		if err != nil || len(id) == 0 {
+			return resp, fmt.Errorf("Okta returned an invalid WebAuthn credential ID for factor %s", k.ID)
+		}
+		ids = append(ids, id)
+	}
+
+	verify, err := url.Parse(factor.Links.Verify.Href)
+	if err != nil {
+		return resp, err
+	}
+	origin := verify.Scheme + "://" + verify.Host
+	rpID := verify.Host
+	if host, _, err := net.SplitHostPort(rpID); err == nil {
+		rpID = host
+	}
+
+	clientData, assertion, err := webAuthnSign(ctx, o.WebAuthn, o.prompter(), "Touch your security key to sign in to Okta", origin, rpID, f.Embedded.Challenge.Challenge, ids)
+	if err != nil {
+		return resp, err
+	}
+
+	// the signature is verified by the factor of the key which made it;
+	// the challenge belongs to the transaction, not the factor
+	signed := factor
+	for i, k := range keys {
+		if bytes.Equal(ids[i], assertion.CredentialID) {
+			signed = k
+			break
+		}
+	}
+	return o.post(ctx, signed.Links.Verify.Href, map[string]string{
+		"stateToken":        resp.StateToken,
+		"clientData":        base64.StdEncoding.EncodeToString(clientData),
+		"authenticatorData": base64.StdEncoding.EncodeToString(assertion.AuthenticatorData),
+		"signatureData":     base64.StdEncoding.EncodeToString(assertion.Signature),
+	})
+}
+
+func (o *OktaProvider) prompter() MFAPrompter {
+	if o.MFA == nil {
+		return TerminalPrompter{}
+	}
+	return o.MFA
+}
+
+// verifyDuo completes a Duo factor by signing the Duo prompt Okta would
+// show, then reporting the signature back to Okta.
+func (o *OktaProvider) verifyDuo(ctx context.Context, resp oktaResponse, factor oktaFactor) (oktaResponse, error) {
+	challenge, err := o.post(ctx, factor.Links.Verify.Href, map[string]string{"stateToken": resp.StateToken})
+	if err != nil {
+		return resp, err
+	}
+	v := challenge.Embedded.Factor.Embedded.Verification
+	if v.Host == "" || v.Links.Complete.Href == "" {
+		return resp, fmt.Errorf("Okta did not return a Duo prompt")
+	}
+
+	verify, err := url.Parse(factor.Links.Verify.Href)
+	if err != nil {
+		return resp, err
+	}
+	parent := verify.Scheme + "://" + verify.Host + "/signin/verify/duo/web"
+
+	duo := duoPrompt{client: o.Client, mfa: o.MFA, factor: o.DuoFactor}
+	sig, err := duo.sign(ctx, duoFrame{Host: v.Host, SigRequest: v.Signature}, parent)
+	if err != nil {
+		return resp, err
+	}
+
+	req, err := formRequest(v.Links.Complete.Href, url.Values{
+		"id":           {factor.ID},
+		"stateToken":   {resp.StateToken},
+		"sig_response": {sig},
+	})
+	if err != nil {
+		return resp, err
+	}
+	done, err := o.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return resp, fmt.Errorf("Okta request failed: %s", err)
+	}
+	done.Body.Close()
+	if done.StatusCode >= 400 {
+		return resp, fmt.Errorf("Okta rejected the Duo response: HTTP %s", done.Status)
+	}
+
+	return o.post(ctx, factor.Links.Verify.Href, map[string]string{"stateToken": resp.StateToken})
+}
+
+func (o *OktaProvider) post(ctx context.Context, u string, body interface{}) (oktaResponse, error) {
+	var r oktaResponse
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return r, err
+	}
+
+	req, err := http.NewRequest("POST", u, bytes.NewReader(b))
+	if err != nil {
+		return r, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return r, fmt.Errorf("Okta request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return r, fmt.Errorf("Okta returned an unexpected response (HTTP %s)", resp.Status)
+	}
+
+	switch {
+	case r.ErrorCode == "E0000004":
+		// authentication failed
+		return r, ErrInvalidCredentials
+	case resp.StatusCode != http.StatusOK:
+		return r, fmt.Errorf("Okta returned an error: %s (%s)", r.ErrorSummary, r.ErrorCode)
+	}
+
+	return r, nil
+}
@@ -0,0 +1,169 @@
+package federator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OneLoginProvider retrieves the SAML assertion of an AWS app directly from
+// OneLogin's API rather than through its login pages.  The API requires
+// client credentials with "Authentication Only" permission, created in the
+// OneLogin admin console.
+type OneLoginProvider struct {
+	// APIURL is the API endpoint for the account's region, for example
+	// https://api.us.onelogin.com
+	APIURL       string
+	ClientID     string
+	ClientSecret string
+
+	// Subdomain is the account's OneLogin subdomain and AppID the ID of the
+	// AWS app, as seen in https://<subdomain>.onelogin.com/launch/<app id>
+	Subdomain string
+	AppID     string
+
+	Username string
+	Password string
+
+	// MFA is asked for a code when OneLogin requires a second factor.
+	MFA MFAPrompter
+
+	// Client is used for all requests.  If it is nil, http.DefaultClient
+	// is used.
+	Client *http.Client
+}
+
+// The registered onelogin provider is configured from the app's launch URL,
+// https://<subdomain>.onelogin.com/launch/<app id>, and the onelogin_region,
+// onelogin_client_id and onelogin_client_secret settings.
+func init() {
+	RegisterProvider("onelogin", func(cfg ProviderConfig) (Provider, error) {
+		u, err := url.Parse(cfg.URL)
+		if err != nil || !strings.HasSuffix(u.Host, ".onelogin.com") || !strings.HasPrefix(u.Path, "/launch/") {
+			return nil, fmt.Errorf("OneLogin sp_identity_url must be the app's launch URL, https://<subdomain>.onelogin.com/launch/<app id>")
+		}
+
+		return &OneLoginProvider{
+			APIURL:       fmt.Sprintf("https://api.%s.onelogin.com", cfg.setting("onelogin_region", "us")),
+			ClientID:     cfg.setting("onelogin_client_id", ""),
+			ClientSecret: cfg.setting("onelogin_client_secret", ""),
+			Subdomain:    strings.TrimSuffix(u.Host, ".onelogin.com"),
+			AppID:        strings.Trim(strings.TrimPrefix(u.Path, "/launch/"), "/"),
+			Username:     cfg.Username,
+			Password:     cfg.Password,
+			MFA:          cfg.MFA,
+			Client:       cfg.Client,
+		}, nil
+	})
+}
+
+type oneLoginResponse struct {
+	AccessToken string `json:"access_token"`
+	Message     string `json:"message"`
+	Data        string `json:"data"`
+	StateToken  string `json:"state_token"`
+	StatusCode  int    `json:"statusCode"`
+	Devices     []struct {
+		DeviceID   int    `json:"device_id"`
+		DeviceType string `json:"device_type"`
+	} `json:"devices"`
+}
+
+// Authenticate implements Provider.
+func (o *OneLoginProvider) Authenticate(ctx context.Context) (SAMLAssertion, error) {
+	if o.Client == nil {
+		o.Client = http.DefaultClient
+	}
+	if o.ClientID == "" || o.ClientSecret == "" {
+		return "", fmt.Errorf("OneLogin API client credentials are required")
+	}
+
+	token, err := o.post(ctx, "/auth/oauth2/v2/token", "client_id:"+o.ClientID+", client_secret:"+o.ClientSecret, map[string]interface{}{
+		"grant_type": "client_credentials",
+	})
+	if err != nil {
+		return "", fmt.Errorf("Could not authenticate with the OneLogin API: %s", err)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("Could not authenticate with the OneLogin API: %s", token.Message)
+	}
+	auth := "bearer:" + token.AccessToken
+
+	resp, err := o.post(ctx, "/api/2/saml_assertion", auth, map[string]interface{}{
+		"username_or_email": o.Username,
+		"password":          o.Password,
+		"app_id":            o.AppID,
+		"subdomain":         o.Subdomain,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StateToken != "" {
+		if len(resp.Devices) == 0 {
+			return "", fmt.Errorf("OneLogin requires MFA but you have no MFA devices registered")
+		}
+		device := resp.Devices[0]
+
+		prompter := o.MFA
+		if prompter == nil {
+			prompter = TerminalPrompter{}
+		}
+		code, err := prompter.MFACode(fmt.Sprintf("%s code", device.DeviceType))
+		if err != nil {
+			return "", err
+		}
+
+		if resp, err = o.post(ctx, "/api/2/saml_assertion/verify_factor", auth, map[string]interface{}{
+			"app_id":      o.AppID,
+			"device_id":   fmt.Sprintf("%d", device.DeviceID),
+			"state_token": resp.StateToken,
+			"otp_token":   code,
+		}); err != nil {
+			return "", err
+		}
+	}
+
+	if resp.Data == "" {
+		return "", fmt.Errorf("OneLogin did not return a SAML assertion: %s", resp.Message)
+	}
+	return SAMLAssertion(resp.Data), nil
+}
+
+func (o *OneLoginProvider) post(ctx context.Context, path, auth string, body interface{}) (oneLoginResponse, error) {
+	var r oneLoginResponse
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return r, err
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimRight(o.APIURL, "/")+path, bytes.NewReader(b))
+	if err != nil {
+		return r, err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return r, fmt.Errorf("OneLogin request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return r, fmt.Errorf("OneLogin returned an unexpected response (HTTP %s)", resp.Status)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized && path == "/api/2/saml_assertion":
+		return r, ErrInvalidCredentials
+	case resp.StatusCode != http.StatusOK:
+		return r, fmt.Errorf("OneLogin returned an error: %s (HTTP %s)", r.Message, resp.Status)
+	}
+	return r, nil
+}
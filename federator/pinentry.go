@@ -0,0 +1,125 @@
+package federator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// PinentryPrompter collects secrets through a GnuPG pinentry program, which
+// presents a system dialog rather than reading from the terminal.  This is
+// useful when the federator is launched from a GUI application without a
+// controlling terminal.
+type PinentryPrompter struct {
+	// Program is the pinentry binary to execute.  Defaults to "pinentry".
+	Program string
+}
+
+func (p PinentryPrompter) MFACode(label string) (string, error) {
+	return p.GetPin("Your identity provider has requested a one-time code.", label)
+}
+
+// Password asks the user for their IdP password.
+func (p PinentryPrompter) Password(username string) (string, error) {
+	return p.GetPin(fmt.Sprintf("Enter the password for %s.", username), "Password")
+}
+
+// GetPin runs a single pinentry session displaying desc and prompt and
+// returns the secret entered by the user.
+func (p PinentryPrompter) GetPin(desc, prompt string) (string, error) {
+	prog := p.Program
+	if prog == "" {
+		prog = "pinentry"
+	}
+
+	cmd := exec.Command(prog)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("Could not start %s: %s", prog, err)
+	}
+	defer cmd.Wait()
+	defer stdin.Close()
+
+	s := assuan{w: stdin, r: bufio.NewReader(stdout)}
+	if _, err := s.response(); err != nil {
+		return "", err
+	}
+
+	cmds := []string{
+		"SETTITLE aws-cli-federator",
+		"SETDESC " + assuanEscape(desc),
+		"SETPROMPT " + assuanEscape(prompt+":"),
+	}
+	if tty := os.Getenv("GPG_TTY"); tty != "" {
+		cmds = append(cmds, "OPTION ttyname="+assuanEscape(tty))
+	}
+	for _, c := range cmds {
+		if _, err := s.command(c); err != nil {
+			return "", err
+		}
+	}
+
+	pin, err := s.command("GETPIN")
+	if err != nil {
+		return "", err
+	}
+	s.command("BYE")
+
+	return pin, nil
+}
+
+// assuan is a minimal client for the Assuan protocol spoken by pinentry.
+type assuan struct {
+	w io.Writer
+	r *bufio.Reader
+}
+
+func (s assuan) command(c string) (string, error) {
+	if _, err := fmt.Fprintf(s.w, "%s\n", c); err != nil {
+		return "", fmt.Errorf("pinentry: %s", err)
+	}
+	return s.response()
+}
+
+// response reads lines until the server acknowledges the last command and
+// returns any data lines that were sent along the way.
+func (s assuan) response() (string, error) {
+	var data string
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("pinentry: %s", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK "):
+			return data, nil
+		case strings.HasPrefix(line, "ERR "):
+			return "", fmt.Errorf("pinentry: %s", line[4:])
+		case strings.HasPrefix(line, "D "):
+			d, err := url.QueryUnescape(strings.Replace(line[2:], "+", "%2B", -1))
+			if err != nil {
+				return "", fmt.Errorf("pinentry: invalid data line: %s", err)
+			}
+			data += d
+		}
+	}
+}
+
+func assuanEscape(s string) string {
+	s = strings.Replace(s, "%", "%25", -1)
+	s = strings.Replace(s, "\r", "%0D", -1)
+	return strings.Replace(s, "\n", "%0A", -1)
+}
@@ -0,0 +1,121 @@
+package federator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+)
+
+// pingMaxPages bounds the number of pages followed during a Ping login.
+const pingMaxPages = 10
+
+// PingProvider logs in through PingFederate's HTML Form Adapter and, when it
+// is configured as a second factor, PingID's one-time passcode page.
+type PingProvider struct {
+	// AppURL is the IdP initiated SSO URL for AWS, for example
+	// https://pf.example.com/idp/startSSO.ping?PartnerSpId=urn:amazon:webservices
+	AppURL   string
+	Username string
+	Password string
+
+	// MFA is asked for a PingID passcode when one is required.
+	MFA MFAPrompter
+
+	// Client is used for all requests.  If it is nil, a client with its own
+	// cookie jar is created.
+	Client *http.Client
+}
+
+func init() {
+	RegisterProvider("ping", func(cfg ProviderConfig) (Provider, error) {
+		return &PingProvider{
+			AppURL:   cfg.URL,
+			Username: cfg.Username,
+			Password: cfg.Password,
+			MFA:      cfg.MFA,
+			Client:   cfg.Client,
+		}, nil
+	})
+}
+
+// Authenticate implements Provider.
+func (p *PingProvider) Authenticate(ctx context.Context) (SAMLAssertion, error) {
+	if p.Client == nil {
+		j, err := cookiejar.New(nil)
+		if err != nil {
+			return "", fmt.Errorf("Could not create cookiejar: %s", err)
+		}
+		p.Client = &http.Client{Jar: j}
+	}
+
+	req, err := http.NewRequest("GET", p.AppURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("Invalid Ping SSO URL '%s': %s", p.AppURL, err)
+	}
+
+	signedIn := false
+	for i := 0; i < pingMaxPages; i++ {
+		resp, err := p.Client.Do(req.WithContext(ctx))
+		if err != nil {
+			return "", fmt.Errorf("Ping request failed: %s", err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return "", fmt.Errorf("Could not read Ping response: %s", err)
+		}
+		if resp.StatusCode >= 500 {
+			return "", fmt.Errorf("Ping returned HTTP %s", resp.Status)
+		}
+
+		if assertion, err := samlResponseFromPage(bytes.NewReader(body)); err == nil {
+			return assertion, nil
+		}
+
+		form, err := hiddenForm(resp.Request.URL, bytes.NewReader(body))
+		if err != nil {
+			return "", fmt.Errorf("Ping returned an unexpected page (HTTP %s)", resp.Status)
+		}
+
+		switch {
+		case hasField(form.Values, "pf.username"):
+			// the HTML Form Adapter shows the login form again on failure
+			if signedIn {
+				return "", ErrInvalidCredentials
+			}
+			signedIn = true
+
+			form.Values.Set("pf.username", p.Username)
+			form.Values.Set("pf.pass", p.Password)
+			// set by JavaScript when the sign on button is clicked
+			form.Values.Set("pf.ok", "clicked")
+		case hasField(form.Values, "otp"):
+			prompter := p.MFA
+			if prompter == nil {
+				prompter = TerminalPrompter{}
+			}
+			code, err := prompter.MFACode("PingID passcode")
+			if err != nil {
+				return "", err
+			}
+			form.Values.Set("otp", code)
+		}
+
+		// other pages, such as those carrying ppm_request and ppm_response
+		// between PingFederate and PingID, submit themselves
+		if req, err = formRequest(form.URL, form.Values); err != nil {
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("Ping login did not reach AWS after %d pages", pingMaxPages)
+}
+
+func hasField(v url.Values, name string) bool {
+	_, ok := v[name]
+	return ok
+}
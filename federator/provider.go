@@ -0,0 +1,168 @@
+package federator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// ErrInvalidCredentials is returned by a Provider when the IdP rejects the
+// username or password.
+var ErrInvalidCredentials = errors.New("Invalid username or password")
+
+// SAMLAssertion is a base64 encoded SAMLResponse as posted by an IdP to the
+// AWS SAML endpoint.
+type SAMLAssertion string
+
+// Provider authenticates a user with an identity provider and returns the
+// SAML assertion it issued for AWS.
+type Provider interface {
+	Authenticate(ctx context.Context) (SAMLAssertion, error)
+}
+
+// samlResponseFromPage returns the SAMLResponse from the self-submitting
+// form IdPs use to post an assertion to AWS.  Portals which post to several
+// service providers from one page have a form for each, so the form posting
+// to the AWS SAML endpoint is preferred over the first SAMLResponse found.
+func samlResponseFromPage(r io.Reader) (SAMLAssertion, error) {
+	var first *string
+	action := ""
+
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if first == nil {
+				return "", fmt.Errorf("IdP response did not contain a SAMLResponse")
+			}
+			if *first == "" {
+				return "", fmt.Errorf("IdP returned an empty SAMLResponse")
+			}
+			return SAMLAssertion(*first), nil
+		case html.EndTagToken:
+			if t := z.Token(); t.Data == "form" {
+				action = ""
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			switch t.Data {
+			case "form":
+				action, _ = findAttrVal("action", t.Attr)
+			case "input":
+				if name, _ := findAttrVal("name", t.Attr); name != "SAMLResponse" {
+					continue
+				}
+				v, _ := findAttrVal("value", t.Attr)
+				if u, err := url.Parse(action); err == nil && isACS("", u) {
+					if v == "" {
+						return "", fmt.Errorf("IdP returned an empty SAMLResponse")
+					}
+					return SAMLAssertion(v), nil
+				}
+				if first == nil {
+					first = &v
+				}
+			}
+		}
+	}
+}
+
+// AWSSignInURL is the AWS sign-in endpoint IdPs post assertions to, the
+// assertion consumer service (ACS) used unless one is configured.
+const AWSSignInURL = "https://signin.aws.amazon.com/saml"
+
+// isACS reports whether u is the assertion consumer service acs, or
+// AWSSignInURL if acs is empty.
+func isACS(acs string, u *url.URL) bool {
+	if acs == "" {
+		acs = AWSSignInURL
+	}
+	a, err := url.Parse(acs)
+	if err != nil {
+		return false
+	}
+	return u.Host == a.Host && strings.HasPrefix(u.Path, a.Path)
+}
+
+// samlForm returns the form on a page which posts to the assertion consumer
+// service acs, for portals which show a form for every application.
+func samlForm(page *url.URL, acs string, r io.Reader) (loginForm, bool) {
+	var form loginForm
+
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return loginForm{}, false
+		case html.EndTagToken:
+			if t := z.Token(); t.Data == "form" && form.URL != "" {
+				return form, true
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			switch t.Data {
+			case "form":
+				action, _ := findAttrVal("action", t.Attr)
+				if u, err := page.Parse(action); err == nil && isACS(acs, u) {
+					form = loginForm{URL: u.String(), Values: make(url.Values)}
+				}
+			case "input":
+				if name, err := findAttrVal("name", t.Attr); err == nil && form.URL != "" {
+					v, _ := findAttrVal("value", t.Attr)
+					form.Values.Add(name, v)
+				}
+			}
+		}
+	}
+}
+
+// hiddenForm returns the first form on a page with the values of its
+// inputs, as used by IdPs to move between pages with a self-submitting form.
+func hiddenForm(page *url.URL, r io.Reader) (loginForm, error) {
+	return formWithID(page, r, "")
+}
+
+// formWithID is hiddenForm for the first form with the given id, or any
+// form if id is empty.
+func formWithID(page *url.URL, r io.Reader, id string) (loginForm, error) {
+	form := loginForm{Values: make(url.Values)}
+
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if form.URL == "" {
+				return form, fmt.Errorf("IdP returned a page without a form")
+			}
+			return form, nil
+		case html.EndTagToken:
+			if t := z.Token(); t.Data == "form" && form.URL != "" {
+				return form, nil
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			switch t.Data {
+			case "form":
+				if v, _ := findAttrVal("id", t.Attr); id != "" && v != id {
+					continue
+				}
+				action, _ := findAttrVal("action", t.Attr)
+				u, err := page.Parse(action)
+				if err != nil {
+					return form, fmt.Errorf("Invalid form action '%s': %s", action, err)
+				}
+				form.URL = u.String()
+			case "input":
+				if name, err := findAttrVal("name", t.Attr); err == nil && form.URL != "" {
+					v, _ := findAttrVal("value", t.Attr)
+					form.Values.Add(name, v)
+				}
+			}
+		}
+	}
+}
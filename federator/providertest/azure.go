@@ -0,0 +1,126 @@
+package providertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+// AzureADFixture simulates the login.microsoftonline.com sign in pages, for
+// use with federator.AzureADProvider.
+type AzureADFixture struct{}
+
+func (AzureADFixture) Provider(url string, mfa federator.MFAPrompter) federator.Provider {
+	return &federator.AzureADProvider{
+		AppURL:   url + "/signin/AWS/app?tenantId=tenant",
+		Username: Username,
+		Password: Password,
+		MFA:      mfa,
+	}
+}
+
+func (AzureADFixture) Handler(s Scenario) http.Handler {
+	return azureIdP{scenario: s}
+}
+
+type azureIdP struct {
+	scenario Scenario
+}
+
+func (a azureIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.scenario == Maintenance {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "<html><body><h1>Service unavailable</h1></body></html>")
+		return
+	}
+
+	switch r.URL.Path {
+	case "/signin/AWS/app":
+		http.Redirect(w, r, "/tenant/saml2?SAMLRequest=abc", http.StatusFound)
+	case "/tenant/saml2":
+		a.page(w, map[string]interface{}{"pgid": "ConvergedSignIn", "urlPost": "/common/login"})
+	case "/common/login":
+		r.ParseForm()
+		if !a.flow(r) {
+			return
+		}
+		if a.scenario == BadPassword || r.PostForm.Get("login") != Username || r.PostForm.Get("passwd") != Password {
+			a.page(w, map[string]interface{}{
+				"pgid":       "ConvergedSignIn",
+				"urlPost":    "/common/login",
+				"sErrorCode": "50126",
+				"sErrTxt":    "Your account or password is incorrect.",
+			})
+			return
+		}
+		if a.scenario == MFARequired {
+			a.page(w, map[string]interface{}{
+				"pgid":         "ConvergedTFA",
+				"urlPost":      "/common/SAS/ProcessAuth",
+				"urlBeginAuth": "/common/SAS/BeginAuth",
+				"urlEndAuth":   "/common/SAS/EndAuth",
+				"arrUserProofs": []interface{}{
+					map[string]interface{}{"authMethodId": "PhoneAppNotification", "isDefault": false},
+					map[string]interface{}{"authMethodId": "PhoneAppOTP", "isDefault": true},
+				},
+			})
+			return
+		}
+		a.page(w, map[string]interface{}{"pgid": "KmsiInterrupt", "urlPost": "/kmsi"})
+	case "/common/SAS/BeginAuth":
+		a.json(w, map[string]interface{}{"Success": true, "SessionId": "sid", "FlowToken": "flow", "Ctx": "ctx"})
+	case "/common/SAS/EndAuth":
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["AdditionalAuthData"] != MFACode || body["SessionId"] != "sid" {
+			a.json(w, map[string]interface{}{"Success": false, "Message": "Invalid code"})
+			return
+		}
+		a.json(w, map[string]interface{}{"Success": true, "FlowToken": "flow", "Ctx": "ctx"})
+	case "/common/SAS/ProcessAuth":
+		r.ParseForm()
+		if r.PostForm.Get("otc") != MFACode || !a.flow(r) {
+			http.Error(w, "MFA not completed", http.StatusForbidden)
+			return
+		}
+		a.page(w, map[string]interface{}{"pgid": "KmsiInterrupt", "urlPost": "/kmsi"})
+	case "/kmsi":
+		r.ParseForm()
+		if !a.flow(r) {
+			return
+		}
+		// Azure AD posts back to the application before AWS
+		fmt.Fprint(w, `<html><body onload="document.forms[0].submit()"><form method="post" action="/signin/AWS/continue"><input type="hidden" name="code" value="xyz"></form></body></html>`)
+	case "/signin/AWS/continue":
+		if a.scenario == WeirdEncoding {
+			w.Header().Set("Content-Type", "text/html; charset=ISO-8859-1")
+			fmt.Fprintf(w, weirdSAMLPage, entityEscape(string(Assertion)))
+			return
+		}
+		fmt.Fprintf(w, samlPage, html.EscapeString(string(Assertion)))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// flow checks the tokens echoed from the previous page.
+func (azureIdP) flow(r *http.Request) bool {
+	return r.PostForm.Get("flowToken") == "flow" && r.PostForm.Get("ctx") == "ctx" && r.PostForm.Get("canary") == "canary"
+}
+
+func (azureIdP) page(w http.ResponseWriter, cfg map[string]interface{}) {
+	cfg["sCtx"] = "ctx"
+	cfg["sFT"] = "flow"
+	cfg["canary"] = "canary"
+
+	b, _ := json.Marshal(cfg)
+	fmt.Fprintf(w, "<!DOCTYPE html><html><head><script type=\"text/javascript\">//<![CDATA[\n$Config=%s;\n//]]></script></head><body><noscript>JavaScript required</noscript></body></html>", b)
+}
+
+func (azureIdP) json(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,133 @@
+package providertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+// CommandFixture simulates an IdP with a bespoke login API, for use with
+// federator.CommandProvider.  The idp_command it configures is the running
+// test binary, which must call CommandPlugin when CommandPluginArg is its
+// first argument:
+//
+//	func TestCommandPlugin(t *testing.T) {
+//		if flag.Arg(0) == providertest.CommandPluginArg {
+//			os.Exit(providertest.CommandPlugin(os.Stdin, os.Stdout))
+//		}
+//	}
+type CommandFixture struct{}
+
+// CommandPluginArg is the argument the test binary is run with as the
+// fixture's idp_command.
+const CommandPluginArg = "command-plugin"
+
+func (CommandFixture) Provider(url string, mfa federator.MFAPrompter) federator.Provider {
+	return &federator.CommandProvider{
+		Command: fmt.Sprintf("%s -test.run=^TestCommandPlugin$ -- %s", shellQuote(os.Args[0]), CommandPluginArg),
+		Request: federator.CommandRequest{
+			Account:  "conformance",
+			URL:      url + "/api/login",
+			Username: Username,
+			Password: Password,
+		},
+		MFA: mfa,
+	}
+}
+
+func (CommandFixture) Handler(s Scenario) http.Handler {
+	return commandIdP{scenario: s}
+}
+
+// shellQuote quotes path for the shell idp_command is run by.
+func shellQuote(path string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + path + `"`
+	}
+	return "'" + strings.Replace(path, "'", `'\''`, -1) + "'"
+}
+
+type commandIdP struct {
+	scenario Scenario
+}
+
+func (c commandIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if c.scenario == Maintenance {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "Down for maintenance")
+		return
+	}
+	if r.URL.Path != "/api/login" || r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	r.ParseForm()
+	if c.scenario == BadPassword || r.PostForm.Get("username") != Username || r.PostForm.Get("password") != Password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if c.scenario == MFARequired && r.PostForm.Get("otp") != MFACode {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if c.scenario == WeirdEncoding {
+		// wrapped as by base64(1), with DOS line endings
+		s := string(Assertion)
+		for len(s) > 76 {
+			fmt.Fprint(w, s[:76]+"\r\n")
+			s = s[76:]
+		}
+		fmt.Fprint(w, s+"\r\n")
+		return
+	}
+	fmt.Fprint(w, Assertion)
+}
+
+// CommandPlugin is CommandFixture's idp_command.  It reads the
+// federator.CommandRequest from stdin, logs in to the fixture's login API,
+// writes the assertion to stdout and returns the exit status.
+func CommandPlugin(stdin io.Reader, stdout io.Writer) int {
+	var req federator.CommandRequest
+	if err := json.NewDecoder(stdin).Decode(&req); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid request: %s\n", err)
+		return 1
+	}
+	if req.APIVersion != federator.CommandAPIVersion {
+		fmt.Fprintf(os.Stderr, "unsupported apiVersion %q\n", req.APIVersion)
+		return 1
+	}
+
+	resp, err := http.PostForm(req.URL, url.Values{
+		"username": {req.Username},
+		"password": {req.Password},
+		"otp":      {req.MFACode},
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		stdout.Write(body)
+		return 0
+	case http.StatusUnauthorized:
+		return federator.CommandExitInvalidCredentials
+	case http.StatusForbidden:
+		return federator.CommandExitMFARequired
+	}
+	fmt.Fprintf(os.Stderr, "login failed: %s\n", resp.Status)
+	return 1
+}
@@ -0,0 +1,27 @@
+package providertest
+
+import (
+	"flag"
+	"os"
+	"testing"
+)
+
+func TestForm(t *testing.T)      { Run(t, FormFixture{}) }
+func TestOkta(t *testing.T)      { Run(t, OktaFixture{}) }
+func TestAzureAD(t *testing.T)   { Run(t, AzureADFixture{}) }
+func TestPing(t *testing.T)      { Run(t, PingFixture{}) }
+func TestGoogle(t *testing.T)    { Run(t, GoogleFixture{}) }
+func TestOneLogin(t *testing.T)  { Run(t, OneLoginFixture{}) }
+func TestJumpCloud(t *testing.T) { Run(t, JumpCloudFixture{}) }
+func TestKeycloak(t *testing.T)  { Run(t, KeycloakFixture{}) }
+func TestDuo(t *testing.T)       { Run(t, DuoFixture{}) }
+func TestWSTrust(t *testing.T)   { Run(t, WSTrustFixture{}) }
+func TestECP(t *testing.T)       { Run(t, ECPFixture{}) }
+func TestCommand(t *testing.T)   { Run(t, CommandFixture{}) }
+
+// TestCommandPlugin is CommandFixture's idp_command.
+func TestCommandPlugin(t *testing.T) {
+	if flag.Arg(0) == CommandPluginArg {
+		os.Exit(CommandPlugin(os.Stdin, os.Stdout))
+	}
+}
@@ -0,0 +1,125 @@
+package providertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+// DuoFixture simulates a form based IdP, such as ADFS with the Duo adapter,
+// which embeds the traditional Duo prompt as its second factor, for use
+// with the generic federator provider.
+type DuoFixture struct{}
+
+func (DuoFixture) Provider(url string, mfa federator.MFAPrompter) federator.Provider {
+	f, err := federator.New(Username, Password, url+"/login")
+	if err != nil {
+		panic(err)
+	}
+	f.MFA = mfa
+	f.DuoFactor = federator.DuoPasscode
+	return &f
+}
+
+func (DuoFixture) Handler(s Scenario) http.Handler {
+	return duoIdP{scenario: s}
+}
+
+type duoIdP struct {
+	scenario Scenario
+}
+
+const (
+	duoSigRequest  = "TX|dHhfcmVx|5f2c:APP|YXBwX3JlcQ==|9a1b"
+	duoAuthCookie  = "AUTH|dXNlcg==|77e0"
+	duoSigResponse = duoAuthCookie + ":APP|YXBwX3JlcQ==|9a1b"
+
+	duoAdapterPage = `<html><body><form method="post" id="duo_form">
+<input type="hidden" name="AuthMethod" value="DuoAdfsAdapter">
+<input type="hidden" name="Context" value="ctx">
+</form>
+<iframe id="duo_iframe" title="Two-Factor Authentication" frameborder="0" data-host="http://%s" data-sig-request="%s" data-post-action="/adfs/duo"></iframe>
+</body></html>`
+
+	duoFramePage = `<html><body><form id="login-form" method="post" action="/frame/prompt">
+<input type="hidden" name="sid" value="sid-1">
+<input type="hidden" name="url" value="/frame/prompt">
+<select name="device"><option value="phone1">iOS</option></select>
+</form></body></html>`
+)
+
+func (d duoIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if d.scenario == Maintenance {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "<html><body><h1>Service Unavailable</h1></body></html>")
+		return
+	}
+
+	r.ParseForm()
+	switch r.URL.Path {
+	case "/login":
+		if r.Method != "POST" {
+			fmt.Fprint(w, loginPage)
+			return
+		}
+		if d.scenario == BadPassword || r.PostForm.Get("UserName") != Username || r.PostForm.Get("Password") != Password {
+			fmt.Fprint(w, loginPage)
+			return
+		}
+		if d.scenario == MFARequired {
+			fmt.Fprintf(w, duoAdapterPage, r.Host, html.EscapeString(duoSigRequest))
+			return
+		}
+	case "/frame/web/v1/auth":
+		if r.URL.Query().Get("tx") != "TX|dHhfcmVx|5f2c" || r.URL.Query().Get("parent") == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, duoFramePage)
+		return
+	case "/frame/prompt":
+		if r.PostForm.Get("sid") != "sid-1" || r.PostForm.Get("factor") != "Passcode" {
+			d.json(w, map[string]interface{}{"stat": "FAIL", "message": "Invalid request"})
+			return
+		}
+		txid := "tx-denied"
+		if r.PostForm.Get("passcode") == MFACode {
+			txid = "tx-allowed"
+		}
+		d.json(w, map[string]interface{}{"stat": "OK", "response": map[string]string{"txid": txid}})
+		return
+	case "/frame/status":
+		if r.PostForm.Get("txid") != "tx-allowed" {
+			d.json(w, map[string]interface{}{"stat": "OK", "response": map[string]string{"result": "FAILURE", "status": "Incorrect passcode", "status_code": "deny"}})
+			return
+		}
+		d.json(w, map[string]interface{}{"stat": "OK", "response": map[string]string{"result": "SUCCESS", "status": "Success. Logging you in...", "status_code": "allow", "result_url": "/frame/status/tx-allowed"}})
+		return
+	case "/frame/status/tx-allowed":
+		d.json(w, map[string]interface{}{"stat": "OK", "response": map[string]string{"cookie": duoAuthCookie}})
+		return
+	case "/adfs/duo":
+		if r.PostForm.Get("sig_response") != duoSigResponse || r.PostForm.Get("AuthMethod") != "DuoAdfsAdapter" {
+			fmt.Fprint(w, loginPage)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if d.scenario == WeirdEncoding {
+		w.Header().Set("Content-Type", "text/html; charset=ISO-8859-1")
+		fmt.Fprintf(w, weirdSAMLPage, entityEscape(string(Assertion)))
+		return
+	}
+	fmt.Fprintf(w, samlPage, html.EscapeString(string(Assertion)))
+}
+
+func (duoIdP) json(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
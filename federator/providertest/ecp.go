@@ -0,0 +1,137 @@
+package providertest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+// ECPFixture simulates the SAML 2.0 ECP SOAP endpoint of a Shibboleth IdP,
+// for use with federator.ECPProvider.  The ECP profile authenticates with
+// HTTP basic authentication alone, so MFARequired isn't supported.
+type ECPFixture struct{}
+
+const ecpPath = "/idp/profile/SAML2/SOAP/ECP"
+
+func (ECPFixture) Provider(url string, mfa federator.MFAPrompter) federator.Provider {
+	return &federator.ECPProvider{
+		Endpoint: url + ecpPath,
+		Username: Username,
+		Password: Password,
+	}
+}
+
+func (ECPFixture) Handler(s Scenario) http.Handler {
+	return ecpIdP{scenario: s}
+}
+
+func (ECPFixture) Supports(s Scenario) bool {
+	return s != MFARequired
+}
+
+type ecpIdP struct {
+	scenario Scenario
+}
+
+const (
+	// ecpResponse is the PAOS response, whose header tells the ECP where
+	// to send the SAML response in its body.
+	ecpResponse = `<S:Envelope xmlns:S="http://schemas.xmlsoap.org/soap/envelope/">` +
+		`<S:Header><ecp:Response xmlns:ecp="urn:oasis:names:tc:SAML:2.0:profiles:SSO:ecp" S:actor="http://schemas.xmlsoap.org/soap/actor/next" S:mustUnderstand="1" AssertionConsumerServiceURL="%s"/></S:Header>` +
+		`<S:Body>%s</S:Body></S:Envelope>`
+
+	// ecpWeirdResponse has an XML declaration, a byte order mark, another
+	// prefix for SOAP and DOS line endings around the SAML response.
+	ecpWeirdResponse = "\xef\xbb\xbf<?xml version='1.0' encoding='UTF-8'?>\r\n" +
+		"<soap11:Envelope xmlns:soap11='http://schemas.xmlsoap.org/soap/envelope/'>\r\n" +
+		"<soap11:Header>\r\n<ecp:Response xmlns:ecp='urn:oasis:names:tc:SAML:2.0:profiles:SSO:ecp' soap11:actor='http://schemas.xmlsoap.org/soap/actor/next' soap11:mustUnderstand='1' AssertionConsumerServiceURL='%s'/>\r\n</soap11:Header>\r\n" +
+		"<soap11:Body>\r\n<!-- ECP response -->\r\n%s\r\n</soap11:Body>\r\n</soap11:Envelope>\r\n"
+
+	ecpFault = `<S:Envelope xmlns:S="http://schemas.xmlsoap.org/soap/envelope/"><S:Body><S:Fault><faultcode>S:Client</faultcode><faultstring>%s</faultstring></S:Fault></S:Body></S:Envelope>`
+)
+
+// ecpAuthnRequest is the SOAP wrapped AuthnRequest sent by the ECP.
+type ecpAuthnRequest struct {
+	AuthnRequest struct {
+		XMLName         xml.Name
+		ID              string `xml:"ID,attr"`
+		Version         string `xml:"Version,attr"`
+		IssueInstant    string `xml:"IssueInstant,attr"`
+		ACS             string `xml:"AssertionConsumerServiceURL,attr"`
+		ProtocolBinding string `xml:"ProtocolBinding,attr"`
+		Issuer          struct {
+			XMLName xml.Name
+			Value   string `xml:",chardata"`
+		} `xml:"Issuer"`
+	} `xml:"Body>AuthnRequest"`
+}
+
+// check returns what is wrong with the AuthnRequest, if anything.
+func (r ecpAuthnRequest) check() error {
+	a := r.AuthnRequest
+	switch {
+	case a.XMLName.Space != "urn:oasis:names:tc:SAML:2.0:protocol":
+		return fmt.Errorf("no samlp:AuthnRequest in the SOAP body")
+	case a.ID == "" || !strings.HasPrefix(a.ID, "_"):
+		return fmt.Errorf("invalid ID %q", a.ID)
+	case a.Version != "2.0":
+		return fmt.Errorf("Version is %q", a.Version)
+	case a.ACS != federator.AWSSignInURL:
+		return fmt.Errorf("AssertionConsumerServiceURL is %q, expected %q", a.ACS, federator.AWSSignInURL)
+	case a.ProtocolBinding != "urn:oasis:names:tc:SAML:2.0:bindings:PAOS":
+		return fmt.Errorf("ProtocolBinding is %q, expected PAOS", a.ProtocolBinding)
+	case a.Issuer.XMLName.Space != "urn:oasis:names:tc:SAML:2.0:assertion" || a.Issuer.Value != federator.DefaultRelyingParty:
+		return fmt.Errorf("Issuer is %q in %q, expected %q", a.Issuer.Value, a.Issuer.XMLName.Space, federator.DefaultRelyingParty)
+	}
+	if _, err := time.Parse(time.RFC3339, a.IssueInstant); err != nil {
+		return fmt.Errorf("invalid IssueInstant: %s", err)
+	}
+	return nil
+}
+
+func (e ecpIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if e.scenario == Maintenance {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "<html><body><h1>Service Unavailable</h1></body></html>")
+		return
+	}
+	if r.URL.Path != ecpPath || r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	user, pass, ok := r.BasicAuth()
+	if e.scenario == BadPassword || !ok || user != Username || pass != Password {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Shibboleth IdP"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.paos+xml")
+	var req ecpAuthnRequest
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "text/xml") {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, ecpFault, "unexpected Content-Type "+xmlEscape(r.Header.Get("Content-Type")))
+		return
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, ecpFault, xmlEscape(err.Error()))
+		return
+	}
+	if err := req.check(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, ecpFault, xmlEscape(err.Error()))
+		return
+	}
+
+	if e.scenario == WeirdEncoding {
+		fmt.Fprintf(w, ecpWeirdResponse, xmlEscape(req.AuthnRequest.ACS), samlResponse)
+		return
+	}
+	fmt.Fprintf(w, ecpResponse, xmlEscape(req.AuthnRequest.ACS), samlResponse)
+}
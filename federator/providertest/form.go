@@ -0,0 +1,114 @@
+package providertest
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+// FormFixture simulates a traditional HTML form based IdP such as ADFS or
+// SimpleSAMLphp, for use with the generic federator provider.
+type FormFixture struct{}
+
+func (FormFixture) Provider(url string, mfa federator.MFAPrompter) federator.Provider {
+	f, err := federator.New(Username, Password, url+"/login")
+	if err != nil {
+		panic(err)
+	}
+	f.MFA = mfa
+	return &f
+}
+
+func (FormFixture) Handler(s Scenario) http.Handler {
+	return formIdP{scenario: s}
+}
+
+type formIdP struct {
+	scenario Scenario
+}
+
+const (
+	loginPage = `<html><body><h1>Sign in</h1>
+<form method="post" action="/login">
+<input type="text" name="UserName">
+<input type="password" name="Password">
+<input type="hidden" name="AuthState" value="abc123">
+<input type="submit" value="Sign in">
+</form></body></html>`
+
+	mfaPage = `<html><body><p>Enter the code from your authenticator app</p>
+<form method="post" action="/mfa">
+<input type="text" name="otp">
+<input type="hidden" name="AuthState" value="abc123">
+</form></body></html>`
+
+	samlPage = `<html><body onload="document.forms[0].submit()">
+<form method="post" action="https://signin.aws.amazon.com/saml">
+<input type="hidden" name="SAMLResponse" value="%s">
+<input type="hidden" name="RelayState" value="">
+</form></body></html>`
+
+	// weirdSAMLPage is served with ISO-8859-1 encoding, upper case markup,
+	// CRLF line endings and an entity escaped assertion.
+	weirdSAMLPage = "<HTML>\r\n<BODY ONLOAD=\"document.forms[0].submit()\">\r\n<P>Redirection en cours, veuillez patienter\xe2\x80\xa6 \xe9t\xe9</P>\r\n" +
+		"<FORM METHOD=\"POST\" ACTION=\"https://signin.aws.amazon.com/saml\">\r\n" +
+		"<INPUT TYPE=\"hidden\" NAME=\"SAMLResponse\" VALUE=\"%s\"/>\r\n</FORM>\r\n</BODY>\r\n</HTML>\r\n"
+)
+
+func (f formIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.scenario == Maintenance {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "<html><body><h1>Down for maintenance</h1><p>Please try again later.</p></body></html>")
+		return
+	}
+
+	if r.Method == "GET" {
+		fmt.Fprint(w, loginPage)
+		return
+	}
+
+	r.ParseForm()
+	switch r.URL.Path {
+	case "/login":
+		if f.scenario == BadPassword || r.PostForm.Get("UserName") != Username || r.PostForm.Get("Password") != Password {
+			fmt.Fprint(w, loginPage)
+			return
+		}
+		if f.scenario == MFARequired {
+			fmt.Fprint(w, mfaPage)
+			return
+		}
+	case "/mfa":
+		if r.PostForm.Get("otp") != MFACode {
+			fmt.Fprint(w, mfaPage)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if f.scenario == WeirdEncoding {
+		w.Header().Set("Content-Type", "text/html; charset=ISO-8859-1")
+		fmt.Fprintf(w, weirdSAMLPage, entityEscape(string(Assertion)))
+		return
+	}
+	fmt.Fprintf(w, samlPage, html.EscapeString(string(Assertion)))
+}
+
+// entityEscape escapes every non alphanumeric character as a numeric
+// character reference, as some IdPs do.
+func entityEscape(s string) string {
+	var out []string
+	for _, r := range s {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			out = append(out, string(r))
+		} else {
+			out = append(out, fmt.Sprintf("&#x%X;", r))
+		}
+	}
+	return strings.Join(out, "")
+}
@@ -0,0 +1,134 @@
+package providertest
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+// GoogleFixture simulates accounts.google.com with its separate email,
+// password and 2-Step Verification pages, for use with
+// federator.GoogleProvider.
+type GoogleFixture struct{}
+
+func (GoogleFixture) Provider(url string, mfa federator.MFAPrompter) federator.Provider {
+	return &federator.GoogleProvider{
+		AppURL:   url + "/o/saml2/initsso?idpid=C01abc&spid=123&forceauthn=false",
+		Username: Username,
+		Password: Password,
+		MFA:      mfa,
+	}
+}
+
+func (GoogleFixture) Handler(s Scenario) http.Handler {
+	return googleIdP{scenario: s}
+}
+
+type googleIdP struct {
+	scenario Scenario
+}
+
+const (
+	googleEmailPage = `<!DOCTYPE html><html><body>
+<form novalidate method="post" action="/signin/v1/lookup" id="gaia_loginform">
+<input name="Page" type="hidden" value="PasswordSeparationSignIn">
+<input type="hidden" name="gxf" value="AFoagUW">
+<input type="hidden" name="continue" value="/o/saml2/continue">
+<input id="Email" name="Email" placeholder="Enter your email" type="email" value="" spellcheck="false">
+<input id="next" name="signIn" class="rc-button rc-button-submit" type="submit" value="Next">
+</form></body></html>`
+
+	googlePasswordPage = `<!DOCTYPE html><html><body>
+<form novalidate method="post" action="/signin/challenge/sl/password" id="gaia_loginform">
+<input name="Page" type="hidden" value="PasswordSeparationSignIn">
+<input type="hidden" name="gxf" value="AFoagUW">
+<input id="Email" type="email" name="Email" spellcheck="false" value="%s" class="hidden" readonly>
+<input id="Passwd" name="Passwd" type="password" placeholder="Password" class="">
+%s
+<input id="signIn" name="signIn" class="rc-button rc-button-submit" type="submit" value="Sign in">
+</form></body></html>`
+
+	googlePasswordError = `<span role="alert" class="error-msg" id="errormsg_0_Passwd">Wrong password. Try again.</span>`
+
+	googleTOTPPage = `<!DOCTYPE html><html><body>
+<form method="post" action="/signin/challenge/skip" id="skip"><input type="hidden" name="challengeId" value="2"></form>
+<form id="challenge" method="post" action="/signin/challenge/totp/2">
+<input type="hidden" name="challengeId" value="2">
+<input type="hidden" name="challengeType" value="6">
+<input type="hidden" name="TL" value="AM3QAYZ">
+<input type="hidden" name="gxf" value="AFoagUW">
+<input type="tel" name="Pin" id="totpPin" pattern="[0-9 ]*" value="" placeholder="Enter code">
+<input type="checkbox" name="TrustDevice" id="trustDevice" checked>
+<input type="submit" id="submit" value="Done">
+</form></body></html>`
+)
+
+func (g googleIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if g.scenario == Maintenance {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "<html><body><h1>503. That's an error.</h1></body></html>")
+		return
+	}
+
+	r.ParseForm()
+	switch r.URL.Path {
+	case "/o/saml2/initsso":
+		http.SetCookie(w, &http.Cookie{Name: "GAPS", Value: "session", Path: "/"})
+		http.Redirect(w, r, "/ServiceLogin?continue=/o/saml2/continue", http.StatusFound)
+		return
+	case "/ServiceLogin":
+		fmt.Fprint(w, googleEmailPage)
+		return
+	case "/signin/v1/lookup":
+		if !g.session(w, r) {
+			return
+		}
+		if r.PostForm.Get("Email") != Username {
+			fmt.Fprint(w, googleEmailPage)
+			return
+		}
+		fmt.Fprintf(w, googlePasswordPage, html.EscapeString(Username), "")
+		return
+	case "/signin/challenge/sl/password":
+		if !g.session(w, r) {
+			return
+		}
+		if g.scenario == BadPassword || r.PostForm.Get("Email") != Username || r.PostForm.Get("Passwd") != Password {
+			fmt.Fprintf(w, googlePasswordPage, html.EscapeString(Username), googlePasswordError)
+			return
+		}
+		if g.scenario == MFARequired {
+			fmt.Fprint(w, googleTOTPPage)
+			return
+		}
+	case "/signin/challenge/totp/2":
+		if !g.session(w, r) {
+			return
+		}
+		if r.PostForm.Get("Pin") != MFACode || r.PostForm.Get("TL") != "AM3QAYZ" {
+			fmt.Fprint(w, googleTOTPPage)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if g.scenario == WeirdEncoding {
+		w.Header().Set("Content-Type", "text/html; charset=ISO-8859-1")
+		fmt.Fprintf(w, weirdSAMLPage, entityEscape(string(Assertion)))
+		return
+	}
+	fmt.Fprintf(w, samlPage, html.EscapeString(string(Assertion)))
+}
+
+// session checks the cookie set when the login started.
+func (googleIdP) session(w http.ResponseWriter, r *http.Request) bool {
+	if c, err := r.Cookie("GAPS"); err != nil || c.Value != "session" {
+		http.Error(w, "Cookies are disabled", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package providertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+// JumpCloudFixture simulates JumpCloud's user console API and SSO
+// application, for use with federator.JumpCloudProvider.
+type JumpCloudFixture struct{}
+
+func (JumpCloudFixture) Provider(url string, mfa federator.MFAPrompter) federator.Provider {
+	return &federator.JumpCloudProvider{
+		AppURL:     url + "/saml2/aws",
+		ConsoleURL: url,
+		Username:   Username,
+		Password:   Password,
+		MFA:        mfa,
+	}
+}
+
+func (JumpCloudFixture) Handler(s Scenario) http.Handler {
+	return jumpCloudIdP{scenario: s}
+}
+
+type jumpCloudIdP struct {
+	scenario Scenario
+}
+
+func (j jumpCloudIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if j.scenario == Maintenance {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "<html><body><h1>503 Service Temporarily Unavailable</h1></body></html>")
+		return
+	}
+
+	switch r.URL.Path {
+	case "/userconsole/xsrf":
+		http.SetCookie(w, &http.Cookie{Name: "_xsrf", Value: "xsrf-cookie", Path: "/"})
+		j.json(w, http.StatusOK, map[string]string{"xsrf": "xsrf-token"})
+	case "/userconsole/auth":
+		if c, err := r.Cookie("_xsrf"); err != nil || c.Value != "xsrf-cookie" || r.Header.Get("X-Xsrftoken") != "xsrf-token" {
+			j.json(w, http.StatusForbidden, map[string]string{"message": "Invalid XSRF token"})
+			return
+		}
+
+		var login map[string]string
+		json.NewDecoder(r.Body).Decode(&login)
+		if j.scenario == BadPassword || login["email"] != Username || login["password"] != Password {
+			j.json(w, http.StatusUnauthorized, map[string]string{"message": "Authentication failed."})
+			return
+		}
+		if j.scenario == MFARequired && login["otp"] != MFACode {
+			j.json(w, http.StatusUnauthorized, map[string]string{"error": "MFA required."})
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{Name: "jcuser", Value: "session", Path: "/"})
+		j.json(w, http.StatusOK, map[string]string{"redirectTo": "/" + login["redirectTo"]})
+	case "/saml2/aws":
+		if c, err := r.Cookie("jcuser"); err != nil || c.Value != "session" {
+			http.Redirect(w, r, "/login?redirectTo=saml2/aws", http.StatusFound)
+			return
+		}
+		if j.scenario == WeirdEncoding {
+			w.Header().Set("Content-Type", "text/html; charset=ISO-8859-1")
+			fmt.Fprintf(w, weirdSAMLPage, entityEscape(string(Assertion)))
+			return
+		}
+		fmt.Fprintf(w, samlPage, html.EscapeString(string(Assertion)))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (jumpCloudIdP) json(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,103 @@
+package providertest
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+// KeycloakFixture simulates a Keycloak realm with its login form and
+// separate one-time password page, for use with federator.KeycloakProvider.
+type KeycloakFixture struct{}
+
+func (KeycloakFixture) Provider(url string, mfa federator.MFAPrompter) federator.Provider {
+	return &federator.KeycloakProvider{
+		AppURL:   url + "/realms/corp/protocol/saml/clients/amazon-aws",
+		Username: Username,
+		Password: Password,
+		MFA:      mfa,
+	}
+}
+
+func (KeycloakFixture) Handler(s Scenario) http.Handler {
+	return keycloakIdP{scenario: s}
+}
+
+type keycloakIdP struct {
+	scenario Scenario
+}
+
+const (
+	keycloakLoginPage = `<!DOCTYPE html><html><body class="login-pf-page">
+%s
+<form id="kc-form-login" onsubmit="login.disabled = true; return true;" action="/realms/corp/login-actions/authenticate?session_code=abc&amp;execution=e1&amp;client_id=urn%%3Aamazon%%3Awebservices&amp;tab_id=t1" method="post">
+<input tabindex="1" id="username" class="pf-c-form-control" name="username" value="" type="text" autofocus autocomplete="off">
+<input tabindex="2" id="password" class="pf-c-form-control" name="password" type="password" autocomplete="off">
+<input type="hidden" id="id-hidden-input" name="credentialId"/>
+<input tabindex="4" class="pf-c-button" name="login" id="kc-login" type="submit" value="Sign In"/>
+</form></body></html>`
+
+	keycloakLoginError = `<div class="alert-error pf-c-alert pf-m-inline pf-m-danger"><span class="pf-c-alert__title kc-feedback-text">Invalid username or password.</span></div>`
+
+	keycloakOTPPage = `<!DOCTYPE html><html><body>
+<form id="kc-otp-login-form" class="form-horizontal" action="/realms/corp/login-actions/authenticate?session_code=abc&amp;execution=e2&amp;client_id=urn%3Aamazon%3Awebservices&amp;tab_id=t1" method="post">
+<input id="otp" name="otp" autocomplete="off" type="text" class="pf-c-form-control" autofocus/>
+<input class="pf-c-button" name="login" id="kc-login" type="submit" value="Sign In"/>
+</form></body></html>`
+
+	keycloakCookiePage = `<!DOCTYPE html><html><body><div id="kc-error-message"><p class="instruction">Cookie not found. Please make sure cookies are enabled in your browser.</p></div></body></html>`
+)
+
+func (k keycloakIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if k.scenario == Maintenance {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "<html><body><h1>Service Unavailable</h1></body></html>")
+		return
+	}
+
+	switch r.URL.Path {
+	case "/realms/corp/protocol/saml/clients/amazon-aws":
+		http.SetCookie(w, &http.Cookie{Name: "AUTH_SESSION_ID", Value: "session", Path: "/realms/corp/"})
+		fmt.Fprintf(w, keycloakLoginPage, "")
+		return
+	case "/realms/corp/login-actions/authenticate":
+		if c, err := r.Cookie("AUTH_SESSION_ID"); err != nil || c.Value != "session" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, keycloakCookiePage)
+			return
+		}
+
+		r.ParseForm()
+		switch r.URL.Query().Get("execution") {
+		case "e1":
+			if k.scenario == BadPassword || r.PostForm.Get("username") != Username || r.PostForm.Get("password") != Password {
+				fmt.Fprintf(w, keycloakLoginPage, keycloakLoginError)
+				return
+			}
+			if k.scenario == MFARequired {
+				fmt.Fprint(w, keycloakOTPPage)
+				return
+			}
+		case "e2":
+			if r.PostForm.Get("otp") != MFACode {
+				fmt.Fprint(w, keycloakOTPPage)
+				return
+			}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if k.scenario == WeirdEncoding {
+		w.Header().Set("Content-Type", "text/html; charset=ISO-8859-1")
+		fmt.Fprintf(w, weirdSAMLPage, entityEscape(string(Assertion)))
+		return
+	}
+	fmt.Fprintf(w, samlPage, html.EscapeString(string(Assertion)))
+}
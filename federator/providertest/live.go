@@ -0,0 +1,112 @@
+package providertest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Environment variables configuring Live.  Only LiveURLEnv is required.
+const (
+	// LiveURLEnv is the sp_identity_url of the IdP sandbox.
+	LiveURLEnv      = "FEDERATOR_LIVE_URL"
+	LiveUsernameEnv = "FEDERATOR_LIVE_USERNAME"
+	LivePasswordEnv = "FEDERATOR_LIVE_PASSWORD"
+	// LiveIdPTypeEnv is the idp_type of the sandbox, form by default.
+	LiveIdPTypeEnv = "FEDERATOR_LIVE_IDP_TYPE"
+	// LiveRoleEnv is the name or ARN of the role to assume, needed if the
+	// user has more than one.
+	LiveRoleEnv = "FEDERATOR_LIVE_ROLE"
+	// LiveSettingsEnv holds comma separated key=value provider settings,
+	// such as okta_factor=totp.
+	LiveSettingsEnv = "FEDERATOR_LIVE_SETTINGS"
+	// LiveTOTPSecretEnv is the base32 TOTP secret of the user, if the IdP
+	// requires MFA.
+	LiveTOTPSecretEnv = "FEDERATOR_LIVE_TOTP_SECRET"
+	// LiveRegionEnv is the region the credentials are checked in,
+	// us-east-1 by default.
+	LiveRegionEnv = "FEDERATOR_LIVE_REGION"
+)
+
+// LiveTimeout bounds how long the live login may take.
+var LiveTimeout = 2 * time.Minute
+
+// Live logs in to a real IdP sandbox with throwaway credentials, assumes a
+// role and checks the credentials with STS, so that changes to an IdP which
+// break its provider are caught before a release.  It is skipped unless
+// FEDERATOR_LIVE_URL is set:
+//
+//	func TestLive(t *testing.T) {
+//		providertest.Live(t)
+//	}
+func Live(t *testing.T) {
+	url := os.Getenv(LiveURLEnv)
+	if url == "" {
+		t.Skipf("%s is not set", LiveURLEnv)
+	}
+
+	opts := federator.FederateOptions{
+		URL:      url,
+		Username: os.Getenv(LiveUsernameEnv),
+		Password: os.Getenv(LivePasswordEnv),
+		IdPType:  os.Getenv(LiveIdPTypeEnv),
+		Role:     os.Getenv(LiveRoleEnv),
+		Settings: make(map[string]string),
+		MFA:      liveNoMFA{},
+	}
+	for _, kv := range strings.Split(os.Getenv(LiveSettingsEnv), ",") {
+		if parts := strings.SplitN(strings.TrimSpace(kv), "=", 2); len(parts) == 2 {
+			opts.Settings[parts[0]] = parts[1]
+		}
+	}
+	if secret := os.Getenv(LiveTOTPSecretEnv); secret != "" {
+		opts.MFA = federator.TOTPPrompter{Secret: secret}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), LiveTimeout)
+	defer cancel()
+
+	creds, err := federator.Federate(ctx, opts)
+	if ctx.Err() != nil {
+		t.Fatalf("live login did not finish within %s", LiveTimeout)
+	}
+	if err != nil {
+		t.Fatalf("live login failed: %s", err)
+	}
+	if creds.AccessKeyId == "" || creds.SecretAccessKey == "" || creds.SessionToken == "" {
+		t.Fatalf("live login returned incomplete credentials")
+	}
+	if !creds.Expiration.After(time.Now()) {
+		t.Fatalf("live login returned credentials which expired at %s", creds.Expiration)
+	}
+
+	region := os.Getenv(LiveRegionEnv)
+	if region == "" {
+		region = "us-east-1"
+	}
+	client := sts.New(sts.Options{
+		Region: region,
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: creds.AccessKeyId, SecretAccessKey: creds.SecretAccessKey, SessionToken: creds.SessionToken}, nil
+		}),
+	})
+	id, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		t.Fatalf("STS rejected the live credentials: %s", err)
+	}
+	t.Logf("logged in as %s", aws.ToString(id.Arn))
+}
+
+// liveNoMFA fails MFA prompts, as there is nobody to answer them.
+type liveNoMFA struct{}
+
+func (liveNoMFA) MFACode(label string) (string, error) {
+	return "", errors.New("the IdP asked for MFA but " + LiveTOTPSecretEnv + " is not set")
+}
@@ -0,0 +1,9 @@
+package providertest
+
+import "testing"
+
+// TestLive is run by 'make integration', and skipped unless
+// FEDERATOR_LIVE_URL is set.
+func TestLive(t *testing.T) {
+	Live(t)
+}
@@ -0,0 +1,119 @@
+package providertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+// oktaAppPath is the embed link path of the fake AWS app.
+const oktaAppPath = "/home/amazon_aws/0oa1b2c3d4/272"
+
+// OktaFixture simulates the Okta authentication API and an AWS app, for use
+// with federator.OktaProvider.  Okta Verify push notifications are always
+// rejected, so the provider falls back to a code.
+type OktaFixture struct{}
+
+func (OktaFixture) Provider(url string, mfa federator.MFAPrompter) federator.Provider {
+	return &federator.OktaProvider{
+		AppURL:   url + oktaAppPath,
+		Username: Username,
+		Password: Password,
+		MFA:      mfa,
+		Push:     true,
+	}
+}
+
+func (OktaFixture) Handler(s Scenario) http.Handler {
+	return oktaIdP{scenario: s}
+}
+
+type oktaIdP struct {
+	scenario Scenario
+}
+
+func (o oktaIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if o.scenario == Maintenance {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "<html><body><h1>Okta is undergoing maintenance</h1></body></html>")
+		return
+	}
+
+	var body map[string]string
+	if r.Method == "POST" {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	switch r.URL.Path {
+	case "/api/v1/authn":
+		if o.scenario == BadPassword || body["username"] != Username || body["password"] != Password {
+			o.json(w, http.StatusUnauthorized, map[string]string{"errorCode": "E0000004", "errorSummary": "Authentication failed"})
+			return
+		}
+		if o.scenario == MFARequired {
+			o.json(w, http.StatusOK, map[string]interface{}{
+				"status":     "MFA_REQUIRED",
+				"stateToken": "state",
+				"_embedded": map[string]interface{}{
+					"factors": []interface{}{
+						map[string]interface{}{
+							"id":         "push",
+							"factorType": "push",
+							"provider":   "OKTA",
+							"_links": map[string]interface{}{
+								"verify": map[string]string{"href": "http://" + r.Host + "/api/v1/authn/factors/push/verify"},
+							},
+						},
+						map[string]interface{}{
+							"id":         "totp",
+							"factorType": "token:software:totp",
+							"provider":   "GOOGLE",
+							"_links": map[string]interface{}{
+								"verify": map[string]string{"href": "http://" + r.Host + "/api/v1/authn/factors/totp/verify"},
+							},
+						},
+					},
+				},
+			})
+			return
+		}
+		o.json(w, http.StatusOK, map[string]string{"status": "SUCCESS", "sessionToken": "session"})
+	case "/api/v1/authn/factors/push/verify":
+		o.json(w, http.StatusOK, map[string]string{"status": "MFA_CHALLENGE", "stateToken": "state", "factorResult": "REJECTED"})
+	case "/api/v1/authn/factors/totp/verify":
+		if body["stateToken"] != "state" || body["passCode"] != MFACode {
+			o.json(w, http.StatusForbidden, map[string]string{"errorCode": "E0000068", "errorSummary": "Invalid Passcode/Answer"})
+			return
+		}
+		o.json(w, http.StatusOK, map[string]string{"status": "SUCCESS", "sessionToken": "session"})
+	case "/login/sessionCookieRedirect":
+		if r.URL.Query().Get("token") != "session" {
+			http.Error(w, "invalid session token", http.StatusForbidden)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "okta-session", Path: "/"})
+		http.Redirect(w, r, r.URL.Query().Get("redirectUrl"), http.StatusFound)
+	case oktaAppPath:
+		if c, err := r.Cookie("sid"); err != nil || c.Value != "okta-session" {
+			http.Redirect(w, r, "/login/login.htm", http.StatusFound)
+			return
+		}
+		if o.scenario == WeirdEncoding {
+			w.Header().Set("Content-Type", "text/html; charset=ISO-8859-1")
+			fmt.Fprintf(w, weirdSAMLPage, entityEscape(string(Assertion)))
+			return
+		}
+		fmt.Fprintf(w, samlPage, html.EscapeString(string(Assertion)))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (oktaIdP) json(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,98 @@
+package providertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+// OneLoginFixture simulates OneLogin's SAML assertion API, for use with
+// federator.OneLoginProvider.
+type OneLoginFixture struct{}
+
+func (OneLoginFixture) Provider(url string, mfa federator.MFAPrompter) federator.Provider {
+	return &federator.OneLoginProvider{
+		APIURL:       url,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Subdomain:    "example",
+		AppID:        "123456",
+		Username:     Username,
+		Password:     Password,
+		MFA:          mfa,
+	}
+}
+
+func (OneLoginFixture) Handler(s Scenario) http.Handler {
+	return oneLoginIdP{scenario: s}
+}
+
+type oneLoginIdP struct {
+	scenario Scenario
+}
+
+func (o oneLoginIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if o.scenario == Maintenance {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "<html><body><h1>503 Service Temporarily Unavailable</h1></body></html>")
+		return
+	}
+
+	var body map[string]string
+	json.NewDecoder(r.Body).Decode(&body)
+
+	switch r.URL.Path {
+	case "/auth/oauth2/v2/token":
+		if r.Header.Get("Authorization") != "client_id:client, client_secret:secret" {
+			o.json(w, http.StatusUnauthorized, map[string]interface{}{"statusCode": 401, "message": "Authentication Failure"})
+			return
+		}
+		o.json(w, http.StatusOK, map[string]interface{}{"access_token": "token", "token_type": "bearer"})
+		return
+	case "/api/2/saml_assertion":
+		if r.Header.Get("Authorization") != "bearer:token" || body["app_id"] != "123456" || body["subdomain"] != "example" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if o.scenario == BadPassword || body["username_or_email"] != Username || body["password"] != Password {
+			o.json(w, http.StatusUnauthorized, map[string]interface{}{"statusCode": 401, "name": "Unauthorized", "message": "Authentication Failed: Invalid user credentials"})
+			return
+		}
+		if o.scenario == MFARequired {
+			o.json(w, http.StatusOK, map[string]interface{}{
+				"state_token": "state",
+				"message":     "MFA is required for this user",
+				"devices":     []interface{}{map[string]interface{}{"device_id": 666, "device_type": "Google Authenticator"}},
+			})
+			return
+		}
+	case "/api/2/saml_assertion/verify_factor":
+		if body["state_token"] != "state" || body["device_id"] != "666" || body["otp_token"] != MFACode {
+			o.json(w, http.StatusUnauthorized, map[string]interface{}{"statusCode": 401, "message": "Failed authentication with this factor"})
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if o.scenario == WeirdEncoding {
+		// every character escaped, as JSON allows
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, "{\r\n  \"message\" : \"Success\",\r\n  \"data\" : \"")
+		for _, c := range string(Assertion) {
+			fmt.Fprintf(w, "\\u%04x", c)
+		}
+		fmt.Fprint(w, "\"\r\n}\r\n")
+		return
+	}
+	o.json(w, http.StatusOK, map[string]interface{}{"message": "Success", "data": string(Assertion)})
+}
+
+func (oneLoginIdP) json(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
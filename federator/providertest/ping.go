@@ -0,0 +1,100 @@
+package providertest
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+// PingFixture simulates PingFederate's HTML Form Adapter with PingID as a
+// second factor, for use with federator.PingProvider.
+type PingFixture struct{}
+
+func (PingFixture) Provider(url string, mfa federator.MFAPrompter) federator.Provider {
+	return &federator.PingProvider{
+		AppURL:   url + "/idp/startSSO.ping?PartnerSpId=urn:amazon:webservices",
+		Username: Username,
+		Password: Password,
+		MFA:      mfa,
+	}
+}
+
+func (PingFixture) Handler(s Scenario) http.Handler {
+	return pingIdP{scenario: s}
+}
+
+type pingIdP struct {
+	scenario Scenario
+}
+
+const (
+	pingLoginPage = `<html><body><form method="POST" action="/idp/AbC12/resumeSAML20/idp/startSSO.ping" autocomplete="off">
+<div class="ping-error">%s</div>
+<input id="username" type="text" name="pf.username" value="">
+<input id="password" type="password" name="pf.pass">
+<input type="hidden" name="pf.ok" value="">
+<input type="hidden" name="pf.cancel" value="">
+<input type="hidden" name="pf.adapterId" value="HTMLFormAdapter">
+<a onclick="postOk();" class="ping-button">Sign On</a>
+</form></body></html>`
+
+	pingPPMPage = `<html><body onload="document.forms[0].submit()">
+<form method="POST" action="/pingid/ppm/auth"><input type="hidden" name="ppm_request" value="req"></form></body></html>`
+
+	pingOTPPage = `<html><body><form id="otp-form" method="POST" action="/pingid/ppm/auth/otp">
+<input type="hidden" name="csrf" value="token">
+<input type="text" name="otp" autocomplete="off">
+</form></body></html>`
+
+	pingResumePage = `<html><body onload="document.forms[0].submit()">
+<form method="POST" action="/idp/AbC12/resumeSAML20/idp/startSSO.ping"><input type="hidden" name="ppm_response" value="resp"></form></body></html>`
+)
+
+func (p pingIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if p.scenario == Maintenance {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "<html><body><h1>Service Unavailable</h1></body></html>")
+		return
+	}
+
+	r.ParseForm()
+	switch r.URL.Path {
+	case "/idp/startSSO.ping":
+		fmt.Fprintf(w, pingLoginPage, "")
+		return
+	case "/idp/AbC12/resumeSAML20/idp/startSSO.ping":
+		if r.PostForm.Get("ppm_response") == "resp" {
+			break
+		}
+		if p.scenario == BadPassword || r.PostForm.Get("pf.ok") != "clicked" || r.PostForm.Get("pf.username") != Username || r.PostForm.Get("pf.pass") != Password {
+			fmt.Fprintf(w, pingLoginPage, "We didn't recognize the username or password you entered. Please try again.")
+			return
+		}
+		if p.scenario == MFARequired {
+			fmt.Fprint(w, pingPPMPage)
+			return
+		}
+	case "/pingid/ppm/auth":
+		fmt.Fprint(w, pingOTPPage)
+		return
+	case "/pingid/ppm/auth/otp":
+		if r.PostForm.Get("otp") != MFACode || r.PostForm.Get("csrf") != "token" {
+			fmt.Fprint(w, pingOTPPage)
+			return
+		}
+		fmt.Fprint(w, pingResumePage)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if p.scenario == WeirdEncoding {
+		w.Header().Set("Content-Type", "text/html; charset=ISO-8859-1")
+		fmt.Fprintf(w, weirdSAMLPage, entityEscape(string(Assertion)))
+		return
+	}
+	fmt.Fprintf(w, samlPage, html.EscapeString(string(Assertion)))
+}
@@ -0,0 +1,186 @@
+// Package providertest contains a conformance suite that every
+// federator.Provider implementation is expected to pass.
+//
+// Each provider supplies a Fixture which simulates its identity provider in
+// the states described by Scenario, and calls Run from its tests:
+//
+//	func TestConformance(t *testing.T) {
+//		providertest.Run(t, myFixture{})
+//	}
+package providertest
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+// Credentials that fixtures must accept, and the MFA code the suite's
+// prompter returns.
+const (
+	Username = "conformance@example.com"
+	Password = "p@ss word+&"
+	MFACode  = "123456"
+)
+
+// Assertion is the SAMLResponse fixtures must issue after a successful
+// login.  It deliberately contains characters that need escaping when
+// embedded in HTML, JSON or form bodies.
+var Assertion = federator.SAMLAssertion(base64.StdEncoding.EncodeToString([]byte(samlResponse)))
+
+// samlResponse is Assertion before it is base64 encoded, for fixtures
+// whose IdPs return it as XML.
+const samlResponse = `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="_conformance">` +
+	`<samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>` +
+	`<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_assertion~+/="></saml:Assertion></samlp:Response>`
+
+// Scenario is a state of the identity provider exercised by the suite.
+type Scenario int
+
+const (
+	// LoginSuccess accepts Username and Password and issues Assertion.
+	LoginSuccess Scenario = iota
+	// BadPassword rejects every login attempt.
+	BadPassword
+	// MFARequired requires MFACode after the password before issuing
+	// Assertion.
+	MFARequired
+	// Maintenance serves an error page for every request.
+	Maintenance
+	// WeirdEncoding behaves like LoginSuccess but serves its pages with
+	// unusual character sets, casing, line endings and entity escaping.
+	WeirdEncoding
+)
+
+func (s Scenario) String() string {
+	switch s {
+	case LoginSuccess:
+		return "LoginSuccess"
+	case BadPassword:
+		return "BadPassword"
+	case MFARequired:
+		return "MFARequired"
+	case Maintenance:
+		return "Maintenance"
+	case WeirdEncoding:
+		return "WeirdEncoding"
+	}
+	return "Unknown"
+}
+
+// Fixture simulates a particular identity provider.
+type Fixture interface {
+	// Handler returns a fake IdP behaving as described by s.
+	Handler(s Scenario) http.Handler
+	// Provider returns the provider under test, configured to log in as
+	// Username/Password to the fake IdP served at url and to obtain MFA
+	// codes from mfa.
+	Provider(url string, mfa federator.MFAPrompter) federator.Provider
+}
+
+// AssertionChecker is implemented by fixtures whose providers build the
+// SAMLResponse themselves around the assertion the IdP issues, rather than
+// returning Assertion.  CheckAssertion is used in place of comparing the
+// assertion returned in s with Assertion.
+type AssertionChecker interface {
+	CheckAssertion(s Scenario, assertion federator.SAMLAssertion) error
+}
+
+// ScenarioSupporter is implemented by fixtures for IdPs which can't be in
+// every Scenario, such as those without MFA.  Run skips the scenarios
+// Supports returns false for.
+type ScenarioSupporter interface {
+	Supports(s Scenario) bool
+}
+
+// Timeout bounds how long a provider may take to complete a scenario.
+var Timeout = 10 * time.Second
+
+type expectation struct {
+	scenario  Scenario
+	succeed   bool
+	err       error
+	promptMFA bool
+}
+
+var expectations = []expectation{
+	{scenario: LoginSuccess, succeed: true},
+	{scenario: BadPassword, err: federator.ErrInvalidCredentials},
+	{scenario: MFARequired, succeed: true, promptMFA: true},
+	{scenario: Maintenance},
+	{scenario: WeirdEncoding, succeed: true},
+}
+
+// Run exercises f's provider against every Scenario.
+func Run(t *testing.T, f Fixture) {
+	for _, e := range expectations {
+		e := e
+		t.Run(e.scenario.String(), func(t *testing.T) {
+			if ss, ok := f.(ScenarioSupporter); ok && !ss.Supports(e.scenario) {
+				t.Skipf("%T does not support %s", f, e.scenario)
+			}
+
+			srv := httptest.NewServer(f.Handler(e.scenario))
+			defer srv.Close()
+
+			mfa := &recordingPrompter{}
+			p := f.Provider(srv.URL, mfa)
+
+			ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+			defer cancel()
+
+			assertion, err := p.Authenticate(ctx)
+			if ctx.Err() != nil {
+				t.Fatalf("provider did not finish within %s", Timeout)
+			}
+
+			checker, custom := f.(AssertionChecker)
+			switch {
+			case e.succeed && err != nil:
+				t.Fatalf("expected successful login, got error: %s", err)
+			case e.succeed && custom:
+				if err := checker.CheckAssertion(e.scenario, assertion); err != nil {
+					t.Fatalf("unexpected assertion: %s", err)
+				}
+			case e.succeed && assertion != Assertion:
+				t.Fatalf("expected assertion %q, got %q", Assertion, assertion)
+			case !e.succeed && err == nil:
+				t.Fatalf("expected login to fail, got assertion %q", assertion)
+			case e.err != nil && err != e.err:
+				t.Fatalf("expected error %q, got %q", e.err, err)
+			}
+
+			if e.promptMFA && mfa.calls() == 0 {
+				t.Fatalf("expected the MFA prompter to be consulted")
+			}
+			if !e.promptMFA && e.succeed && mfa.calls() != 0 {
+				t.Fatalf("MFA prompter consulted %d time(s) when no MFA was required", mfa.calls())
+			}
+		})
+	}
+}
+
+// recordingPrompter returns MFACode and counts how often it was asked.
+type recordingPrompter struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (r *recordingPrompter) MFACode(label string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.n++
+	return MFACode, nil
+}
+
+func (r *recordingPrompter) calls() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.n
+}
@@ -0,0 +1,233 @@
+package providertest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+// WSTrustFixture simulates the WS-Trust 1.3 usernamemixed endpoint of ADFS,
+// for use with federator.WSTrustProvider.  ADFS doesn't apply MFA to
+// WS-Trust requests, so MFARequired isn't supported.
+type WSTrustFixture struct{}
+
+const wsTrustPath = "/adfs/services/trust/13/usernamemixed"
+
+func (WSTrustFixture) Provider(url string, mfa federator.MFAPrompter) federator.Provider {
+	return &federator.WSTrustProvider{
+		Endpoint: url + wsTrustPath,
+		Username: Username,
+		Password: Password,
+	}
+}
+
+func (WSTrustFixture) Handler(s Scenario) http.Handler {
+	return wsTrustIdP{scenario: s}
+}
+
+func (WSTrustFixture) Supports(s Scenario) bool {
+	return s != MFARequired
+}
+
+// CheckAssertion checks that the assertion ADFS issued in s was wrapped,
+// byte for byte so that its signature stays valid, in a successful
+// SAMLResponse to the AWS sign-in endpoint from the assertion's issuer.
+func (WSTrustFixture) CheckAssertion(s Scenario, assertion federator.SAMLAssertion) error {
+	issued := wsTrustAssertion
+	if s == WeirdEncoding {
+		issued = wsTrustWeirdAssertion
+	}
+	return checkWrappedAssertion(assertion, []byte(issued), wsTrustIssuer)
+}
+
+// checkWrappedAssertion checks that assertion is a successful SAMLResponse to
+// the AWS sign-in endpoint from issuer, holding issued exactly as sent.
+func checkWrappedAssertion(assertion federator.SAMLAssertion, issued []byte, issuer string) error {
+	response, err := base64.StdEncoding.DecodeString(string(assertion))
+	if err != nil {
+		return fmt.Errorf("not base64 encoded: %s", err)
+	}
+
+	var r struct {
+		XMLName      xml.Name
+		ID           string `xml:"ID,attr"`
+		Version      string `xml:"Version,attr"`
+		IssueInstant string `xml:"IssueInstant,attr"`
+		Destination  string `xml:"Destination,attr"`
+		Issuer       struct {
+			XMLName xml.Name
+			Value   string `xml:",chardata"`
+		} `xml:"Issuer"`
+		Status struct {
+			Value string `xml:"Value,attr"`
+		} `xml:"Status>StatusCode"`
+	}
+	if err := xml.Unmarshal(response, &r); err != nil {
+		return fmt.Errorf("invalid SAMLResponse %s: %s", response, err)
+	}
+
+	switch {
+	case r.XMLName.Space != "urn:oasis:names:tc:SAML:2.0:protocol" || r.XMLName.Local != "Response":
+		return fmt.Errorf("root element is %s %s, not a samlp:Response", r.XMLName.Space, r.XMLName.Local)
+	case r.ID == "" || !strings.HasPrefix(r.ID, "_"):
+		return fmt.Errorf("invalid ID %q", r.ID)
+	case r.Version != "2.0":
+		return fmt.Errorf("Version is %q", r.Version)
+	case r.Destination != federator.AWSSignInURL:
+		return fmt.Errorf("Destination is %q, expected %q", r.Destination, federator.AWSSignInURL)
+	case r.Issuer.XMLName.Space != "urn:oasis:names:tc:SAML:2.0:assertion" || r.Issuer.Value != issuer:
+		return fmt.Errorf("Issuer is %q in %q, expected %q", r.Issuer.Value, r.Issuer.XMLName.Space, issuer)
+	case r.Status.Value != "urn:oasis:names:tc:SAML:2.0:status:Success":
+		return fmt.Errorf("StatusCode is %q", r.Status.Value)
+	case !bytes.Contains(response, issued):
+		return fmt.Errorf("the issued assertion was not included unchanged in %s", response)
+	}
+	if _, err := time.Parse(time.RFC3339, r.IssueInstant); err != nil {
+		return fmt.Errorf("invalid IssueInstant: %s", err)
+	}
+	return nil
+}
+
+type wsTrustIdP struct {
+	scenario Scenario
+}
+
+const (
+	wsTrustIssuer = "http://adfs.example.com/adfs/services/trust"
+
+	wsTrustAssertion = `<saml:Assertion MajorVersion="1" ID="_d71a3a8e" Version="2.0" IssueInstant="2024-01-01T00:00:00.000Z" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion">` +
+		`<saml:Issuer>` + wsTrustIssuer + `</saml:Issuer>` +
+		`<ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#"><ds:SignedInfo/><ds:SignatureValue>c2lnbmF0dXJl</ds:SignatureValue></ds:Signature>` +
+		`<saml:Subject><saml:NameID>` + Username + `</saml:NameID></saml:Subject>` +
+		`</saml:Assertion>`
+
+	// wsTrustWeirdAssertion uses the default namespace, single quoted
+	// attributes, DOS line endings and character references, all of which
+	// must survive to keep the signature valid.
+	wsTrustWeirdAssertion = "<Assertion ID='_d71a3a8e' Version='2.0' IssueInstant='2024-01-01T00:00:00.000Z' xmlns='urn:oasis:names:tc:SAML:2.0:assertion'>\r\n" +
+		"  <Issuer>http:&#x2F;&#x2F;adfs.example.com&#x2F;adfs&#x2F;services&#x2F;trust</Issuer>\r\n" +
+		"  <ds:Signature xmlns:ds='http://www.w3.org/2000/09/xmldsig#'><ds:SignedInfo/><ds:SignatureValue>c2lnbmF0dXJl</ds:SignatureValue></ds:Signature>\r\n" +
+		"  <Subject><NameID>conformance&#64;example.com</NameID></Subject>\r\n" +
+		"</Assertion>"
+
+	wsTrustResponse = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://www.w3.org/2005/08/addressing">` +
+		`<s:Header><a:Action s:mustUnderstand="1">http://docs.oasis-open.org/ws-sx/ws-trust/200512/RSTRC/IssueFinal</a:Action></s:Header>` +
+		`<s:Body><trust:RequestSecurityTokenResponseCollection xmlns:trust="http://docs.oasis-open.org/ws-sx/ws-trust/200512">` +
+		`<trust:RequestSecurityTokenResponse><trust:RequestedSecurityToken>%s</trust:RequestedSecurityToken>` +
+		`<trust:TokenType>urn:oasis:names:tc:SAML:2.0:assertion</trust:TokenType></trust:RequestSecurityTokenResponse>` +
+		`</trust:RequestSecurityTokenResponseCollection></s:Body></s:Envelope>`
+
+	wsTrustFault = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://www.w3.org/2005/08/addressing">` +
+		`<s:Body><s:Fault><s:Code><s:Value>s:Sender</s:Value><s:Subcode><s:Value xmlns:a="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd">a:FailedAuthentication</s:Value></s:Subcode></s:Code>` +
+		`<s:Reason><s:Text xml:lang="en-US">%s</s:Text></s:Reason></s:Fault></s:Body></s:Envelope>`
+)
+
+// wsTrustRequest is the part of a WS-Trust Issue request checked by the
+// fixture.
+type wsTrustRequest struct {
+	Action   string `xml:"Header>Action"`
+	To       string `xml:"Header>To"`
+	Security struct {
+		Created  string `xml:"Timestamp>Created"`
+		Expires  string `xml:"Timestamp>Expires"`
+		Username string `xml:"UsernameToken>Username"`
+		Password struct {
+			Type  string `xml:"Type,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"UsernameToken>Password"`
+	} `xml:"Header>Security"`
+	RST struct {
+		AppliesTo   string `xml:"AppliesTo>EndpointReference>Address"`
+		KeyType     string `xml:"KeyType"`
+		RequestType string `xml:"RequestType"`
+		TokenType   string `xml:"TokenType"`
+	} `xml:"Body>RequestSecurityToken"`
+}
+
+// check returns what is wrong with the request sent to endpoint, if
+// anything, besides the credentials.
+func (r wsTrustRequest) check(endpoint string) error {
+	created, err := time.Parse(time.RFC3339, r.Security.Created)
+	if err != nil {
+		return fmt.Errorf("invalid Timestamp Created: %s", err)
+	}
+	expires, err := time.Parse(time.RFC3339, r.Security.Expires)
+	if err != nil {
+		return fmt.Errorf("invalid Timestamp Expires: %s", err)
+	}
+
+	switch {
+	case r.Action != "http://docs.oasis-open.org/ws-sx/ws-trust/200512/RST/Issue":
+		return fmt.Errorf("unexpected Action %q", r.Action)
+	case r.To != endpoint:
+		return fmt.Errorf("To is %q, expected %q", r.To, endpoint)
+	case !expires.After(created):
+		return fmt.Errorf("Timestamp expires at %s, before it was created at %s", expires, created)
+	case r.Security.Password.Type != "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordText":
+		return fmt.Errorf("unexpected Password Type %q", r.Security.Password.Type)
+	case r.RST.AppliesTo != federator.DefaultRelyingParty:
+		return fmt.Errorf("AppliesTo is %q, expected %q", r.RST.AppliesTo, federator.DefaultRelyingParty)
+	case r.RST.KeyType != "http://docs.oasis-open.org/ws-sx/ws-trust/200512/Bearer":
+		return fmt.Errorf("unexpected KeyType %q", r.RST.KeyType)
+	case r.RST.RequestType != "http://docs.oasis-open.org/ws-sx/ws-trust/200512/Issue":
+		return fmt.Errorf("unexpected RequestType %q", r.RST.RequestType)
+	case r.RST.TokenType != "urn:oasis:names:tc:SAML:2.0:assertion":
+		return fmt.Errorf("unexpected TokenType %q", r.RST.TokenType)
+	}
+	return nil
+}
+
+func (f wsTrustIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.scenario == Maintenance {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "<html><body><h1>Service Unavailable</h1></body></html>")
+		return
+	}
+	if r.URL.Path != wsTrustPath || r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+	var req wsTrustRequest
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/soap+xml") {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		fmt.Fprintf(w, wsTrustFault, "unexpected Content-Type "+xmlEscape(r.Header.Get("Content-Type")))
+		return
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, wsTrustFault, xmlEscape(err.Error()))
+		return
+	}
+	if err := req.check("http://" + r.Host + wsTrustPath); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, wsTrustFault, xmlEscape(err.Error()))
+		return
+	}
+
+	if f.scenario == BadPassword || req.Security.Username != Username || req.Security.Password.Value != Password {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, wsTrustFault, "MSIS3127: The specified request failed. ID3242: The security token could not be authenticated or authorized.")
+		return
+	}
+
+	if f.scenario == WeirdEncoding {
+		fmt.Fprintf(w, "\xef\xbb\xbf"+strings.Replace(wsTrustResponse, "><", ">\r\n<", -1), wsTrustWeirdAssertion)
+		return
+	}
+	fmt.Fprintf(w, wsTrustResponse, wsTrustAssertion)
+}
+
+// xmlEscape escapes s as XML character data.
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
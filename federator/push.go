@@ -0,0 +1,97 @@
+package federator
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// PushTimeout is how long a push notification is waited for before it is
+// treated as declined.
+var PushTimeout = 60 * time.Second
+
+// pushPollInterval is how often the IdP is asked whether a push notification
+// has been approved.
+const pushPollInterval = 2 * time.Second
+
+// PushAction is what the user chose to do while a push notification is
+// waiting for approval.
+type PushAction int
+
+const (
+	// PushWait keeps waiting for approval.
+	PushWait PushAction = iota
+	// PushResend sends the notification again and restarts the countdown.
+	PushResend
+	// PushUseCode stops waiting and asks for a one-time code instead.
+	PushUseCode
+)
+
+// PushPrompter is implemented by MFAPrompters which can show the progress of
+// a push notification and let the user resend it or enter a code instead.
+// Prompters which don't implement it leave the IdP to wait until
+// PushTimeout.
+type PushPrompter interface {
+	MFAPrompter
+	// PushWaiting is called about once a second while the push described
+	// by label waits for approval, with the time left before it expires.
+	// It should return promptly with the user's choice.
+	PushWaiting(label string, left time.Duration) PushAction
+	// PushDone is called when waiting ends, however it ended.
+	PushDone(label string)
+}
+
+// ErrPushTimeout is returned when a push notification was not approved in
+// time.
+var ErrPushTimeout = errors.New("MFA push notification was not approved in time")
+
+// waitForPush calls poll until it reports the push notification approved,
+// showing mfa the countdown when it is a PushPrompter.  resend sends the
+// notification again, and is nil if the IdP can't.  useCode is true if the
+// user chose to enter a code instead.
+func waitForPush(ctx context.Context, mfa MFAPrompter, label string, poll func() (bool, error), resend func() error) (useCode bool, err error) {
+	prompter, _ := mfa.(PushPrompter)
+	if prompter != nil {
+		defer prompter.PushDone(label)
+	}
+
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	deadline := time.Now().Add(PushTimeout)
+	nextPoll := time.Now()
+	for {
+		now := time.Now()
+		if !now.Before(deadline) {
+			return false, ErrPushTimeout
+		}
+
+		if prompter != nil {
+			switch prompter.PushWaiting(label, deadline.Sub(now)) {
+			case PushResend:
+				if resend != nil {
+					if err := resend(); err != nil {
+						return false, err
+					}
+					deadline = time.Now().Add(PushTimeout)
+				}
+			case PushUseCode:
+				return true, nil
+			}
+		}
+
+		if !now.Before(nextPoll) {
+			approved, err := poll()
+			if err != nil || approved {
+				return false, err
+			}
+			nextPoll = now.Add(pushPollInterval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-tick.C:
+		}
+	}
+}
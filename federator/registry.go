@@ -0,0 +1,102 @@
+package federator
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// ProviderConfig is what a registered provider is created from.
+type ProviderConfig struct {
+	// Account is the name of the account's configuration section.
+	Account string
+	// URL is the account's sp_identity_url.
+	URL      string
+	Username string
+	Password string
+
+	MFA    MFAPrompter
+	Client *http.Client
+
+	// ACSURL is the assertion consumer service assertions are issued for,
+	// or "" for AWSSignInURL.  Audience, if set, is the entity ID the
+	// service provider is known by at the IdP, used by providers which
+	// request assertions for it by name.
+	ACSURL   string
+	Audience string
+
+	// Interactive is true when MFA asks the user, who can then also respond
+	// to push notifications, rather than supplying codes by itself.
+	Interactive bool
+
+	// Setting returns the value of a provider specific configuration key,
+	// or "" if it is not set.  It may be nil.
+	Setting func(key string) string
+}
+
+func (c ProviderConfig) setting(key, def string) string {
+	if c.Setting != nil {
+		if v := c.Setting(key); v != "" {
+			return v
+		}
+	}
+	return def
+}
+
+// ProviderFactory creates a provider for an account.  Returning a nil
+// Provider selects the Federator's generic form login.
+type ProviderFactory func(cfg ProviderConfig) (Provider, error)
+
+var (
+	providersMu sync.Mutex
+	providers   = make(map[string]ProviderFactory)
+)
+
+// RegisterProvider makes a provider available as the idp_type name.  It is
+// intended to be called from init functions, and panics if name is already
+// registered.
+func RegisterProvider(name string, f ProviderFactory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	if _, ok := providers[name]; ok {
+		panic("federator: provider " + name + " registered twice")
+	}
+	providers[name] = f
+}
+
+// NewProvider creates the provider registered as idpType.  An empty idpType
+// is the generic form login.
+func NewProvider(idpType string, cfg ProviderConfig) (Provider, error) {
+	if idpType == "" {
+		idpType = "form"
+	}
+
+	providersMu.Lock()
+	f, ok := providers[idpType]
+	providersMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown idp_type '%s'", idpType)
+	}
+	return f(cfg)
+}
+
+// ProviderTypes returns the sorted names of the registered providers.
+func ProviderTypes() []string {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	var names []string
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterProvider("form", func(ProviderConfig) (Provider, error) {
+		return nil, nil
+	})
+}
@@ -0,0 +1,143 @@
+package federator
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// ErrAssertionConsumed is returned by AssumeRole when the IdP marked the
+// SAML assertion as one-time-use and it has already been exchanged with STS.
+// A fresh assertion must be obtained by calling Login again.
+var ErrAssertionConsumed = errors.New("SAML assertion is one-time-use and has already been used")
+
+// AssertionLedger records which SAML assertions have been presented to STS.
+type AssertionLedger interface {
+	// Consumed reports whether the assertion identified by id has been used.
+	Consumed(id string) bool
+	// Consume records that the assertion identified by id has been used.
+	// Entries may be forgotten once the assertion expires.
+	Consume(id string, expires time.Time) error
+}
+
+// assertionInfo holds the parts of a SAML assertion needed to decide whether
+// it can be reused.
+type assertionInfo struct {
+	ID           string
+	InResponseTo string
+	NotOnOrAfter time.Time
+	OneTimeUse   bool
+}
+
+// key identifies the assertion in a ledger.  The assertion ID is preferred,
+// falling back to the request the response was issued for.
+func (i assertionInfo) key() string {
+	if i.ID != "" {
+		return i.ID
+	}
+	return i.InResponseTo
+}
+
+func parseAssertionInfo(samlResponse64 string) (assertionInfo, error) {
+	raw, err := base64.StdEncoding.DecodeString(samlResponse64)
+	if err != nil {
+		return assertionInfo{}, fmt.Errorf("Unable to decode SAMLResponse: %s", err)
+	}
+
+	var r struct {
+		ID           string `xml:"ID,attr"`
+		InResponseTo string `xml:"InResponseTo,attr"`
+		Assertion    struct {
+			ID         string `xml:"ID,attr"`
+			Conditions struct {
+				NotOnOrAfter string    `xml:"NotOnOrAfter,attr"`
+				OneTimeUse   *struct{} `xml:"OneTimeUse"`
+			} `xml:"Conditions"`
+		} `xml:"Assertion"`
+	}
+	if err := xml.Unmarshal(raw, &r); err != nil {
+		return assertionInfo{}, fmt.Errorf("Unable to parse SAMLResponse: %s", err)
+	}
+
+	info := assertionInfo{
+		ID:           r.Assertion.ID,
+		InResponseTo: r.InResponseTo,
+		OneTimeUse:   r.Assertion.Conditions.OneTimeUse != nil,
+	}
+	if info.ID == "" {
+		info.ID = r.ID
+	}
+	if t, err := time.Parse(time.RFC3339, r.Assertion.Conditions.NotOnOrAfter); err == nil {
+		info.NotOnOrAfter = t
+	}
+
+	return info, nil
+}
+
+// MemoryLedger is an AssertionLedger that only lasts for the lifetime of the
+// process.
+type MemoryLedger struct {
+	mu       sync.Mutex
+	consumed map[string]time.Time
+}
+
+func (m *MemoryLedger) Consumed(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.consumed[id]
+	return ok
+}
+
+func (m *MemoryLedger) Consume(id string, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.consumed == nil {
+		m.consumed = make(map[string]time.Time)
+	}
+	m.consumed[id] = expires
+	return nil
+}
+
+// FileLedger is an AssertionLedger persisted to a JSON file so consumed
+// assertions are remembered across invocations.
+type FileLedger struct {
+	Path string
+}
+
+func (f FileLedger) load() map[string]time.Time {
+	entries := make(map[string]time.Time)
+	if b, err := ioutil.ReadFile(f.Path); err == nil {
+		json.Unmarshal(b, &entries)
+	}
+	return entries
+}
+
+func (f FileLedger) Consumed(id string) bool {
+	_, ok := f.load()[id]
+	return ok
+}
+
+func (f FileLedger) Consume(id string, expires time.Time) error {
+	entries := f.load()
+
+	now := time.Now()
+	for k, exp := range entries {
+		if !exp.IsZero() && now.After(exp) {
+			delete(entries, k)
+		}
+	}
+	entries[id] = expires
+
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(f.Path, b, 0600)
+}
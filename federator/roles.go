@@ -8,15 +8,33 @@ import (
 
 type Role string
 
+// roleArnPattern matches the role ARN of a Role, in any partition such as
+// aws, aws-cn or aws-us-gov, capturing the account ID and the role's path
+// and name.
+var roleArnPattern = regexp.MustCompile(`arn:[^:,]+:iam::(\d{12}):role/([^,]+)`)
+
+// Parse returns the account ID and name of the role, without its path, or
+// an error if the Role doesn't hold an IAM role ARN.
+func (r Role) Parse() (accountID, name string, err error) {
+	parts := roleArnPattern.FindStringSubmatch(string(r))
+	if parts == nil {
+		return "", "", fmt.Errorf("'%s' is not an IAM role ARN", r.RoleArn())
+	}
+	name = parts[2][strings.LastIndex(parts[2], "/")+1:]
+	if name == "" {
+		return "", "", fmt.Errorf("'%s' is not an IAM role ARN", r.RoleArn())
+	}
+	return parts[1], name, nil
+}
+
 // String creates a prettier representation of the raw RoleArn/PrincipalArn
 //  string for presenting to the user
 func (r Role) String() string {
-	//doesn't match all valid characters according to doco
-	//http://docs.aws.amazon.com/IAM/latest/UserGuide/reference_iam-limits.html
-	re := regexp.MustCompile("arn:aws:iam::(\\d+):role/(\\w+)")
-	parts := re.FindStringSubmatch(string(r))
-
-	return fmt.Sprintf("%s - %s", parts[1], parts[2])
+	id, name, err := r.Parse()
+	if err != nil {
+		return r.RoleArn()
+	}
+	return fmt.Sprintf("%s - %s", id, name)
 }
 
 func (r Role) RoleArn() string {
@@ -24,19 +42,34 @@ func (r Role) RoleArn() string {
 }
 
 func (r Role) PrincipalArn() string {
-	return strings.Split(string(r), ",")[1]
+	parts := strings.Split(string(r), ",")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
 }
 
+// AccountId returns the ID of the role's account, or "" if the Role doesn't
+// hold an IAM role ARN.
 func (r Role) AccountId() string {
-	re := regexp.MustCompile("arn:aws:iam::(\\d+):role")
-	a := re.FindStringSubmatch(string(r))
-
-	return a[1]
+	id, _, _ := r.Parse()
+	return id
 }
 
+// RoleName returns the name of the role, or "" if the Role doesn't hold an
+// IAM role ARN.
 func (r Role) RoleName() string {
-	re := regexp.MustCompile("arn:aws:iam::\\d+:role/(\\w+)")
-	a := re.FindStringSubmatch(string(r))
+	_, name, _ := r.Parse()
+	return name
+}
 
-	return a[1]
+// partition returns the partition of the ARNs of region's resources.
+func partition(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	}
+	return "aws"
 }
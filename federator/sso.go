@@ -0,0 +1,345 @@
+package federator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrSSOSessionExpired is returned when AWS SSO rejects the cached access
+// token, which has been discarded so that the next login signs in again.
+var ErrSSOSessionExpired = errors.New("The AWS SSO session has expired or was signed out, run again to sign in")
+
+// SSO obtains role credentials from AWS IAM Identity Center (AWS SSO), using
+// the SSO-OIDC device authorization flow to sign in.  Its roles have no
+// principal, as they are not assumed with SAML.
+type SSO struct {
+	// StartURL is the AWS access portal URL, such as
+	// https://example.awsapps.com/start
+	StartURL string
+	// Region is the region IAM Identity Center is enabled in.
+	Region string
+
+	// CachePath, if set, is a file the client registration and access
+	// token are kept in between logins.
+	CachePath string
+
+	// Authorize is called with the URL the user must visit, and the code
+	// they must confirm there, to sign in.  If it is nil they are written
+	// to STDERR.
+	Authorize func(verificationURL, userCode string)
+
+	Client *http.Client
+
+	// AccountNames are the names of the accounts found by Roles, by ID.
+	AccountNames map[string]string
+
+	token ssoToken
+}
+
+// ssoToken is the client registration and access token kept in CachePath.
+type ssoToken struct {
+	StartURL        string    `json:"startUrl"`
+	Region          string    `json:"region"`
+	ClientID        string    `json:"clientId"`
+	ClientSecret    string    `json:"clientSecret"`
+	ClientExpiresAt time.Time `json:"registrationExpiresAt"`
+	AccessToken     string    `json:"accessToken"`
+	ExpiresAt       time.Time `json:"expiresAt"`
+}
+
+// ssoDeviceCodeGrant is the OAuth grant type of the device authorization
+// flow.
+const ssoDeviceCodeGrant = "urn:ietf:params:oauth:grant-type:device_code"
+
+// Login signs in to AWS SSO unless a cached access token is still valid.
+func (s *SSO) Login(ctx context.Context) error {
+	s.load()
+	now := time.Now()
+	if s.token.AccessToken != "" && now.Add(time.Minute).Before(s.token.ExpiresAt) {
+		return nil
+	}
+
+	if s.token.ClientID == "" || !now.Add(time.Hour).Before(s.token.ClientExpiresAt) {
+		var reg struct {
+			ClientID              string `json:"clientId"`
+			ClientSecret          string `json:"clientSecret"`
+			ClientSecretExpiresAt int64  `json:"clientSecretExpiresAt"`
+		}
+		err := s.oidc(ctx, "/client/register", map[string]interface{}{
+			"clientName": "aws-cli-federator",
+			"clientType": "public",
+		}, &reg)
+		if err != nil {
+			return fmt.Errorf("Could not register with AWS SSO: %s", err)
+		}
+		s.token.ClientID, s.token.ClientSecret = reg.ClientID, reg.ClientSecret
+		s.token.ClientExpiresAt = time.Unix(reg.ClientSecretExpiresAt, 0)
+	}
+
+	var auth struct {
+		DeviceCode              string `json:"deviceCode"`
+		UserCode                string `json:"userCode"`
+		VerificationURIComplete string `json:"verificationUriComplete"`
+		ExpiresIn               int    `json:"expiresIn"`
+		Interval                int    `json:"interval"`
+	}
+	err := s.oidc(ctx, "/device_authorization", map[string]interface{}{
+		"clientId":     s.token.ClientID,
+		"clientSecret": s.token.ClientSecret,
+		"startUrl":     s.StartURL,
+	}, &auth)
+	if err != nil {
+		return fmt.Errorf("Could not start AWS SSO sign in: %s", err)
+	}
+
+	if s.Authorize != nil {
+		s.Authorize(auth.VerificationURIComplete, auth.UserCode)
+	} else {
+		fmt.Fprintf(os.Stderr, "Sign in to AWS SSO at %s and confirm the code %s\n", auth.VerificationURIComplete, auth.UserCode)
+	}
+
+	interval := time.Duration(auth.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(auth.ExpiresIn) * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		var tok struct {
+			AccessToken string `json:"accessToken"`
+			ExpiresIn   int    `json:"expiresIn"`
+		}
+		err := s.oidc(ctx, "/token", map[string]interface{}{
+			"clientId":     s.token.ClientID,
+			"clientSecret": s.token.ClientSecret,
+			"grantType":    ssoDeviceCodeGrant,
+			"deviceCode":   auth.DeviceCode,
+		}, &tok)
+		if e, ok := err.(ssoError); ok && e.Code == "authorization_pending" {
+			continue
+		} else if ok && e.Code == "slow_down" {
+			interval += 5 * time.Second
+			continue
+		} else if err != nil {
+			return fmt.Errorf("AWS SSO sign in failed: %s", err)
+		}
+
+		s.token.AccessToken = tok.AccessToken
+		s.token.ExpiresAt = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+		s.save()
+		return nil
+	}
+	return errors.New("AWS SSO sign in was not confirmed in time")
+}
+
+// Roles returns the roles the user can use in every account assigned to
+// them, recording the accounts' names in AccountNames.
+func (s *SSO) Roles(ctx context.Context) ([]Role, error) {
+	if s.AccountNames == nil {
+		s.AccountNames = make(map[string]string)
+	}
+
+	var accounts []string
+	next := ""
+	for {
+		var page struct {
+			AccountList []struct {
+				AccountID   string `json:"accountId"`
+				AccountName string `json:"accountName"`
+			} `json:"accountList"`
+			NextToken string `json:"nextToken"`
+		}
+		q := url.Values{"max_result": {"100"}}
+		if next != "" {
+			q.Set("next_token", next)
+		}
+		if err := s.portal(ctx, "/assignment/accounts", q, &page); err != nil {
+			return nil, err
+		}
+		for _, a := range page.AccountList {
+			accounts = append(accounts, a.AccountID)
+			s.AccountNames[a.AccountID] = a.AccountName
+		}
+		if next = page.NextToken; next == "" {
+			break
+		}
+	}
+
+	var roles []Role
+	for _, id := range accounts {
+		next := ""
+		for {
+			var page struct {
+				RoleList []struct {
+					RoleName string `json:"roleName"`
+				} `json:"roleList"`
+				NextToken string `json:"nextToken"`
+			}
+			q := url.Values{"account_id": {id}, "max_result": {"100"}}
+			if next != "" {
+				q.Set("next_token", next)
+			}
+			if err := s.portal(ctx, "/assignment/roles", q, &page); err != nil {
+				return nil, err
+			}
+			for _, r := range page.RoleList {
+				roles = append(roles, Role(fmt.Sprintf("arn:%s:iam::%s:role/%s,", partition(s.Region), id, r.RoleName)))
+			}
+			if next = page.NextToken; next == "" {
+				break
+			}
+		}
+	}
+	return roles, nil
+}
+
+// Credentials returns credentials for one of the roles returned by Roles.
+func (s *SSO) Credentials(ctx context.Context, r Role) (Credentials, error) {
+	var resp struct {
+		RoleCredentials struct {
+			AccessKeyID     string `json:"accessKeyId"`
+			SecretAccessKey string `json:"secretAccessKey"`
+			SessionToken    string `json:"sessionToken"`
+			Expiration      int64  `json:"expiration"`
+		} `json:"roleCredentials"`
+	}
+	q := url.Values{"account_id": {r.AccountId()}, "role_name": {r.RoleName()}}
+	if err := s.portal(ctx, "/federation/credentials", q, &resp); err != nil {
+		return Credentials{}, fmt.Errorf("Unable to get role credentials: %s", err)
+	}
+
+	rc := resp.RoleCredentials
+	return Credentials{
+		AccessKeyId:     rc.AccessKeyID,
+		SecretAccessKey: rc.SecretAccessKey,
+		SessionToken:    rc.SessionToken,
+		Expiration:      time.Unix(0, rc.Expiration*int64(time.Millisecond)),
+	}, nil
+}
+
+// ssoError is an error response from SSO-OIDC or the SSO portal.
+type ssoError struct {
+	Status      int
+	Code        string
+	Description string
+}
+
+func (e ssoError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("%s: %s", e.Code, e.Description)
+	}
+	if e.Code != "" {
+		return e.Code
+	}
+	return fmt.Sprintf("HTTP %d", e.Status)
+}
+
+func (s *SSO) oidc(ctx context.Context, path string, in interface{}, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", "https://oidc."+s.Region+".amazonaws.com"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return s.do(ctx, req, out)
+}
+
+func (s *SSO) portal(ctx context.Context, path string, q url.Values, out interface{}) error {
+	req, err := http.NewRequest("GET", "https://portal.sso."+s.Region+".amazonaws.com"+path+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-amz-sso_bearer_token", s.token.AccessToken)
+
+	err = s.do(ctx, req, out)
+	if e, ok := err.(ssoError); ok && e.Status == http.StatusUnauthorized {
+		s.token.AccessToken = ""
+		s.save()
+		return ErrSSOSessionExpired
+	}
+	return err
+}
+
+func (s *SSO) do(ctx context.Context, req *http.Request, out interface{}) error {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+			Message     string `json:"message"`
+		}
+		json.Unmarshal(body, &e)
+		if e.Error == "" {
+			e.Error = resp.Header.Get("X-Amzn-ErrorType")
+		}
+		if e.Description == "" {
+			e.Description = e.Message
+		}
+		return ssoError{Status: resp.StatusCode, Code: e.Error, Description: e.Description}
+	}
+	return json.Unmarshal(body, out)
+}
+
+// load reads the cached token, ignoring one for another portal.
+func (s *SSO) load() {
+	if s.CachePath == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(s.CachePath)
+	if err != nil {
+		return
+	}
+	var tok ssoToken
+	if err := json.Unmarshal(data, &tok); err != nil || tok.StartURL != s.StartURL || tok.Region != s.Region {
+		return
+	}
+	s.token = tok
+}
+
+// save writes the token to CachePath.  The cache only saves signing in
+// again, so failures are ignored.
+func (s *SSO) save() {
+	if s.CachePath == "" {
+		return
+	}
+	s.token.StartURL, s.token.Region = s.StartURL, s.Region
+	data, err := json.MarshalIndent(s.token, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(s.CachePath), 0700); err != nil {
+		return
+	}
+	WriteFileAtomic(s.CachePath, data, 0600)
+}
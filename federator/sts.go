@@ -0,0 +1,188 @@
+package federator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// fipsRegions are the regions with a FIPS 140-2 validated STS endpoint.
+var fipsRegions = map[string]bool{
+	"us-east-1":     true,
+	"us-east-2":     true,
+	"us-west-1":     true,
+	"us-west-2":     true,
+	"us-gov-east-1": true,
+	"us-gov-west-1": true,
+}
+
+// STSConfig configures how a Federator calls STS.  The zero value uses the
+// us-east-1 endpoint with the SDK's default HTTP client and retries.
+type STSConfig struct {
+	// Region selects the regional STS endpoint, reducing latency and
+	// keeping working during a us-east-1 outage.
+	Region string
+
+	// Endpoint overrides the STS endpoint URL entirely.
+	Endpoint string
+
+	// FIPS uses the FIPS endpoint of Region.
+	FIPS bool
+
+	// HTTPClient is used to make requests, for example to tunnel them or use
+	// a particular proxy.
+	HTTPClient *http.Client
+
+	// Retryer decides whether and when failed requests are retried.  If it
+	// is nil, the SDK's standard retryer makes MaxRetries retries, or its
+	// default number if that is 0.
+	Retryer    aws.Retryer
+	MaxRetries int
+
+	// Debug, if set, receives the parameters of each AssumeRoleWithSAML
+	// request and the raw body of any error STS responds with.
+	Debug io.Writer
+}
+
+func (c STSConfig) region() string {
+	if c.Region == "" {
+		return "us-east-1"
+	}
+	return c.Region
+}
+
+// client returns an STS client configured by c, signing requests with
+// creds.  AssumeRoleWithSAML is not signed, so creds may be nil.  An
+// endpoint which can't be used is an error, rather than falling back to one
+// which may not be allowed, such as the non-FIPS endpoint.
+func (c STSConfig) client(creds aws.CredentialsProvider) (*sts.Client, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if creds == nil {
+		creds = aws.AnonymousCredentials{}
+	}
+
+	opts := sts.Options{
+		Region:      c.region(),
+		Credentials: creds,
+		Retryer:     c.Retryer,
+	}
+	if c.HTTPClient != nil {
+		opts.HTTPClient = c.HTTPClient
+	}
+	if opts.Retryer == nil {
+		opts.Retryer = retry.NewStandard(func(o *retry.StandardOptions) {
+			if c.MaxRetries > 0 {
+				o.MaxAttempts = c.MaxRetries + 1
+			}
+		})
+	}
+	if c.Endpoint != "" {
+		opts.BaseEndpoint = aws.String(c.Endpoint)
+	} else if c.FIPS {
+		opts.EndpointOptions.UseFIPSEndpoint = aws.FIPSEndpointStateEnabled
+	}
+
+	return sts.New(opts), nil
+}
+
+// Validate reports configuration that cannot be used, such as a FIPS
+// endpoint in a region without one.
+func (c STSConfig) Validate() error {
+	if c.FIPS && c.Endpoint == "" && !fipsRegions[c.region()] {
+		return fmt.Errorf("STS has no FIPS endpoint in %s", c.region())
+	}
+	return nil
+}
+
+// apiErrorCode returns the code of the error STS responded with, or "".
+func apiErrorCode(err error) (code, message string) {
+	var e smithy.APIError
+	if errors.As(err, &e) {
+		return e.ErrorCode(), e.ErrorMessage()
+	}
+	return "", ""
+}
+
+// debug returns an option printing the parameters of an AssumeRoleWithSAML
+// request to c.Debug, with the raw body of an error response before the SDK
+// parses it.
+func (c STSConfig) debug(in *sts.AssumeRoleWithSAMLInput, info assertionInfo) func(*sts.Options) {
+	return func(o *sts.Options) {
+		if c.Debug == nil {
+			return
+		}
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			// added after the deserializer, so it sees the response first
+			return stack.Deserialize.Add(stsDebug{c.Debug, in, info}, middleware.After)
+		})
+	}
+}
+
+// stsDebug is the middleware added by STSConfig.debug.
+type stsDebug struct {
+	w    io.Writer
+	in   *sts.AssumeRoleWithSAMLInput
+	info assertionInfo
+}
+
+func (stsDebug) ID() string { return "FederatorSTSDebug" }
+
+func (d stsDebug) HandleDeserialize(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (middleware.DeserializeOutput, middleware.Metadata, error) {
+	w := d.w
+
+	decoded := "invalid base64"
+	if raw, err := base64.StdEncoding.DecodeString(aws.ToString(d.in.SAMLAssertion)); err == nil {
+		decoded = fmt.Sprintf("%d bytes decoded", len(raw))
+	}
+	duration := "STS default"
+	if d.in.DurationSeconds != nil {
+		duration = fmt.Sprintf("%ds", *d.in.DurationSeconds)
+	}
+
+	fmt.Fprintf(w, "STS AssumeRoleWithSAML request:\n")
+	if req, ok := in.Request.(*smithyhttp.Request); ok {
+		fmt.Fprintf(w, "  Endpoint:        %s\n", req.URL)
+	}
+	fmt.Fprintf(w, "  PrincipalArn:    %s\n", aws.ToString(d.in.PrincipalArn))
+	fmt.Fprintf(w, "  RoleArn:         %s\n", aws.ToString(d.in.RoleArn))
+	fmt.Fprintf(w, "  DurationSeconds: %s\n", duration)
+	if d.in.Policy != nil {
+		fmt.Fprintf(w, "  Policy:          %d bytes\n", len(*d.in.Policy))
+	}
+	fmt.Fprintf(w, "  SAMLAssertion:   %d bytes base64, %s\n", len(aws.ToString(d.in.SAMLAssertion)), decoded)
+	if d.info.ID != "" {
+		fmt.Fprintf(w, "  Assertion ID:    %s\n", d.info.ID)
+	}
+	if !d.info.NotOnOrAfter.IsZero() {
+		fmt.Fprintf(w, "  NotOnOrAfter:    %s (%s from now)\n", d.info.NotOnOrAfter.Format(time.RFC3339), d.info.NotOnOrAfter.Sub(time.Now())/time.Second*time.Second)
+	}
+
+	out, metadata, err := next.HandleDeserialize(ctx, in)
+	resp, ok := out.RawResponse.(*smithyhttp.Response)
+	if err != nil || !ok || resp.StatusCode < 300 {
+		return out, metadata, err
+	}
+	body, readErr := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if readErr != nil {
+		fmt.Fprintf(w, "STS error response could not be read: %s\n", readErr)
+	}
+	fmt.Fprintf(w, "STS responded %s:\n%s\n", resp.Status, bytes.TrimSpace(body))
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return out, metadata, err
+}
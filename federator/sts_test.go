@@ -0,0 +1,218 @@
+package federator
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RobotsAndPencils/go-saml"
+)
+
+const stsTestRole = Role("arn:aws:iam::123456789012:role/Admin,arn:aws:iam::123456789012:saml-provider/idp")
+
+const stsCredentialsXML = `<Credentials>
+      <AccessKeyId>ASIAEXAMPLE</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>2030-01-02T03:04:05Z</Expiration>
+    </Credentials>`
+
+// fakeSTS answers AssumeRoleWithSAML and AssumeRole, recording the last
+// request, or fails every request with status and body.
+type fakeSTS struct {
+	status int
+	body   string
+
+	form          url.Values
+	authorization string
+}
+
+func (f *fakeSTS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	f.form, _ = url.ParseQuery(string(body))
+	f.authorization = r.Header.Get("Authorization")
+
+	w.Header().Set("Content-Type", "text/xml")
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		w.Write([]byte(f.body))
+		return
+	}
+	action := f.form.Get("Action")
+	w.Write([]byte(`<` + action + `Response xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><` + action + `Result>` + stsCredentialsXML + `</` + action + `Result></` + action + `Response>`))
+}
+
+func stsError(code, message string) string {
+	return `<ErrorResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><Error><Type>Sender</Type><Code>` + code + `</Code><Message>` + message + `</Message></Error><RequestId>1</RequestId></ErrorResponse>`
+}
+
+func stsTestFederator(endpoint string) *Federator {
+	return &Federator{
+		STS:            STSConfig{Endpoint: endpoint},
+		samlResponse:   &saml.Response{},
+		samlResponse64: base64.StdEncoding.EncodeToString([]byte(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="_r"></samlp:Response>`)),
+	}
+}
+
+func TestAssumeRoleWithSAML(t *testing.T) {
+	sts := &fakeSTS{}
+	srv := httptest.NewServer(sts)
+	defer srv.Close()
+
+	a := stsTestFederator(srv.URL)
+	a.SessionDuration = 2 * time.Hour
+	creds, err := a.AssumeRole(stsTestRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyId != "ASIAEXAMPLE" || creds.SecretAccessKey != "secret" || creds.SessionToken != "token" {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+	if !creds.Expiration.Equal(time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("expiration %s", creds.Expiration)
+	}
+
+	want := map[string]string{
+		"Action":          "AssumeRoleWithSAML",
+		"RoleArn":         stsTestRole.RoleArn(),
+		"PrincipalArn":    stsTestRole.PrincipalArn(),
+		"SAMLAssertion":   a.samlResponse64,
+		"DurationSeconds": "7200",
+	}
+	for k, v := range want {
+		if got := sts.form.Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+	if sts.authorization != "" {
+		t.Errorf("AssumeRoleWithSAML was signed: %s", sts.authorization)
+	}
+}
+
+func TestAssumeRoleWithSAMLErrors(t *testing.T) {
+	tests := []struct {
+		code, message string
+		check         func(error) bool
+	}{
+		{"ValidationError", "The requested DurationSeconds exceeds the MaxSessionDuration set for this role.", func(err error) bool {
+			_, ok := err.(*DurationError)
+			return ok
+		}},
+		{"ExpiredTokenException", "Token must be redeemed within 5 minutes of issuance", func(err error) bool {
+			_, ok := err.(*ExpiredAssertionError)
+			return ok
+		}},
+		{"AccessDenied", "Not authorized to perform sts:AssumeRoleWithSAML", func(err error) bool {
+			return strings.Contains(err.Error(), "Not authorized")
+		}},
+	}
+
+	for _, tt := range tests {
+		srv := httptest.NewServer(&fakeSTS{status: http.StatusBadRequest, body: stsError(tt.code, tt.message)})
+		a := stsTestFederator(srv.URL)
+		var debug bytes.Buffer
+		a.STS.Debug = &debug
+
+		_, err := a.AssumeRole(stsTestRole)
+		srv.Close()
+		if err == nil || !tt.check(err) {
+			t.Errorf("%s: unexpected error %v", tt.code, err)
+		}
+		if !strings.Contains(debug.String(), "<Code>"+tt.code+"</Code>") || !strings.Contains(debug.String(), "RoleArn:         "+stsTestRole.RoleArn()) {
+			t.Errorf("%s: debug output missing the request or response:\n%s", tt.code, debug.String())
+		}
+	}
+}
+
+func TestChainRole(t *testing.T) {
+	sts := &fakeSTS{}
+	srv := httptest.NewServer(sts)
+	defer srv.Close()
+
+	a := stsTestFederator(srv.URL)
+	creds, err := a.ChainRole(Credentials{AccessKeyId: "ASIAFIRST", SecretAccessKey: "s", SessionToken: "t"}, ChainInput{
+		RoleArn:     "arn:aws:iam::210987654321:role/Deploy",
+		SessionName: "alice",
+		ExternalID:  "ext",
+		Duration:    30 * time.Minute,
+		Tags:        map[string]string{"team": "ops", "env": "prod"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyId != "ASIAEXAMPLE" {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+
+	want := map[string]string{
+		"Action":              "AssumeRole",
+		"RoleArn":             "arn:aws:iam::210987654321:role/Deploy",
+		"RoleSessionName":     "alice",
+		"ExternalId":          "ext",
+		"DurationSeconds":     "1800",
+		"Tags.member.1.Key":   "env",
+		"Tags.member.1.Value": "prod",
+		"Tags.member.2.Key":   "team",
+		"Tags.member.2.Value": "ops",
+	}
+	for k, v := range want {
+		if got := sts.form.Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+	if !strings.Contains(sts.authorization, "Credential=ASIAFIRST/") {
+		t.Errorf("AssumeRole was not signed with the first credentials: %q", sts.authorization)
+	}
+}
+
+func TestSTSConfigValidate(t *testing.T) {
+	tests := []struct {
+		c  STSConfig
+		ok bool
+	}{
+		{STSConfig{}, true},
+		{STSConfig{FIPS: true}, true},
+		{STSConfig{FIPS: true, Region: "us-gov-west-1"}, true},
+		{STSConfig{FIPS: true, Region: "eu-west-1"}, false},
+		{STSConfig{FIPS: true, Region: "eu-west-1", Endpoint: "https://sts.example.com"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.c.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%+v: Validate() = %v", tt.c, err)
+		}
+		if _, err := tt.c.client(nil); (err == nil) != tt.ok {
+			t.Errorf("%+v: client() = %v", tt.c, err)
+		}
+	}
+}
+
+// brokenLedger records nothing, as when the state directory isn't writable.
+type brokenLedger struct{}
+
+func (brokenLedger) Consumed(id string) bool { return false }
+
+func (brokenLedger) Consume(id string, expires time.Time) error {
+	return errors.New("read-only file system")
+}
+
+func TestAssumeRoleLedgerFailure(t *testing.T) {
+	srv := httptest.NewServer(&fakeSTS{})
+	defer srv.Close()
+
+	a := stsTestFederator(srv.URL)
+	a.Ledger = brokenLedger{}
+	creds, err := a.AssumeRole(stsTestRole)
+	if err != nil {
+		t.Fatalf("credentials were discarded because the ledger failed: %s", err)
+	}
+	if creds.AccessKeyId != "ASIAEXAMPLE" {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+}
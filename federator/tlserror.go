@@ -0,0 +1,186 @@
+package federator
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// TLSError is returned for requests to the IdP whose certificate couldn't
+// be verified.  It describes the certificates the server presented and
+// suggests why they weren't trusted, as Go's x509 errors alone rarely make
+// a missing corporate proxy CA or an outdated trust store obvious.
+type TLSError struct {
+	Host string
+	Err  error
+
+	// Chain is the certificates the server presented, if they could be
+	// fetched again, otherwise just the one that failed verification.
+	Chain []*x509.Certificate
+
+	// Proxied is set when the request went through a proxy, which may be
+	// the one presenting the certificates.
+	Proxied bool
+}
+
+func (e *TLSError) Error() string {
+	lines := []string{e.Err.Error()}
+	if len(e.Chain) > 0 {
+		lines = append(lines, fmt.Sprintf("  %s presented:", e.Host))
+		for i, cert := range e.Chain {
+			lines = append(lines, fmt.Sprintf("    %d: %s, issued by %s, valid until %s", i, certName(cert.Subject), certName(cert.Issuer), cert.NotAfter.Format("2006-01-02")))
+		}
+	}
+	if hint := e.hint(); hint != "" {
+		lines = append(lines, "  "+hint)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *TLSError) hint() string {
+	now := time.Now()
+	switch err := e.Err.(type) {
+	case x509.UnknownAuthorityError:
+		issuer := "its issuer"
+		if len(e.Chain) > 0 {
+			issuer = "'" + certName(e.Chain[len(e.Chain)-1].Issuer) + "'"
+		}
+		store := "the system's trusted certificates"
+		if f := os.Getenv("SSL_CERT_FILE"); f != "" {
+			store = "SSL_CERT_FILE (" + f + ")"
+		}
+		hint := fmt.Sprintf("The certificates were issued by %s, which is not in %s.", issuer, store)
+		if e.Proxied {
+			hint += "  The request went through a proxy, which may be inspecting TLS traffic."
+		}
+		return hint + "  If " + issuer + " belongs to your organisation's TLS inspecting proxy or security software, add its root CA to " + store + "."
+	case x509.CertificateInvalidError:
+		if err.Reason != x509.Expired {
+			return ""
+		}
+		for _, cert := range e.Chain {
+			if now.After(cert.NotAfter) {
+				return fmt.Sprintf("The certificate for %s expired on %s.  If it is a CA certificate, the server's chain or your trust store may be out of date; updating your operating system's CA certificates can fix this.", certName(cert.Subject), cert.NotAfter.Format("2006-01-02"))
+			}
+			if now.Before(cert.NotBefore) {
+				return fmt.Sprintf("The certificate for %s isn't valid until %s.  Check that the system clock, which reads %s, is correct.", certName(cert.Subject), cert.NotBefore.Format("2006-01-02"), now.Format("2006-01-02 15:04"))
+			}
+		}
+		return fmt.Sprintf("Check that the system clock, which reads %s, is correct.", now.Format("2006-01-02 15:04"))
+	case x509.HostnameError:
+		return fmt.Sprintf("The certificate is for %s rather than %s.  If connections to it are redirected or proxied, they may be reaching the wrong server.", strings.Join(certNames(err.Certificate), ", "), err.Host)
+	}
+	return ""
+}
+
+func certName(n pkix.Name) string {
+	name := n.CommonName
+	if len(n.Organization) > 0 {
+		if name == "" {
+			return n.Organization[0]
+		}
+		name += " (" + n.Organization[0] + ")"
+	}
+	return name
+}
+
+func certNames(cert *x509.Certificate) []string {
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames
+	}
+	return []string{cert.Subject.CommonName}
+}
+
+// certificateError returns the x509 verification error in err, if any, and
+// the certificate it concerns.
+func certificateError(err error) (error, *x509.Certificate) {
+	for err != nil {
+		switch e := err.(type) {
+		case x509.UnknownAuthorityError:
+			return e, e.Cert
+		case x509.CertificateInvalidError:
+			return e, e.Cert
+		case x509.HostnameError:
+			return e, e.Certificate
+		}
+		u, ok := err.(interface {
+			Unwrap() error
+		})
+		if !ok {
+			return nil, nil
+		}
+		err = u.Unwrap()
+	}
+	return nil, nil
+}
+
+// tlsDiagnostics is installed by New to turn certificate verification
+// failures into TLSErrors.  It is given t, rather than the Federator, so
+// that it sees the dialer and proxy set on it later.
+func tlsDiagnostics(t *http.Transport) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := next.RoundTrip(req)
+			if err == nil {
+				return resp, nil
+			}
+			verr, cert := certificateError(err)
+			if verr == nil {
+				return resp, err
+			}
+
+			e := &TLSError{Host: req.URL.Host, Err: verr}
+			if t.Proxy != nil {
+				if u, _ := t.Proxy(req); u != nil {
+					e.Proxied = true
+				}
+			}
+			if !e.Proxied {
+				e.Chain = presentedChain(t, req)
+			}
+			if len(e.Chain) == 0 && cert != nil {
+				e.Chain = []*x509.Certificate{cert}
+			}
+			return resp, e
+		})
+	}
+}
+
+// presentedChain connects to the request's host again without verifying
+// its certificates, returning those it presents.
+func presentedChain(t *http.Transport, req *http.Request) []*x509.Certificate {
+	addr := req.URL.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "443")
+	}
+	dial := t.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 10 * time.Second}).Dial
+	}
+
+	conn, err := dial("tcp", addr)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	host, _, _ := net.SplitHostPort(addr)
+	tc := tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: true})
+	if err := tc.Handshake(); err != nil {
+		return nil
+	}
+	return tc.ConnectionState().PeerCertificates
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
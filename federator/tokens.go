@@ -0,0 +1,25 @@
+package federator
+
+import "regexp"
+
+// tokenPages recognise the pages of MFA adapters commonly added to ADFS
+// which ask for a one-time code, so that the user is told exactly which code
+// to enter.  More specific pages come first.
+var tokenPages = []struct {
+	pattern *regexp.Regexp
+	label   string
+}{
+	{regexp.MustCompile(`(?i)next\s*token\s*code|wait (until|for) the (token\s*code|code on your token) (to )?change`), "RSA SecurID next tokencode (wait for the code on your token to change)"},
+	{regexp.MustCompile(`(?i)securid`), "RSA SecurID passcode (your PIN followed by the tokencode)"},
+	{regexp.MustCompile(`(?i)symantec|vip access|vip credential|\bvip\s+(security\s+)?code`), "Symantec VIP security code"},
+}
+
+// otpLabel describes the one-time code asked for by page.
+func otpLabel(page []byte) string {
+	for _, p := range tokenPages {
+		if p.pattern.Match(page) {
+			return p.label
+		}
+	}
+	return "MFA code"
+}
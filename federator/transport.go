@@ -0,0 +1,149 @@
+package federator
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// CommandDialer connects through the stdin and stdout of a command, in the
+// manner of OpenSSH's ProxyCommand, for IdPs only reachable via a bastion:
+//
+//	CommandDialer{Command: "ssh -W %h:%p bastion"}
+//
+// %h and %p in Command are replaced with the host and port being dialed.
+type CommandDialer struct {
+	Command string
+}
+
+// Dial starts the command for addr and returns a connection over its stdio.
+func (d CommandDialer) Dial(network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := shellCommand(strings.NewReplacer("%h", host, "%p", port).Replace(d.Command))
+	cmd.Stderr = os.Stderr
+
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Could not start transport command: %s", err)
+	}
+
+	return &cmdConn{cmd: cmd, in: in, out: out, addr: cmdAddr(addr)}, nil
+}
+
+// SetDialer makes all connections to the IdP, or its proxy, through dial.
+func (a *Federator) SetDialer(dial func(network, addr string) (net.Conn, error)) {
+	a.transport.Dial = dial
+}
+
+// SetProxy sets the function choosing the proxy for each request to the IdP.
+// By default the proxy is taken from the environment; nil disables proxying.
+func (a *Federator) SetProxy(proxy func(*http.Request) (*url.URL, error)) {
+	a.transport.Proxy = proxy
+}
+
+// AliasDialer connects to the address given in Aliases for a host instead of
+// the one DNS resolves, for split-horizon DNS setups.  As only the dialed
+// address changes, TLS server names and Host headers are unaffected.
+type AliasDialer struct {
+	// Aliases maps host names to the host or IP address to dial.
+	Aliases map[string]string
+
+	// Next dials the resulting address.  If it is nil, net.Dial is used.
+	Next func(network, addr string) (net.Conn, error)
+}
+
+// Dial implements the dial function of an http.Transport.
+func (d AliasDialer) Dial(network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if alias, ok := d.Aliases[strings.ToLower(host)]; ok {
+		addr = net.JoinHostPort(alias, port)
+	}
+
+	if d.Next != nil {
+		return d.Next(network, addr)
+	}
+	return (&net.Dialer{Timeout: 30 * time.Second}).Dial(network, addr)
+}
+
+// ParseHostAliases parses a comma separated list of host=address pairs.
+func ParseHostAliases(s string) (map[string]string, error) {
+	aliases := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("Invalid host alias '%s', expected host=address", strings.TrimSpace(pair))
+		}
+		aliases[strings.ToLower(strings.TrimSpace(parts[0]))] = strings.TrimSpace(parts[1])
+	}
+	return aliases, nil
+}
+
+// HTTPClientWithDialer returns an HTTP client which makes its connections
+// through dial, such as for STSConfig.HTTPClient.
+func HTTPClientWithDialer(dial func(network, addr string) (net.Conn, error)) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Dial:                dial,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// cmdConn is a net.Conn over a command's stdin and stdout.  Deadlines are not
+// supported.
+type cmdConn struct {
+	cmd  *exec.Cmd
+	in   io.WriteCloser
+	out  io.Reader
+	addr cmdAddr
+}
+
+func (c *cmdConn) Read(b []byte) (int, error)  { return c.out.Read(b) }
+func (c *cmdConn) Write(b []byte) (int, error) { return c.in.Write(b) }
+
+func (c *cmdConn) Close() error {
+	c.in.Close()
+	if c.cmd.Process != nil {
+		c.cmd.Process.Kill()
+	}
+	c.cmd.Wait()
+	return nil
+}
+
+func (c *cmdConn) LocalAddr() net.Addr                { return c.addr }
+func (c *cmdConn) RemoteAddr() net.Addr               { return c.addr }
+func (c *cmdConn) SetDeadline(t time.Time) error      { return nil }
+func (c *cmdConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *cmdConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// cmdAddr is the address a cmdConn was dialed for.
+type cmdAddr string
+
+func (a cmdAddr) Network() string { return "cmd" }
+func (a cmdAddr) String() string  { return string(a) }
@@ -0,0 +1,136 @@
+package federator
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// WebAuthnRequest asks a security key to sign in to a relying party.  It is
+// written as JSON, with binary fields base64 encoded, to the STDIN of a
+// webauthn_command.
+type WebAuthnRequest struct {
+	APIVersion string `json:"apiVersion"`
+	// RPID is the relying party ID, usually the IdP's host name.
+	RPID string `json:"rpId"`
+	// ClientDataHash is the SHA-256 hash of the client data to sign.
+	ClientDataHash []byte `json:"clientDataHash"`
+	// CredentialIDs are the credentials registered with the IdP, any of
+	// which may be used.
+	CredentialIDs [][]byte `json:"credentialIds"`
+}
+
+// WebAuthnAssertion is the signed assertion returned by a security key.
+type WebAuthnAssertion struct {
+	CredentialID      []byte `json:"credentialId"`
+	AuthenticatorData []byte `json:"authenticatorData"`
+	Signature         []byte `json:"signature"`
+}
+
+// WebAuthnAuthenticator signs WebAuthn requests with a security key.
+type WebAuthnAuthenticator interface {
+	Assert(ctx context.Context, req WebAuthnRequest) (WebAuthnAssertion, error)
+}
+
+// TouchPrompter is implemented by MFAPrompters which can tell the user to
+// touch their security key.
+type TouchPrompter interface {
+	MFAPrompter
+	// TouchKey is called before waiting for the security key described by
+	// label to be touched.
+	TouchKey(label string)
+	// TouchDone is called when waiting ends, however it ended.
+	TouchDone(label string)
+}
+
+// CommandAuthenticator signs WebAuthn requests by running an external
+// program, for keys or platforms FIDO2Authenticator can't use.  The program
+// receives a WebAuthnRequest on STDIN and must write a WebAuthnAssertion to
+// STDOUT.  Its STDERR is the user's, so it may prompt there, for example for
+// the key's PIN.
+type CommandAuthenticator struct {
+	Command string
+}
+
+// Assert implements WebAuthnAuthenticator.
+func (a CommandAuthenticator) Assert(ctx context.Context, req WebAuthnRequest) (WebAuthnAssertion, error) {
+	var assertion WebAuthnAssertion
+
+	req.APIVersion = CommandAPIVersion
+	in, err := json.Marshal(req)
+	if err != nil {
+		return assertion, err
+	}
+
+	var out bytes.Buffer
+	cmd := shellCommand(a.Command)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return assertion, fmt.Errorf("Could not run webauthn_command: %s", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		<-done
+		return assertion, ctx.Err()
+	}
+	if err != nil {
+		return assertion, fmt.Errorf("webauthn_command failed: %s", err)
+	}
+
+	if err := json.Unmarshal(out.Bytes(), &assertion); err != nil {
+		return assertion, fmt.Errorf("webauthn_command returned an invalid assertion: %s", err)
+	}
+	if len(assertion.AuthenticatorData) == 0 || len(assertion.Signature) == 0 {
+		return assertion, fmt.Errorf("webauthn_command did not return a signed assertion")
+	}
+	return assertion, nil
+}
+
+// webAuthnSign builds the client data for a WebAuthn sign in to origin and
+// has auth sign it, telling the user to touch their key.  The client data
+// JSON is returned along with the assertion as IdPs verify both.
+func webAuthnSign(ctx context.Context, auth WebAuthnAuthenticator, mfa MFAPrompter, label, origin, rpID string, challenge string, credentialIDs [][]byte) ([]byte, WebAuthnAssertion, error) {
+	clientData, err := json.Marshal(struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+		Origin    string `json:"origin"`
+	}{"webauthn.get", challenge, origin})
+	if err != nil {
+		return nil, WebAuthnAssertion{}, err
+	}
+	hash := sha256.Sum256(clientData)
+
+	touch, ok := mfa.(TouchPrompter)
+	if !ok {
+		// codes may come from a command, but the key is still touched
+		touch = TerminalPrompter{}
+	}
+	touch.TouchKey(label)
+	defer touch.TouchDone(label)
+
+	assertion, err := auth.Assert(ctx, WebAuthnRequest{
+		RPID:           rpID,
+		ClientDataHash: hash[:],
+		CredentialIDs:  credentialIDs,
+	})
+	return clientData, assertion, err
+}
+
+// base64URL decodes s whether or not it is padded.
+func base64URL(s string) ([]byte, error) {
+	for len(s)%4 != 0 {
+		s += "="
+	}
+	return base64.URLEncoding.DecodeString(s)
+}
@@ -0,0 +1,252 @@
+package federator
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultRelyingParty is the identifier of the AWS relying party trust in
+// ADFS.
+const DefaultRelyingParty = "urn:amazon:webservices"
+
+// audience returns the entity ID assertions are requested for by providers
+// naming the relying party.
+func audience(cfg ProviderConfig) string {
+	if cfg.Audience != "" {
+		return cfg.Audience
+	}
+	return DefaultRelyingParty
+}
+
+// WSTrustProvider requests the SAML token straight from ADFS's WS-Trust 1.3
+// usernamemixed endpoint rather than scraping its login forms, which is more
+// reliable and needs no HTML.  ADFS doesn't apply additional (MFA)
+// authentication to WS-Trust requests, so the endpoint must be enabled and
+// allowed by the relying party's access policy.
+type WSTrustProvider struct {
+	// Endpoint is the usernamemixed endpoint, for example
+	// https://adfs.example.com/adfs/services/trust/13/usernamemixed
+	Endpoint string
+	// RelyingParty is the identifier of the AWS relying party trust.  If it
+	// is empty, DefaultRelyingParty is used.
+	RelyingParty string
+	// ACSURL is the Destination of the wrapped response.  If it is empty,
+	// AWSSignInURL is used.
+	ACSURL string
+
+	Username string
+	Password string
+	Client   *http.Client
+}
+
+func init() {
+	RegisterProvider("adfs-wstrust", func(cfg ProviderConfig) (Provider, error) {
+		endpoint := cfg.setting("adfs_wstrust_endpoint", "")
+		if endpoint == "" {
+			u, err := url.Parse(cfg.URL)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid sp_identity_url: %s", err)
+			}
+			endpoint = u.Scheme + "://" + u.Host + "/adfs/services/trust/13/usernamemixed"
+		}
+		return &WSTrustProvider{
+			Endpoint:     endpoint,
+			RelyingParty: cfg.setting("adfs_relying_party", audience(cfg)),
+			ACSURL:       cfg.ACSURL,
+			Username:     cfg.Username,
+			Password:     cfg.Password,
+			Client:       cfg.Client,
+		}, nil
+	})
+}
+
+const (
+	samlAssertionNS = "urn:oasis:names:tc:SAML:2.0:assertion"
+	// adfsInvalidCredentials is the ADFS event ID in the fault returned for
+	// a bad username or password.
+	adfsInvalidCredentials = "ID3242"
+)
+
+// Authenticate implements Provider.
+func (p *WSTrustProvider) Authenticate(ctx context.Context) (SAMLAssertion, error) {
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	rp := p.RelyingParty
+	if rp == "" {
+		rp = DefaultRelyingParty
+	}
+
+	req, err := http.NewRequest("POST", p.Endpoint, strings.NewReader(wsTrustRequest(p.Endpoint, rp, p.Username, p.Password, time.Now())))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/soap+xml; charset=utf-8")
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("Could not reach the ADFS WS-Trust endpoint: %s", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("Could not read ADFS WS-Trust response: %s", err)
+	}
+
+	if reason, ok := soapFault(body); ok {
+		if strings.Contains(reason, adfsInvalidCredentials) {
+			return "", ErrInvalidCredentials
+		}
+		return "", fmt.Errorf("ADFS refused the WS-Trust request: %s", reason)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("ADFS WS-Trust endpoint returned HTTP %s", resp.Status)
+	}
+
+	assertion, err := wsTrustAssertion(body)
+	if err != nil {
+		return "", err
+	}
+	response, err := wrapAssertion(assertion, p.ACSURL, time.Now())
+	if err != nil {
+		return "", err
+	}
+	return SAMLAssertion(base64.StdEncoding.EncodeToString(response)), nil
+}
+
+// wsTrustRequest is a WS-Trust 1.3 Issue request for a bearer SAML 2.0
+// token, authenticated with a username token.
+func wsTrustRequest(endpoint, rp, user, pass string, now time.Time) string {
+	esc := func(s string) string {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+
+	return `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://www.w3.org/2005/08/addressing" xmlns:u="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd">` +
+		`<s:Header>` +
+		`<a:Action s:mustUnderstand="1">http://docs.oasis-open.org/ws-sx/ws-trust/200512/RST/Issue</a:Action>` +
+		`<a:To s:mustUnderstand="1">` + esc(endpoint) + `</a:To>` +
+		`<o:Security s:mustUnderstand="1" xmlns:o="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd">` +
+		`<u:Timestamp u:Id="_0"><u:Created>` + now.UTC().Format(time.RFC3339) + `</u:Created><u:Expires>` + now.Add(5*time.Minute).UTC().Format(time.RFC3339) + `</u:Expires></u:Timestamp>` +
+		`<o:UsernameToken u:Id="` + xmlID() + `"><o:Username>` + esc(user) + `</o:Username>` +
+		`<o:Password Type="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordText">` + esc(pass) + `</o:Password></o:UsernameToken>` +
+		`</o:Security>` +
+		`</s:Header>` +
+		`<s:Body>` +
+		`<trust:RequestSecurityToken xmlns:trust="http://docs.oasis-open.org/ws-sx/ws-trust/200512">` +
+		`<wsp:AppliesTo xmlns:wsp="http://schemas.xmlsoap.org/ws/2004/09/policy"><a:EndpointReference><a:Address>` + esc(rp) + `</a:Address></a:EndpointReference></wsp:AppliesTo>` +
+		`<trust:KeyType>http://docs.oasis-open.org/ws-sx/ws-trust/200512/Bearer</trust:KeyType>` +
+		`<trust:RequestType>http://docs.oasis-open.org/ws-sx/ws-trust/200512/Issue</trust:RequestType>` +
+		`<trust:TokenType>` + samlAssertionNS + `</trust:TokenType>` +
+		`</trust:RequestSecurityToken>` +
+		`</s:Body>` +
+		`</s:Envelope>`
+}
+
+// soapFault returns the reason given by a SOAP 1.2 fault, including its
+// subcode, which ADFS uses for event IDs, or by a SOAP 1.1 fault.
+func soapFault(body []byte) (string, bool) {
+	var env struct {
+		Fault *struct {
+			Code struct {
+				Subcode struct {
+					Value string `xml:"Value"`
+				} `xml:"Subcode"`
+			} `xml:"Code"`
+			Reason string `xml:"Reason>Text"`
+			String string `xml:"faultstring"`
+		} `xml:"Body>Fault"`
+	}
+	if err := xml.Unmarshal(body, &env); err != nil || env.Fault == nil {
+		return "", false
+	}
+	reason := strings.TrimSpace(env.Fault.Reason)
+	if reason == "" {
+		reason = strings.TrimSpace(env.Fault.String)
+	}
+	if code := strings.TrimSpace(env.Fault.Code.Subcode.Value); code != "" {
+		reason += " (" + code + ")"
+	}
+	return reason, true
+}
+
+// wsTrustAssertion returns the SAML 2.0 assertion in a WS-Trust response
+// exactly as sent, so that its signature stays valid.
+func wsTrustAssertion(body []byte) ([]byte, error) {
+	assertion, err := rawElement(body, samlAssertionNS, "Assertion")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ADFS WS-Trust response: %s", err)
+	} else if assertion == nil {
+		return nil, fmt.Errorf("ADFS WS-Trust response did not contain a SAML 2.0 assertion")
+	}
+	return assertion, nil
+}
+
+// rawElement returns the bytes of the first element of doc with the given
+// name, or nil if there isn't one.
+func rawElement(doc []byte, space, local string) ([]byte, error) {
+	d := xml.NewDecoder(bytes.NewReader(doc))
+	for {
+		start := d.InputOffset()
+		t, err := d.Token()
+		if err == io.EOF {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if se, ok := t.(xml.StartElement); ok && se.Name.Local == local && se.Name.Space == space {
+			if err := d.Skip(); err != nil {
+				return nil, err
+			}
+			return doc[start:d.InputOffset()], nil
+		}
+	}
+}
+
+// wrapAssertion wraps a signed assertion in the SAML protocol response AWS
+// expects to be posted by the IdP to acs, or AWSSignInURL if it is empty.
+func wrapAssertion(assertion []byte, acs string, now time.Time) ([]byte, error) {
+	if acs == "" {
+		acs = AWSSignInURL
+	}
+
+	var a struct {
+		Issuer string `xml:"Issuer"`
+	}
+	if err := xml.Unmarshal(assertion, &a); err != nil {
+		return nil, fmt.Errorf("Invalid SAML assertion from ADFS: %s", err)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="%s" Version="2.0" IssueInstant="%s" Destination="`, xmlID(), now.UTC().Format(time.RFC3339))
+	xml.EscapeText(&b, []byte(acs))
+	b.WriteString(`">`)
+	b.WriteString(`<Issuer xmlns="` + samlAssertionNS + `">`)
+	xml.EscapeText(&b, []byte(strings.TrimSpace(a.Issuer)))
+	b.WriteString(`</Issuer>`)
+	b.WriteString(`<samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>`)
+	b.Write(assertion)
+	b.WriteString(`</samlp:Response>`)
+	return b.Bytes(), nil
+}
+
+// xmlID returns a random XML ID, which must not start with a digit.
+func xmlID() string {
+	id := make([]byte, 16)
+	io.ReadFull(rand.Reader, id)
+	return "_" + hex.EncodeToString(id)
+}
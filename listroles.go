@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+func init() {
+	commands["roles"] = listRoles
+}
+
+// roleInfo describes a role the user can assume, as printed by the roles
+// command.
+type roleInfo struct {
+	RoleArn     string `json:"role_arn"`
+	AccountID   string `json:"account_id"`
+	AccountName string `json:"account_name,omitempty"`
+}
+
+// listRoles logs in to the account and prints the roles available to the
+// user as JSON, without assuming any of them.
+func listRoles(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("roles takes no arguments")
+	}
+	acct, err := c.resolveAccount()
+	if err != nil {
+		return err
+	}
+
+	var roles []federator.Role
+	names := c.accountNames()
+	if ssoAccount(acct) {
+		var sso *federator.SSO
+		if sso, roles, err = ssoLogin(acct); err != nil {
+			return err
+		}
+		for id, name := range sso.AccountNames {
+			if _, ok := names[id]; !ok {
+				names[id] = name
+			}
+		}
+	} else {
+		fed, err := login(acct)
+		if err != nil {
+			return err
+		}
+		if roles, err = availableRoles(acct, &fed); err != nil {
+			return err
+		}
+	}
+
+	infos := make([]roleInfo, 0, len(roles))
+	for _, r := range roles {
+		infos = append(infos, roleInfo{RoleArn: r.RoleArn(), AccountID: r.AccountId(), AccountName: names[r.AccountId()]})
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(infos)
+}
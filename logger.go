@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+// verbosity is the level set by the -v, -vv and -vvv flags.
+type verbosity int
+
+const (
+	// levelInfo prints what the federator is doing.
+	levelInfo verbosity = iota + 1
+	// levelDebug also prints each request made to the IdP.
+	levelDebug
+	// levelTrace also prints the headers and bodies of those requests
+	// and their responses, and the STS requests.
+	levelTrace
+)
+
+// levelFlag is a boolean flag raising the verbosity to its level.
+type levelFlag struct {
+	v     *verbosity
+	level verbosity
+}
+
+func (f levelFlag) String() string {
+	if f.v == nil {
+		return "false"
+	}
+	return strconv.FormatBool(*f.v >= f.level)
+}
+
+func (f levelFlag) Set(s string) error {
+	on, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	if on && *f.v < f.level {
+		*f.v = f.level
+	}
+	return nil
+}
+
+func (f levelFlag) IsBoolFlag() bool { return true }
+
+// sensitiveHeaders match headers which are redacted from traced requests.
+var sensitiveHeaders = regexp.MustCompile(`(?im)^((?:Proxy-)?Authorization|Cookie|Set-Cookie):[^\r\n]*`)
+
+// logHTTP logs the federator's requests to the IdP at levelDebug, and dumps
+// them along with their responses at levelTrace.  Everything is scrubbed of
+// registered secrets first.
+func logHTTP(fed *federator.Federator, level verbosity) {
+	if level < levelDebug {
+		return
+	}
+
+	fed.Use(func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if level >= levelTrace {
+				if dump, err := httputil.DumpRequestOut(req, true); err == nil {
+					l.Printf("HTTP request:\n%s\n", traceScrub(dump))
+				}
+			}
+
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				l.Printf("HTTP %s %s failed: %s\n", req.Method, scrub(req.URL.String()), err)
+				return nil, err
+			}
+			l.Printf("HTTP %s %s: %s (%s)\n", req.Method, scrub(req.URL.String()), resp.Status, time.Since(start))
+
+			if level >= levelTrace {
+				if dump, err := httputil.DumpResponse(resp, true); err == nil {
+					l.Printf("HTTP response:\n%s\n", traceScrub(dump))
+				}
+			}
+			return resp, nil
+		})
+	})
+}
+
+func traceScrub(dump []byte) string {
+	return scrub(sensitiveHeaders.ReplaceAllString(string(dump), "$1: [REDACTED]"))
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"github.com/aidan-/aws-cli-federator/platform"
+	"github.com/howeyc/gopass"
+	"gopkg.in/ini.v1"
+)
+
+// mfaCodeEnv names the environment variable that can supply a one-time
+// code for scripted logins.
+const mfaCodeEnv = "AWS_FEDERATOR_MFA_CODE"
+
+// login collects the credentials configured for the account, prompting for
+// any that are missing, and authenticates with its IdP.
+func login(acct *ini.Section) (federator.Federator, error) {
+	if ssoAccount(acct) {
+		return federator.Federator{}, fmt.Errorf("Account '%s' is an AWS SSO account, which can't be used here", acct.Name())
+	}
+	if c.assertionGiven() {
+		return loginWithAssertion(acct)
+	}
+	if fed, ok := cachedAssertionLogin(acct); ok {
+		return fed, nil
+	}
+	if !acct.HasKey("sp_identity_url") {
+		return federator.Federator{}, fmt.Errorf("Account configuration '%s' does not have an 'sp_identity_url' defined", acct.Name())
+	}
+	spIdentityURL := acct.Key("sp_identity_url").String()
+
+	var clientCert *tls.Certificate
+	if acct.HasKey("client_cert") {
+		cert, err := loadClientCertificate(acct)
+		if err != nil {
+			return federator.Federator{}, err
+		}
+		clientCert = &cert
+	}
+
+	// idp_command plugins and the browser log in themselves, and are only
+	// given the username and password if they are configured
+	browser := *c.browser || acct.Key("idp_type").String() == "browser"
+	external := acct.HasKey("idp_command") || browser
+
+	//get username
+	user := ""
+	if acct.HasKey("username") {
+		user = acct.Key("username").String()
+	} else if clientCert != nil {
+		u, err := federator.UsernameFromCertificate(clientCert.Leaf)
+		if err != nil {
+			return federator.Federator{}, fmt.Errorf("Could not determine username from client certificate: %s", err)
+		}
+		l.Printf("Using username from client certificate: %s\n", u)
+		user = u
+	} else if !external {
+		reader := bufio.NewReader(os.Stdin)
+		fmt.Fprint(os.Stderr, "Enter Username: ")
+		u, _ := reader.ReadString('\n')
+		user = strings.TrimSpace(u)
+	}
+
+	//get password
+	pinentry := federator.PinentryPrompter{Program: acct.Key("pinentry_program").String()}
+	if pinentry.Program == "" {
+		pinentry.Program = defaultPinentry()
+	}
+	usePinentry := acct.Key("prompt").String() == "pinentry"
+
+	useKeychain, _ := acct.Key("keychain").Bool()
+	keychainAccount := user + "@" + spIdentityURL
+	storePassword := false
+
+	pass := ""
+	if acct.HasKey("password") {
+		pass = acct.Key("password").String()
+	} else if p := keychainPassword(useKeychain, keychainAccount); p != "" {
+		pass = p
+	} else if external {
+		// the plugin prompts for its own password
+	} else if usePinentry {
+		p, err := pinentry.Password(user)
+		if err != nil {
+			return federator.Federator{}, fmt.Errorf("Could not get password: %s", err)
+		}
+		pass = p
+		storePassword = useKeychain
+	} else {
+		fmt.Fprint(os.Stderr, "Enter Password: ")
+		p, err := gopass.GetPasswd()
+		if err != nil {
+			return federator.Federator{}, fmt.Errorf("Could not get password: %s", err)
+		}
+		pass = string(p)
+		storePassword = useKeychain
+	}
+
+	addSecret(pass, acct.Key("totp_secret").String(), acct.Key("onelogin_client_secret").String())
+
+	aws, err := federator.New(user, pass, spIdentityURL)
+	if err != nil {
+		return aws, fmt.Errorf("Failed to initialize federator: %s", err)
+	}
+
+	if clientCert != nil {
+		aws.SetClientCertificate(*clientCert)
+	}
+
+	if err := configureTransport(acct, &aws); err != nil {
+		return aws, err
+	}
+	if err := configureEndpoints(acct, &aws); err != nil {
+		return aws, err
+	}
+	if *c.debugSTS || c.verbose >= levelTrace {
+		aws.STS.Debug = os.Stderr
+	}
+
+	if acct.Key("ntlm").MustBool(false) {
+		aws.EnableNTLM()
+	}
+	if acct.Key("http_cache").MustBool(true) && !*c.export {
+		if dir, err := statePath("http-cache"); err != nil {
+			l.Printf("Not caching IdP responses: %s\n", err)
+		} else {
+			aws.SetHTTPCache(dir)
+		}
+	}
+	logHTTP(&aws, c.verbose)
+
+	saveCookies := func() {}
+	if !*c.noCookie && !*c.export {
+		if save, err := persistCookies(acct.Name(), &aws); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: IdP cookies will not be remembered: %s\n", err)
+		} else {
+			saveCookies = save
+		}
+	}
+
+	// fail rather than skip the ledger, as the state directory may belong
+	// to another user
+	ledger, err := statePath("assertions")
+	if err != nil {
+		return aws, err
+	}
+	aws.Ledger = federator.FileLedger{Path: ledger}
+	if *c.export {
+		aws.Ledger = readOnlyLedger{federator.FileLedger{Path: ledger}}
+	}
+
+	mfaCode := c.mfaCode
+	if mfaCode == "" {
+		mfaCode = os.Getenv(mfaCodeEnv)
+	}
+
+	interactive := false
+	switch {
+	case mfaCode != "":
+		aws.MFA = &federator.StaticPrompter{Code: mfaCode}
+	case acct.HasKey("totp_secret"):
+		aws.MFA = federator.TOTPPrompter{Secret: acct.Key("totp_secret").String()}
+	case acct.HasKey("mfa_command"):
+		aws.MFA = federator.CommandPrompter{Command: acct.Key("mfa_command").String()}
+	case usePinentry:
+		aws.MFA = pinentry
+		interactive = true
+	default:
+		aws.MFA = &terminalPrompter{plain: c.plainOutput()}
+		interactive = true
+	}
+	aws.Events = progressEvents(aws.MFA)
+	aws.DuoFactor = acct.Key("duo_factor").String()
+	aws.UsernameField = acct.Key("form_username_field").String()
+	aws.PasswordField = acct.Key("form_password_field").String()
+	if aws.ExtraFields, err = federator.ParseFormFields(acct.Key("form_extra_fields").String()); err != nil {
+		return aws, err
+	}
+
+	idpType := acct.Key("idp_type").String()
+	if browser {
+		idpType = "browser"
+	} else if idpType == "" && external {
+		idpType = "command"
+	}
+	switch backend := acct.Key("auth_backend").String(); backend {
+	case "", "http":
+	case "browser-headless":
+		idpType = backend
+	default:
+		return aws, fmt.Errorf("Unknown auth_backend '%s', expected http or browser-headless", backend)
+	}
+	aws.Provider, err = federator.NewProvider(idpType, federator.ProviderConfig{
+		Account:     acct.Name(),
+		URL:         spIdentityURL,
+		Username:    user,
+		Password:    pass,
+		MFA:         aws.Prompter(),
+		Client:      aws.Client(),
+		Interactive: interactive,
+		ACSURL:      aws.ACSURL,
+		Audience:    acct.Key("saml_audience").String(),
+		Setting: func(key string) string {
+			return acct.Key(key).String()
+		},
+	})
+	if err != nil {
+		return aws, err
+	}
+	if b, ok := aws.Provider.(*federator.BrowserProvider); ok {
+		b.Open = platform.Native().OpenBrowser
+	}
+
+	if err = aws.Login(); err != nil {
+		return aws, fmt.Errorf("Authentication failure: %s", err)
+	}
+	saveCookies()
+	cacheAssertion(acct, &aws)
+
+	if storePassword {
+		if err := platform.Native().KeychainSet(keychainService, keychainAccount, pass); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: Could not save password to keychain: %s\n", err)
+		}
+	}
+
+	return aws, nil
+}
+
+// loginWithAssertion uses the SAMLResponse given with -assertion-file or
+// -assertion-stdin instead of logging in to the account's IdP.
+func loginWithAssertion(acct *ini.Section) (federator.Federator, error) {
+	assertion, err := c.readAssertion()
+	if err != nil {
+		return federator.Federator{}, err
+	}
+	return assertionLogin(acct, assertion)
+}
+
+// assertionLogin returns a federator for acct using assertion, obtained
+// without logging in to the account's IdP.
+func assertionLogin(acct *ini.Section, assertion federator.SAMLAssertion) (federator.Federator, error) {
+	addSecret(string(assertion))
+
+	sp := acct.Key("sp_identity_url").MustString("https://signin.aws.amazon.com/saml")
+	aws, err := federator.New("", "", sp)
+	if err != nil {
+		return aws, fmt.Errorf("Failed to initialize federator: %s", err)
+	}
+	if err := configureTransport(acct, &aws); err != nil {
+		return aws, err
+	}
+	if err := configureEndpoints(acct, &aws); err != nil {
+		return aws, err
+	}
+	if *c.debugSTS || c.verbose >= levelTrace {
+		aws.STS.Debug = os.Stderr
+	}
+
+	ledger, err := statePath("assertions")
+	if err != nil {
+		return aws, err
+	}
+	aws.Ledger = federator.FileLedger{Path: ledger}
+
+	aws.Provider = federator.AssertionProvider{Assertion: assertion}
+	if err := aws.Login(); err != nil {
+		return aws, fmt.Errorf("Could not use the SAML assertion: %s", err)
+	}
+	return aws, nil
+}
+
+// assertionGiven reports whether a SAMLResponse was given on the command
+// line.
+func (c configuration) assertionGiven() bool {
+	return c.assertionFile != "" || *c.assertionStdin
+}
+
+// readAssertion reads the SAMLResponse given with -assertion-file or
+// -assertion-stdin.
+func (c configuration) readAssertion() (federator.SAMLAssertion, error) {
+	if c.assertionFile != "" && *c.assertionStdin {
+		return "", fmt.Errorf("Only one of -assertion-file and -assertion-stdin can be given")
+	}
+
+	var data []byte
+	var err error
+	if *c.assertionStdin {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(c.assertionFile)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Could not read the SAML assertion: %s", err)
+	}
+	return federator.ParseAssertion(data)
+}
+
+// loadClientCertificate loads the account's client_cert, which may be PEM
+// files or a PKCS#12 file.  The password of a PKCS#12 file is taken from
+// client_cert_password, or asked for if the file needs one.
+func loadClientCertificate(acct *ini.Section) (tls.Certificate, error) {
+	file := acct.Key("client_cert").String()
+	if ext := strings.ToLower(filepath.Ext(file)); ext != ".p12" && ext != ".pfx" {
+		return federator.LoadClientCertificate(file, acct.Key("client_key").String())
+	}
+
+	password := acct.Key("client_cert_password").String()
+	addSecret(password)
+	cert, err := federator.LoadPKCS12Certificate(file, password)
+	if err != federator.ErrCertificatePassword || acct.HasKey("client_cert_password") {
+		return cert, err
+	}
+
+	fmt.Fprintf(os.Stderr, "Enter password for %s: ", file)
+	p, err := gopass.GetPasswd()
+	if err != nil {
+		return cert, fmt.Errorf("Could not get password: %s", err)
+	}
+	addSecret(string(p))
+	return federator.LoadPKCS12Certificate(file, string(p))
+}
+
+// configureTransport applies the account's settings for how connections to
+// the IdP, and optionally STS, are made.
+func configureTransport(acct *ini.Section, fed *federator.Federator) error {
+	var dial, stsDial func(network, addr string) (net.Conn, error)
+
+	if acct.HasKey("proxy") {
+		proxy, err := accountProxy(acct)
+		if err != nil {
+			return err
+		}
+		fed.SetProxy(proxy)
+		fed.STS.HTTPClient = stsClientWithProxy(proxy)
+	}
+
+	if cmd := acct.Key("transport_cmd").String(); cmd != "" {
+		dial = federator.CommandDialer{Command: cmd}.Dial
+		fed.SetProxy(nil)
+		if acct.Key("transport_cmd_sts").MustBool(false) {
+			stsDial = dial
+		}
+	}
+
+	if acct.HasKey("host_aliases") {
+		aliases, err := federator.ParseHostAliases(acct.Key("host_aliases").String())
+		if err != nil {
+			return err
+		}
+		dial = federator.AliasDialer{Aliases: aliases, Next: dial}.Dial
+		if stsDial != nil {
+			stsDial = federator.AliasDialer{Aliases: aliases, Next: stsDial}.Dial
+		}
+	}
+
+	if dial != nil {
+		fed.SetDialer(dial)
+	}
+	if stsDial != nil {
+		fed.STS.HTTPClient = federator.HTTPClientWithDialer(stsDial)
+	}
+	return nil
+}
+
+// availableRoles returns the roles in the SAML assertion which the account's
+// role_pattern and role_lookup settings allow.
+func availableRoles(acct *ini.Section, fed *federator.Federator) ([]federator.Role, error) {
+	roles, err := fed.GetRoles()
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve roles: %s", err)
+	}
+
+	if acct.HasKey("role_pattern") {
+		pattern := acct.Key("role_pattern").String()
+		if roles = filterRoles(roles, pattern); len(roles) == 0 {
+			return nil, fmt.Errorf("No roles match the pattern '%s'", pattern)
+		}
+	}
+
+	if acct.HasKey("role_lookup") {
+		if roles, err = lookupRoles(acct, fed, roles); err != nil {
+			return nil, fmt.Errorf("Could not look up assigned roles: %s", err)
+		} else if len(roles) == 0 {
+			return nil, fmt.Errorf("None of your roles are assigned to you by '%s'", acct.Key("role_lookup").String())
+		}
+	}
+
+	return roles, nil
+}
+
+const keychainService = "aws-cli-federator"
+
+// keychainPassword returns the password stored in the system keychain for
+// account, or an empty string if the keychain is disabled or holds nothing.
+func keychainPassword(enabled bool, account string) string {
+	if !enabled {
+		return ""
+	}
+
+	p, err := platform.Native().KeychainGet(keychainService, account)
+	if err != nil {
+		if err != platform.ErrNotFound {
+			fmt.Fprintf(os.Stderr, "WARNING: Could not read password from keychain: %s\n", err)
+		}
+		return ""
+	}
+
+	l.Printf("Using password stored in the system keychain\n")
+	return p
+}
+
+// defaultPinentry prefers the native macOS dialog when it is installed.
+func defaultPinentry() string {
+	if runtime.GOOS == "darwin" {
+		if p, err := platform.LookPath("pinentry-mac"); err == nil {
+			return p
+		}
+	}
+	if p, err := platform.LookPath("pinentry"); err == nil {
+		return p
+	}
+	return "pinentry"
+}
+
+// readOnlyLedger refuses assertions already recorded in a ledger without
+// recording new ones, for -export, which writes nothing to disk.
+type readOnlyLedger struct {
+	federator.FileLedger
+}
+
+func (readOnlyLedger) Consume(id string, expires time.Time) error {
+	return nil
+}
@@ -1,30 +1,59 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/user"
 	"path/filepath"
-	"runtime"
 	"strings"
+	"time"
 
 	"github.com/aidan-/aws-cli-federator/federator"
-	"github.com/howeyc/gopass"
+	"github.com/aidan-/aws-cli-federator/picker"
+	"github.com/aidan-/aws-cli-federator/platform"
 	"gopkg.in/ini.v1"
 )
 
 type configuration struct {
-	version *bool
-	verbose *bool
-	path    string
-	cfg     *ini.File
-
-	account string
-	profile string
+	version  *bool
+	verbose  verbosity
+	explain  *bool
+	debugSTS *bool
+	noCookie *bool
+	browser  *bool
+	printARN *bool
+	noDaemon *bool
+	force    *bool
+	plain    *bool
+	refresh  *bool
+	export   *bool
+	path     string
+	cfg      *ini.File
+
+	account           string
+	role              string
+	positionalAccount string
+	accountSource     string
+	profile           string
+	output            string
+	mfaCode           string
+	duration          time.Duration
+	sessionPolicy     string
+	endpointURL       string
+	metricsTextfile   string
+	credsFD           int
+	credsFIFO         string
+	assertionFile     string
+	assertionStdin    *bool
+	tags              tagList
+	canI              canIList
+
+	timeFormat string
+	shell      string
 }
 
 var Version = "1.0.0"
@@ -34,12 +63,39 @@ var l *log.Logger
 
 func init() {
 	c.version = flag.Bool("version", false, "prints cli version information")
-	c.verbose = flag.Bool("v", false, "print debug messages to STDOUT")
+	flag.Var(levelFlag{&c.verbose, levelInfo}, "v", "print what is being done to STDERR")
+	flag.Var(levelFlag{&c.verbose, levelDebug}, "vv", "also print each request made to the IdP")
+	flag.Var(levelFlag{&c.verbose, levelTrace}, "vvv", "also print the headers and bodies of IdP requests and responses, and the STS requests")
+	c.explain = flag.Bool("explain", false, "print how the account was chosen and exit")
+	c.noCookie = flag.Bool("no-cookie", false, "don't reuse or remember the IdP's session and device cookies")
+	c.browser = flag.Bool("browser", false, "sign in through your web browser, capturing the SAMLResponse on a local listener")
+	c.printARN = flag.Bool("print-arn", false, "print the ARN of the assumed role to STDOUT")
+	c.assertionStdin = flag.Bool("assertion-stdin", false, "read a base64 SAMLResponse from STDIN instead of logging in to the IdP")
+	c.plain = flag.Bool("plain", false, "print progress and prompts as plain lines, without spinners or redrawing, for screen readers and dumb terminals")
+	c.export = flag.Bool("export", false, "print the temporary credentials as commands setting environment variables, for eval, without writing credentials, IdP cookies, caches or the selected role to disk")
+	c.force = flag.Bool("force", false, "log in even when cached credentials or those in the profile are still valid")
+	c.refresh = flag.Bool("refresh", false, "with daemon, keep the profiles of configured accounts refreshed before their credentials expire")
+	c.noDaemon = flag.Bool("no-daemon", false, "log in here even when a daemon is running, rather than asking it to refresh the profile")
+	c.debugSTS = flag.Bool("debug-sts", false, "print the STS AssumeRoleWithSAML request parameters and raw error responses to STDERR")
 
 	flag.StringVar(&c.path, "path", "", "set path to aws-federator configuration")
 	flag.StringVar(&c.account, "account", "", "set which AWS account configuration should be used")
 	flag.StringVar(&c.account, "acct", "", "set which AWS account configuration should be used (shorthand)")
+	flag.StringVar(&c.role, "role", "", "set the name or ARN of the role to assume, overriding 'assume_role'")
+	flag.Var(&c.tags, "tag", "limit list and batch to accounts with this tag (repeatable or comma separated)")
+	flag.Var(&c.canI, "can-i", "after assuming the role, check that its policies allow an action, optionally on a resource ARN, such as 's3:PutObject arn:aws:s3:::bucket/*' (repeatable)")
 	flag.StringVar(&c.profile, "profile", "", "set which AWS credential profile the temporary credentials should be written to. Defaults to 'default'")
+	flag.StringVar(&c.output, "output", "", fmt.Sprintf("print the temporary credentials to STDOUT in the given format %v. Defaults to 'env' when no profile is written", outputFormatNames()))
+	flag.IntVar(&c.credsFD, "creds-fd", -1, "write the temporary credentials as JSON to this open file descriptor")
+	flag.StringVar(&c.credsFIFO, "creds-fifo", "", "write the temporary credentials as JSON to this named pipe")
+	flag.StringVar(&c.assertionFile, "assertion-file", "", "read a base64 SAMLResponse from this file instead of logging in to the IdP")
+	flag.DurationVar(&c.duration, "duration", 0, "request credentials lasting this long, such as 4h, overriding 'session_duration'")
+	flag.StringVar(&c.endpointURL, "endpoint-url", "", "send AWS requests to this endpoint, such as LocalStack or moto, overriding 'endpoint_url' and 'sts_endpoint'")
+	flag.StringVar(&c.sessionPolicy, "session-policy", "", "limit the credentials with the IAM policy in this JSON file, overriding 'session_policy'")
+	flag.StringVar(&c.metricsTextfile, "metrics-textfile", "", "with status, also write its gauges to this file in the Prometheus text format, for node_exporter's textfile collector")
+	flag.StringVar(&c.mfaCode, "mfa-code", "", "use this one-time code when the IdP asks for MFA. Defaults to $"+mfaCodeEnv)
+	flag.StringVar(&c.shell, "shell", "", fmt.Sprintf("set the shell the env and terraform-env outputs are for %v. Defaults to cmd on Windows and $SHELL elsewhere", shellNames()))
+	flag.StringVar(&c.timeFormat, "time-format", "default", fmt.Sprintf("set how expiry times are displayed %v", timeFormatNames()))
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", filepath.Base(os.Args[0]))
@@ -48,44 +104,8 @@ func init() {
 	}
 }
 
-func (c *configuration) loadConfigurationFile() error {
-	if c.path == "" {
-		usr, err := user.Current()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: Unable to get current user information: %s\n", err)
-			os.Exit(1)
-		}
-
-		l.Printf("Found user's homedirectory: %s\n", usr.HomeDir)
-		c.path = filepath.Join(usr.HomeDir, ".aws/federatedcli")
-	}
-
-	l.Printf("Loading configuration from file: %s\n", c.path)
-	cfg, err := ini.Load(c.path)
-	if err != nil {
-		return err
-	}
-	cfg.BlockMode = false
-	c.cfg = cfg
-
-	return nil
-}
-
-// findAccount looks through the loaded configuration file to locate a
-//   matching account declaration with the account name loaded from the CLI.
-// It returns the configuration block if there is a match and false if there
-//   is not.
-func (c configuration) matchAccount() (*ini.Section, bool) {
-	for _, acct := range c.cfg.Sections() {
-		if acct.Name() == c.account {
-			return acct, true
-		}
-	}
-
-	return &ini.Section{}, false
-}
-
 func main() {
+	defer handlePanic()
 	flag.Parse()
 
 	if *c.version {
@@ -94,76 +114,183 @@ func main() {
 	}
 
 	l = log.New(ioutil.Discard, "", log.LstdFlags)
-	if *c.verbose {
+	if c.verbose >= levelInfo {
 		l.SetOutput(os.Stderr)
 	}
 
-	if c.account == "" {
-		c.account = "default"
+	if flag.NArg() > 0 {
+		if _, ok := commands[flag.Arg(0)]; ok {
+			runCommand(flag.Args())
+		}
+
+		// an argument which isn't a command names the account
+		c.positionalAccount = flag.Arg(0)
+		if err := flag.CommandLine.Parse(flag.Args()[1:]); err != nil {
+			os.Exit(1)
+		}
+		if flag.NArg() > 0 {
+			fmt.Fprintf(os.Stderr, "ERROR: Unexpected arguments %v\n", flag.Args())
+			os.Exit(1)
+		}
 	}
 
-	if err := c.loadConfigurationFile(); err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: Unable to parse configuration file: %s\n", err)
+	if _, ok := timeFormats[c.timeFormat]; !ok {
+		fmt.Fprintf(os.Stderr, "ERROR: Unknown time format '%s'\n", c.timeFormat)
 		os.Exit(1)
 	}
 
-	acct, found := c.matchAccount()
-	if !found {
-		fmt.Fprintf(os.Stderr, "ERROR: Could not find configuration matching provided account name '%s'\n", c.account)
-		os.Exit(1)
+	if c.shell != "" {
+		if _, ok := shells[c.shell]; !ok {
+			fmt.Fprintf(os.Stderr, "ERROR: Unknown shell '%s'\n", c.shell)
+			os.Exit(1)
+		}
 	}
 
-	if !acct.HasKey("sp_identity_url") {
-		fmt.Fprintf(os.Stderr, "ERROR: Account configuration '%s' does not have an 'sp_identity_url' defined\n", c.account)
+	if *c.export {
+		if c.profile != "" || c.sendingCredentials() {
+			fmt.Fprintf(os.Stderr, "ERROR: -export prints the credentials without writing or sending them anywhere, so can't be used with -profile, -creds-fd or -creds-fifo\n")
+			os.Exit(1)
+		}
+		if c.output != "" && c.output != "env" {
+			fmt.Fprintf(os.Stderr, "ERROR: -export prints the credentials in the env format, not '%s'\n", c.output)
+			os.Exit(1)
+		}
+		c.output = "env"
+	}
+
+	output, ok := outputFormats[c.output]
+	if c.output == "" {
+		output = printEnv
+	} else if !ok {
+		fmt.Fprintf(os.Stderr, "ERROR: Unknown output format '%s'\n", c.output)
 		os.Exit(1)
 	}
-	spIdentityURL := acct.Key("sp_identity_url").String()
 
-	//get username
-	user := ""
-	if acct.HasKey("username") {
-		user = acct.Key("username").String()
-	} else {
-		reader := bufio.NewReader(os.Stdin)
-		fmt.Fprint(os.Stderr, "Enter Username: ")
-		u, _ := reader.ReadString('\n')
-		user = strings.TrimSpace(u)
+	acct, err := c.resolveAccount()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	if *c.explain {
+		os.Exit(0)
+	}
+	duration, err := sessionDuration(acct)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	if duration != 0 && ssoAccount(acct) {
+		fmt.Fprintf(os.Stderr, "WARNING: AWS SSO credentials last as long as the permission set's session duration, ignoring the requested %s\n", duration)
+	}
+	policy, err := sessionPolicy(acct)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	if policy != "" && ssoAccount(acct) {
+		fmt.Fprintf(os.Stderr, "WARNING: AWS SSO credentials can't be limited by a session policy, ignoring it\n")
 	}
 
-	//get password
-	pass := ""
-	if acct.HasKey("password") {
-		pass = acct.Key("password").String()
-	} else {
-		fmt.Fprint(os.Stderr, "Enter Password: ")
-		var err error
-		p, err := gopass.GetPasswd()
+	if c.output == "credential_process" && c.profile != "" && !*c.force {
+		creds, ok, err := cachedProcessCredentials(acct, c.profile)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: Could not get password: %s\n", err)
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
 			os.Exit(1)
 		}
-		pass = string(p)
-		//pass = strings.TrimSpace(p)
+		if ok {
+			if err := output(os.Stdout, outputCredentials{Credentials: creds, Region: acct.Key("region").String()}); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: Failed to output credentials: %s\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
 	}
 
-	aws, err := federator.New(user, pass, spIdentityURL)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: Failed to initialize federator: %s\n", err)
-		os.Exit(1)
+	choice, stored, storedRole := offerReuse(c.profile)
+	if choice == reuseNone {
+		if creds, role, ok := cachedRole(acct, policy, duration); ok {
+			fmt.Fprintf(os.Stderr, "Using cached credentials for %s, run with -force to log in again.\n", role.RoleArn())
+			deliverCredentials(acct, output, role, creds, ssoAccount(acct))
+			os.Exit(0)
+		}
 	}
-
-	if err = aws.Login(); err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: Authentication failure: %s\n", err)
-		os.Exit(1)
+	if choice == reuseNone || choice == reuseRefresh {
+		role := c.role
+		if choice == reuseRefresh {
+			role = federatedRole(acct, storedRole)
+		}
+		if creds, arn, ok := c.refreshWithDaemon(acct, role); ok {
+			choice, stored, storedRole = reuseKeep, creds, arn
+		}
+	}
+	switch choice {
+	case reuseKeep:
+		if c.sendingCredentials() {
+			if err := c.sendCredentials(outputCredentials{Credentials: stored, Region: acct.Key("region").String()}); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: Failed to send credentials: %s\n", err)
+				os.Exit(1)
+			}
+		}
+		if c.output != "" {
+			out := outputCredentials{Credentials: stored, Region: acct.Key("region").String()}
+			if err := output(os.Stdout, out); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: Failed to output credentials: %s\n", err)
+				os.Exit(1)
+			}
+		}
+		if *c.printARN && storedRole != "" {
+			fmt.Println(storedRole)
+		}
+		requirePermissions(acct, federator.Role(storedRole+","), stored, ssoAccount(acct))
+		os.Exit(0)
+	case reuseRefresh:
+		c.role = federatedRole(acct, storedRole)
+	case reuseChoose:
+		c.role = ""
 	}
 
-	roles, err := aws.GetRoles()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: Could not retrieve roles: %s\n", err)
+	var aws federator.Federator
+	var sso *federator.SSO
+	var roles []federator.Role
+	names := c.accountNames()
+	if ssoAccount(acct) {
+		sso, roles, err = ssoLogin(acct)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		for id, name := range sso.AccountNames {
+			if _, ok := names[id]; !ok {
+				names[id] = name
+			}
+		}
+	} else {
+		aws, err = login(acct)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(1)
+		}
+
+		roles, err = availableRoles(acct, &aws)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(1)
+		}
 	}
 
 	var roleToAssume federator.Role
-	if acct.HasKey("assume_role") {
+	if c.role != "" {
+		for _, r := range roles {
+			if roleMatches(r, c.role) {
+				roleToAssume = r
+				break
+			}
+		}
+		if roleToAssume == "" {
+			fmt.Fprintf(os.Stderr, "ERROR: Unable to find role '%s' in the roles available to you\n", c.role)
+			os.Exit(1)
+		}
+	} else if acct.HasKey("assume_role") && choice != reuseChoose {
 		for _, r := range roles {
 			if acct.Key("assume_role").String() == string(r) {
 				roleToAssume = r
@@ -176,119 +303,127 @@ func main() {
 			os.Exit(1)
 		}
 	} else {
-		if len(roles) == 1 {
-			roleToAssume = roles[0]
-		} else {
-			accountMap, err := c.cfg.GetSection("account_map")
-			if err == nil {
-				for n, role := range roles {
-					if accountMap.HasKey(role.AccountId()) {
-						an := accountMap.Key(role.AccountId()).String()
-						fmt.Fprintf(os.Stderr, "%d) %s:role/%s\n", n+1, an, role.RoleName())
-					} else {
-						fmt.Fprintf(os.Stderr, "%d) %s\n", n+1, role.RoleArn())
-					}
-				}
-			} else {
-				for n, role := range roles {
-					fmt.Fprintf(os.Stderr, "%d) %s\n", n+1, role.RoleArn())
-				}
-			}
-			var i int
-
-			fmt.Fprintf(os.Stderr, "Enter the ID# of the role you want to assume: ")
-
-			_, err = fmt.Scanf("%d", &i)
+		candidates := roles
+		if acct.HasKey("role_policy") && choice != reuseChoose {
+			policy, err := parseRolePolicy(acct.Key("role_policy").String())
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "ERROR: Invalid selection made.\n")
+				fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
 				os.Exit(1)
 			}
+			if chosen, ok := applyRolePolicy(policy, roles, names, c.awsAccountTags()); ok {
+				candidates = chosen
+			}
+		}
 
-			if i > len(roles)+1 {
-				fmt.Fprintf(os.Stderr, "ERROR: Invalid ID selection, but in range from %d to %d.\n", 1, len(roles)+1)
-				os.Exit(1)
+		memKey := roleMemoryKey(c.account, acct.Key("remember_role").String())
+
+		opts := picker.Options{
+			AccountNames: names,
+			Default:      recalledRole(memKey),
+			Copy:         platform.Native().CopyToClipboard,
+		}
+		// STDIN held the assertion, so the choice is read from the terminal
+		if *c.assertionStdin {
+			if tty, err := os.Open("/dev/tty"); err == nil {
+				defer tty.Close()
+				opts.In = tty
 			}
+		}
+
+		r, err := picker.Select(candidates, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		roleToAssume = r
 
-			roleToAssume = roles[i-1]
+		if memKey != "" && len(candidates) > 1 && !*c.export {
+			if err := rememberRole(memKey, roleToAssume); err != nil {
+				l.Printf("Unable to remember selected role: %s\n", err)
+			}
 		}
 	}
 
 	l.Printf("User has selected ARN: %s\n", roleToAssume)
-	l.Printf("Attempting to AssumeRoleWithSAML\n")
-	creds, err := aws.AssumeRole(roleToAssume)
+	var creds federator.Credentials
+	if sso != nil {
+		l.Printf("Getting AWS SSO role credentials\n")
+		creds, err = sso.Credentials(context.Background(), roleToAssume)
+	} else {
+		l.Printf("Attempting to AssumeRoleWithSAML\n")
+		aws.SessionDuration = duration
+		aws.SessionPolicy = policy
+		creds, err = assumeRoleRelogin(acct, &aws, roleToAssume)
+	}
+	addSecret(creds.AccessKeyId, creds.SecretAccessKey, creds.SessionToken)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: Failed to assume role: %s", err)
 		os.Exit(1)
 	}
+	federated := roleToAssume
+	if acct.HasKey("chain_role_arn") {
+		creds, roleToAssume, err = chainRole(acct, &aws, creds, duration)
+		addSecret(creds.AccessKeyId, creds.SecretAccessKey, creds.SessionToken)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if *c.export {
+		l.Printf("Not caching credentials, as -export writes nothing to disk\n")
+	} else if c.endpointURL != "" {
+		l.Printf("Not caching credentials from %s\n", c.endpointURL)
+	} else if err := cacheCredentials(acct, federated, roleToAssume, policy, duration, creds); err != nil {
+		l.Printf("Unable to cache credentials: %s\n", err)
+	}
+	notifyWebhook(acct, &aws, roleToAssume, creds)
+
+	deliverCredentials(acct, output, roleToAssume, creds, sso != nil)
+}
 
+// deliverCredentials writes creds for roleToAssume to the profile, sends or
+// prints them with output as requested and runs the -can-i checks.
+func deliverCredentials(acct *ini.Section, output func(io.Writer, outputCredentials) error, roleToAssume federator.Role, creds federator.Credentials, sso bool) {
 	fmt.Fprintln(os.Stderr, "-------------------------------------------------------")
-	// output temporary credentials to stdout instead of writing to credentials file
-	if c.profile == "" {
-		fmt.Fprintf(os.Stderr, "Temporary credentials successfully generated. Set the following environment variables to being using them:\n\n")
-		if runtime.GOOS == "windows" {
-			fmt.Printf("set AWS_ACCESS_KEY_ID=%s\n", creds.AccessKeyId)
-			fmt.Printf("set AWS_SECRET_ACCESS_KEY=%s\n", creds.SecretAccessKey)
-			fmt.Printf("set AWS_SESSION_TOKEN=%s\n", creds.SessionToken)
-		} else {
-			fmt.Printf("export AWS_ACCESS_KEY_ID=%s\n", creds.AccessKeyId)
-			fmt.Printf("export AWS_SECRET_ACCESS_KEY=%s\n", creds.SecretAccessKey)
-			fmt.Printf("export AWS_SESSION_TOKEN=%s\n", creds.SessionToken)
-		}
-	} else {
-		if err := WriteAWSCredentials(creds, c.profile); err != nil {
+	if c.profile != "" {
+		if err := WriteAWSCredentials(creds, roleToAssume, c.profile); err != nil {
 			fmt.Fprintf(os.Stderr, "ERROR: Failed to write credentials: %s", err)
 			os.Exit(1)
 		}
 
 		fmt.Fprintf(os.Stderr, "Temporary credentials successfully saved to credential profile '%s'.\nYou can use these credentials with the AWS CLI by including the '--profile %s' flag.\n", c.profile, c.profile)
 
-	}
-	fmt.Fprintf(os.Stderr, "\nThese credentials will remain valid until %s\n", creds.Expiration.String())
-}
-
-func WriteAWSCredentials(c federator.Credentials, p string) error {
-	usr, err := user.Current()
-	if err != nil {
-		return fmt.Errorf("Unable to get current user information: %s\n", err)
-	}
-
-	cpath := filepath.Join(usr.HomeDir, ".aws/credentials")
-
-	l.Printf("Writing to AWS credentials file: %s\n", cpath)
-	cfg, err := ini.Load(cpath)
-	if err != nil {
-		return err
-	}
-
-	if _, err := cfg.GetSection(p); err != nil {
-		if _, err := cfg.NewSection(p); err != nil {
-			return fmt.Errorf("Unable to create credential profile: %s", err)
+		if keys, err := conflictingConfigKeys(c.profile); err != nil {
+			l.Printf("Unable to check AWS config file for conflicting settings: %s\n", err)
+		} else if len(keys) > 0 {
+			fmt.Fprintf(os.Stderr, "WARNING: The '%s' profile in your AWS config file sets %s which will take precedence over these credentials.\n", c.profile, strings.Join(keys, ", "))
 		}
-	}
-
-	prof, err := cfg.GetSection(p)
-	if err != nil {
-		return fmt.Errorf("Unable to retrieve recently created profile: %s", err)
-	}
 
-	//aws_access_key_id
-	if _, err := prof.NewKey("aws_access_key_id", c.AccessKeyId); err != nil {
-		return fmt.Errorf("Unable to write aws_access_key_id to credential file: %s", err)
+		reportChainedProfiles(creds, c.profile, acct.Key("prewarm_source_profiles").MustBool(false))
 	}
 
-	//aws_secret_access_key
-	if _, err := prof.NewKey("aws_secret_access_key", c.SecretAccessKey); err != nil {
-		return fmt.Errorf("Unable to write aws_secret_access_key to credential file: %s", err)
+	if c.sendingCredentials() {
+		if err := c.sendCredentials(outputCredentials{Credentials: creds, Region: acct.Key("region").String()}); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Failed to send credentials: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Temporary credentials successfully sent to the parent process.\n")
 	}
 
-	//aws_session_token
-	if _, err := prof.NewKey("aws_session_token", c.SessionToken); err != nil {
-		return fmt.Errorf("Unable to write aws_session_token to credential file: %s", err)
+	// output temporary credentials to stdout instead of writing to credentials file
+	if c.output != "" || (c.profile == "" && !c.sendingCredentials()) {
+		if c.output == "" || c.output == "env" {
+			fmt.Fprintf(os.Stderr, "Temporary credentials successfully generated. Set the following environment variables to being using them:\n\n")
+		}
+		out := outputCredentials{Credentials: creds, Region: acct.Key("region").String()}
+		if err := output(os.Stdout, out); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Failed to output credentials: %s\n", err)
+			os.Exit(1)
+		}
 	}
-
-	if err := cfg.SaveTo(filepath.Join(usr.HomeDir, ".aws/credentials")); err != nil {
-		return fmt.Errorf("Unable to save configuration to disk: %s", err)
+	fmt.Fprintf(os.Stderr, "\nThese credentials will remain valid until %s\n", formatTime(creds.Expiration))
+	if *c.printARN {
+		fmt.Println(roleToAssume.RoleArn())
 	}
-
-	return nil
+	requirePermissions(acct, roleToAssume, creds, sso)
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	commands["migrate-state"] = migrateState
+}
+
+// migrateState moves state files from their legacy location beside the
+// configuration file (~/.aws/federatedcli-<name>) into the state directory
+// and tightens the permissions of everything in it.
+//
+// Files are moved with a rename, so a process reading state at the same
+// time sees either the old or the new file and never a partial one, and the
+// state lock is held so that processes writing state wait for the migration.
+func migrateState(args []string) error {
+	dir, err := stateDir()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockState()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := os.Chmod(dir, 0700); err != nil {
+		return fmt.Errorf("Unable to set permissions of %s: %s", dir, err)
+	}
+
+	legacy, err := filepath.Glob(filepath.Join(filepath.Dir(dir), "federatedcli-*"))
+	if err != nil {
+		return err
+	}
+
+	moved, conflicts := 0, 0
+	for _, old := range legacy {
+		name := strings.TrimPrefix(filepath.Base(old), "federatedcli-")
+		dest := filepath.Join(dir, name)
+
+		if _, err := os.Stat(dest); err == nil {
+			fmt.Fprintf(os.Stderr, "WARNING: Not moving %s as %s already exists.  Remove whichever is out of date.\n", old, dest)
+			conflicts++
+			continue
+		}
+
+		if err := os.Rename(old, dest); err != nil {
+			return fmt.Errorf("Unable to move %s to %s: %s", old, dest, err)
+		}
+		fmt.Fprintf(os.Stderr, "Moved %s to %s\n", old, dest)
+		moved++
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if fi.IsDir() || fi.Mode().Perm() == 0600 {
+			continue
+		}
+
+		p := filepath.Join(dir, fi.Name())
+		if err := os.Chmod(p, 0600); err != nil {
+			return fmt.Errorf("Unable to set permissions of %s: %s", p, err)
+		}
+		fmt.Fprintf(os.Stderr, "Restricted permissions of %s\n", p)
+	}
+
+	fmt.Fprintf(os.Stderr, "Migrated %d state file(s) to %s", moved, dir)
+	if conflicts > 0 {
+		fmt.Fprintf(os.Stderr, ", %d left in place", conflicts)
+	}
+	fmt.Fprintf(os.Stderr, "\n")
+
+	return nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+// outputCredentials are the temporary credentials along with the account
+// settings needed to use them.
+type outputCredentials struct {
+	federator.Credentials
+	Region string
+}
+
+type envVar struct {
+	Name  string
+	Value string
+}
+
+// environment returns the standard AWS environment variables for creds.
+func (creds outputCredentials) environment() []envVar {
+	vars := []envVar{
+		{"AWS_ACCESS_KEY_ID", creds.AccessKeyId},
+		{"AWS_SECRET_ACCESS_KEY", creds.SecretAccessKey},
+		{"AWS_SESSION_TOKEN", creds.SessionToken},
+	}
+	if creds.Region != "" {
+		vars = append(vars, envVar{"AWS_DEFAULT_REGION", creds.Region}, envVar{"AWS_REGION", creds.Region})
+	}
+	return vars
+}
+
+// outputFormats are the formats the credentials can be printed to STDOUT in,
+// selected with the -output flag.
+var outputFormats = map[string]func(io.Writer, outputCredentials) error{
+	"env":                printEnv,
+	"credential_process": printCredentialProcess,
+	"terraform":          printTerraform,
+	"terraform-env":      printTerraformEnv,
+}
+
+// outputFormatDocs describe the output formats for the docs command.
+var outputFormatDocs = map[string]string{
+	"env":                "commands setting the standard AWS environment variables for the current shell (see -shell), the default when no profile is written",
+	"credential_process": "the JSON document expected from a credential_process command in ~/.aws/config",
+	"terraform":          "an AWS provider block to paste into a Terraform configuration",
+	"terraform-env":      "TF_VAR_ input variables for Terraform configurations that pass them to the provider themselves",
+}
+
+func outputFormatNames() []string {
+	var names []string
+	for n := range outputFormats {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// shells format a command setting an environment variable, for the env and
+// terraform-env output formats.  They are selected with the -shell flag.
+var shells = map[string]func(name, value string) string{
+	"bash":       posixSet,
+	"zsh":        posixSet,
+	"sh":         posixSet,
+	"fish":       fishSet,
+	"powershell": powershellSet,
+	"pwsh":       powershellSet,
+	"cmd":        cmdSet,
+}
+
+func posixSet(name, value string) string {
+	return fmt.Sprintf("export %s=%s", name, shellQuote(value, `'`+strings.Replace(value, `'`, `'\''`, -1)+`'`))
+}
+
+func fishSet(name, value string) string {
+	return fmt.Sprintf("set -gx %s %s;", name, shellQuote(value, `'`+strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)+`'`))
+}
+
+func cmdSet(name, value string) string {
+	return fmt.Sprintf("set %s=%s", name, value)
+}
+
+func powershellSet(name, value string) string {
+	return fmt.Sprintf("$env:%s = '%s'", name, strings.Replace(value, "'", "''", -1))
+}
+
+// unquotedValue matches values which need no quoting in the POSIX shells and
+// fish, which includes the credentials themselves.
+var unquotedValue = regexp.MustCompile(`^[A-Za-z0-9_+/=.,:@%-]+$`)
+
+// shellQuote returns value, or quoted if it needs quoting.
+func shellQuote(value, quoted string) string {
+	if unquotedValue.MatchString(value) {
+		return value
+	}
+	return quoted
+}
+
+func shellNames() []string {
+	var names []string
+	for n := range shells {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setEnv returns the command setting an environment variable in the shell
+// named by -shell, or else cmd on Windows and $SHELL elsewhere, falling
+// back to the POSIX syntax.
+func setEnv(name, value string) string {
+	shell := c.shell
+	if shell == "" && runtime.GOOS == "windows" {
+		shell = "cmd"
+	} else if shell == "" {
+		shell = filepath.Base(os.Getenv("SHELL"))
+	}
+	if set, ok := shells[shell]; ok {
+		return set(name, value)
+	}
+	return posixSet(name, value)
+}
+
+// printEnv prints commands setting the standard AWS environment variables
+// for the current shell.
+func printEnv(w io.Writer, creds outputCredentials) error {
+	for _, v := range creds.environment() {
+		fmt.Fprintln(w, setEnv(v.Name, v.Value))
+	}
+	return nil
+}
+
+// printCredentialProcess prints the JSON document expected from a
+// credential_process command in ~/.aws/config.
+func printCredentialProcess(w io.Writer, creds outputCredentials) error {
+	return json.NewEncoder(w).Encode(struct {
+		Version         int
+		AccessKeyId     string
+		SecretAccessKey string
+		SessionToken    string
+		Expiration      string
+	}{1, creds.AccessKeyId, creds.SecretAccessKey, creds.SessionToken, creds.Expiration.Format(time.RFC3339)})
+}
+
+// printTerraform prints an AWS provider block that can be pasted into a
+// Terraform configuration.
+func printTerraform(w io.Writer, creds outputCredentials) error {
+	fmt.Fprintf(w, "# credentials expire %s\n", formatTime(creds.Expiration))
+	fmt.Fprintf(w, "provider \"aws\" {\n")
+	fmt.Fprintf(w, "  access_key = %q\n", creds.AccessKeyId)
+	fmt.Fprintf(w, "  secret_key = %q\n", creds.SecretAccessKey)
+	fmt.Fprintf(w, "  token      = %q\n", creds.SessionToken)
+	if creds.Region != "" {
+		fmt.Fprintf(w, "  region     = %q\n", creds.Region)
+	}
+	fmt.Fprintf(w, "}\n")
+	return nil
+}
+
+// printTerraformEnv prints the credentials as TF_VAR_ input variables for
+// configurations that pass them into the provider themselves.
+func printTerraformEnv(w io.Writer, creds outputCredentials) error {
+	fmt.Fprintln(w, setEnv("TF_VAR_aws_access_key_id", creds.AccessKeyId))
+	fmt.Fprintln(w, setEnv("TF_VAR_aws_secret_access_key", creds.SecretAccessKey))
+	fmt.Fprintln(w, setEnv("TF_VAR_aws_session_token", creds.SessionToken))
+	if creds.Region != "" {
+		fmt.Fprintln(w, setEnv("TF_VAR_aws_region", creds.Region))
+	}
+	return nil
+}
+
+// timeFormats are the formats expiry times can be displayed in, selected
+// with the -time-format flag.
+var timeFormats = map[string]func(time.Time) string{
+	"default": func(t time.Time) string { return t.String() },
+	"rfc3339": func(t time.Time) string { return t.Format(time.RFC3339) },
+	"unix":    func(t time.Time) string { return strconv.FormatInt(t.Unix(), 10) },
+	"relative": func(t time.Time) string {
+		d := t.Sub(time.Now())
+		if d < 0 {
+			return fmt.Sprintf("%s ago", (-d / time.Second * time.Second).String())
+		}
+		return fmt.Sprintf("in %s", (d / time.Second * time.Second).String())
+	},
+}
+
+func timeFormatNames() []string {
+	var names []string
+	for n := range timeFormats {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// formatTime formats t according to the -time-format flag.
+func formatTime(t time.Time) string {
+	if f, ok := timeFormats[c.timeFormat]; ok {
+		return f(t)
+	}
+	return t.String()
+}
@@ -0,0 +1,142 @@
+// Package picker implements the interactive role selection menu used by
+// aws-cli-federator, so that other tools embedding the federator can offer
+// the same experience.
+package picker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+// ErrNoRoles is returned when there are no roles to choose from.
+var ErrNoRoles = errors.New("No roles to choose from")
+
+// Options control how the menu is presented.  The zero value reads from
+// STDIN and writes to STDERR.
+type Options struct {
+	// In and Out are where the selection is read from and the menu written
+	// to.
+	In  io.Reader
+	Out io.Writer
+
+	// AccountNames maps account IDs to friendly names displayed in place
+	// of the role ARN.
+	AccountNames map[string]string
+
+	// Default is selected if the user enters nothing.  It is ignored if it
+	// is not one of the roles.
+	Default federator.Role
+
+	// Prompt replaces the default prompt text.
+	Prompt string
+
+	// Copy, if set, lets the user copy a role's ARN by entering c<ID#>, or
+	// its account ID with a<ID#>, before choosing.  It is typically
+	// platform.Native().CopyToClipboard.
+	Copy func(text string) error
+}
+
+// Select asks the user to choose one of roles.  If there is only a single
+// role it is returned without prompting.
+func Select(roles []federator.Role, opts Options) (federator.Role, error) {
+	if len(roles) == 0 {
+		return "", ErrNoRoles
+	}
+	if len(roles) == 1 {
+		return roles[0], nil
+	}
+
+	in, out := opts.In, opts.Out
+	if in == nil {
+		in = os.Stdin
+	}
+	if out == nil {
+		out = os.Stderr
+	}
+
+	def := -1
+	for n, role := range roles {
+		fmt.Fprintf(out, "%d) %s\n", n+1, Label(role, opts.AccountNames))
+		if opts.Default != "" && role == opts.Default {
+			def = n
+		}
+	}
+
+	prompt := opts.Prompt
+	if prompt == "" {
+		prompt = "Enter the ID# of the role you want to assume"
+	}
+	if opts.Copy != nil {
+		fmt.Fprintf(out, "(enter c<ID#> to copy a role's ARN, or a<ID#> its account ID)\n")
+	}
+
+	r := bufio.NewReader(in)
+	for {
+		if def >= 0 {
+			fmt.Fprintf(out, "%s [%d]: ", prompt, def+1)
+		} else {
+			fmt.Fprintf(out, "%s: ", prompt)
+		}
+
+		line, readErr := r.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return "", fmt.Errorf("Could not read selection: %s", readErr)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" && def >= 0 {
+			return roles[def], nil
+		}
+
+		if opts.Copy != nil && line != "" && (line[0] == 'c' || line[0] == 'a') {
+			role, err := parseID(roles, strings.TrimSpace(line[1:]))
+			if err != nil {
+				return "", err
+			}
+			text, what := role.RoleArn(), "role ARN"
+			if line[0] == 'a' {
+				text, what = role.AccountId(), "account ID"
+			}
+			if err := opts.Copy(text); err != nil {
+				fmt.Fprintf(out, "Could not copy to the clipboard: %s\n", err)
+			} else {
+				fmt.Fprintf(out, "Copied %s %s to the clipboard.\n", what, text)
+			}
+			// nothing more can be read to choose with
+			if readErr == io.EOF {
+				return "", fmt.Errorf("Invalid selection made.")
+			}
+			continue
+		}
+
+		return parseID(roles, line)
+	}
+}
+
+// parseID returns the role numbered id in the menu.
+func parseID(roles []federator.Role, id string) (federator.Role, error) {
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return "", fmt.Errorf("Invalid selection made.")
+	}
+	if i < 1 || i > len(roles) {
+		return "", fmt.Errorf("Invalid ID selection, but in range from %d to %d.", 1, len(roles))
+	}
+
+	return roles[i-1], nil
+}
+
+// Label returns how role is displayed in the menu, substituting the account
+// name from names when one is known.
+func Label(role federator.Role, names map[string]string) string {
+	if name, ok := names[role.AccountId()]; ok {
+		return fmt.Sprintf("%s:role/%s", name, role.RoleName())
+	}
+	return role.RoleArn()
+}
@@ -0,0 +1,55 @@
+//go:build darwin || linux || freebsd || netbsd || openbsd || dragonfly
+// +build darwin linux freebsd netbsd openbsd dragonfly
+
+package platform
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// termiosKeys reads keys from a terminal with canonical mode and echo
+// disabled.  VMIN=0 and VTIME=1 make each read return after 100ms even if
+// no key was pressed, so a reader never outlives Close to steal input meant
+// for the next prompt.
+type termiosKeys struct {
+	f   *os.File
+	old syscall.Termios
+}
+
+func rawTermiosKeys(f *os.File) (Keys, error) {
+	k := &termiosKeys{f: f}
+	if err := termios(f, ioctlGetTermios, &k.old); err != nil {
+		return nil, err
+	}
+
+	t := k.old
+	t.Lflag &^= syscall.ICANON | syscall.ECHO
+	t.Cc[syscall.VMIN] = 0
+	t.Cc[syscall.VTIME] = 1
+	if err := termios(f, ioctlSetTermios, &t); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *termiosKeys) Key() (byte, bool) {
+	var b [1]byte
+	n, err := k.f.Read(b[:])
+	if err != nil || n == 0 {
+		return 0, false
+	}
+	return b[0], true
+}
+
+func (k *termiosKeys) Close() error {
+	return termios(k.f, ioctlSetTermios, &k.old)
+}
+
+func termios(f *os.File, req uintptr, t *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return errno
+	}
+	return nil
+}
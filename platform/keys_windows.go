@@ -0,0 +1,70 @@
+package platform
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procReadConsoleInput        = kernel32.NewProc("ReadConsoleInputW")
+	procWaitForSingleObject     = kernel32.NewProc("WaitForSingleObject")
+	procFlushConsoleInputBuffer = kernel32.NewProc("FlushConsoleInputBuffer")
+)
+
+const (
+	keyEvent    = 0x0001
+	waitObject0 = 0x00000000
+)
+
+// inputRecord is an INPUT_RECORD holding a KEY_EVENT_RECORD.
+type inputRecord struct {
+	eventType       uint16
+	_               uint16
+	keyDown         int32
+	repeatCount     uint16
+	virtualKeyCode  uint16
+	virtualScanCode uint16
+	char            uint16
+	controlKeyState uint32
+}
+
+// consoleKeys reads key events directly from the console input buffer,
+// which does not wait for Enter or echo.
+type consoleKeys struct {
+	h syscall.Handle
+}
+
+func (windows) RawKeys(f *os.File) (Keys, error) {
+	h := syscall.Handle(f.Fd())
+	var mode uint32
+	if err := syscall.GetConsoleMode(h, &mode); err != nil {
+		return nil, err
+	}
+	return consoleKeys{h}, nil
+}
+
+func (k consoleKeys) Key() (byte, bool) {
+	for {
+		r, _, _ := procWaitForSingleObject.Call(uintptr(k.h), 100)
+		if r != waitObject0 {
+			return 0, false
+		}
+
+		var rec inputRecord
+		var n uint32
+		ok, _, _ := procReadConsoleInput.Call(uintptr(k.h), uintptr(unsafe.Pointer(&rec)), 1, uintptr(unsafe.Pointer(&n)))
+		if ok == 0 || n == 0 {
+			return 0, false
+		}
+		// skip key releases, mouse and focus events
+		if rec.eventType == keyEvent && rec.keyDown != 0 && rec.char != 0 && rec.char < 0x80 {
+			return byte(rec.char), true
+		}
+	}
+}
+
+func (k consoleKeys) Close() error {
+	procFlushConsoleInputBuffer.Call(uintptr(k.h))
+	return nil
+}
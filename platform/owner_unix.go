@@ -0,0 +1,27 @@
+//go:build darwin || linux || freebsd || netbsd || openbsd || dragonfly
+// +build darwin linux freebsd netbsd openbsd dragonfly
+
+package platform
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// statOwner returns the numeric user ID owning the file described by fi.
+func statOwner(fi os.FileInfo) (string, error) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", ErrUnsupported
+	}
+	return strconv.FormatUint(uint64(st.Uid), 10), nil
+}
+
+// signalProcess reports whether the process pid is running, by sending it
+// the null signal.  A process belonging to another user can't be signalled
+// but is running.
+func signalProcess(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,141 @@
+// Package platform provides access to the native operating system helpers
+// used by the federator: the credential store (keychain), the default web
+// browser, the clipboard, the system proxy settings and single key presses on
+// a terminal.  Each supported GOOS has its own build
+// specific implementation of Helpers.
+package platform
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// ErrNotFound is returned by KeychainGet when no secret has been stored.
+var ErrNotFound = errors.New("secret not found in keychain")
+
+// ErrUnsupported is returned when the running platform has no native helper
+// for the requested operation.
+var ErrUnsupported = errors.New("operation not supported on " + runtime.GOOS + "/" + runtime.GOARCH)
+
+// Helpers is the common interface implemented for every platform.
+type Helpers interface {
+	// KeychainGet retrieves the secret stored for service and account.
+	KeychainGet(service, account string) (string, error)
+	// KeychainSet creates or replaces the secret stored for service and
+	// account.
+	KeychainSet(service, account, secret string) error
+	// OpenBrowser opens url in the user's default web browser.
+	OpenBrowser(url string) error
+	// CopyToClipboard replaces the clipboard contents with text.
+	CopyToClipboard(text string) error
+	// SystemProxy returns the proxy the operating system is configured to
+	// use for target, evaluating proxy auto-config (PAC) files where
+	// necessary.  It returns nil if target should be connected to directly.
+	SystemProxy(target *url.URL) (*url.URL, error)
+	// RawKeys starts reading single key presses from the terminal f,
+	// without waiting for Enter or echoing them.
+	RawKeys(f *os.File) (Keys, error)
+	// FileOwner returns the ID of the user owning the file described by
+	// fi, in the same form as os/user's User.Uid.
+	FileOwner(fi os.FileInfo) (string, error)
+	// ProcessRunning reports whether the process pid is running.  It
+	// returns true if this can't be determined.
+	ProcessRunning(pid int) bool
+}
+
+// Keys reads key presses from a terminal started with RawKeys.
+type Keys interface {
+	// Key returns the next key pressed, waiting for at most about 100ms.
+	// ok is false if no key was pressed.
+	Key() (key byte, ok bool)
+	// Close restores the terminal to how it was before RawKeys.
+	Close() error
+}
+
+// Native returns the helpers for the platform the binary was built for.
+func Native() Helpers {
+	return native
+}
+
+// LookPath locates a helper program.  In addition to $PATH, the package
+// manager prefixes for the running architecture are searched, as GUI
+// applications are frequently started without them on their $PATH (for
+// example Homebrew installs to /opt/homebrew on darwin/arm64 but
+// /usr/local on darwin/amd64).
+func LookPath(name string) (string, error) {
+	if p, err := exec.LookPath(name); err == nil {
+		return p, nil
+	}
+
+	for _, dir := range helperDirs() {
+		p := filepath.Join(dir, name)
+		if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+			return p, nil
+		}
+	}
+
+	return "", &exec.Error{Name: name, Err: exec.ErrNotFound}
+}
+
+// runWithInput runs a helper program feeding input on its STDIN.
+func runWithInput(input string, name string, args ...string) error {
+	path, err := LookPath(name)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(path, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if _, err := stdin.Write([]byte(input)); err != nil {
+		return err
+	}
+	stdin.Close()
+
+	return cmd.Wait()
+}
+
+// parsePACResult returns the first usable proxy from the result of a PAC
+// file's FindProxyForURL, such as "PROXY proxy:8080; DIRECT".
+func parsePACResult(result string) (*url.URL, error) {
+	for _, entry := range strings.Split(result, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "DIRECT":
+			return nil, nil
+		case "PROXY", "HTTP":
+			if len(fields) == 2 {
+				return url.Parse("http://" + fields[1])
+			}
+		case "HTTPS":
+			if len(fields) == 2 {
+				return url.Parse("https://" + fields[1])
+			}
+		}
+	}
+	return nil, fmt.Errorf("no supported proxy in PAC result '%s'", result)
+}
+
+// hostname returns the host of u without any port.
+func hostname(u *url.URL) string {
+	if h, _, err := net.SplitHostPort(u.Host); err == nil {
+		return h
+	}
+	return u.Host
+}
@@ -0,0 +1,74 @@
+package platform
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+type darwin struct{}
+
+var native Helpers = darwin{}
+
+// The system binaries used here are universal, so they run natively on
+// both Intel and Apple Silicon without Rosetta.
+const securityPath = "/usr/bin/security"
+
+func helperDirs() []string {
+	if runtime.GOARCH == "arm64" {
+		return []string{"/opt/homebrew/bin", "/usr/local/bin"}
+	}
+	return []string{"/usr/local/bin", "/opt/local/bin"}
+}
+
+func (darwin) KeychainGet(service, account string) (string, error) {
+	out, err := exec.Command(securityPath, "find-generic-password", "-s", service, "-a", account, "-w").Output()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// KeychainSet feeds the command to security on STDIN rather than passing
+// the secret as an argument, where any user could read it with ps.
+func (darwin) KeychainSet(service, account, secret string) error {
+	if strings.ContainsAny(secret, "\r\n") {
+		return errors.New("Secrets stored in the keychain can't contain line breaks")
+	}
+	args := []string{"add-generic-password", "-U", "-s", service, "-a", account, "-w", secret}
+	for i, arg := range args {
+		args[i] = securityArg(arg)
+	}
+	return runWithInput(strings.Join(args, " ")+"\n", securityPath, "-i")
+}
+
+// securityArg quotes arg for a command line read by security -i, which
+// splits on spaces outside double quotes and unescapes backslashes.
+func securityArg(arg string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+func (darwin) OpenBrowser(url string) error {
+	return exec.Command("/usr/bin/open", url).Run()
+}
+
+func (darwin) CopyToClipboard(text string) error {
+	return runWithInput(text, "/usr/bin/pbcopy")
+}
+
+func (darwin) RawKeys(f *os.File) (Keys, error) {
+	return rawTermiosKeys(f)
+}
+
+func (darwin) FileOwner(fi os.FileInfo) (string, error) {
+	return statOwner(fi)
+}
+
+func (darwin) ProcessRunning(pid int) bool {
+	return signalProcess(pid)
+}
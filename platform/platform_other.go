@@ -0,0 +1,49 @@
+//go:build !darwin && !linux && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+// +build !darwin,!linux,!freebsd,!netbsd,!openbsd,!dragonfly,!windows
+
+package platform
+
+import (
+	"net/url"
+	"os"
+)
+
+type unsupported struct{}
+
+var native Helpers = unsupported{}
+
+func helperDirs() []string {
+	return nil
+}
+
+func (unsupported) KeychainGet(service, account string) (string, error) {
+	return "", ErrUnsupported
+}
+
+func (unsupported) KeychainSet(service, account, secret string) error {
+	return ErrUnsupported
+}
+
+func (unsupported) OpenBrowser(url string) error {
+	return ErrUnsupported
+}
+
+func (unsupported) CopyToClipboard(text string) error {
+	return ErrUnsupported
+}
+
+func (unsupported) SystemProxy(target *url.URL) (*url.URL, error) {
+	return nil, ErrUnsupported
+}
+
+func (unsupported) RawKeys(f *os.File) (Keys, error) {
+	return nil, ErrUnsupported
+}
+
+func (unsupported) FileOwner(fi os.FileInfo) (string, error) {
+	return "", ErrUnsupported
+}
+
+func (unsupported) ProcessRunning(pid int) bool {
+	return true
+}
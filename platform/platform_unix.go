@@ -0,0 +1,91 @@
+//go:build linux || freebsd || netbsd || openbsd || dragonfly
+// +build linux freebsd netbsd openbsd dragonfly
+
+package platform
+
+import (
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+type unix struct{}
+
+var native Helpers = unix{}
+
+func helperDirs() []string {
+	return []string{"/usr/local/bin", "/usr/bin", "/snap/bin"}
+}
+
+// KeychainGet uses secret-tool to query the Secret Service (GNOME Keyring,
+// KWallet).
+func (unix) KeychainGet(service, account string) (string, error) {
+	path, err := LookPath("secret-tool")
+	if err != nil {
+		return "", ErrUnsupported
+	}
+
+	out, err := exec.Command(path, "lookup", "service", service, "account", account).Output()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	if len(out) == 0 {
+		return "", ErrNotFound
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+func (unix) KeychainSet(service, account, secret string) error {
+	if _, err := LookPath("secret-tool"); err != nil {
+		return ErrUnsupported
+	}
+	return runWithInput(secret, "secret-tool", "store", "--label="+service+" ("+account+")", "service", service, "account", account)
+}
+
+func (unix) OpenBrowser(url string) error {
+	path, err := LookPath("xdg-open")
+	if err != nil {
+		return ErrUnsupported
+	}
+	return exec.Command(path, url).Start()
+}
+
+// CopyToClipboard prefers the Wayland helper when running under a Wayland
+// compositor and falls back to the X11 helpers.
+func (unix) CopyToClipboard(text string) error {
+	var helpers [][]string
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		helpers = append(helpers, []string{"wl-copy"})
+	}
+	helpers = append(helpers,
+		[]string{"xclip", "-selection", "clipboard"},
+		[]string{"xsel", "--clipboard", "--input"},
+	)
+
+	for _, h := range helpers {
+		if _, err := LookPath(h[0]); err == nil {
+			return runWithInput(text, h[0], h[1:]...)
+		}
+	}
+	return ErrUnsupported
+}
+
+func (unix) SystemProxy(target *url.URL) (*url.URL, error) {
+	return nil, ErrUnsupported
+}
+
+func (unix) RawKeys(f *os.File) (Keys, error) {
+	return rawTermiosKeys(f)
+}
+
+func (unix) FileOwner(fi os.FileInfo) (string, error) {
+	return statOwner(fi)
+}
+
+func (unix) ProcessRunning(pid int) bool {
+	return signalProcess(pid)
+}
@@ -0,0 +1,137 @@
+package platform
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+type windows struct{}
+
+var native Helpers = windows{}
+
+var (
+	advapi32      = syscall.NewLazyDLL("advapi32.dll")
+	procCredRead  = advapi32.NewProc("CredReadW")
+	procCredWrite = advapi32.NewProc("CredWriteW")
+	procCredFree  = advapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+	errorNotFound           = 1168
+	errorInvalidParameter   = 87
+	stillActive             = 259
+)
+
+// credential mirrors the Win32 CREDENTIALW structure.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+func helperDirs() []string {
+	return nil
+}
+
+func credTarget(service, account string) (*uint16, error) {
+	return syscall.UTF16PtrFromString(service + ":" + account)
+}
+
+// KeychainGet reads a generic credential from the Windows Credential Manager.
+func (windows) KeychainGet(service, account string) (string, error) {
+	target, err := credTarget(service, account)
+	if err != nil {
+		return "", err
+	}
+
+	var cred *credential
+	r, _, err := procCredRead.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if r == 0 {
+		if errno, ok := err.(syscall.Errno); ok && errno == errorNotFound {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	// an empty secret has no blob
+	if cred.CredentialBlobSize == 0 {
+		return "", nil
+	}
+	blob := (*[1 << 20]byte)(unsafe.Pointer(cred.CredentialBlob))[:cred.CredentialBlobSize:cred.CredentialBlobSize]
+	return string(blob), nil
+}
+
+// KeychainSet writes a generic credential to the Windows Credential Manager.
+func (windows) KeychainSet(service, account, secret string) error {
+	target, err := credTarget(service, account)
+	if err != nil {
+		return err
+	}
+	user, err := syscall.UTF16PtrFromString(account)
+	if err != nil {
+		return err
+	}
+
+	blob := []byte(secret)
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		UserName:           user,
+		CredentialBlobSize: uint32(len(blob)),
+		Persist:            credPersistLocalMachine,
+	}
+	if len(blob) > 0 {
+		cred.CredentialBlob = &blob[0]
+	}
+
+	if r, _, err := procCredWrite.Call(uintptr(unsafe.Pointer(&cred)), 0); r == 0 {
+		return err
+	}
+	return nil
+}
+
+func (windows) OpenBrowser(url string) error {
+	return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+}
+
+func (windows) CopyToClipboard(text string) error {
+	return runWithInput(text, "clip")
+}
+
+// FileOwner is not supported as user profiles on Windows are protected by
+// their ACLs rather than by ownership.
+func (windows) FileOwner(fi os.FileInfo) (string, error) {
+	return "", ErrUnsupported
+}
+
+func (windows) ProcessRunning(pid int) bool {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// a process which exited and was reaped can't be opened, but
+		// neither can one belonging to another user
+		errno, ok := err.(syscall.Errno)
+		return !ok || errno != errorInvalidParameter
+	}
+	defer syscall.CloseHandle(h)
+
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return true
+	}
+	// stillActive is the exit code of a process which hasn't exited
+	return code == stillActive
+}
@@ -0,0 +1,127 @@
+package platform
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// pacScript evaluates a PAC file with JavaScript for Automation, providing
+// the standard PAC helper functions.  It is run as
+// "osascript -l JavaScript -e pacScript <pac> <url> <host>".
+const pacScript = `ObjC.import('Foundation');
+function run(argv) {
+	function dnsResolve(h) { var a = $.NSHost.hostWithName(h).address; return a ? ObjC.unwrap(a) : null; }
+	function myIpAddress() { var a = $.NSHost.currentHost.address; return a ? ObjC.unwrap(a) : '127.0.0.1'; }
+	function isResolvable(h) { return dnsResolve(h) != null; }
+	function isPlainHostName(h) { return h.indexOf('.') < 0; }
+	function dnsDomainIs(h, d) { return h.length >= d.length && h.substring(h.length - d.length) == d; }
+	function localHostOrDomainIs(h, hd) { return h == hd || hd.lastIndexOf(h + '.', 0) == 0; }
+	function dnsDomainLevels(h) { return h.split('.').length - 1; }
+	function ip(s) { var p = s.split('.'); return ((+p[0] << 24) >>> 0) + (+p[1] << 16) + (+p[2] << 8) + (+p[3]); }
+	function isInNet(h, pat, mask) { var a = /^[0-9.]+$/.test(h) ? h : dnsResolve(h); if (!a) return false; return ((ip(a) & ip(mask)) >>> 0) == ((ip(pat) & ip(mask)) >>> 0); }
+	function shExpMatch(s, p) { return new RegExp('^' + p.replace(/[.+^${}()|[\]\\]/g, '\\$&').replace(/\*/g, '.*').replace(/\?/g, '.') + '$').test(s); }
+	function weekdayRange() { return true; }
+	function dateRange() { return true; }
+	function timeRange() { return true; }
+	eval(argv[0]);
+	return FindProxyForURL(argv[1], argv[2]);
+}`
+
+// SystemProxy reads the proxy settings of the active network service with
+// scutil, evaluating the auto-config (PAC) file if one is configured.
+func (darwin) SystemProxy(target *url.URL) (*url.URL, error) {
+	out, err := exec.Command("/usr/sbin/scutil", "--proxy").Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not read system proxy settings: %s", err)
+	}
+	settings := parseScutil(out)
+
+	if settings["ProxyAutoConfigEnable"] == "1" && settings["ProxyAutoConfigURLString"] != "" {
+		return evaluatePAC(settings["ProxyAutoConfigURLString"], target)
+	}
+
+	for _, e := range scutilList(out, "ExceptionsList") {
+		if ok, _ := path.Match(e, hostname(target)); ok || hostname(target) == e {
+			return nil, nil
+		}
+	}
+
+	prefix := "HTTP"
+	if target.Scheme == "https" {
+		prefix = "HTTPS"
+	}
+	if settings[prefix+"Enable"] == "1" && settings[prefix+"Proxy"] != "" {
+		return url.Parse(fmt.Sprintf("http://%s:%s", settings[prefix+"Proxy"], settings[prefix+"Port"]))
+	}
+
+	return nil, nil
+}
+
+func evaluatePAC(pacURL string, target *url.URL) (*url.URL, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(pacURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not download PAC file: %s", err)
+	}
+	defer resp.Body.Close()
+
+	pac, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not download PAC file: %s", err)
+	}
+
+	out, err := exec.Command("/usr/bin/osascript", "-l", "JavaScript", "-e", pacScript, string(pac), target.String(), hostname(target)).Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not evaluate PAC file: %s", err)
+	}
+
+	return parsePACResult(strings.TrimSpace(string(out)))
+}
+
+// parseScutil reads the "key : value" lines of scutil's output.
+func parseScutil(out []byte) map[string]string {
+	settings := make(map[string]string)
+
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		parts := strings.SplitN(s.Text(), " : ", 2)
+		if len(parts) == 2 {
+			settings[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	return settings
+}
+
+// scutilList reads the values of an array in scutil's output:
+//
+//	ExceptionsList : <array> {
+//	  0 : *.local
+//	}
+func scutilList(out []byte, name string) []string {
+	var values []string
+
+	in := false
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case strings.HasPrefix(line, name+" : <array>"):
+			in = true
+		case in && line == "}":
+			return values
+		case in:
+			if parts := strings.SplitN(line, " : ", 2); len(parts) == 2 {
+				values = append(values, strings.TrimSpace(parts[1]))
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,177 @@
+package platform
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	winhttp                                   = syscall.NewLazyDLL("winhttp.dll")
+	procWinHttpOpen                           = winhttp.NewProc("WinHttpOpen")
+	procWinHttpCloseHandle                    = winhttp.NewProc("WinHttpCloseHandle")
+	procWinHttpGetProxyForUrl                 = winhttp.NewProc("WinHttpGetProxyForUrl")
+	procWinHttpGetIEProxyConfigForCurrentUser = winhttp.NewProc("WinHttpGetIEProxyConfigForCurrentUser")
+
+	kernel32       = syscall.NewLazyDLL("kernel32.dll")
+	procGlobalFree = kernel32.NewProc("GlobalFree")
+)
+
+const (
+	winhttpAccessTypeNoProxy    = 1
+	winhttpAccessTypeNamedProxy = 3
+	winhttpAutoproxyAutoDetect  = 1
+	winhttpAutoproxyConfigURL   = 2
+	winhttpAutoDetectTypeDHCP   = 1
+	winhttpAutoDetectTypeDNSA   = 2
+)
+
+// ieProxyConfig mirrors WINHTTP_CURRENT_USER_IE_PROXY_CONFIG.
+type ieProxyConfig struct {
+	AutoDetect    int32
+	AutoConfigURL *uint16
+	Proxy         *uint16
+	ProxyBypass   *uint16
+}
+
+// autoProxyOptions mirrors WINHTTP_AUTOPROXY_OPTIONS.
+type autoProxyOptions struct {
+	Flags                 uint32
+	AutoDetectFlags       uint32
+	AutoConfigURL         *uint16
+	Reserved              uintptr
+	ReservedDword         uint32
+	AutoLogonIfChallenged int32
+}
+
+// proxyInfo mirrors WINHTTP_PROXY_INFO.
+type proxyInfo struct {
+	AccessType  uint32
+	Proxy       *uint16
+	ProxyBypass *uint16
+}
+
+// SystemProxy uses WinHTTP to find the proxy Internet Options are configured
+// with for target, which evaluates auto-config (PAC) files and WPAD.
+func (windows) SystemProxy(target *url.URL) (*url.URL, error) {
+	var ie ieProxyConfig
+	if r, _, err := procWinHttpGetIEProxyConfigForCurrentUser.Call(uintptr(unsafe.Pointer(&ie))); r == 0 {
+		return nil, fmt.Errorf("could not read system proxy settings: %s", err)
+	}
+	defer globalFree(ie.AutoConfigURL)
+	defer globalFree(ie.Proxy)
+	defer globalFree(ie.ProxyBypass)
+
+	if ie.AutoDetect != 0 || ie.AutoConfigURL != nil {
+		opts := autoProxyOptions{AutoLogonIfChallenged: 1}
+		if ie.AutoConfigURL != nil {
+			opts.Flags = winhttpAutoproxyConfigURL
+			opts.AutoConfigURL = ie.AutoConfigURL
+		} else {
+			opts.Flags = winhttpAutoproxyAutoDetect
+			opts.AutoDetectFlags = winhttpAutoDetectTypeDHCP | winhttpAutoDetectTypeDNSA
+		}
+
+		proxy, err := winhttpProxyForURL(target, &opts)
+		if err == nil {
+			return proxy, nil
+		}
+		if ie.Proxy == nil {
+			return nil, err
+		}
+		// fall back to the manually configured proxy
+	}
+
+	if ie.Proxy == nil {
+		return nil, nil
+	}
+	if bypassed(target, utf16PtrToString(ie.ProxyBypass)) {
+		return nil, nil
+	}
+	return parseWinProxyList(utf16PtrToString(ie.Proxy), target.Scheme)
+}
+
+func winhttpProxyForURL(target *url.URL, opts *autoProxyOptions) (*url.URL, error) {
+	agent, _ := syscall.UTF16PtrFromString("aws-cli-federator")
+	session, _, err := procWinHttpOpen.Call(uintptr(unsafe.Pointer(agent)), winhttpAccessTypeNoProxy, 0, 0, 0)
+	if session == 0 {
+		return nil, fmt.Errorf("could not open WinHTTP session: %s", err)
+	}
+	defer procWinHttpCloseHandle.Call(session)
+
+	u, err := syscall.UTF16PtrFromString(target.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var info proxyInfo
+	if r, _, err := procWinHttpGetProxyForUrl.Call(session, uintptr(unsafe.Pointer(u)), uintptr(unsafe.Pointer(opts)), uintptr(unsafe.Pointer(&info))); r == 0 {
+		return nil, fmt.Errorf("could not evaluate proxy auto-config: %s", err)
+	}
+	defer globalFree(info.Proxy)
+	defer globalFree(info.ProxyBypass)
+
+	if info.AccessType != winhttpAccessTypeNamedProxy || info.Proxy == nil {
+		return nil, nil
+	}
+	return parseWinProxyList(utf16PtrToString(info.Proxy), target.Scheme)
+}
+
+// parseWinProxyList parses WinHTTP's proxy lists, which are either a single
+// "host:port" or per scheme "http=host:port;https=host:port" entries.
+func parseWinProxyList(list, scheme string) (*url.URL, error) {
+	var fallback string
+	for _, entry := range strings.FieldsFunc(list, func(r rune) bool { return r == ';' || r == ' ' }) {
+		if i := strings.Index(entry, "="); i >= 0 {
+			if strings.EqualFold(entry[:i], scheme) {
+				return url.Parse("http://" + entry[i+1:])
+			}
+			continue
+		}
+		if fallback == "" {
+			fallback = entry
+		}
+	}
+
+	if fallback == "" {
+		return nil, nil
+	}
+	return url.Parse("http://" + fallback)
+}
+
+// bypassed reports whether target matches the Internet Options bypass list.
+func bypassed(target *url.URL, list string) bool {
+	host := strings.ToLower(hostname(target))
+	for _, b := range strings.FieldsFunc(list, func(r rune) bool { return r == ';' || r == ' ' }) {
+		b = strings.ToLower(b)
+		switch {
+		case b == "<local>" && !strings.Contains(host, "."):
+			return true
+		case strings.HasPrefix(b, "*") && strings.HasSuffix(host, b[1:]):
+			return true
+		case b == host:
+			return true
+		}
+	}
+	return false
+}
+
+func utf16PtrToString(p *uint16) string {
+	if p == nil {
+		return ""
+	}
+	s := (*[1 << 16]uint16)(unsafe.Pointer(p))
+	n := 0
+	for s[n] != 0 {
+		n++
+	}
+	return syscall.UTF16ToString(s[:n])
+}
+
+func globalFree(p *uint16) {
+	if p != nil {
+		procGlobalFree.Call(uintptr(unsafe.Pointer(p)))
+	}
+}
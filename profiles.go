@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+// profileMeta is recorded in the profiles.json state file for each
+// credential profile written, so that what a profile holds can be found
+// without relying on the x_ keys written to the credentials file.
+type profileMeta struct {
+	RoleArn      string    `json:"role_arn"`
+	AccountID    string    `json:"account_id"`
+	AccountAlias string    `json:"account_alias,omitempty"`
+	IssuedAt     time.Time `json:"issued_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	Version      string    `json:"federator_version"`
+
+	// FailedAt is when a refresh of the profile by the daemon last failed,
+	// with RefreshError, if that was after the credentials were issued.
+	FailedAt     *time.Time `json:"refresh_failed_at,omitempty"`
+	RefreshError string     `json:"refresh_error,omitempty"`
+}
+
+// refreshFailed reports whether the last refresh of the profile failed.
+func (m profileMeta) refreshFailed() bool {
+	return m.FailedAt != nil && m.FailedAt.After(m.IssuedAt)
+}
+
+// loadProfiles reads profiles.json.  It is empty if nothing has been
+// recorded yet.
+func loadProfiles() (map[string]profileMeta, error) {
+	p, err := statePath("profiles.json")
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := make(map[string]profileMeta)
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return profiles, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("Invalid profile metadata in %s: %s", p, err)
+	}
+	return profiles, nil
+}
+
+// accountAlias returns the name account_map gives accountID, or "".  It may
+// fetch and cache the map, so it must be called without the state lock.
+func accountAlias(accountID string) string {
+	if c.cfg == nil {
+		return ""
+	}
+	return c.accountNames()[accountID]
+}
+
+// profileRecord returns profiles.json recording the credentials for role
+// written to profile p, in the account named alias.  The state lock must be
+// held.
+func profileRecord(p string, creds federator.Credentials, role federator.Role, alias string) ([]byte, error) {
+	profiles, err := loadProfiles()
+	if err != nil {
+		return nil, err
+	}
+
+	meta := profileMeta{
+		RoleArn:      role.RoleArn(),
+		AccountID:    role.AccountId(),
+		IssuedAt:     time.Now().UTC(),
+		ExpiresAt:    creds.Expiration.UTC(),
+		Version:      Version,
+		AccountAlias: alias,
+	}
+	profiles[p] = meta
+
+	return json.MarshalIndent(profiles, "", "  ")
+}
+
+// recordedProfile returns the metadata recorded for profile p.
+func recordedProfile(p string) (profileMeta, bool) {
+	profiles, err := loadProfiles()
+	if err != nil {
+		l.Printf("Unable to read profile metadata: %s\n", err)
+		return profileMeta{}, false
+	}
+	meta, ok := profiles[p]
+	return meta, ok
+}
+
+// recordRefreshFailure records in profiles.json that refreshing profile p
+// failed, until credentials are next written to it.
+func recordRefreshFailure(p string, failure error) error {
+	unlock, err := lockState()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := recoverTxn(); err != nil {
+		return err
+	}
+	profiles, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	meta := profiles[p]
+	meta.FailedAt = &now
+	meta.RefreshError = failure.Error()
+	profiles[p] = meta
+
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+	path, err := statePath("profiles.json")
+	if err != nil {
+		return err
+	}
+	return federator.WriteFileAtomic(path, data, 0600)
+}
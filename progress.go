@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// spinnerFrames are drawn in turn while a stage is in progress.
+const spinnerFrames = `|/-\`
+
+// spinner shows the current stage of a slow login on a terminal, so that
+// waiting for the IdP does not look like the tool has hung.
+type spinner struct {
+	out io.Writer
+	// pushUI is set when the MFA prompter shows its own countdown while a
+	// push notification waits for approval.
+	pushUI bool
+
+	mu    sync.Mutex
+	label string
+	width int
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// progressEvents returns an event callback drawing a spinner on STDERR, or
+// printing each stage on a line of its own with -plain, or nil when STDERR
+// is not a terminal or debug messages are being printed.
+func progressEvents(mfa federator.MFAPrompter) func(federator.Event) {
+	if c.verbose >= levelInfo || !terminal.IsTerminal(int(os.Stderr.Fd())) {
+		return nil
+	}
+	_, pushUI := mfa.(federator.PushPrompter)
+	if c.plainOutput() {
+		p := &plainProgress{out: os.Stderr, pushUI: pushUI}
+		return p.event
+	}
+	s := &spinner{out: os.Stderr, pushUI: pushUI}
+	return s.event
+}
+
+// plainOutput reports whether progress and prompts are printed as plain
+// lines, without the cursor movement screen readers and dumb terminals
+// can't follow: with -plain, plain = true or TERM=dumb.
+func (c configuration) plainOutput() bool {
+	if *c.plain || os.Getenv("TERM") == "dumb" {
+		return true
+	}
+	return c.cfg != nil && c.cfg.Section("").Key("plain").MustBool(false)
+}
+
+// plainProgress prints the stages shown by the spinner once each, as lines.
+type plainProgress struct {
+	out    io.Writer
+	pushUI bool
+	last   string
+}
+
+func (p *plainProgress) event(e federator.Event) {
+	label := ""
+	switch {
+	case e.Type == federator.StageStarted && e.Stage == federator.StageLogin:
+		label = "Contacting IdP..."
+	case e.Type == federator.StageStarted && e.Stage == federator.StageAssumeRole:
+		label = "Calling STS..."
+	case e.Type == federator.MFAPushWaiting && !p.pushUI:
+		label = "Waiting for MFA push..."
+	case e.Type == federator.StageFinished && e.Stage == federator.StageMFA && e.Err == nil:
+		label = "Waiting for IdP to accept MFA..."
+	case e.Type == federator.StageFinished:
+		p.last = ""
+	}
+	if label != "" && label != p.last {
+		fmt.Fprintln(p.out, label)
+		p.last = label
+	}
+}
+
+func (s *spinner) event(e federator.Event) {
+	switch {
+	case e.Type == federator.StageStarted && e.Stage == federator.StageLogin:
+		s.start("Contacting IdP...")
+	case e.Type == federator.StageStarted && e.Stage == federator.StageAssumeRole:
+		s.start("Calling STS...")
+	case e.Type == federator.MFARequired, e.Type == federator.MFATouchRequired:
+		// the prompter may need the terminal
+		s.clear()
+	case e.Type == federator.MFAPushWaiting:
+		if s.pushUI {
+			s.clear()
+		} else {
+			s.start(fmt.Sprintf("Waiting for MFA push, %ds left...", int(e.Remaining.Seconds()+0.5)))
+		}
+	case e.Type == federator.StageFinished && e.Stage == federator.StageMFA:
+		if e.Err == nil {
+			s.start("Waiting for IdP to accept MFA...")
+		}
+	case e.Type == federator.StageFinished:
+		s.clear()
+	}
+}
+
+// start shows label, starting the spinner if it is not already running.
+func (s *spinner) start(label string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.label = label
+	if s.stop != nil {
+		return
+	}
+
+	s.stop, s.done = make(chan struct{}), make(chan struct{})
+	go s.run(s.stop, s.done)
+}
+
+// clear stops the spinner and erases it.
+func (s *spinner) clear() {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+func (s *spinner) run(stop, done chan struct{}) {
+	defer close(done)
+
+	t := time.NewTicker(100 * time.Millisecond)
+	defer t.Stop()
+
+	for i := 0; ; i++ {
+		s.mu.Lock()
+		line := fmt.Sprintf("%c %s", spinnerFrames[i%len(spinnerFrames)], s.label)
+		// pad over the end of a longer previous label
+		if n := len(line); n < s.width {
+			line += strings.Repeat(" ", s.width-n)
+		}
+		s.width = len(line)
+		s.mu.Unlock()
+
+		fmt.Fprintf(s.out, "\r%s", line)
+
+		select {
+		case <-stop:
+			s.mu.Lock()
+			fmt.Fprintf(s.out, "\r%s\r", strings.Repeat(" ", s.width))
+			s.width = 0
+			s.mu.Unlock()
+			return
+		case <-t.C:
+		}
+	}
+}
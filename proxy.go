@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/platform"
+	"gopkg.in/ini.v1"
+)
+
+// proxyFunc chooses the proxy for a request, as used by http.Transport.
+type proxyFunc func(*http.Request) (*url.URL, error)
+
+// accountProxy returns how requests should be proxied according to the
+// account's proxy setting: env (the default) uses the HTTPS_PROXY family of
+// environment variables, system asks the operating system, none disables
+// proxying and anything else is the URL of the proxy to use.
+func accountProxy(acct *ini.Section) (proxyFunc, error) {
+	switch p := acct.Key("proxy").String(); p {
+	case "", "env":
+		return http.ProxyFromEnvironment, nil
+	case "none":
+		return nil, nil
+	case "system":
+		return systemProxy(), nil
+	default:
+		u, err := url.Parse(p)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("Invalid proxy '%s'", p)
+		}
+		return http.ProxyURL(u), nil
+	}
+}
+
+// systemProxy returns a proxyFunc which asks the operating system for the
+// proxy of each host, remembering the answer as evaluating PAC files is
+// slow.  Where the platform has no proxy settings, or they can't be read,
+// the environment is used.
+func systemProxy() proxyFunc {
+	var mu sync.Mutex
+	cache := make(map[string]*url.URL)
+
+	return func(req *http.Request) (*url.URL, error) {
+		key := req.URL.Scheme + "://" + req.URL.Host
+
+		mu.Lock()
+		defer mu.Unlock()
+		if u, ok := cache[key]; ok {
+			return u, nil
+		}
+
+		u, err := platform.Native().SystemProxy(req.URL)
+		if err != nil {
+			if err != platform.ErrUnsupported {
+				l.Printf("Unable to determine system proxy, using environment: %s\n", err)
+			}
+			return http.ProxyFromEnvironment(req)
+		}
+
+		if u != nil {
+			l.Printf("Using system proxy %s for %s\n", u, key)
+		}
+		cache[key] = u
+		return u, nil
+	}
+}
+
+// stsClientWithProxy returns an HTTP client for STS using proxy.
+func stsClientWithProxy(proxy proxyFunc) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:               proxy,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
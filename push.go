@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"github.com/aidan-/aws-cli-federator/platform"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// terminalPrompter is federator.TerminalPrompter with a countdown while a
+// push notification waits for approval, during which r resends it and c
+// switches to entering a code.
+type terminalPrompter struct {
+	federator.TerminalPrompter
+
+	// plain prints the time left every plainPushInterval on a line of its
+	// own instead of redrawing the countdown, without reading keys.
+	plain     bool
+	announced time.Time
+
+	keys  platform.Keys
+	width int
+}
+
+const plainPushInterval = 15 * time.Second
+
+func (t *terminalPrompter) PushWaiting(label string, left time.Duration) federator.PushAction {
+	if t.plain {
+		if time.Since(t.announced) >= plainPushInterval {
+			fmt.Fprintf(os.Stderr, "Waiting for %s approval, %ds left\n", label, int(left.Seconds()+0.5))
+			t.announced = time.Now()
+		}
+		return federator.PushWait
+	}
+
+	if t.keys == nil && terminal.IsTerminal(int(os.Stdin.Fd())) {
+		t.keys, _ = platform.Native().RawKeys(os.Stdin)
+	}
+
+	line := fmt.Sprintf("Waiting for %s approval, %ds left", label, int(left.Seconds()+0.5))
+	if t.keys != nil {
+		line += " (r to resend, c to enter a code)"
+	}
+	if n := len(line); n < t.width {
+		line += strings.Repeat(" ", t.width-n)
+	}
+	t.width = len(line)
+	fmt.Fprintf(os.Stderr, "\r%s", line)
+
+	if t.keys == nil {
+		return federator.PushWait
+	}
+	key, ok := t.keys.Key()
+	switch {
+	case !ok:
+	case key == 'r' || key == 'R':
+		return federator.PushResend
+	case key == 'c' || key == 'C':
+		return federator.PushUseCode
+	}
+	return federator.PushWait
+}
+
+func (t *terminalPrompter) PushDone(label string) {
+	if t.plain {
+		t.announced = time.Time{}
+		return
+	}
+	if t.keys != nil {
+		t.keys.Close()
+		t.keys = nil
+	}
+	fmt.Fprintf(os.Stderr, "\r%s\r", strings.Repeat(" ", t.width))
+	t.width = 0
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"gopkg.in/ini.v1"
+)
+
+// assumeRoleRelogin is assumeRole, logging in to acct again and retrying
+// once if the SAML assertion can no longer be used, as when it expired
+// while a role was being chosen.  fed is replaced by the new login.
+func assumeRoleRelogin(acct *ini.Section, fed *federator.Federator, r federator.Role) (federator.Credentials, error) {
+	creds, err := assumeRole(fed, r)
+	// a given assertion can't be replaced by logging in
+	if !assertionStale(err) || c.assertionGiven() {
+		return creds, err
+	}
+
+	l.Printf("%s\n", err)
+	fmt.Fprintf(os.Stderr, "WARNING: The SAML assertion can no longer be used, logging in again\n")
+	forgetAssertion(acct)
+	fresh, err := login(acct)
+	if err != nil {
+		return creds, err
+	}
+	fresh.SessionDuration, fresh.SessionPolicy = fed.SessionDuration, fed.SessionPolicy
+	*fed = fresh
+	return assumeRole(fed, r)
+}
+
+// assertionStale reports whether err means a fresh SAML assertion is needed.
+func assertionStale(err error) bool {
+	if err == federator.ErrAssertionConsumed {
+		return true
+	}
+	_, ok := err.(*federator.ExpiredAssertionError)
+	return ok
+}
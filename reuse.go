@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// reuseChoice is the user's answer when the target profile still holds
+// valid credentials.
+type reuseChoice int
+
+const (
+	reuseNone    reuseChoice = iota // log in and select a role as usual
+	reuseKeep                       // keep the stored credentials
+	reuseRefresh                    // log in and assume the stored role again
+	reuseChoose                     // log in and always show the role picker
+)
+
+// offerReuse checks whether profile p still holds unexpired credentials and,
+// when running interactively, asks whether to keep them, refresh them or
+// choose a different role.  role is the ARN recorded with the credentials.
+func offerReuse(p string) (choice reuseChoice, creds federator.Credentials, role string) {
+	// credential_process output is read by the SDKs, which apply
+	// refresh_margin instead, and a given assertion is meant to be used
+	if p == "" || *c.force || c.output == "credential_process" || c.assertionGiven() || !terminal.IsTerminal(int(os.Stdin.Fd())) || !terminal.IsTerminal(int(os.Stderr.Fd())) {
+		return reuseNone, creds, ""
+	}
+
+	creds, err := readProfileCredentials(p)
+	if err != nil || !time.Now().Before(creds.Expiration) {
+		return reuseNone, creds, ""
+	}
+	role, _ = profileRoleArn(p)
+
+	// a role requested on the command line which differs from the stored
+	// one leaves nothing to ask
+	if c.role != "" && c.role != role && !strings.HasSuffix(role, "/"+c.role) {
+		return reuseNone, creds, role
+	}
+
+	held := "credentials"
+	if role != "" {
+		held = "credentials for " + role
+	}
+	fmt.Fprintf(os.Stderr, "Profile '%s' already holds %s valid until %s (%s left).\n", p, held, formatTime(creds.Expiration), creds.Expiration.Sub(time.Now())/time.Second*time.Second)
+	fmt.Fprint(os.Stderr, "[u]se them, [r]efresh them or [c]hoose a different role? [u]: ")
+
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "", "u", "use":
+		return reuseKeep, creds, role
+	case "r", "refresh":
+		if role != "" {
+			return reuseRefresh, creds, role
+		}
+	}
+	return reuseChoose, creds, role
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"gopkg.in/ini.v1"
+)
+
+// revokePolicyName matches the inline policy the IAM console attaches when
+// revoking a role's active sessions, so either can update the other.
+const revokePolicyName = "AWSRevokeOlderSessions"
+
+func init() {
+	commands["revoke"] = revoke
+}
+
+// revoke denies every action to sessions of the role named by -role that
+// were issued before now, as the IAM console's "Revoke active sessions"
+// does.  The IAM call is made with the credentials of -profile, or the
+// default AWS credential chain.
+func revoke(args []string) error {
+	if c.role == "" {
+		return fmt.Errorf("revoke requires the role to revoke to be given with -role <name or ARN>")
+	}
+
+	name := c.role
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Profile:           c.profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to load AWS credentials: %s", err)
+	}
+	svc := iam.New(sess)
+
+	role, err := svc.GetRole(&iam.GetRoleInput{RoleName: aws.String(name)})
+	if err != nil {
+		return fmt.Errorf("Unable to find role '%s': %s", name, err)
+	}
+	arn := aws.StringValue(role.Role.Arn)
+
+	now := time.Now().UTC()
+	policy, err := revokePolicy(now)
+	if err != nil {
+		return err
+	}
+
+	if _, err := svc.PutRolePolicy(&iam.PutRolePolicyInput{
+		RoleName:       aws.String(name),
+		PolicyName:     aws.String(revokePolicyName),
+		PolicyDocument: aws.String(policy),
+	}); err != nil {
+		return fmt.Errorf("Unable to attach %s policy to %s: %s", revokePolicyName, arn, err)
+	}
+	fmt.Fprintf(os.Stderr, "Sessions for %s issued before %s are now denied.\n", arn, now.Format(time.RFC3339))
+
+	if err := forgetCachedCredentials(arn); err != nil {
+		l.Printf("Unable to remove cached credentials: %s\n", err)
+	}
+
+	invalid, unknown, err := revokedProfiles(arn)
+	if err != nil {
+		l.Printf("Unable to check local credential profiles: %s\n", err)
+		return nil
+	}
+
+	if len(invalid) > 0 {
+		fmt.Fprintf(os.Stderr, "\nThe following local credential profiles are no longer valid: %s\n", strings.Join(invalid, ", "))
+	}
+	if len(unknown) > 0 {
+		fmt.Fprintf(os.Stderr, "\nThe role of the following credential profiles was not recorded, they may no longer be valid: %s\n", strings.Join(unknown, ", "))
+	}
+
+	return nil
+}
+
+// revokePolicy returns a policy denying everything to sessions issued
+// before t.
+func revokePolicy(t time.Time) (string, error) {
+	doc := map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []interface{}{
+			map[string]interface{}{
+				"Effect":   "Deny",
+				"Action":   []string{"*"},
+				"Resource": []string{"*"},
+				"Condition": map[string]interface{}{
+					"DateLessThan": map[string]string{
+						"aws:TokenIssueTime": t.Format(time.RFC3339),
+					},
+				},
+			},
+		},
+	}
+
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("Unable to create revocation policy: %s", err)
+	}
+	return string(b), nil
+}
+
+// revokedProfiles returns the unexpired credential profiles written for the
+// role, and those whose role was not recorded.
+func revokedProfiles(arn string) ([]string, []string, error) {
+	cpath, err := credentialsPath()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg, err := ini.Load(cpath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	recorded, err := loadProfiles()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var invalid, unknown []string
+	for _, sec := range cfg.Sections() {
+		exp, err := time.Parse(time.RFC3339, sec.Key("x_security_token_expires").String())
+		if err != nil || time.Now().After(exp) {
+			continue
+		}
+
+		role := sec.Key("x_role_arn").String()
+		if meta, ok := recorded[sec.Name()]; ok && meta.RoleArn != "" {
+			role = meta.RoleArn
+		}
+		switch role {
+		case arn:
+			invalid = append(invalid, sec.Name())
+		case "":
+			unknown = append(unknown, sec.Name())
+		}
+	}
+
+	return invalid, unknown, nil
+}
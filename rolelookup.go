@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gopkg.in/ini.v1"
+)
+
+// lookupRoles narrows roles down to those assigned to the user by the
+// centrally managed mapping named in the account's role_lookup setting:
+//
+//	role_lookup = ssm:/org/federator/roles/{user}
+//	role_lookup = s3://bucket/federator/{user}.json
+//
+// The mapping is read using credentials for the read-only role matching
+// role_lookup_role.  It holds either a single role name or ARN, or a JSON
+// object mapping account IDs to role names or ARNs.
+func lookupRoles(acct *ini.Section, fed *federator.Federator, roles []federator.Role) ([]federator.Role, error) {
+	if !acct.HasKey("role_lookup_role") {
+		return nil, fmt.Errorf("role_lookup requires role_lookup_role to name the role used to read the mapping")
+	}
+
+	bootstrap := filterRoles(roles, acct.Key("role_lookup_role").String())
+	if len(bootstrap) == 0 {
+		return nil, fmt.Errorf("No roles match role_lookup_role '%s'", acct.Key("role_lookup_role").String())
+	}
+
+	l.Printf("Assuming %s to look up assigned roles\n", bootstrap[0].RoleArn())
+	creds, err := fed.AssumeRole(bootstrap[0])
+	if err != nil {
+		return nil, err
+	}
+
+	region := acct.Key("region").MustString("us-east-1")
+	location := strings.NewReplacer("{user}", fed.Username, "{account}", acct.Name()).Replace(acct.Key("role_lookup").String())
+
+	l.Printf("Looking up assigned roles from %s\n", location)
+	var value string
+	switch {
+	case strings.HasPrefix(location, "ssm:"):
+		value, err = ssmParameter(acct, fed, creds, region, strings.TrimPrefix(location, "ssm:"))
+	case strings.HasPrefix(location, "s3://"):
+		value, err = s3Object(creds, region, strings.TrimPrefix(location, "s3://"))
+	default:
+		return nil, fmt.Errorf("Unsupported role_lookup location '%s'", location)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s: %s", location, err)
+	}
+
+	var matched []federator.Role
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "{") {
+		var mapping map[string]string
+		if err := json.Unmarshal([]byte(value), &mapping); err != nil {
+			return nil, fmt.Errorf("Invalid role mapping in %s: %s", location, err)
+		}
+		for _, r := range roles {
+			if want, ok := mapping[r.AccountId()]; ok && roleMatches(r, want) {
+				matched = append(matched, r)
+			}
+		}
+	} else {
+		for _, r := range roles {
+			if roleMatches(r, value) {
+				matched = append(matched, r)
+			}
+		}
+	}
+
+	return matched, nil
+}
+
+// roleMatches reports whether r is identified by want, a role ARN or name.
+func roleMatches(r federator.Role, want string) bool {
+	return r.RoleArn() == want || r.RoleName() == want
+}
+
+func s3Object(creds federator.Credentials, region, location string) (string, error) {
+	parts := strings.SplitN(location, "/", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("S3 locations must be of the form s3://bucket/key")
+	}
+
+	sess := session.New(&aws.Config{
+		Region:      aws.String(region),
+		Credentials: credentials.NewStaticCredentials(creds.AccessKeyId, creds.SecretAccessKey, creds.SessionToken),
+	})
+
+	resp, err := s3.New(sess).GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(parts[0]),
+		Key:    aws.String(parts[1]),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	return string(b), err
+}
+
+// ssmParameter reads a Parameter Store value, from endpoint_url if the
+// account sets one.  The federator's client is used, so that the request
+// goes through the account's proxy, transport_cmd and host_aliases.  The
+// vendored aws-sdk-go predates Parameter Store.
+func ssmParameter(acct *ini.Section, fed *federator.Federator, creds federator.Credentials, region, name string) (string, error) {
+	call := awsJSONCall{
+		Service:  "ssm",
+		Region:   region,
+		Endpoint: endpointURL(acct),
+		Target:   "AmazonSSM.GetParameter",
+		Client:   fed.Client(),
+	}
+	var out struct {
+		Parameter struct {
+			Value string
+		}
+	}
+	err := call.do(credentials.NewStaticCredentials(creds.AccessKeyId, creds.SecretAccessKey, creds.SessionToken), map[string]interface{}{"Name": name, "WithDecryption": true}, &out)
+	return out.Parameter.Value, err
+}
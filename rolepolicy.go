@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+// roleRule is one rule of an account's role_policy.  A rule chooses the
+// roles matching all of its conditions, or every role if it is a prompt
+// rule.
+type roleRule struct {
+	prompt     bool
+	conditions []roleCondition
+}
+
+// roleCondition is a single kind:value condition of a roleRule:
+//
+//	role:<glob>    the role name or ARN matches
+//	account:<glob> the account ID or its account_map name matches
+//	tag:<tag>      the account is given the tag in account_tags
+type roleCondition struct {
+	kind  string
+	value string
+}
+
+// parseRolePolicy parses a role_policy setting: comma separated rules, each
+// made of space separated conditions, tried in order.  For example
+//
+//	role_policy = tag:prod role:Admin, role:ReadOnly, prompt
+func parseRolePolicy(s string) ([]roleRule, error) {
+	var rules []roleRule
+	for _, text := range strings.Split(s, ",") {
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) == 1 && fields[0] == "prompt" {
+			rules = append(rules, roleRule{prompt: true})
+			continue
+		}
+
+		var rule roleRule
+		for _, f := range fields {
+			i := strings.Index(f, ":")
+			if i < 0 {
+				return nil, fmt.Errorf("role_policy condition '%s' must be role:, account: or tag: followed by a value", f)
+			}
+			cond := roleCondition{kind: f[:i], value: f[i+1:]}
+			switch cond.kind {
+			case "role", "account", "tag":
+			default:
+				return nil, fmt.Errorf("role_policy condition '%s' must be role:, account: or tag: followed by a value", f)
+			}
+			if _, err := path.Match(cond.value, ""); err != nil {
+				return nil, fmt.Errorf("role_policy condition '%s' has an invalid pattern: %s", f, err)
+			}
+			rule.conditions = append(rule.conditions, cond)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (r roleRule) matches(role federator.Role, names map[string]string, tags map[string][]string) bool {
+	for _, cond := range r.conditions {
+		if !cond.matches(role, names, tags) {
+			return false
+		}
+	}
+	return true
+}
+
+func (cond roleCondition) matches(role federator.Role, names map[string]string, tags map[string][]string) bool {
+	switch cond.kind {
+	case "role":
+		return globMatches(cond.value, role.RoleName(), role.RoleArn())
+	case "account":
+		return globMatches(cond.value, role.AccountId(), names[role.AccountId()])
+	case "tag":
+		for _, t := range tags[role.AccountId()] {
+			if t == cond.value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func globMatches(pattern string, values ...string) bool {
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		if ok, _ := path.Match(pattern, v); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRolePolicy returns the roles chosen by the first rule of policy which
+// matches any of roles.  ok is false if no rule does.
+func applyRolePolicy(policy []roleRule, roles []federator.Role, names map[string]string, tags map[string][]string) (chosen []federator.Role, ok bool) {
+	for _, rule := range policy {
+		if rule.prompt {
+			return roles, true
+		}
+		for _, r := range roles {
+			if rule.matches(r, names, tags) {
+				chosen = append(chosen, r)
+			}
+		}
+		if len(chosen) > 0 {
+			return chosen, true
+		}
+	}
+	return nil, false
+}
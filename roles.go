@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+// roleMemoryKey returns the key the last selected role for account is
+// remembered under, according to the account's remember_role setting:
+//
+//	true - remember one role per account
+//	git  - remember one role per account and git repository, so that
+//	       running inside different repositories defaults to different roles
+//
+// An empty key is returned when roles should not be remembered.
+func roleMemoryKey(account, mode string) string {
+	switch strings.ToLower(mode) {
+	case "true", "yes", "on", "1":
+		return account
+	case "git":
+		if repo := gitRepository(); repo != "" {
+			return account + " " + repo
+		}
+		return account
+	}
+	return ""
+}
+
+// gitRepository identifies the git repository containing the working
+// directory by its origin remote, falling back to its top level directory.
+func gitRepository() string {
+	if out, err := exec.Command("git", "config", "--get", "remote.origin.url").Output(); err == nil {
+		if remote := strings.TrimSpace(string(out)); remote != "" {
+			return remote
+		}
+	}
+
+	if out, err := exec.Command("git", "rev-parse", "--show-toplevel").Output(); err == nil {
+		return strings.TrimSpace(string(out))
+	}
+
+	return ""
+}
+
+func loadRoleMemory() map[string]string {
+	m := make(map[string]string)
+
+	p, err := statePath("roles")
+	if err != nil {
+		return m
+	}
+	if b, err := ioutil.ReadFile(p); err == nil {
+		json.Unmarshal(b, &m)
+	}
+	return m
+}
+
+// recalledRole returns the role last selected under key.
+func recalledRole(key string) federator.Role {
+	if key == "" {
+		return ""
+	}
+	return federator.Role(loadRoleMemory()[key])
+}
+
+// rememberRole records r as the last role selected under key.
+func rememberRole(key string, r federator.Role) error {
+	// held while the memory is read, so that roles remembered by other
+	// processes aren't lost
+	unlock, err := lockState()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	m := loadRoleMemory()
+	m[key] = string(r)
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	p, err := statePath("roles")
+	if err != nil {
+		return err
+	}
+	return federator.WriteFileAtomic(p, b, 0600)
+}
+
+// filterRoles returns the roles whose ARN or name match the glob pattern.
+func filterRoles(roles []federator.Role, pattern string) []federator.Role {
+	var matched []federator.Role
+	for _, r := range roles {
+		if ok, _ := path.Match(pattern, r.RoleArn()); ok {
+			matched = append(matched, r)
+		} else if ok, _ := path.Match(pattern, r.RoleName()); ok {
+			matched = append(matched, r)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// sessionPolicy returns the session policy given with -session-policy or
+// the account's session_policy setting, or "" if there is none.
+func sessionPolicy(acct *ini.Section) (string, error) {
+	if c.sessionPolicy != "" {
+		return readSessionPolicy(c.sessionPolicy)
+	}
+	return readSessionPolicy(acct.Key("session_policy").String())
+}
+
+// readSessionPolicy reads a session policy, given either as the path of a
+// file holding the policy document or as the document itself.  It is
+// compacted, as STS limits the size of session policies.
+func readSessionPolicy(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+
+	doc := []byte(s)
+	if !strings.HasPrefix(strings.TrimSpace(s), "{") {
+		var err error
+		if doc, err = ioutil.ReadFile(s); err != nil {
+			return "", fmt.Errorf("Unable to read session policy: %s", err)
+		}
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, doc); err != nil {
+		return "", fmt.Errorf("Session policy is not valid JSON: %s", err)
+	}
+	return compact.String(), nil
+}
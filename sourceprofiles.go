@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"gopkg.in/ini.v1"
+)
+
+// chainedProfile is a profile in ~/.aws/config which assumes a role using
+// the credentials of its source_profile.
+type chainedProfile struct {
+	name        string
+	source      string
+	roleArn     string
+	externalID  string
+	mfaSerial   string
+	sessionName string
+	duration    int
+}
+
+// chainedProfiles returns the profiles in ~/.aws/config which declare a
+// source_profile, keyed by that source profile.
+func chainedProfiles() (map[string][]chainedProfile, error) {
+	path, err := awsConfigPath()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	cfg, err := ini.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load %s: %s", path, err)
+	}
+
+	chains := map[string][]chainedProfile{}
+	for _, sec := range cfg.Sections() {
+		if !sec.HasKey("source_profile") {
+			continue
+		}
+
+		name := strings.TrimSpace(strings.TrimPrefix(sec.Name(), "profile "))
+		p := chainedProfile{
+			name:        name,
+			source:      sec.Key("source_profile").String(),
+			roleArn:     sec.Key("role_arn").String(),
+			externalID:  sec.Key("external_id").String(),
+			mfaSerial:   sec.Key("mfa_serial").String(),
+			sessionName: sec.Key("role_session_name").String(),
+			duration:    sec.Key("duration_seconds").MustInt(0),
+		}
+		chains[p.source] = append(chains[p.source], p)
+	}
+
+	return chains, nil
+}
+
+// reportChainedProfiles lists the profiles which use profile p, directly or
+// through other profiles, as their source_profile.  If prewarm is set, their
+// roles are assumed with creds and cached where the AWS CLI will find them.
+func reportChainedProfiles(creds federator.Credentials, p string, prewarm bool) {
+	chains, err := chainedProfiles()
+	if err != nil {
+		l.Printf("Unable to check AWS config file for source_profile references: %s\n", err)
+		return
+	}
+	if len(chains[p]) == 0 {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "The following profiles use '%s' as their source_profile:\n", p)
+	walkChainedProfiles(chains, p, creds, prewarm, map[string]bool{p: true}, "  ")
+}
+
+func walkChainedProfiles(chains map[string][]chainedProfile, source string, creds federator.Credentials, prewarm bool, seen map[string]bool, indent string) {
+	profiles := chains[source]
+	names := make([]string, 0, len(profiles))
+	byName := map[string]chainedProfile{}
+	for _, cp := range profiles {
+		names = append(names, cp.name)
+		byName[cp.name] = cp
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		cp := byName[n]
+		if seen[cp.name] {
+			continue
+		}
+		seen[cp.name] = true
+
+		status := ""
+		var next federator.Credentials
+		switch {
+		case !prewarm:
+		case creds.AccessKeyId == "":
+			status = " (not pre-warmed, source was not pre-warmed)"
+		case cp.roleArn == "":
+			status = " (not pre-warmed, no role_arn)"
+		case cp.mfaSerial != "":
+			status = " (not pre-warmed, requires MFA)"
+		default:
+			var err error
+			if next, err = prewarmProfile(cp, creds); err != nil {
+				status = fmt.Sprintf(" (pre-warm failed: %s)", err)
+			} else {
+				status = fmt.Sprintf(" (pre-warmed until %s)", formatTime(next.Expiration))
+			}
+		}
+		fmt.Fprintf(os.Stderr, "%s%s%s\n", indent, cp.name, status)
+
+		walkChainedProfiles(chains, cp.name, next, prewarm, seen, indent+"  ")
+	}
+}
+
+// prewarmProfile assumes the profile's role with the credentials of its
+// source profile and stores the result in the AWS CLI's credential cache.
+func prewarmProfile(cp chainedProfile, creds federator.Credentials) (federator.Credentials, error) {
+	sess := session.New(&aws.Config{
+		Credentials: credentials.NewStaticCredentials(creds.AccessKeyId, creds.SecretAccessKey, creds.SessionToken),
+	})
+
+	name := cp.sessionName
+	if name == "" {
+		name = fmt.Sprintf("aws-cli-federator-%d", time.Now().Unix())
+	}
+
+	params := &sts.AssumeRoleInput{
+		RoleArn:         aws.String(cp.roleArn),
+		RoleSessionName: aws.String(name),
+	}
+	if cp.externalID != "" {
+		params.ExternalId = aws.String(cp.externalID)
+	}
+	if cp.duration > 0 {
+		params.DurationSeconds = aws.Int64(int64(cp.duration))
+	}
+
+	resp, err := sts.New(sess).AssumeRole(params)
+	if err != nil {
+		return federator.Credentials{}, err
+	}
+
+	next := federator.Credentials{
+		AccessKeyId:     *resp.Credentials.AccessKeyId,
+		SecretAccessKey: *resp.Credentials.SecretAccessKey,
+		SessionToken:    *resp.Credentials.SessionToken,
+		Expiration:      *resp.Credentials.Expiration,
+	}
+
+	if err := writeCLICache(cp, next, resp.AssumedRoleUser); err != nil {
+		return federator.Credentials{}, err
+	}
+	return next, nil
+}
+
+// cliCacheKey reproduces the file name the AWS CLI uses to cache the
+// credentials of an assume role profile: the SHA1 of its AssumeRole
+// arguments (without the session name) serialised as Python's json.dumps
+// would with sorted keys.
+func cliCacheKey(cp chainedProfile) string {
+	var args []string
+	add := func(k string, v interface{}) {
+		b, _ := json.Marshal(v)
+		args = append(args, fmt.Sprintf("%q: %s", k, b))
+	}
+
+	// keys must remain in sorted order
+	if cp.duration > 0 {
+		add("DurationSeconds", cp.duration)
+	}
+	if cp.externalID != "" {
+		add("ExternalId", cp.externalID)
+	}
+	add("RoleArn", cp.roleArn)
+
+	sum := sha1.Sum([]byte("{" + strings.Join(args, ", ") + "}"))
+	return hex.EncodeToString(sum[:])
+}
+
+func writeCLICache(cp chainedProfile, creds federator.Credentials, assumed *sts.AssumedRoleUser) error {
+	usr, err := user.Current()
+	if err != nil {
+		return fmt.Errorf("Unable to get current user information: %s", err)
+	}
+
+	dir := filepath.Join(usr.HomeDir, ".aws", "cli", "cache")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("Unable to create AWS CLI cache directory: %s", err)
+	}
+
+	entry := map[string]interface{}{
+		"Credentials": map[string]string{
+			"AccessKeyId":     creds.AccessKeyId,
+			"SecretAccessKey": creds.SecretAccessKey,
+			"SessionToken":    creds.SessionToken,
+			"Expiration":      creds.Expiration.UTC().Format(time.RFC3339),
+		},
+	}
+	if assumed != nil {
+		entry["AssumedRoleUser"] = map[string]string{
+			"Arn":           aws.StringValue(assumed.Arn),
+			"AssumedRoleId": aws.StringValue(assumed.AssumedRoleId),
+		}
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, cliCacheKey(cp)+".json")
+	if err := federator.WriteFileAtomic(path, b, 0600); err != nil {
+		return fmt.Errorf("Unable to write AWS CLI cache: %s", err)
+	}
+	return nil
+}
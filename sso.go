@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"github.com/aidan-/aws-cli-federator/platform"
+	"gopkg.in/ini.v1"
+)
+
+// ssoAccount reports whether acct gets its credentials from AWS IAM Identity
+// Center (AWS SSO) rather than by SAML federation.
+func ssoAccount(acct *ini.Section) bool {
+	return acct.Key("account_type").String() == "sso"
+}
+
+// ssoLogin signs in to the account's AWS SSO portal, reusing the session of
+// an earlier login while it lasts, and returns the roles assigned to the
+// user which role_pattern allows.
+func ssoLogin(acct *ini.Section) (*federator.SSO, []federator.Role, error) {
+	start := acct.Key("sso_start_url").String()
+	region := acct.Key("sso_region").String()
+	if start == "" || region == "" {
+		return nil, nil, fmt.Errorf("AWS SSO account '%s' must set sso_start_url and sso_region", acct.Name())
+	}
+
+	// the federator's client carries the account's proxy and transport
+	// settings
+	fed, err := federator.New("", "", start)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid sso_start_url: %s", err)
+	}
+	if err := configureTransport(acct, &fed); err != nil {
+		return nil, nil, err
+	}
+	logHTTP(&fed, c.verbose)
+
+	// -export writes nothing, so the access token isn't kept
+	var cache string
+	if !*c.export {
+		sum := sha1.Sum([]byte(start + " " + region))
+		if cache, err = statePath("sso-" + hex.EncodeToString(sum[:]) + ".json"); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	sso := &federator.SSO{
+		StartURL:  start,
+		Region:    region,
+		CachePath: cache,
+		Client:    fed.Client(),
+		Authorize: func(u, code string) {
+			fmt.Fprintf(os.Stderr, "Confirm the code %s to sign in to AWS SSO.\n", code)
+			if platform.Native().OpenBrowser(u) != nil {
+				fmt.Fprintf(os.Stderr, "Open this URL in your browser: %s\n", u)
+			}
+		},
+	}
+	if err := sso.Login(context.Background()); err != nil {
+		return nil, nil, err
+	}
+
+	roles, err := sso.Roles(context.Background())
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not retrieve roles: %s", err)
+	}
+	if acct.HasKey("role_pattern") {
+		pattern := acct.Key("role_pattern").String()
+		if roles = filterRoles(roles, pattern); len(roles) == 0 {
+			return nil, nil, fmt.Errorf("No roles match the pattern '%s'", pattern)
+		}
+	}
+	if len(roles) == 0 {
+		return nil, nil, fmt.Errorf("No AWS SSO roles are assigned to you")
+	}
+	return sso, roles, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"github.com/aidan-/aws-cli-federator/platform"
+)
+
+// stateLockTimeout is how long lockState waits for another process to
+// release the state directory.  A lock whose owner can't be identified, such
+// as one taken on another host sharing the home directory, is considered
+// stale once it is this old.
+const stateLockTimeout = 30 * time.Second
+
+// stateDir returns the directory holding files used to persist state between
+// invocations, stored alongside the federatedcli configuration.  It refuses
+// to return a directory belonging to another user.
+func stateDir() (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("Unable to get current user information: %s", err)
+	}
+
+	dir := filepath.Join(usr.HomeDir, ".aws", "federatedcli.d")
+	if err := checkStateOwner(dir, usr); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// checkStateOwner reports an error if dir exists but is owned by a user
+// other than usr.  On shared machines such as jump hosts this happens when
+// HOME points at another user's home directory, for example after sudo
+// without -H, and cached assertions, roles and locks must not be shared
+// between people.
+func checkStateOwner(dir string, usr *user.User) error {
+	fi, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Unable to read state directory: %s", err)
+	}
+
+	owner, err := platform.Native().FileOwner(fi)
+	if err != nil || owner == usr.Uid {
+		return nil
+	}
+
+	name := "uid " + owner
+	if u, err := user.LookupId(owner); err == nil {
+		name = u.Username
+	}
+	return fmt.Errorf("The state directory %s belongs to %s, not %s.  Refusing to share cached state between users; run as %s or make sure HOME is your own home directory (for example with 'sudo -H')", dir, name, usr.Username, name)
+}
+
+// legacyStatePath returns where versions before the state directory was
+// introduced stored the state file name.
+func legacyStatePath(name string) (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("Unable to get current user information: %s", err)
+	}
+
+	return filepath.Join(usr.HomeDir, ".aws", "federatedcli-"+name), nil
+}
+
+// statePath returns the location of the state file name, creating the state
+// directory if needed.  State left in the legacy location is used until it
+// is moved by migrate-state.
+func statePath(name string) (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+
+	p := filepath.Join(dir, name)
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		if legacy, err := legacyStatePath(name); err == nil {
+			if _, err := os.Stat(legacy); err == nil {
+				return legacy, nil
+			}
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("Unable to create state directory: %s", err)
+	}
+	return p, nil
+}
+
+// lockState takes an exclusive lock on the state directory, waiting for any
+// other process holding it.  The returned function releases the lock.
+func lockState() (func(), error) {
+	dir, err := stateDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("Unable to create state directory: %s", err)
+	}
+
+	lock := filepath.Join(dir, ".lock")
+	host, _ := os.Hostname()
+	deadline := time.Now().Add(stateLockTimeout)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintf(f, "%d %s\n", os.Getpid(), host)
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("Unable to lock state directory: %s", err)
+		}
+
+		if owner, stale := staleLock(lock, host); stale {
+			l.Printf("Removing stale state lock %s\n", lock)
+			// another process may have found it stale and taken the lock
+			// since, which must not be removed
+			if b, err := ioutil.ReadFile(lock); err == nil && string(b) == owner {
+				os.Remove(lock)
+			}
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Timed out waiting for the state directory lock %s", lock)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// staleLock reports whether the state lock was left behind by a process
+// which has exited, returning the lock's contents.  The process is checked
+// when the lock was taken on this host; otherwise, or if it hasn't recorded
+// its PID yet, the lock is stale after stateLockTimeout.
+func staleLock(lock, host string) (string, bool) {
+	fi, err := os.Stat(lock)
+	if err != nil {
+		return "", false
+	}
+	b, err := ioutil.ReadFile(lock)
+	if err != nil {
+		return "", false
+	}
+
+	var pid int
+	var lockHost string
+	if n, _ := fmt.Sscanf(string(b), "%d %s", &pid, &lockHost); n == 2 && lockHost == host && pid > 0 {
+		return string(b), !platform.Native().ProcessRunning(pid)
+	}
+	return string(b), time.Since(fi.ModTime()) > stateLockTimeout
+}
+
+// writeState replaces the state file name with data.  The state lock is held
+// while its path is resolved and the file written, so that it can't be
+// written to the legacy location as migrate-state moves it.  Callers already
+// holding the lock use statePath and federator.WriteFileAtomic instead.
+func writeState(name string, data []byte) error {
+	unlock, err := lockState()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	p, err := statePath(name)
+	if err != nil {
+		return err
+	}
+	return federator.WriteFileAtomic(p, data, 0600)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+func init() {
+	commands["status"] = status
+}
+
+// profileStatus is what status reports for the profile of an account.
+type profileStatus struct {
+	account, profile string
+	expires          time.Time
+	ok               bool // the profile holds credentials written by this tool
+	meta             profileMeta
+	recorded         bool
+}
+
+// status prints how long the credentials in each configured account's
+// profile remain valid and whether they were last refreshed successfully,
+// writing the same as Prometheus gauges with -metrics-textfile.
+func status(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("status takes no arguments")
+	}
+	if err := c.loadConfigurationFile(); err != nil {
+		return fmt.Errorf("Unable to parse configuration file: %s", err)
+	}
+
+	var statuses []profileStatus
+	seen := make(map[string]bool)
+	for _, name := range c.taggedAccounts() {
+		p := c.cfg.Section(name).Key("profile").String()
+		if p == "" || seen[p] {
+			continue
+		}
+		seen[p] = true
+
+		s := profileStatus{account: name, profile: p}
+		if exp, err := profileExpiry(p); err == nil {
+			s.expires, s.ok = exp, true
+		}
+		s.meta, s.recorded = recordedProfile(p)
+		statuses = append(statuses, s)
+	}
+	if len(statuses) == 0 {
+		return fmt.Errorf("No accounts have a 'profile' configured")
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PROFILE\tACCOUNT\tEXPIRES\tLAST REFRESH")
+	for _, s := range statuses {
+		expires := "-"
+		if s.ok && s.expires.After(now) {
+			expires = fmt.Sprintf("%s (in %s)", formatTime(s.expires), s.expires.Sub(now)/time.Minute*time.Minute)
+		} else if s.ok {
+			expires = fmt.Sprintf("%s (expired)", formatTime(s.expires))
+		}
+		refreshed := "-"
+		if s.recorded && s.meta.refreshFailed() {
+			refreshed = fmt.Sprintf("failed %s: %s", formatTime(*s.meta.FailedAt), s.meta.RefreshError)
+		} else if s.recorded && !s.meta.IssuedAt.IsZero() {
+			refreshed = fmt.Sprintf("succeeded %s", formatTime(s.meta.IssuedAt))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.profile, s.account, expires, refreshed)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if c.metricsTextfile != "" {
+		if err := federator.WriteFileAtomic(c.metricsTextfile, statusMetrics(statuses, now), 0644); err != nil {
+			return fmt.Errorf("Unable to write metrics to %s: %s", c.metricsTextfile, err)
+		}
+	}
+	return nil
+}
+
+// statusMetrics formats statuses as gauges in the Prometheus text format.
+// A profile has no TTL gauge until it holds credentials from this tool, and
+// no refresh gauges until it has been refreshed.
+func statusMetrics(statuses []profileStatus, now time.Time) []byte {
+	var buf bytes.Buffer
+	gauge := func(name, help string, value func(profileStatus) (float64, bool)) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, s := range statuses {
+			if v, ok := value(s); ok {
+				fmt.Fprintf(&buf, "%s{profile=\"%s\",account=\"%s\"} %s\n", name, metricLabel(s.profile), metricLabel(s.account), strconv.FormatFloat(v, 'f', -1, 64))
+			}
+		}
+	}
+
+	gauge("aws_cli_federator_credentials_ttl_seconds", "Seconds until the credentials in the profile expire, negative once they have.", func(s profileStatus) (float64, bool) {
+		return float64(s.expires.Sub(now) / time.Second), s.ok
+	})
+	gauge("aws_cli_federator_credentials_expiry_timestamp_seconds", "When the credentials in the profile expire, in seconds since the epoch.", func(s profileStatus) (float64, bool) {
+		return float64(s.expires.Unix()), s.ok
+	})
+	gauge("aws_cli_federator_last_refresh_success", "Whether the last refresh of the profile succeeded.", func(s profileStatus) (float64, bool) {
+		if !s.recorded {
+			return 0, false
+		}
+		if s.meta.refreshFailed() {
+			return 0, true
+		}
+		return 1, !s.meta.IssuedAt.IsZero()
+	})
+	gauge("aws_cli_federator_last_refresh_success_timestamp_seconds", "When credentials were last written to the profile, in seconds since the epoch.", func(s profileStatus) (float64, bool) {
+		return float64(s.meta.IssuedAt.Unix()), s.recorded && !s.meta.IssuedAt.IsZero()
+	})
+	return buf.Bytes()
+}
+
+// metricLabel escapes a label value for the Prometheus text format.
+func metricLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"gopkg.in/ini.v1"
+)
+
+// supportCrashReports is how many of the most recent crash reports are
+// included in a support bundle.
+const supportCrashReports = 5
+
+// secretKeyPattern matches the names of settings which aren't known to be
+// secret but look it, such as those of newer versions.
+var secretKeyPattern = regexp.MustCompile(`(?i)password|secret|token|passphrase`)
+
+func init() {
+	commands["support-bundle"] = supportBundle
+}
+
+// supportBundle writes a tarball describing this installation for
+// attaching to bug reports: the version and platform, the configuration
+// file with its secrets removed, what validate finds wrong with it, the
+// profiles written and the most recent crash reports.  Credentials and the
+// caches holding them are never included.
+func supportBundle(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("support-bundle takes at most the path of the bundle to write")
+	}
+	path := fmt.Sprintf("aws-cli-federator-support-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))
+	if len(args) == 1 {
+		path = args[0]
+	}
+
+	files := []struct {
+		name string
+		data func() ([]byte, error)
+	}{
+		{"version.txt", supportVersion},
+		{"platform.txt", supportPlatform},
+		{"config.ini", supportConfig},
+		{"validate.txt", supportValidate},
+		{"profiles.json", supportProfiles},
+		{"state.txt", supportState},
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: "aws-cli-federator-support/" + name, Mode: 0600, Size: int64(len(data)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	for _, f := range files {
+		data, err := f.data()
+		if err != nil {
+			// what couldn't be gathered is useful to triage too
+			data = []byte(fmt.Sprintf("Unable to gather %s: %s\n", f.name, err))
+		}
+		if err := add(f.name, []byte(scrub(string(data)))); err != nil {
+			return err
+		}
+	}
+	reports, err := recentCrashReports()
+	if err != nil {
+		l.Printf("Unable to list crash reports: %s\n", err)
+	}
+	for _, p := range reports {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		if err := add("crash/"+filepath.Base(p), []byte(scrub(string(data)))); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := federator.WriteFileAtomic(path, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("Unable to write %s: %s", path, err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s.  Passwords and other secrets have been removed, but please look through it before attaching it to an issue.\n", path)
+	return nil
+}
+
+func supportVersion() ([]byte, error) {
+	return []byte(fmt.Sprintf("aws-cli-federator %s\ngo: %s\ncompiler: %s\n", Version, runtime.Version(), runtime.Compiler)), nil
+}
+
+// supportPlatform describes the operating system and the environment
+// affecting the tool, naming the AWS variables set without their values.
+func supportPlatform() ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "os: %s\narch: %s\ncpus: %d\n", runtime.GOOS, runtime.GOARCH, runtime.NumCPU())
+	for _, name := range []string{"TERM", "SHELL", "LANG", "HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"} {
+		if v := os.Getenv(name); v != "" {
+			fmt.Fprintf(&buf, "%s: %s\n", name, stripUserinfo(v))
+		}
+	}
+
+	var aws []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "AWS_") {
+			aws = append(aws, kv[:strings.Index(kv, "=")])
+		}
+	}
+	sort.Strings(aws)
+	fmt.Fprintf(&buf, "AWS variables set: %s\n", strings.Join(aws, ", "))
+	return buf.Bytes(), nil
+}
+
+// stripUserinfo removes the credentials from a proxy URL.
+func stripUserinfo(s string) string {
+	if i := strings.Index(s, "@"); i >= 0 {
+		if j := strings.Index(s, "://"); j >= 0 && j < i {
+			return s[:j+3] + "[REDACTED]" + s[i:]
+		}
+		return "[REDACTED]" + s[i:]
+	}
+	return s
+}
+
+// redactURL removes the credentials and query, which often holds a
+// signature or token, from a URL such as proxy or account_map_url.
+func redactURL(s string) string {
+	s = stripUserinfo(s)
+	if i := strings.Index(s, "?"); i >= 0 {
+		return s[:i] + "?[REDACTED]"
+	}
+	return s
+}
+
+// supportConfig returns the configuration file with the values of secret
+// settings replaced, and the credentials removed from URLs.
+func supportConfig() ([]byte, error) {
+	c.resolvePath()
+	data, err := readConfigFile(c.path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ini.Load(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, sec := range cfg.Sections() {
+		for _, key := range sec.Keys() {
+			k, known := lookupConfigKey(key.Name())
+			if k.secret || (!known && secretKeyPattern.MatchString(key.Name())) {
+				key.SetValue("[REDACTED]")
+			} else if strings.Contains(key.Value(), "://") {
+				key.SetValue(redactURL(key.Value()))
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "; %s\n", c.path)
+	if _, err := cfg.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// supportValidate returns what validate finds wrong with the configuration.
+func supportValidate() ([]byte, error) {
+	c.resolvePath()
+	data, err := readConfigFile(c.path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ini.Load(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	issues := lintConfig(cfg, string(data))
+	for _, i := range issues {
+		level := "error"
+		if i.warning {
+			level = "warning"
+		}
+		fmt.Fprintf(&buf, "%d: %s: %s\n", i.line, level, i.message)
+	}
+	if len(issues) == 0 {
+		fmt.Fprintf(&buf, "no problems found\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// supportProfiles returns profiles.json, which records what each profile
+// holds but no credentials.
+func supportProfiles() ([]byte, error) {
+	p, err := statePath("profiles.json")
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return []byte("{}\n"), nil
+	}
+	return data, err
+}
+
+// supportState lists the files in the state directory, without their
+// contents.
+func supportState() ([]byte, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for _, fi := range infos {
+		fmt.Fprintf(&buf, "%s %8d %s %s\n", fi.Mode(), fi.Size(), fi.ModTime().UTC().Format(time.RFC3339), fi.Name())
+	}
+	return buf.Bytes(), nil
+}
+
+// recentCrashReports returns the paths of the most recent crash reports in
+// the state directory.
+func recentCrashReports() ([]string, error) {
+	pattern, err := statePath("crash-*.txt")
+	if err != nil {
+		return nil, err
+	}
+	reports, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	// the names sort by the time they were written
+	sort.Strings(reports)
+	if len(reports) > supportCrashReports {
+		reports = reports[len(reports)-supportCrashReports:]
+	}
+	return reports, nil
+}
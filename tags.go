@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/ini.v1"
+)
+
+// tagList collects repeated -tag flags.
+type tagList []string
+
+func (t *tagList) String() string {
+	return strings.Join(*t, ",")
+}
+
+func (t *tagList) Set(s string) error {
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			*t = append(*t, tag)
+		}
+	}
+	return nil
+}
+
+func init() {
+	commands["list"] = listAccounts
+}
+
+// accountTags returns the tags an account is labelled with by its comma
+// separated tags setting.
+func accountTags(acct *ini.Section) []string {
+	var tags []string
+	for _, t := range strings.Split(acct.Key("tags").String(), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// hasTags reports whether an account is labelled with every tag in want.
+func hasTags(acct *ini.Section, want []string) bool {
+	have := make(map[string]bool)
+	for _, t := range accountTags(acct) {
+		have[t] = true
+	}
+	for _, t := range want {
+		if !have[t] {
+			return false
+		}
+	}
+	return true
+}
+
+// taggedAccounts returns the names of the accounts in the configuration
+// file labelled with every tag given with -tag, in file order.
+func (c configuration) taggedAccounts() []string {
+	return configuredAccounts(c.cfg, c.tags)
+}
+
+// configuredAccounts returns the names of the accounts in cfg labelled
+// with every tag in tags, in file order.
+func configuredAccounts(cfg *ini.File, tags []string) []string {
+	var names []string
+	for _, sec := range cfg.Sections() {
+		name := sec.Name()
+		if name == ini.DEFAULT_SECTION || name == "account_map" || name == "account_tags" || isPlatformSection(name) {
+			continue
+		}
+		if (sec.Key("sp_identity_url").String() != "" || ssoAccount(sec)) && hasTags(sec, tags) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// listAccounts prints the configured accounts, limited to those with the
+// tags given with -tag.
+func listAccounts(args []string) error {
+	if _, err := c.loadConfiguration(); err != nil {
+		return err
+	}
+
+	names := c.taggedAccounts()
+	if len(names) == 0 {
+		if len(c.tags) > 0 {
+			return fmt.Errorf("No accounts are tagged %s", c.tags.String())
+		}
+		return fmt.Errorf("No accounts are configured")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tTAGS\tPROFILE")
+	for _, name := range names {
+		sec := c.cfg.Section(name)
+		tags := accountTags(sec)
+		sort.Strings(tags)
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, strings.Join(tags, ","), sec.Key("profile").String())
+	}
+	return w.Flush()
+}
@@ -0,0 +1,115 @@
+//go:build tray
+// +build tray
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/getlantern/systray"
+	"github.com/kardianos/osext"
+)
+
+// trayAccount is an account section with a configured credential profile.
+type trayAccount struct {
+	account string
+	profile string
+	item    *systray.MenuItem
+}
+
+func init() {
+	commands["tray"] = runTray
+}
+
+// runTray shows the credential profiles managed through the configuration
+// file in the system tray along with their remaining validity.  Clicking an
+// entry refreshes it by invoking this executable for the account.
+func runTray(args []string) error {
+	if err := c.loadConfigurationFile(); err != nil {
+		return fmt.Errorf("Unable to parse configuration file: %s", err)
+	}
+
+	var accounts []*trayAccount
+	for _, sec := range c.cfg.Sections() {
+		if sec.HasKey("sp_identity_url") && sec.HasKey("profile") && !isPlatformSection(sec.Name()) {
+			accounts = append(accounts, &trayAccount{
+				account: sec.Name(),
+				profile: sec.Key("profile").String(),
+			})
+		}
+	}
+	if len(accounts) == 0 {
+		return fmt.Errorf("No account sections have a 'profile' configured")
+	}
+
+	self, err := osext.Executable()
+	if err != nil {
+		return fmt.Errorf("Unable to locate executable: %s", err)
+	}
+
+	systray.Run(func() {
+		systray.SetTitle("AWS")
+		systray.SetTooltip("aws-cli-federator")
+
+		for _, a := range accounts {
+			a.item = systray.AddMenuItem(a.account, "Click to refresh credentials")
+			go func(a *trayAccount) {
+				for range a.item.ClickedCh {
+					a.item.SetTitle(fmt.Sprintf("%s (%s): refreshing...", a.account, a.profile))
+					refreshTrayAccount(self, a)
+					updateTrayAccount(a)
+				}
+			}(a)
+		}
+
+		systray.AddSeparator()
+		quit := systray.AddMenuItem("Quit", "Quit aws-cli-federator")
+		go func() {
+			<-quit.ClickedCh
+			systray.Quit()
+		}()
+
+		go func() {
+			for {
+				for _, a := range accounts {
+					updateTrayAccount(a)
+				}
+				time.Sleep(30 * time.Second)
+			}
+		}()
+	}, func() {})
+
+	return nil
+}
+
+func updateTrayAccount(a *trayAccount) {
+	exp, err := profileExpiry(a.profile)
+	switch {
+	case err != nil:
+		a.item.SetTitle(fmt.Sprintf("%s (%s): no credentials", a.account, a.profile))
+	case time.Now().After(exp):
+		a.item.SetTitle(fmt.Sprintf("%s (%s): expired", a.account, a.profile))
+	default:
+		ttl := exp.Sub(time.Now()) / time.Minute * time.Minute
+		a.item.SetTitle(fmt.Sprintf("%s (%s): %s remaining", a.account, a.profile, ttl))
+	}
+}
+
+// refreshTrayAccount generates new credentials for the account.  As there
+// is no terminal, accounts should use 'prompt = pinentry' or have their
+// password configured.
+func refreshTrayAccount(self string, a *trayAccount) {
+	args := []string{"-account", a.account, "-profile", a.profile}
+	if c.path != "" {
+		args = append(args, "-path", c.path)
+	}
+
+	cmd := exec.Command(self, args...)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		l.Printf("Failed to refresh account %s: %s\n", a.account, err)
+	}
+}
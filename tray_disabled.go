@@ -0,0 +1,12 @@
+//go:build !tray
+// +build !tray
+
+package main
+
+import "fmt"
+
+func init() {
+	commands["tray"] = func(args []string) error {
+		return fmt.Errorf("This build does not include system tray support.  Rebuild with 'make TAGS=tray'")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+)
+
+// fileTxn writes several files so that an interrupted run leaves either all
+// of them updated or none, such as the credentials file and profiles.json
+// agreeing about a profile.  Each file's new contents are staged beside it,
+// then a journal of the staged files is written to the state directory
+// before they are renamed into place.  A run finding a journal left behind
+// finishes renaming the files it lists.
+type fileTxn struct {
+	staged []stagedFile
+}
+
+type stagedFile struct {
+	Path   string `json:"path"`
+	Staged string `json:"staged"`
+}
+
+// write stages data to be written to path.  An existing file keeps its
+// permissions.
+func (t *fileTxn) write(path string, data []byte, perm os.FileMode) error {
+	if fi, err := os.Stat(path); err == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	staged := path + ".txn"
+	f, err := os.OpenFile(staged, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(staged)
+		return err
+	}
+
+	t.staged = append(t.staged, stagedFile{Path: path, Staged: staged})
+	return nil
+}
+
+// commit moves the staged files into place.  The state lock must be held.
+func (t *fileTxn) commit() error {
+	journal, err := statePath("txn.json")
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(t.staged)
+	if err != nil {
+		return err
+	}
+	if err := federator.WriteFileAtomic(journal, data, 0600); err != nil {
+		return fmt.Errorf("Unable to write transaction journal: %s", err)
+	}
+
+	if err := applyStaged(t.staged); err != nil {
+		// the journal is kept so that the next run finishes the commit
+		return err
+	}
+	t.staged = nil
+	return os.Remove(journal)
+}
+
+// abort removes any staged files not committed.
+func (t *fileTxn) abort() {
+	for _, s := range t.staged {
+		os.Remove(s.Staged)
+	}
+	t.staged = nil
+}
+
+// recoverTxn finishes a commit interrupted before all of its files were
+// moved into place.  The state lock must be held.
+func recoverTxn() error {
+	journal, err := statePath("txn.json")
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(journal)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var staged []stagedFile
+	if err := json.Unmarshal(data, &staged); err != nil {
+		return fmt.Errorf("Invalid transaction journal %s: %s", journal, err)
+	}
+	l.Printf("Finishing interrupted write of %d file(s)\n", len(staged))
+	if err := applyStaged(staged); err != nil {
+		return err
+	}
+	return os.Remove(journal)
+}
+
+// applyStaged renames each staged file over its destination.  Files already
+// renamed by an earlier attempt are skipped.
+func applyStaged(staged []stagedFile) error {
+	for _, s := range staged {
+		if err := os.Rename(s.Staged, s.Path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Unable to write %s: %s", s.Path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"gopkg.in/ini.v1"
+)
+
+var accountIDPattern = regexp.MustCompile(`^\d{12}$`)
+
+func init() {
+	commands["validate"] = validate
+}
+
+// lintIssue is a problem found in the configuration file.
+type lintIssue struct {
+	line    int
+	warning bool
+	message string
+}
+
+// validate checks the configuration file for mistakes that would otherwise
+// only show up as confusing behaviour at login.
+func validate(args []string) error {
+	if err := c.loadConfigurationFile(); err != nil {
+		return fmt.Errorf("Unable to parse configuration file: %s", err)
+	}
+
+	data, err := readConfigFile(c.path)
+	if err != nil {
+		return err
+	}
+	issues := lintConfig(c.cfg, string(data))
+
+	if overlay, err := c.loadProjectOverlay(); err != nil {
+		issues = append(issues, lintIssue{warning: true, message: fmt.Sprintf("unable to load project configuration: %s", err)})
+	} else if overlay != nil {
+		issues = append(issues, lintOverlay(c.cfg, overlay)...)
+	}
+
+	errors := 0
+	for _, i := range issues {
+		level := "error"
+		if i.warning {
+			level = "warning"
+		} else {
+			errors++
+		}
+
+		if i.line > 0 {
+			fmt.Fprintf(os.Stderr, "%s:%d: %s: %s\n", c.path, i.line, level, i.message)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %s: %s\n", c.path, level, i.message)
+		}
+	}
+
+	if errors > 0 {
+		return fmt.Errorf("%d error(s) found in %s", errors, c.path)
+	}
+	fmt.Fprintf(os.Stderr, "%s is valid\n", c.path)
+	return nil
+}
+
+// lintConfig scans the raw configuration so that problems the INI parser
+// silently resolves, such as duplicate sections, can be reported with their
+// line numbers.
+func lintConfig(cfg *ini.File, data string) []lintIssue {
+	var issues []lintIssue
+
+	section := ""
+	sections := map[string]int{}
+	keys := map[string]int{}
+
+	for n, line := range strings.Split(data, "\n") {
+		n++
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			end := strings.LastIndex(line, "]")
+			if end < 0 {
+				issues = append(issues, lintIssue{line: n, message: "unclosed section header"})
+				continue
+			}
+			section = strings.TrimSpace(line[1:end])
+			if first, ok := sections[section]; ok {
+				issues = append(issues, lintIssue{line: n, message: fmt.Sprintf("duplicate section [%s], first defined on line %d; their settings are merged", section, first)})
+			} else {
+				sections[section] = n
+			}
+			keys = map[string]int{}
+			continue
+		}
+
+		i := strings.IndexAny(line, "=:")
+		if i < 0 {
+			issues = append(issues, lintIssue{line: n, message: fmt.Sprintf("expected 'key = value', found '%s'", line)})
+			continue
+		}
+		key := strings.TrimSpace(line[:i])
+
+		if first, ok := keys[key]; ok {
+			issues = append(issues, lintIssue{line: n, message: fmt.Sprintf("duplicate key '%s', first set on line %d; this value wins", key, first)})
+		}
+		keys[key] = n
+
+		switch {
+		case section == "account_map", section == "account_tags":
+			if !accountIDPattern.MatchString(key) {
+				issues = append(issues, lintIssue{line: n, message: fmt.Sprintf("%s key '%s' is not a 12 digit AWS account ID", section, key)})
+			}
+		case section == "":
+			if k, ok := lookupConfigKey(key); !ok || !k.global {
+				issues = append(issues, unknownKey(n, key, true))
+			}
+		default:
+			if k, ok := lookupConfigKey(key); !ok || k.global {
+				issues = append(issues, unknownKey(n, key, false))
+			}
+			switch key {
+			case "role_policy":
+				if _, err := parseRolePolicy(strings.TrimSpace(line[i+1:])); err != nil {
+					issues = append(issues, lintIssue{line: n, message: err.Error()})
+				}
+			case "session_duration":
+				if _, err := parseSessionDuration(strings.TrimSpace(line[i+1:])); err != nil {
+					issues = append(issues, lintIssue{line: n, message: err.Error()})
+				}
+			case "session_policy":
+				if _, err := readSessionPolicy(strings.TrimSpace(line[i+1:])); err != nil {
+					issues = append(issues, lintIssue{line: n, message: err.Error()})
+				}
+			case "sts_region":
+				if r := strings.TrimSpace(line[i+1:]); !regionPattern.MatchString(r) {
+					issues = append(issues, lintIssue{line: n, message: fmt.Sprintf("sts_region '%s' is not a region such as eu-west-1", r)})
+				}
+			case "saml_acs_url", "sts_endpoint", "endpoint_url":
+				if err := checkEndpointURL(key, strings.TrimSpace(line[i+1:])); err != nil {
+					issues = append(issues, lintIssue{line: n, message: err.Error()})
+				}
+			case "session_tags":
+				if _, err := parseSessionTags(strings.TrimSpace(line[i+1:])); err != nil {
+					issues = append(issues, lintIssue{line: n, message: err.Error()})
+				}
+			case "form_extra_fields":
+				if _, err := federator.ParseFormFields(strings.TrimSpace(line[i+1:])); err != nil {
+					issues = append(issues, lintIssue{line: n, message: err.Error()})
+				}
+			}
+		}
+	}
+
+	// Keys are inherited from parent sections, so the parsed configuration
+	// is consulted to find accounts that can never log in.
+	for name, line := range sections {
+		if name == "account_map" || name == "account_tags" {
+			continue
+		}
+		switch t := cfg.Section(name).Key("account_type").String(); t {
+		case "", "saml":
+			if cfg.Section(name).Key("sp_identity_url").String() == "" {
+				issues = append(issues, lintIssue{line: line, message: fmt.Sprintf("account [%s] has no sp_identity_url and cannot be used", name)})
+			}
+		case "sso":
+			if cfg.Section(name).Key("sso_start_url").String() == "" || cfg.Section(name).Key("sso_region").String() == "" {
+				issues = append(issues, lintIssue{line: line, message: fmt.Sprintf("AWS SSO account [%s] must set sso_start_url and sso_region", name)})
+			}
+		default:
+			issues = append(issues, lintIssue{line: line, message: fmt.Sprintf("account [%s] has unknown account_type '%s', expected saml or sso", name, t)})
+		}
+		for _, k := range []string{"session_name", "session_tags"} {
+			if cfg.Section(name).HasKey(k) && !cfg.Section(name).HasKey("chain_role_arn") {
+				issues = append(issues, lintIssue{line: line, message: fmt.Sprintf("account [%s] sets %s without chain_role_arn; the IdP names and tags SAML sessions, so it is ignored", name, k)})
+			}
+		}
+		switch b := cfg.Section(name).Key("auth_backend").String(); b {
+		case "", "http", "browser-headless":
+		default:
+			issues = append(issues, lintIssue{line: line, message: fmt.Sprintf("account [%s] has unknown auth_backend '%s', expected http or browser-headless", name, b)})
+		}
+		if t := cfg.Section(name).Key("idp_type").String(); t != "" && !knownProvider(t) {
+			issues = append(issues, lintIssue{line: line, message: fmt.Sprintf("account [%s] has unknown idp_type '%s', expected one of %s", name, t, strings.Join(federator.ProviderTypes(), ", "))})
+		}
+	}
+
+	sort.Sort(byLine(issues))
+	return issues
+}
+
+func knownProvider(name string) bool {
+	for _, t := range federator.ProviderTypes() {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+type byLine []lintIssue
+
+func (s byLine) Len() int           { return len(s) }
+func (s byLine) Less(i, j int) bool { return s[i].line < s[j].line }
+func (s byLine) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// lintOverlay reports account settings shadowed by the project overlay.
+func lintOverlay(cfg *ini.File, overlay *ini.Section) []lintIssue {
+	var issues []lintIssue
+
+	if overlay.HasKey("account") {
+		if _, err := cfg.GetSection(overlay.Key("account").String()); err != nil {
+			issues = append(issues, lintIssue{message: fmt.Sprintf("project configuration selects account '%s' which does not exist", overlay.Key("account").String())})
+		}
+	}
+
+	for _, sec := range cfg.Sections() {
+		for _, k := range projectOverlayKeys {
+			if k != "account" && overlay.HasKey(k) && sec.HasKey(k) {
+				issues = append(issues, lintIssue{warning: true, message: fmt.Sprintf("%s for account [%s] is overridden by the project configuration in this directory", k, sec.Name())})
+			}
+		}
+	}
+
+	return issues
+}
+
+func unknownKey(line int, key string, global bool) lintIssue {
+	msg := fmt.Sprintf("unknown key '%s'", key)
+	if global {
+		msg = fmt.Sprintf("unknown global key '%s'", key)
+	}
+
+	if s := suggestKey(key); s != "" {
+		if k, _ := lookupConfigKey(s); k.global == global {
+			msg += fmt.Sprintf(", did you mean '%s'?", s)
+		} else if k.global {
+			msg += fmt.Sprintf(", '%s' must be set before the first section", s)
+		} else {
+			msg += fmt.Sprintf(", '%s' must be set in an account section", s)
+		}
+	}
+
+	return lintIssue{line: line, message: msg}
+}
+
+// suggestKey returns the known key closest to key, if any is close enough
+// to be a likely typo.
+func suggestKey(key string) string {
+	best, bestDist := "", len(key)/3+2
+	for _, k := range configKeys {
+		if d := editDistance(key, k.name); d < bestDist {
+			best, bestDist = k.name, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aidan-/aws-cli-federator/federator"
+	"gopkg.in/ini.v1"
+)
+
+// webhookTimeout bounds how long a slow webhook can delay the CLI.
+const webhookTimeout = 5 * time.Second
+
+// assumeEvent is posted to the account's webhook_url after a role has been
+// assumed.
+type assumeEvent struct {
+	Event      string `json:"event"`
+	Account    string `json:"account"`
+	AccountID  string `json:"account_id"`
+	RoleArn    string `json:"role_arn"`
+	Username   string `json:"username"`
+	Hostname   string `json:"hostname"`
+	Time       string `json:"time"`
+	Expiration string `json:"expiration"`
+}
+
+// notifyWebhook posts an assumeEvent to the account's webhook_url, if one is
+// configured.  Failures are reported as warnings as the credentials have
+// already been issued.
+func notifyWebhook(acct *ini.Section, fed *federator.Federator, r federator.Role, creds federator.Credentials) {
+	url := acct.Key("webhook_url").String()
+	if url == "" {
+		return
+	}
+
+	host, _ := os.Hostname()
+	b, err := json.Marshal(assumeEvent{
+		Event:      "assume_role",
+		Account:    acct.Name(),
+		AccountID:  r.AccountId(),
+		RoleArn:    r.RoleArn(),
+		Username:   fed.Username,
+		Hostname:   host,
+		Time:       time.Now().UTC().Format(time.RFC3339),
+		Expiration: creds.Expiration.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: Could not create webhook event: %s\n", err)
+		return
+	}
+
+	l.Printf("Posting role assumption event to %s\n", url)
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: Could not post to webhook: %s\n", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		fmt.Fprintf(os.Stderr, "WARNING: Webhook returned HTTP %s\n", resp.Status)
+	}
+}